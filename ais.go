@@ -10,8 +10,10 @@ import (
    "strconv"
    "math/rand"
    "time"
-   "bufio"
    "strings"
+   "bufio"
+   "io"
+   "flag"
 )
 
 var rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -39,36 +41,28 @@ type Node struct {
 // A world map.
 type World [][]Node;
 
-// ---------------------------------------------------------------------------------------------------
-// Simulator data model
-// ---------------------------------------------------------------------------------------------------
-
-// The simulator does not assume that the provided input file conforms to any topological
-//   constraints, so its data model is different from the generator's simple model.
-// Each city node (SNode) that we read in has pointers for other city nodes that lie in the
-//   four cardinal directions. The only assumption we make is that if city A has a "north"
-//   connection to city B, then city B has a "south" connection to city A (and similar to east-west
-//   roads). If the input file violates that (e.g. city B has a "south" connection to some city "C"
-//   instead) then we abort the simulator with an error.
-
-// Additional indices for SNode.roads
-const WEST  int = 2;
-const NORTH int = 3;
-
-type SNodeArray []SNode         // a city data store
-
-type SNodeMap map[string]int    // index into a city data store (access city struct's index by city name)
-
-type SNode struct {
-	index        int        // Own index in the SNodeArray
-	cityName     string     // Name of the city ("" is an invalid name)
-	roads        [4]int     // Index into a city data store of adjacent cities in the four directions, -1 if none
-	sroads       [4]string  // Names of adjacent cities in the four directions (for the first parser pass), "" if none
-	dead         bool       // Set to true if the city has been destroyed
-	alienid      int        // Alien that is present in this city, or -1 if none
+// The name of the city generated at the given grid coordinates.
+func cityNameAt(x int, y int) string {
+	return fmt.Sprintf("X%dY%d", x, y)
 }
 
-type AlienArray []int        // Index is alien number, value is index into a SNodeArray (i.e. which city)
+// Recovers the grid coordinates from a city name generated by this generator ("X<x>Y<y>").
+// Returns ok == false if the name does not follow that scheme.
+func parseCoords(cityName string) (x int, y int, ok bool) {
+	if (! strings.HasPrefix(cityName, "X")) {
+		return 0, 0, false
+	}
+	yPos := strings.Index(cityName, "Y")
+	if (yPos < 2) {
+		return 0, 0, false
+	}
+	x, xerr := strconv.Atoi(cityName[1:yPos])
+	y, yerr := strconv.Atoi(cityName[yPos+1:])
+	if (xerr != nil) || (yerr != nil) || (x < 0) || (y < 0) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
 
 // ---------------------------------------------------------------------------------------------------
 // Print help
@@ -77,20 +71,388 @@ type AlienArray []int        // Index is alien number, value is index into a SNo
 func printHelp() {
 	fmt.Println();
 	fmt.Println("Map generation mode usage: ");
-	fmt.Println("   ais -gen <MAPFILE> <MAXX> <MAXY> <CD> <RD>");
+	fmt.Println("   ais -gen <MAPFILE> <MAXX> <MAXY> <CD> <RD> [options]");
 	fmt.Println();
-	fmt.Println("   <MAPFILE>  Name of the output file where the generated map data will be stored.");
+	fmt.Println("   <MAPFILE>  Name of the output file where the generated map data will be stored,");
+	fmt.Println("              or '-' to write it to the standard output (the messages then go to");
+	fmt.Println("              the standard error). A name ending in '.gz' writes it compressed.");
 	fmt.Println("   <MAXX>     Positive integer width of the city grid.");
 	fmt.Println("   <MAXY>     Positive integer height of the city grid..");
 	fmt.Println("   <CD>       Real number in the [0, 1] range for the density of cities in the grid.");
 	fmt.Println("   <RD>       Real number in the [0, 1] range for the density of roads in the grid.");
+	fmt.Println("   -topology T");
+	fmt.Println("              grid          bounded rectangular grid (the default)");
+	fmt.Println("              torus         grid that wraps around east-west and north-south");
+	fmt.Println("              hex           hexagonal grid, drawn as a brick wall (three neighbors per city)");
+	fmt.Println("              random-graph  <MAXX>*<MAXY>*<CD> cities with random reciprocal roads and an");
+	fmt.Println("                            average degree of 4*<RD>, with no geometry");
+	fmt.Println("   -symmetry S");
+	fmt.Println("              horizontal (east half mirrors west half), vertical (south half mirrors");
+	fmt.Println("              north half) or rotational (same map when turned 180 degrees).");
+	fmt.Println("   -connected Add the roads (and bridging cities) needed to connect all the cities.");
+	fmt.Println("   -names N   City names: 'coords' for names like X3Y7 (the default), 'syllables' for");
+	fmt.Println("              made-up names, or the name of a file with one name per line to draw");
+	fmt.Println("              unique names from.");
+	fmt.Println("   -rd-ew RD  Density of the east-west roads, instead of <RD> (e.g. 0.8 with -rd-ns 0.2");
+	fmt.Println("              for corridor-like maps).");
+	fmt.Println("   -rd-ns RD  Density of the north-south roads, instead of <RD>.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Calibrated map generation mode usage: ");
+	fmt.Println("   ais -calibrate <MAPFILE> <CITIES> <AVGDEG> [-cd <CD>] [-names N]");
+	fmt.Println();
+	fmt.Println("   Solves for the grid size and densities that give about <CITIES> cities with an");
+	fmt.Println("   average of <AVGDEG> roads per city (less than 4), then generates the map.");
+	fmt.Println("   -cd CD     Use this city density instead of solving for it.");
+	fmt.Println("   -names N   City names, as in map generation mode.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map validation mode usage: ");
+	fmt.Println("   ais -validate <MAPFILE>");
+	fmt.Println();
+	fmt.Println("   Reports every problem in the map file with its line number, and exits with");
+	fmt.Println("   status 3 if there is any.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map analysis mode usage: ");
+	fmt.Println("   ais -analyze <MAPFILE>");
+	fmt.Println();
+	fmt.Println("   Reports the connected components, isolated cities, dead ends, average degree and");
+	fmt.Println("   an estimate of the diameter of the map.");
 	fmt.Println();
 	fmt.Println();
 	fmt.Println("Simulation mode usage: ");
 	fmt.Println("   ais <MAPFILE> <NUMALIENS>");
 	fmt.Println();
-	fmt.Println("   <MAPFILE>    Name of the input file where the generated map data is stored.");
-	fmt.Println("   <NUMALIENS>  Positive integer number of aliens to unleash in the city.");
+	fmt.Println("   <MAPFILE>    Name of the input file where the generated map data is stored, or '-'");
+	fmt.Println("                to read it from the standard input. The output files of a map read");
+	fmt.Println("                from the standard input are named after 'stdin' (e.g. stdin.result).");
+	fmt.Println("                Gzip-compressed maps are read as well; the result map of a map file");
+	fmt.Println("                named '<NAME>.gz' is compressed too, and named '<NAME>.result.gz'.");
+	fmt.Println("   <NUMALIENS>  Positive integer number of aliens to unleash in the city, or 'auto'");
+	fmt.Println("                to pick one alien per 10 alive cities, or 'auto:RATIO' to pick RATIO");
+	fmt.Println("                aliens per alive city (at least one). The number chosen is printed");
+	fmt.Println("                and recorded in the summary.");
+	fmt.Println();
+	fmt.Println("   Options (given after the positional arguments):");
+	fmt.Println("   -spawn-border  Spawn aliens only at cities on the outer rows/columns of grid maps,");
+	fmt.Println("                  or at the minimum-degree cities of maps that are not grids. Same as");
+	fmt.Println("                  -spawn-policy perimeter.");
+	fmt.Println("   -spawn-policy P");
+	fmt.Println("                  Where the aliens spawn: 'uniform' (the default) at random cities,");
+	fmt.Println("                  'clustered' within 2 roads of a random epicenter (a new one for each");
+	fmt.Println("                  wave), 'perimeter' at the border cities (see -spawn-border),");
+	fmt.Println("                  'weighted-degree' or 'weighted-population' at random cities with a");
+	fmt.Println("                  probability proportional to their roads or their population.");
+	fmt.Println("   -max-steps N   Maximum number of movement steps to simulate (default 10000).");
+	fmt.Println("   -stop-when C   Also stop when condition C holds after a step. C is 'all-trapped'");
+	fmt.Println("                  (no alien can move) or 'half-destroyed' (half of the cities are");
+	fmt.Println("                  gone). May be given more than once.");
+	fmt.Println("   -stop-after-quiescent K");
+	fmt.Println("                  Stop if no fight happened in the last K steps.");
+	fmt.Println("   -defense-rate R");
+	fmt.Println("                  Every surviving city gains R defense points in each step. A city with");
+	fmt.Println("                  D points kills an incoming alien with probability D / (D + 100).");
+	fmt.Println("   -fight-threshold N");
+	fmt.Println("                  Aliens fight when N of them are in the same city (default 2). Fewer");
+	fmt.Println("                  aliens share a city in peace. All the aliens in a fight die (but see");
+	fmt.Println("                  -combat-model).");
+	fmt.Println("   -spare-cities  Fights kill the aliens but leave the city standing.");
+	fmt.Println("   -combat-model M");
+	fmt.Println("                  How fights end. 'annihilate' (the default): all the fighters die and");
+	fmt.Println("                  the city is destroyed. 'wounds': in every round each fighter strikes");
+	fmt.Println("                  an enemy, taking a hit point half of the time, until the ones left are");
+	fmt.Println("                  too few to fight (or of one faction); they survive wounded and hold");
+	fmt.Println("                  the city, which is destroyed only if nobody is left. 'damage': in every");
+	fmt.Println("                  round the fighters and the city lose a hit point; the city is destroyed");
+	fmt.Println("                  with the aliens in it when it has none left, and stands damaged if the");
+	fmt.Println("                  aliens give out first. Wounded aliens and damaged cities are reported.");
+	fmt.Println("   -hit-points N  Hit points of every alien (default 1), for -combat-model wounds or");
+	fmt.Println("                  damage. Wounds last for the rest of the simulation.");
+	fmt.Println("   -city-hit-points N");
+	fmt.Println("                  Hit points of every city (default 3), for -combat-model damage.");
+	fmt.Println("   -movement M    'sequential' (the default): the aliens move one after the other, in");
+	fmt.Println("                  order. 'simultaneous': all the aliens pick their moves, then move at");
+	fmt.Println("                  once. Two aliens crossing the same road in opposite directions");
+	fmt.Println("                  fight halfway, and the road is destroyed. 'parallel': as simultaneous,");
+	fmt.Println("                  with the moves picked by several goroutines (for huge alien counts).");
+	fmt.Println("                  Each alien draws its own random numbers, so the results of a seed");
+	fmt.Println("                  differ from simultaneous movement, but not with the number of workers.");
+	fmt.Println("   -workers N     Goroutines that pick the moves with -movement parallel (default: one");
+	fmt.Println("                  per CPU).");
+	fmt.Println("   -factions K    Deal the aliens to K factions in turn (alien #0 to faction #0, and so");
+	fmt.Println("                  on). Aliens of the same faction share cities in peace; only aliens of");
+	fmt.Println("                  different factions fight. The survivors of each faction are reported.");
+	fmt.Println("   -defenders     When aliens fight in a city with population P (given in the map file");
+	fmt.Println("                  as pop=P), its defenders kill them all with probability P / (P + 1000),");
+	fmt.Println("                  losing one person per alien, and the city survives. Otherwise the");
+	fmt.Println("                  city is destroyed with its population. The human casualties and the");
+	fmt.Println("                  alien losses are reported.");
+	fmt.Println("   -road-decay P  Every road is destroyed with probability P in each step.");
+	fmt.Println("   -collateral P  When a city is destroyed, every road of its neighbors is destroyed");
+	fmt.Println("                  with it with probability P. The roads destroyed on their own (also by");
+	fmt.Println("                  clashes) and the roads that survived are reported in the summary.");
+	fmt.Println("   -fear-of-ruins P");
+	fmt.Println("                  When an alien is about to move next to a destroyed city, it takes a");
+	fmt.Println("                  road away from the ruins instead with probability P (if it has one).");
+	fmt.Println("   -script F      Let the Starlark script F choose the moves of the aliens: it defines");
+	fmt.Println("                  choose(alien, city, roads), which is called for every alien in every");
+	fmt.Println("                  step with the alien, its city and the roads to the cities still");
+	fmt.Println("                  standing, and returns the direction of a road or None to stay. Scripts");
+	fmt.Println("                  draw random numbers with random() and randint(n). Needs a build with");
+	fmt.Println("                  '-tags starlark'.");
+	fmt.Println("   -waves CxN     Spawn the aliens in C waves, one every N steps, instead of all at once.");
+	fmt.Println("                  The aliens are split evenly between the waves; a wave lands after the");
+	fmt.Println("                  aliens already spawned have moved, and the simulation doesn't stop for");
+	fmt.Println("                  lack of aliens or fights while waves are still to come.");
+	fmt.Println("   -max-memory S  Refuse to simulate a map whose model (cities, names, roads and aliens)");
+	fmt.Println("                  is estimated to need more than S bytes, e.g. 512M or 2G, to protect");
+	fmt.Println("                  shared machines from giant inputs. Map files larger than S are refused");
+	fmt.Println("                  before they are read. The peak memory in use is reported in the summary.");
+	fmt.Println("   -two-pass      Read the map file twice, first the city names and then the roads,");
+	fmt.Println("                  which needs less memory for very large maps. The map can't be read");
+	fmt.Println("                  from the standard input then.");
+	fmt.Println("   -out F         Write the result map to F instead of <MAPFILE>.result (compressed if F");
+	fmt.Println("                  ends in '.gz'). With '-out -' the result map is written to the standard");
+	fmt.Println("                  output and the messages go to the standard error, e.g.:");
+	fmt.Println("                  ais -gen - 20 20 0.8 0.8 | ais - 10 -out -");
+	fmt.Println("   -quiet         Only print errors and the summary (no banner, phases or events).");
+	fmt.Println("   -verbose       Also print every alien move.");
+	fmt.Println("   -log-format F  Print the messages as 'text' (the default) or as 'json', one object");
+	fmt.Println("                  per line with its time, level, kind and message (or the summary).");
+	fmt.Println("   -mute K1,K2    Don't print the messages of these kinds: destroyed, fight, defended,");
+	fmt.Println("                  repelled, clash, road-destroyed, trapped, move, phase, stop, progress");
+	fmt.Println("                  and run.");
+	fmt.Println("                  Errors and the summary are always printed.");
+	fmt.Println("   -overwrite     Replace the output files of a previous run. Without this option the");
+	fmt.Println("                  simulator refuses to start if <MAPFILE>.result or");
+	fmt.Println("                  <MAPFILE>.summary.json already exist.");
+	fmt.Println("   -interactive   Pause between movement steps and read commands from the terminal");
+	fmt.Println("                  (step [n], status, city <name>, alien <id>, run, quit).");
+	fmt.Println("   -watch         Draw the map on the terminal after every movement step, showing live");
+	fmt.Println("                  cities, destroyed cities and aliens. Needs a map whose city names");
+	fmt.Println("                  encode the grid coordinates, as written by the map generator. When");
+	fmt.Println("                  the output is not a terminal (or TERM=dumb), the frames are written one");
+	fmt.Println("                  after the other without colors; NO_COLOR also turns the colors off.");
+	fmt.Println("   -frame-delay D Delay between watch mode frames (default 200ms).");
+	fmt.Println("   -seed N        Seed for the random number generator, to reproduce a previous run");
+	fmt.Println("                  (the seed of every run is shown in the summary).");
+	fmt.Println("   -paths         Record the cities visited by each alien and write them to");
+	fmt.Println("                  <MAPFILE>.paths, one line per alien (see the render mode).");
+	fmt.Println("   -visits        Write the iteration at which each city was last visited by an alien, and");
+	fmt.Println("                  how long it has been idle since, to <MAPFILE>.visits.");
+	fmt.Println("   -positions     Write the city of each alien left alive at the end to");
+	fmt.Println("                  <MAPFILE>.positions, one line per alien (see the render mode).");
+	fmt.Println("   -stream F      Write destruction events and partial results to F as JSON lines while");
+	fmt.Println("                  the simulation runs, flushing after every step, so that interrupted");
+	fmt.Println("                  runs still leave usable output.");
+	fmt.Println("   -stream-every N");
+	fmt.Println("                  Steps between the partial results written to the stream (default 100).");
+	fmt.Println("   -stats F       Write a CSV timeline to F, one row per step: iteration, aliens alive,");
+	fmt.Println("                  cities alive, fights in the step and trapped aliens. Row 0 is the");
+	fmt.Println("                  state after the spawn phase.");
+	fmt.Println("   -checkpoint-every N");
+	fmt.Println("                  Save the full simulation state to <MAPFILE>.checkpoint every N steps.");
+	fmt.Println("   -diagnostics F On a fatal simulation error, write a zip with the error, the options,");
+	fmt.Println("                  the simulation state and the last events to F, for bug reports.");
+	fmt.Println("   -check-invariants");
+	fmt.Println("                  Check the simulation state after every step (aliens only in live");
+	fmt.Println("                  cities, city occupants matching the aliens, roads matching their");
+	fmt.Println("                  opposite roads, counters matching the state), and abort with a dump");
+	fmt.Println("                  of the cities and aliens involved if it is broken. Slow; for debugging.");
+	fmt.Println("   -progress D    Instead of the progress dots, print a line with the iteration, the");
+	fmt.Println("                  aliens alive and the cities standing every D of running time, e.g. 10s.");
+	fmt.Println("   -time-limit D  Stop the simulation after running for D, e.g. 30m, and write its");
+	fmt.Println("                  results as usual (stop reason 'time-limit').");
+	fmt.Println("                  On Ctrl-C (SIGINT), the simulation stops at the end of the current step");
+	fmt.Println("                  and writes its results and events so far before exiting (stop reason");
+	fmt.Println("                  'interrupted', exit code 4). A second Ctrl-C exits at once.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Resume mode usage: ");
+	fmt.Println("   ais -resume <CHECKPOINT> [options]");
+	fmt.Println();
+	fmt.Println("   Resumes a simulation from a checkpoint written with -checkpoint-every. Takes the");
+	fmt.Println("   same options as the simulation mode; the options of the interrupted run are kept");
+	fmt.Println("   unless given again.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Transform mode usage: ");
+	fmt.Println("   ais -transform <MAPFILE> <OUTFILE> [options]");
+	fmt.Println();
+	fmt.Println("   Lays out a map (e.g. a simulation result) on a grid, using the coordinates in the");
+	fmt.Println("   city names or, failing that, the directions of the roads, and transforms it.");
+	fmt.Println("   -crop X0,Y0,X1,Y1  Keep only the grid positions from X0,Y0 to X1,Y1.");
+	fmt.Println("   -mirror AXIS       Flip the grid: horizontal, vertical or both.");
+	fmt.Println("   -wrap              Add the roads that wrap the grid around into a torus.");
+	fmt.Println("   -overwrite         Replace <OUTFILE> if it already exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map comparison mode usage: ");
+	fmt.Println("   ais -compare <MAPFILE_A> <MAPFILE_B> <NUMALIENS> [options]");
+	fmt.Println();
+	fmt.Println("   Simulates both maps with the same alien count and seeds, and prints a side-by-side");
+	fmt.Println("   report. The map that loses the smaller fraction of its cities is more resilient.");
+	fmt.Println("   -runs N        Average over N runs with consecutive seeds (default 1).");
+	fmt.Println("   -seed S        Seed of the first run (default: time-based).");
+	fmt.Println("   -max-steps, -stop-when, -stop-after-quiescent, -spawn-border, -spawn-policy,");
+	fmt.Println("   -defense-rate, -fight-threshold, -spare-cities, -combat-model, -hit-points,");
+	fmt.Println("   -city-hit-points, -movement, -factions, -road-decay, -collateral, -fear-of-ruins,");
+	fmt.Println("   -defenders and -waves are also accepted.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map diff mode usage: ");
+	fmt.Println("   ais -diff <ORIGINAL> <RESULT> [-json F] [-overwrite]");
+	fmt.Println();
+	fmt.Println("   Compares a map file with the result map file of its simulation, and prints the");
+	fmt.Println("   cities that were destroyed, the roads that were lost (with their cities or on");
+	fmt.Println("   their own) and the percentages of each. The order of the lines and roads in the");
+	fmt.Println("   files doesn't matter.");
+	fmt.Println("   -json F        Also write the diff as JSON to F.");
+	fmt.Println("   -overwrite     Replace F if it already exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Tournament mode usage: ");
+	fmt.Println("   ais tournament <NUMALIENS> <MAPFILE>... [options]");
+	fmt.Println("   ais -tournament <MAPFILE> <NUMALIENS> [options]");
+	fmt.Println();
+	fmt.Println("   Plays every movement strategy (random-walk, fear-of-ruins and the ones registered");
+	fmt.Println("   by programs that embed the simulator) on every map with the same seeds and options,");
+	fmt.Println("   and prints the strategies ranked by the fraction of their aliens that survived,");
+	fmt.Println("   then by the fraction of the cities they destroyed, with the average survivors,");
+	fmt.Println("   cities destroyed and steps until the last fight. A strategy wins a game if it keeps");
+	fmt.Println("   the most aliens alive; a sign test over the games tells whether each strategy is");
+	fmt.Println("   significantly different from the first.");
+	fmt.Println("   <NUMALIENS> can be 'auto' or 'auto:RATIO', as in simulation mode.");
+	fmt.Println("   -runs N        Play N games with consecutive seeds on each map (default 10).");
+	fmt.Println("   -seed S        Seed of the first game (default 1).");
+	fmt.Println("   -fear-of-ruins P");
+	fmt.Println("                  The fear of the fear-of-ruins strategy (default 0.5).");
+	fmt.Println("   -script F      Also play the strategy of the Starlark script F (see simulation mode).");
+	fmt.Println("   The other options of the map comparison mode are also accepted.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Baseline check mode usage: ");
+	fmt.Println("   ais check-baseline <SUMMARY> <BASELINE> [-tolerance T]");
+	fmt.Println();
+	fmt.Println("   Compares the key metrics of a run summary (<MAPFILE>.summary.json) with those of");
+	fmt.Println("   a baseline summary, and exits with a nonzero status if a numeric metric drifted");
+	fmt.Println("   by more than T percent (e.g. '5%', default 0) or another metric changed.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Demo mode usage: ");
+	fmt.Println("   ais demo [<NAME> [<NUMALIENS>] [options]]");
+	fmt.Println();
+	fmt.Println("   Runs one of the bundled scenarios (town, maze or continents), which need no");
+	fmt.Println("   input files. The map is written to <NAME>.map and then simulated as in");
+	fmt.Println("   simulation mode, with the number of aliens and options of the scenario unless");
+	fmt.Println("   given. Without a name, lists the demos.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map anonymizer mode usage: ");
+	fmt.Println("   ais anonymize <MAPFILE> [-overwrite]");
+	fmt.Println();
+	fmt.Println("   Replaces the city names in <MAPFILE> with pseudonyms and writes the result to");
+	fmt.Println("   <MAPFILE>.anon, and the pseudonym to city name mapping to <MAPFILE>.anon.names.");
+	fmt.Println("   A compressed <MAP>.gz gives <MAP>.anon.gz and <MAP>.anon.names, and a map read from");
+	fmt.Println("   the standard input ('-') gives stdin.anon and stdin.anon.names.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Import mode usage: ");
+	fmt.Println("   ais -import <INFILE> <MAPFILE> [options]");
+	fmt.Println();
+	fmt.Println("   Converts a road network into a map file, e.g. to invade real cities.");
+	fmt.Println();
+	fmt.Println("   <INFILE>     A GeoJSON FeatureCollection whose LineString features are roads (e.g.");
+	fmt.Println("                an OSM extract; Point features with a 'name' name the cities), or a");
+	fmt.Println("                CSV edge list with a header line and the columns from, to and,");
+	fmt.Println("                optionally, direction, from_x, from_y, to_x and to_y.");
+	fmt.Println("   <MAPFILE>    Name of the map file to write.");
+	fmt.Println();
+	fmt.Println("   Options:");
+	fmt.Println("   -format F      geojson or csv (default: by the name of <INFILE>).");
+	fmt.Println("   -directions M  How roads with a position get their direction: cardinal (the four");
+	fmt.Println("                  cardinal directions), compass (also the diagonals; the default) or");
+	fmt.Println("                  generic (road1, road2, ..., which fit any network). Roads that have");
+	fmt.Println("                  no free direction close to their bearing are dropped.");
+	fmt.Println("   -overwrite     Replace <MAPFILE> if it exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map editor mode usage: ");
+	fmt.Println("   ais -edit <MAPFILE> [-commands F]");
+	fmt.Println();
+	fmt.Println("   Edits a map file (or starts a new one) with commands to add and remove cities");
+	fmt.Println("   and roads, type 'help' for the list. Each command is checked as it is given: a");
+	fmt.Println("   road needs both of its cities and a free direction at each end, and is added and");
+	fmt.Println("   removed together with its opposite.");
+	fmt.Println("   -commands F  Run the commands in F instead, then save the map. The first command");
+	fmt.Println("                that fails stops the editor and nothing is saved.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Map merging mode usage: ");
+	fmt.Println("   ais -merge <MAPFILE_A> <MAPFILE_B> <OUTFILE> [options]");
+	fmt.Println();
+	fmt.Println("   Writes the cities and roads of two maps to one map file. The cities of the two");
+	fmt.Println("   maps must have different names.");
+	fmt.Println("   -road A:DIR:B    Also connect city A of the first map to city B of the second with");
+	fmt.Println("                    a road in direction DIR (and its opposite). Can be repeated.");
+	fmt.Println("   -prefix-a P      Prefix the names of the cities of the first map with P.");
+	fmt.Println("   -prefix-b P      Prefix the names of the cities of the second map with P.");
+	fmt.Println("   -overwrite       Replace <OUTFILE> if it exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Experiment mode usage: ");
+	fmt.Println("   ais -experiment <FILE> [options]");
+	fmt.Println();
+	fmt.Println("   Runs every combination of the maps (files, or generated from sizes and densities),");
+	fmt.Println("   option profiles, swept options, alien counts, strategies and seeds listed in the");
+	fmt.Println("   YAML experiment file <FILE>, and writes one row per run with its parameters and");
+	fmt.Println("   summary to a CSV file (or JSON, if the file name ends in .json). See experiment.go");
+	fmt.Println("   for the format of the file.");
+	fmt.Println("   -out F        Write the results to F (default: 'output' in the experiment file, or");
+	fmt.Println("                 <FILE> with a .csv extension).");
+	fmt.Println("   -workers N    Simulate N runs at the same time (default: 'workers' in the file, or");
+	fmt.Println("                 one per CPU). The results don't depend on it.");
+	fmt.Println("   -overwrite    Replace the results file if it exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Render mode usage: ");
+	fmt.Println("   ais -render <MAPFILE> <OUTFILE> [options]");
+	fmt.Println();
+	fmt.Println("   <MAPFILE>    Map to draw, or the result map of a simulation (<MAP>.result), which is");
+	fmt.Println("                drawn over its map with the destroyed cities and roads. Grid maps (city");
+	fmt.Println("                names that encode the coordinates, e.g. 'X3Y7', or roads that fit in a");
+	fmt.Println("                grid) are drawn on their grid, and other maps with a force-directed");
+	fmt.Println("                layout (up to 2000 cities).");
+	fmt.Println("   <OUTFILE>    Name of the SVG or PNG file to write (by its suffix).");
+	fmt.Println();
+	fmt.Println("   -map M       The map of the result map being drawn, if it is not <MAP>.");
+	fmt.Println("   -positions F Draw the aliens left at the end at the positions in F, as written by a");
+	fmt.Println("                simulation run with -positions (default: <MAP>.positions, if it exists).");
+	fmt.Println("   -paths F     Overlay the alien paths recorded in F by a simulation run with -paths.");
+	fmt.Println("   -aliens L    Only draw the paths of the aliens in the comma-separated list L.");
+	fmt.Println("   -layout L    auto (the default), grid or force.");
+	fmt.Println("   -overwrite   Replace <OUTFILE> if it already exists.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Server mode usage: ");
+	fmt.Println("   ais -serve <ADDR>");
+	fmt.Println();
+	fmt.Println("   Serves a REST API on <ADDR> (e.g. ':8080') to upload maps (POST /maps), start");
+	fmt.Println("   simulations (POST /simulations), poll them (GET /simulations/{id}) and download");
+	fmt.Println("   their results and events as JSON (GET /simulations/{id}/result and .../events).");
+	fmt.Println("   Events are also streamed live over a WebSocket at /simulations/{id}/stream.");
+	fmt.Println("   GET /metrics serves counters of all the simulations (running, steps, fights, cities");
+	fmt.Println("   destroyed, rejected maps and requests...) for Prometheus.");
+	fmt.Println();
+	fmt.Println();
+	fmt.Println("Exit status: ");
+	fmt.Println("   0  Success.");
+	fmt.Println("   1  Any other error, e.g. a file that can't be read or written, or a baseline check");
+	fmt.Println("      that failed.");
+	fmt.Println("   2  Invalid command line.");
+	fmt.Println("   3  A map file that can't be parsed (or that is invalid, in validation mode).");
+	fmt.Println("   4  A simulation that was refused by -max-memory, or that failed while running.");
 	fmt.Println();
 }
 
@@ -98,10 +460,12 @@ func printHelp() {
 // Map file generator
 // ---------------------------------------------------------------------------------------------------
 
-func generate(mapfile string, maxx int, maxy int, cd float64, rd float64) {
-	fmt.Printf("Will write mapfile '%s' with dimensions %d x %d, city density %f and road density %f.\n", mapfile, maxx, maxy, cd, rd);
-
-	wmap := make([][]Node, maxy);
+// Builds a random world of "maxx" by "maxy" nodes, where each node has a city with probability
+//   "cd" and each pair of adjacent cities is connected by a road with probability "rdEW" (for
+//   east-west roads) or "rdNS" (for north-south roads).
+// The "topology" (see topology.go) decides which nodes are adjacent.
+func generateWorld(maxx int, maxy int, cd float64, rdEW float64, rdNS float64, topology string) World {
+	wmap := make(World, maxy);
 
 	// Generate cities first, placing them freely over the world matrix.
 	// The city names generated here are boring; it's just a string with the city coordinates.
@@ -110,7 +474,7 @@ func generate(mapfile string, maxx int, maxy int, cd float64, rd float64) {
 		row := make([]Node, maxx)
 		for x := 0; x < maxx; x++ {
 			if (rnd.Float64() <= cd) {
-				row[x].cityName = fmt.Sprintf("X%dY%d", x, y)
+				row[x].cityName = cityNameAt(x, y)
 			} else {
 				row[x].cityName = ""
 			}
@@ -125,468 +489,238 @@ func generate(mapfile string, maxx int, maxy int, cd float64, rd float64) {
 			if (wmap[y][x].cityName != "") {
 
 				// Consider creating an EAST road to connect City X,Y to City X+1,Y
-				if (x < maxx - 1) && (wmap[y][x+1].cityName != "") {
-					wmap[y][x].roads[EAST] = rnd.Float64() <= rd
+				if (adjacent(topology, maxx, maxy, x, y, EAST)) {
+					nx, ny := wmap.neighbor(x, y, EAST)
+					if (wmap[ny][nx].cityName != "") {
+						wmap[y][x].roads[EAST] = rnd.Float64() <= rdEW
+					}
 				}
 
 				// Consider creating a SOUTH road to connect City X,Y to City X,Y+1
-				if (y < maxy - 1) && (wmap[y+1][x].cityName != "") {
-					wmap[y][x].roads[SOUTH] = rnd.Float64() <= rd
+				if (adjacent(topology, maxx, maxy, x, y, SOUTH)) {
+					nx, ny := wmap.neighbor(x, y, SOUTH)
+					if (wmap[ny][nx].cityName != "") {
+						wmap[y][x].roads[SOUTH] = rnd.Float64() <= rdNS
+					}
 				}
 			}
 		}
 	}
 
-	// Serialize the generated world model to the text file
-	// This serializer is optimized to this generator; it doesn't generate north= and west=
-	//   roads. However, the file reader in simulate() understands those if you give it a
-	//   file provided by a source that uses them.
+	return wmap
+}
 
-	file, err := os.Create(mapfile)
-	if (err != nil) {
-		fmt.Printf("ERROR: Cannot write to output file '%s'.\n", mapfile)
-	} else {
-		defer file.Close()
-
-		for y := 0; y < maxy; y++ {
-			for x := 0; x < maxx; x++ {
-            cname := wmap[y][x].cityName
-            if (cname != "") {
-               s := fmt.Sprintf("%s", cname)
-               if (wmap[y][x].roads[EAST]) {
-                  s += fmt.Sprintf(" east=%s", wmap[y][x+1].cityName)
-               }
-               if (wmap[y][x].roads[SOUTH]) {
-                  s += fmt.Sprintf(" south=%s", wmap[y+1][x].cityName)
-               }
-               s += "\n"
-               file.WriteString(s)
-            }
+// Counts the cities and roads in a world.
+func (wmap World) stats() (cities int, roads int) {
+	for y := 0; y < len(wmap); y++ {
+		for x := 0; x < len(wmap[y]); x++ {
+			if (wmap[y][x].cityName != "") {
+				cities ++
+				if (wmap[y][x].roads[EAST]) { roads ++ }
+				if (wmap[y][x].roads[SOUTH]) { roads ++ }
 			}
 		}
 	}
-
-	fmt.Println("Done.");
+	return cities, roads
 }
 
-// ---------------------------------------------------------------------------------------------------
-// Map file parser and simulator
-// ---------------------------------------------------------------------------------------------------
-
-func simulate(mapfile string, numaliens int) {
-	fmt.Printf("Will read mapfile '%s' and simulate it with %d aliens.\n", mapfile, numaliens)
-
-	var nodes SNodeArray = nil
-	var nodeMap SNodeMap =  make(map[string]int)
-
-	// ---------------------------------------------------------------------------------------------------
-	// Read map file.
-	// ---------------------------------------------------------------------------------------------------
-
-	file, err := os.Open(mapfile)
-	if (err != nil) {
-		fmt.Printf("ERROR: Cannot read from input file '%s'.\n", mapfile)
-		return
-	}
-	defer file.Close()
-
-	// Each new SNode is pushed to the end of the SNodeArray
-	var nextIndex = 0;
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-
-		// Fetch a new line from the input file to process
-		line := scanner.Text()
-
-		// Line is some tokens separated by a space
-		items := strings.Split(line, " ")
-
-		// If the line isn't empty, it denotes a new city definition
-		if (len(items) >= 1) {
-
-			cityName := items[0];
-
-			// Forbid city redefinition
-			_, exists := nodeMap[cityName]
-			if (exists) {
-				fmt.Printf("ERROR: Duplicate city definition found: '%s'.\n", cityName)
-				return
-			}
-
-			// Allocate a new city struct with the city name and dummy road pointers
-			newNode := new(SNode);
-			newNode.cityName = cityName;
-			newNode.index    = nextIndex;
-			nextIndex ++;
-			newNode.roads    = [4]int   {-1, -1, -1, -1};
-			newNode.sroads   = [4]string{"", "", "", ""};
-			newNode.dead     = false;
-			newNode.alienid  = -1;
-
-			// Parse all DIRECTION=CITY items from this line and apply them to newNode.sroads
-			for i := 1; i < len(items); i++ {
-				inners := strings.Split(items[i], "=")
-				if (len(inners) != 2) {
-					fmt.Printf("ERROR: Syntax error parsing city connection in line '%s'.\n", line)
-					return
+// Serializes the generated world model in the map file format.
+// This serializer is optimized to this generator; it doesn't generate north= and west=
+//   roads. However, the file reader in simulate() understands those if you give it a
+//   file provided by a source that uses them.
+func (wmap World) write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	for y := 0; y < len(wmap); y++ {
+		for x := 0; x < len(wmap[y]); x++ {
+			cname := wmap[y][x].cityName
+			if (cname != "") {
+				s := fmt.Sprintf("%s", cname)
+				if (wmap[y][x].roads[EAST]) {
+					nx, ny := wmap.neighbor(x, y, EAST)
+					s += fmt.Sprintf(" east=%s", wmap[ny][nx].cityName)
 				}
-
-				// **********************************************
-				// FIXME: Make a name->int const map instead.
-				// **********************************************
-				var dir int;
-				switch inners[0] {
-				case "east":   dir = EAST;
-				case "south":  dir = SOUTH;
-				case "west":   dir = WEST;
-				case "north":  dir = NORTH;
-				default:
-					fmt.Printf("ERROR: Unknown cardinal direction '%s' in line '%s'.\n", inners[0], line);
-					return
+				if (wmap[y][x].roads[SOUTH]) {
+					nx, ny := wmap.neighbor(x, y, SOUTH)
+					s += fmt.Sprintf(" south=%s", wmap[ny][nx].cityName)
 				}
-
-				var neighborName = inners[1];
-				if (neighborName == cityName) {
-					fmt.Printf("ERROR: City '%s' is being defined as a neighbor of itself.\n", cityName)
-					return
+				s += "\n"
+				if _, err := bw.WriteString(s); err != nil {
+					return err
 				}
-				newNode.sroads[dir] = neighborName;
-			}
-
-			// Store the first-pass node data in the node array
-			nodes = append(nodes, *newNode);
-
-			// Update the node map that helps us find a city's index in the node array by its name
-			nodeMap[newNode.cityName] = newNode.index;
-
-			// FIXME: change to an assert
-			if (len(nodes) - 1 != newNode.index) {
-				fmt.Printf("ERROR: The file reader is broken. Expected index %i, found %i.\n", newNode.index, len(nodes) - 1)
-				return
 			}
 		}
 	}
+	return bw.Flush()
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("ERROR: Error encountered while parsing input file '%s'.\n", mapfile)
-		return
+// The coordinates of the node next to X,Y in the EAST or SOUTH direction. Coordinates past the
+//   last column or row wrap around, which only matters for the roads of a torus.
+func (wmap World) neighbor(x int, y int, dir int) (int, int) {
+	if (dir == EAST) {
+		return (x + 1) % len(wmap[y]), y
 	}
+	return x, (y + 1) % len(wmap)
+}
 
-	// ---------------------------------------------------------------------------------------------------
-	// Now we have read all of the cities from the file (we only do one reading pass on the file).
-	// Compile SNode.sroads to SNode.roads (convert city names into city node indices).
-	// We also check that north/south and east/west connections between adjacent cities are consistent.
-	// ---------------------------------------------------------------------------------------------------
-
-	fmt.Printf("Successfully read %d cities from the input file. Checking road links...\n", len(nodes))
-
-	for i := 0; i < len(nodes); i++ {
-
-		var node *SNode = &nodes[i]
-
-		for d := 0; d < 4; d++ {
-
-			neighborName := node.sroads[d];
-
-			if (neighborName == "") {
-				continue
-			}
-
-			idx, ok := nodeMap[neighborName];
-			if (! ok) {
-				fmt.Printf("ERROR: City '%s' references an adjacent but non-existing city '%s'.\n", node.cityName, neighborName)
-				return
-			}
-
-			node.roads[d] = idx;
-
-			// Now, either the neighbor hasn't defined the backlink to us, or if they did, it must point
-			//   to us as well. If they did not define it, we will set it now.
-			// (We use the node name for this check, since it is filled up from the previous pass)
+// Writes a generated world to a map file, atomically.
+func saveWorld(mapfile string, wmap World) error {
+	return writeFileAtomic(mapfile, true, wmap.write)
+}
 
-			// This converds the ESWN "d" into its cardinal opposite as "od"
-			// E.g. NORTH ( 3 ), becomes SOUTH ( 1 ).
-			od := d;
-			od += 2;
-			od = od % 4;
+// Map generator options that are given as optional flags after the positional arguments.
+type GenOptions struct {
+	Topology   string   // How the nodes are connected (see topology.go)
+	Connected  bool     // Add roads and cities as needed to leave all cities in a single connected component
+	Symmetry   string   // Make the map symmetric (see symmetry.go), or "" for none
+	Names      string   // How the cities are named (see newCityNamer)
+	RDEW       float64  // Density of the east-west roads, or -1 to use the road density of the map
+	RDNS       float64  // Density of the north-south roads, or -1 to use the road density of the map
+}
 
-			var neighNode *SNode = &nodes[idx];
+// The map generator options when no flags are given.
+func defaultGenOptions() GenOptions {
+	return GenOptions{ Topology: TOPOLOGY_GRID, Names: "coords", RDEW: -1, RDNS: -1 }
+}
 
-			if (neighNode.sroads[od] == "") || (neighNode.sroads[od] == node.cityName) {
-				neighNode.roads[od] = node.index;
-			} else {
-				fmt.Printf("ERROR: City '%s' declares a %d road to city '%s', but the inverse %d road points to '%s' instead.\n",
-					node.cityName, d, neighNode.cityName, od, neighNode.sroads[od])
-				return
-			}
-		}
+// The densities of the east-west and north-south roads of a map with road density "rd".
+func (opts GenOptions) roadDensities(rd float64) (float64, float64) {
+	rdEW, rdNS := rd, rd
+	if (opts.RDEW >= 0) {
+		rdEW = opts.RDEW
 	}
-
-	fmt.Println("Done reading input file.");
-
-	// ---------------------------------------------------------------------------------------------------
-	// Alien spawn phase.
-	// Spawn the aliens randomly, one after the other.
-	// If two aliens are spawned in the same city, they die and the city is destroyed.
-	// If we run out of cities before all aliens are spawned, the simulation ends and no result file
-	//   is written (empty city).
-	// ---------------------------------------------------------------------------------------------------
-
-	fmt.Printf("\nSimulation Phase #1: Spawning %d aliens at random cities.\n", numaliens);
-
-	var liveAlienCounter = 0
-
-	var aliens AlienArray = make([]int, numaliens);
-
-	// Initialize all aliens as dead (FIXME: surely there's a better way to do this)
-
-	for i := 0; i < numaliens; i++ {
-		aliens[i] = -1
+	if (opts.RDNS >= 0) {
+		rdNS = opts.RDNS
 	}
+	return rdEW, rdNS
+}
 
-	// Place aliens in sequence.
-
-	for i := 0; i < numaliens; i++ {
-
-		// Choose a random city index to place the next alien.
-
-		chosenCityIndex := -1;
-		tryCityIndex := rnd.Intn(len(nodes));
-
-		for cs := 0; cs < len(nodes); cs ++ {
-
-			// Attempt to place alien in the city pointed by the index.
-			// If that city was already destroyed, try the next city in the array.
-
-			if (! nodes[tryCityIndex].dead) {
-				chosenCityIndex = tryCityIndex
-				break
-			}
-
-			tryCityIndex ++
-			if (tryCityIndex >= len(nodes)) {
-				tryCityIndex = 0
-			}
-		}
-
-		// Check if we have zero cities left.
-
-		if (chosenCityIndex == -1) {
-			fmt.Printf("Simulation has ended at Phase #1: no cities left to place Alien #%d. The resulting map is empty (no result map file written).\n", i)
-			return
-		}
-
-		// Place the alien.
-
-		aliens[i] = chosenCityIndex
-		liveAlienCounter ++
-
-		// Check if that alien placement caused a fight.
-		// If it did, destroy the city and the two aliens involved.
-
-		existingAlienIdx := nodes[chosenCityIndex].alienid
-
-		if (existingAlienIdx != -1) {
-
-			fmt.Printf("City '%s' has been destroyed by spawning Alien #%d on top of Alien #%d!\n", nodes[chosenCityIndex].cityName, i, existingAlienIdx)
-
-			// Just mark the city as dead
-			nodes[chosenCityIndex].dead = true
-
-			// Dead aliens are in no city
-			aliens[i] = -1
-			aliens[existingAlienIdx] = -1
-
-			liveAlienCounter -= 2
-		} else {
-
-			// No fight, so just cache the alien's city location in the city node itself
-			nodes[chosenCityIndex].alienid = i;
+// Checks the per-direction road densities, which only make sense for maps with a geometry.
+func checkRoadDensities(opts GenOptions) error {
+	for _, d := range []float64{ opts.RDEW, opts.RDNS } {
+		if (d != -1) && ((d < 0) || (d > 1)) {
+			return fmt.Errorf("Per-direction road densities must be in the [0, 1] range")
 		}
 	}
-
-	// ---------------------------------------------------------------------------------------------------
-	// Alien movement phase
-	// ---------------------------------------------------------------------------------------------------
-
-	fmt.Println("\nSimulation Phase #2: Moving aliens.\n");
-
-	// We are going to run at most 10,000 movement steps.
-	// Each movement step involves moving each alien randomly across a valid road to a city that has
-	//   not been destroyed (some aliens can be trapped and unable to move, but if there IS a single
-	//   valid path out of their current city, they must be able to take it).
-
-	var dot bool = false;
-	var percent int = 0;
-	const maxIter int = 10000;
-	
-	for r := 0; r < maxIter; r++ {
-
-		if (liveAlienCounter <= 0) {
-			fmt.Printf("We have %d aliens left alive at iteration %d. Stopping the simulator.\n", liveAlienCounter, r)
-			break
-		}
-
-		for i := 0; i < numaliens; i++ {
-
-			if (aliens[i] == -1) {
-				continue    // skip movement on dead aliens
-			}
-
-			// Get a reference to the simulation node where Alien #"i" is
-
-			var anode *SNode = &nodes[aliens[i]]
-
-			// Choose one of the four directions to roam
-
-			chosenDirection := -1;
-			destCityIndex   := -1;
-			tryDirection    := rnd.Intn(4);
-
-			for dr := 0; dr < 4; dr ++ {
-
-				// Check if that direction is a valid movement direction
-
-				destCityIndex = anode.roads[tryDirection]
-
-				// Skip roads to nowhere (-1) and roads to cities that are already dead
-				if (destCityIndex != -1) && (! nodes[destCityIndex].dead) {
-					chosenDirection = tryDirection
-					break
-				}
-
-				tryDirection ++
-				if (tryDirection >= 4) { // FIXME: replace all magic "4"s with MAX_DIRECTION
-					tryDirection = 0
-				}
-			}
-
-			// Check if the alien has nowhere to go.
-
-			if (chosenDirection == -1) {
-				continue // Alien is just trapped.
-			}
-
-			// Move the alien.
-
-			// FIXME: Should be an assert.
-			if (destCityIndex == -1) || (nodes[destCityIndex].dead) {
-				fmt.Println("ERROR: Simulator has a bug, moving Alien #%d to a bad destCityIndex %d.", i, destCityIndex)
-				return
-			}
-
-			nodes[aliens[i]].alienid = -1    // remove this alien from the previous location's alienid cache
-
-			aliens[i] = destCityIndex;
-
-			// Check if the destination city (where alien i moved in) didn't already have an alien in it.
-			// If so, they fight, both die and the city is destroyed.
-
-			existingAlienIdx := nodes[destCityIndex].alienid
-
-			if (existingAlienIdx != -1) {
-
-				if (dot) {
-					dot = false
-					fmt.Printf("\n")
-				}
-				
-				fmt.Printf("City '%s' has been destroyed by Alien #%d and Alien #%d!\n", nodes[destCityIndex].cityName, i, existingAlienIdx)
-
-				// Just mark the city as dead
-				nodes[destCityIndex].dead = true
-
-				// Dead aliens are in no city
-				aliens[i] = -1
-				aliens[existingAlienIdx] = -1
-
-				liveAlienCounter -= 2
-			} else {
-
-				// Cache the alien into the new location
-				nodes[destCityIndex].alienid = i
-			}
-		}
-
-		fmt.Printf(".")
-		dot = true
-
-		var newPercent int = 100 * r / maxIter;
-		if (newPercent > percent) {
-			percent = newPercent
-			fmt.Printf("(%d%%)", percent);
-		}
+	if (opts.Topology == TOPOLOGY_RANDOM_GRAPH) && ((opts.RDEW != -1) || (opts.RDNS != -1)) {
+		return fmt.Errorf("Per-direction road densities are not supported by the %s topology", opts.Topology)
 	}
+	return nil
+}
 
-	fmt.Printf("\nSimulation complete. Aliens remaining alive: %d\n", liveAlienCounter);
-
-	// ---------------------------------------------------------------------------------------------------
-	// Serialize the simulator data model to "<mapfile>.result"
-	// ---------------------------------------------------------------------------------------------------
-
-	resultFileName := mapfile + ".result"
-
-	fmt.Printf("\nWriting resulting map file to '%s'.\n", resultFileName);
+// Creates the flag set for the map generator options. The current values in "opts" are the defaults.
+func genFlags(name string, opts *GenOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&opts.Topology, "topology", opts.Topology, "grid, torus, hex or random-graph")
+	flags.StringVar(&opts.Symmetry, "symmetry", opts.Symmetry, "horizontal, vertical or rotational")
+	flags.BoolVar(&opts.Connected, "connected", opts.Connected, "add roads and cities to leave a single connected component")
+	flags.StringVar(&opts.Names, "names", opts.Names, "city names: coords, syllables, or a word list file")
+	flags.Float64Var(&opts.RDEW, "rd-ew", opts.RDEW, "density of the east-west roads, instead of <RD>")
+	flags.Float64Var(&opts.RDNS, "rd-ns", opts.RDNS, "density of the north-south roads, instead of <RD>")
+	return flags
+}
 
-	ofile, oerr := os.Create(resultFileName)
-	if (oerr != nil) {
-		fmt.Printf("ERROR: Cannot write to simulation result output file '%s'.\n", resultFileName)
+// Generates a random world and writes it to a map file.
+func generate(mapfile string, maxx int, maxy int, cd float64, rd float64, opts GenOptions) error {
+	rdEW, rdNS := opts.roadDensities(rd)
+	if (rdEW == rdNS) {
+		fmt.Printf("Will write mapfile '%s' with dimensions %d x %d, city density %f and road density %f.\n", mapfile, maxx, maxy, cd, rdEW);
 	} else {
-		defer ofile.Close()
+		fmt.Printf("Will write mapfile '%s' with dimensions %d x %d, city density %f and road densities %f (east-west) and %f (north-south).\n", mapfile, maxx, maxy, cd, rdEW, rdNS);
+	}
 
-		for i := 0; i < len(nodes); i++ {
+	namer, err := newCityNamer(opts.Names)
+	if (err != nil) {
+		return err
+	}
 
-			// Skip dead cities
-			if (nodes[i].dead) {
-				continue
-			}
+	if (opts.Topology == TOPOLOGY_RANDOM_GRAPH) {
+		return generateRandomGraph(mapfile, maxx, maxy, cd, rd, opts, namer)
+	}
 
-			// Line starts with the name of the non-destroyed city
-			line := nodes[i].cityName;
+	wmap := generateWorld(maxx, maxy, cd, rdEW, rdNS, opts.Topology)
 
-			// Then we look for all valid directions that link to other non-dead
-			//   cities and append them to the output line
-			for d := 0; d < 4; d++ {
+	if (opts.Symmetry != "") && (len(wmap) > 0) {
+		wmap.symmetrize(opts.Symmetry)
+	}
 
-				otherIdx := nodes[i].roads[d]
+	if (opts.Connected) {
+		newRoads, newCities := wmap.connect(opts.Topology)
+		if (opts.Symmetry != "") && (len(wmap) > 0) {
+			moreRoads, moreCities := wmap.closeSymmetry(opts.Symmetry)
+			newRoads += moreRoads
+			newCities += moreCities
+		}
+		fmt.Printf("Connected the map by adding %d roads and %d bridging cities.\n", newRoads, newCities);
+	}
 
-				// No road
-				if (otherIdx == -1) {
-					continue
-				}
+	if (opts.Names != "coords") {
+		wmap.rename(namer)
+	}
 
-				// Leads to dead city
-				if (nodes[otherIdx].dead) {
-					continue
-				}
+	if err := saveWorld(mapfile, wmap); err != nil {
+		return err
+	}
 
-				// It's good
+	cities, roads := wmap.stats()
+	printGenerated(cities, roads)
+	return nil
+}
 
-				otherCityName := nodes[otherIdx].cityName
-				directionName := "ERROR"
+// Reports the size of a generated map.
+func printGenerated(cities int, roads int) {
+	degree := 0.0
+	if (cities > 0) {
+		degree = 2 * float64(roads) / float64(cities)
+	}
+	fmt.Printf("Generated %d cities and %d roads (average degree %.3f).\n", cities, roads, degree);
 
-				// ****************************
-				// FIXME: Do it the right way
-				// ****************************
-				switch d {
-				case EAST:  directionName = "east"
-				case SOUTH: directionName = "south"
-				case WEST:  directionName = "west"
-				case NORTH: directionName = "north"
-				}
+	fmt.Println("Done.");
+}
 
-				line += " " + directionName + "=" + otherCityName;
-			}
+// Handles the command line of the map generation mode:
+//   -gen <MAPFILE> <MAXX> <MAXY> <CD> <RD> [-topology T] [-symmetry S] [-connected] [-names N]
+//        [-rd-ew RD] [-rd-ns RD]
+func mainGenerate(args []string) int {
+	opts := defaultGenOptions()
+	flags := genFlags("gen", &opts)
 
-			line += "\n";
+	if (len(args) < 5) {
+		fmt.Println("Too few arguments for map generation mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[5:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map generation mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	if err := checkTopology(opts.Topology); err != nil {
+		fmt.Printf("Generate: %s.\n", err);
+		return usageError()
+	}
+	if err := checkRoadDensities(opts); err != nil {
+		fmt.Printf("Generate: %s.\n", err);
+		return usageError()
+	}
 
-			// Write out the line
-			ofile.WriteString(line)
-		}
+	mapfile := args[0];
+	maxx, err1 := strconv.Atoi( args[1] );
+	maxy, err2 := strconv.Atoi( args[2] );
+	cd, err3 := strconv.ParseFloat( args[3], 64 );
+	rd, err4 := strconv.ParseFloat( args[4], 64 );
+	if (err1 != nil) || (err2 != nil) || (err3 != nil) || (err4 != nil) {
+		fmt.Println("Generate: Error parsing numeric arguments.");
+		return usageError()
+	}
+	if err := checkSymmetry(opts.Symmetry, opts.Topology, maxx, maxy); err != nil {
+		fmt.Printf("Generate: %s.\n", err);
+		return usageError()
 	}
 
-	fmt.Println("Done.");
+	return reportError(generate(mapfile, maxx, maxy, cd, rd, opts))
 }
 
 // ---------------------------------------------------------------------------------------------------
@@ -594,50 +728,61 @@ func simulate(mapfile string, numaliens int) {
 // ---------------------------------------------------------------------------------------------------
 
 func main() {
-	fmt.Println("Alien Invasion Simulator!\n")
+	if (writesToStdout(os.Args[1:])) {
+		logToStderr()
+	}
 
+	if (! logsQuietly(os.Args[1:])) {
+		fmt.Println("Alien Invasion Simulator!")
+		fmt.Println()
+	}
+
+   var code int
    if (len(os.Args) < 2) {
       fmt.Println("No arguments given.");
-      printHelp();
+      code = usageError()
    } else if (os.Args[1] == "-gen") {
-      if (len(os.Args) < 7) {
-         fmt.Println("Too few arguments for map generation mode.");
-         printHelp();
-      } else if (len(os.Args) > 7) {
-         fmt.Printf("Too many arguments for map generation mode: '%s'.\n", os.Args[7]);
-         printHelp();
-      } else {
-			mapfile := os.Args[2];
-			maxx, ok := strconv.Atoi( os.Args[3] );
-			maxy, ok := strconv.Atoi( os.Args[4] );
-			cd, ok := strconv.ParseFloat( os.Args[5], 64 );
-			rd, ok := strconv.ParseFloat( os.Args[6], 64 );
-			if (ok != nil) {
-				fmt.Println("Generate: Error parsing numeric arguments.");
-				printHelp();
-			} else {
-				generate(mapfile, maxx, maxy, cd, rd);
-			}
-      }
-   } else if (os.Args[1][0] == '-') {
+      code = mainGenerate(os.Args[2:])
+   } else if (os.Args[1] == "-calibrate") {
+      code = mainCalibrate(os.Args[2:])
+   } else if (os.Args[1] == "-validate") {
+      code = mainValidate(os.Args[2:])
+   } else if (os.Args[1] == "-analyze") {
+      code = mainAnalyze(os.Args[2:])
+   } else if (os.Args[1] == "-transform") {
+      code = mainTransform(os.Args[2:])
+   } else if (os.Args[1] == "-compare") {
+      code = mainCompare(os.Args[2:])
+   } else if (os.Args[1] == "-diff") {
+      code = mainDiff(os.Args[2:])
+   } else if (os.Args[1] == "-anonymize") || (os.Args[1] == "anonymize") {
+      code = mainAnonymize(os.Args[2:])
+   } else if (os.Args[1] == "-demo") || (os.Args[1] == "demo") {
+      code = mainDemo(os.Args[2:])
+   } else if (os.Args[1] == "-tournament") || (os.Args[1] == "tournament") {
+      code = mainTournament(os.Args[2:])
+   } else if (os.Args[1] == "-check-baseline") || (os.Args[1] == "check-baseline") {
+      code = mainCheckBaseline(os.Args[2:])
+   } else if (os.Args[1] == "-resume") {
+      code = mainResume(os.Args[2:])
+   } else if (os.Args[1] == "-serve") {
+      code = mainServe(os.Args[2:])
+   } else if (os.Args[1] == "-render") {
+      code = mainRender(os.Args[2:])
+   } else if (os.Args[1] == "-import") {
+      code = mainImport(os.Args[2:])
+   } else if (os.Args[1] == "-edit") {
+      code = mainEdit(os.Args[2:])
+   } else if (os.Args[1] == "-merge") {
+      code = mainMerge(os.Args[2:])
+   } else if (os.Args[1] == "-experiment") {
+      code = mainExperiment(os.Args[2:])
+   } else if (os.Args[1][0] == '-') && (os.Args[1] != STDIO) {
 		fmt.Printf("Unsupported command: '%s'\n", os.Args[1]);
-		printHelp();
+		code = usageError()
    } else {
-      if (len(os.Args) < 3) {
-         fmt.Println("Too few arguments for simulation mode.");
-         printHelp();
-      } else if (len(os.Args) > 3) {
-         fmt.Printf("Too many arguments for simulation mode: '%s'.\n", os.Args[3]);
-         printHelp();
-      } else {
-			mapfile := os.Args[1];
-			numaliens, ok := strconv.Atoi( os.Args[2] );
-			if (ok != nil) {
-				print("Simulate: Error parsing numeric arguments.");
-				printHelp();
-			} else {
-				simulate(mapfile, numaliens);
-			}
-      }
+      code = mainSimulate(os.Args[1:])
    }
+
+   os.Exit(code)
 }
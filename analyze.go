@@ -0,0 +1,175 @@
+/*
+   Alien Invasion Simulator - map graph analysis
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The maximum number of city names listed for each kind of city in the analysis report.
+const ANALYZE_MAX_LISTED = 10
+
+// The topology of a map, as computed by analyzeMap.
+type MapAnalysis struct {
+	cities      int
+	roads       int
+	components  []int       // Size of each connected component, largest first
+	isolated    []int       // Cities with no roads
+	deadEnds    []int       // Cities with exactly one road
+	diameter    int         // Lower bound on the diameter of the largest component
+}
+
+// Visits the cities reachable from "start" in breadth-first order, stopping at cities already
+//   marked in "seen" (which is updated). Calls "visit" with each city and its road distance from
+//   "start", and returns the number of cities visited.
+func bfs(nodes SNodeArray, start int, seen []bool, visit func(city int, dist int)) int {
+	queue := []int{start}
+	dist := []int{0}
+	seen[start] = true
+	for i := 0; i < len(queue); i++ {
+		visit(queue[i], dist[i])
+		for _, road := range nodes[queue[i]].roads {
+			if next := road.to; (! seen[next]) {
+				seen[next] = true
+				queue = append(queue, next)
+				dist = append(dist, dist[i] + 1)
+			}
+		}
+	}
+	return len(queue)
+}
+
+// Finds the city farthest away from "start" and its distance.
+func farthest(nodes SNodeArray, start int) (int, int) {
+	far, farDist := start, 0
+	bfs(nodes, start, make([]bool, len(nodes)), func(city int, dist int) {
+		if (dist > farDist) {
+			far, farDist = city, dist
+		}
+	})
+	return far, farDist
+}
+
+// Computes the connected components, isolated cities, dead ends and an estimate of the diameter
+//   of a map.
+// The diameter is estimated with repeated double sweeps (a BFS from the city found farthest away
+//   by the previous one), which gives a lower bound that is exact for trees and usually close for
+//   grids, at the cost of a few BFS passes instead of one per city.
+func analyzeMap(nodes SNodeArray) MapAnalysis {
+	var a MapAnalysis
+	a.cities = len(nodes)
+
+	degrees := 0
+	for i := 0; i < len(nodes); i++ {
+		degree := len(nodes[i].roads)
+		degrees += degree
+		if (degree == 0) {
+			a.isolated = append(a.isolated, i)
+		} else if (degree == 1) {
+			a.deadEnds = append(a.deadEnds, i)
+		}
+	}
+	a.roads = degrees / 2
+
+	seen := make([]bool, len(nodes))
+	largest, largestSize := -1, 0
+	for i := 0; i < len(nodes); i++ {
+		if (! seen[i]) {
+			size := bfs(nodes, i, seen, func(int, int) {})
+			a.components = append(a.components, size)
+			if (size > largestSize) {
+				largest, largestSize = i, size
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(a.components)))
+
+	if (largest != -1) {
+		city := largest
+		for sweep := 0; sweep < 4; sweep++ {
+			next, dist := farthest(nodes, city)
+			if (dist <= a.diameter) && (sweep > 0) {
+				break
+			}
+			a.diameter = dist
+			city = next
+		}
+	}
+	return a
+}
+
+// The names of the first ANALYZE_MAX_LISTED cities in a list, for the report.
+func cityNames(nodes SNodeArray, cities []int) string {
+	var names []string
+	for i, city := range cities {
+		if (i == ANALYZE_MAX_LISTED) {
+			names = append(names, fmt.Sprintf("... (%d more)", len(cities) - i))
+			break
+		}
+		names = append(names, nodes[city].cityName)
+	}
+	return strings.Join(names, " ")
+}
+
+// Prints the topology report of a map file.
+func analyze(mapfile string) error {
+	fmt.Printf("Will read mapfile '%s' and analyze it.\n", mapfile)
+
+	nodes, _, err := loadMap(mapfile)
+	if (err != nil) {
+		return err
+	}
+
+	a := analyzeMap(nodes)
+	percent := func(n int) float64 {
+		if (a.cities == 0) {
+			return 0
+		}
+		return 100 * float64(n) / float64(a.cities)
+	}
+	degree := 0.0
+	if (a.cities > 0) {
+		degree = 2 * float64(a.roads) / float64(a.cities)
+	}
+
+	fmt.Println("\nAnalysis:");
+	fmt.Printf("   Cities:                %d\n", a.cities);
+	fmt.Printf("   Roads:                 %d\n", a.roads);
+	fmt.Printf("   Average degree:        %.3f\n", degree);
+	fmt.Printf("   Connected components:  %d\n", len(a.components));
+	if (len(a.components) > 0) {
+		fmt.Printf("   Largest component:     %d cities (%.1f%%)\n", a.components[0], percent(a.components[0]));
+		fmt.Printf("   Diameter (estimate):   %d roads, in the largest component\n", a.diameter);
+	}
+	fmt.Printf("   Isolated cities:       %d (%.1f%%)\n", len(a.isolated), percent(len(a.isolated)));
+	if (len(a.isolated) > 0) {
+		fmt.Printf("      %s\n", cityNames(nodes, a.isolated));
+	}
+	fmt.Printf("   Dead ends (one road):  %d (%.1f%%)\n", len(a.deadEnds), percent(len(a.deadEnds)));
+	if (len(a.deadEnds) > 0) {
+		fmt.Printf("      %s\n", cityNames(nodes, a.deadEnds));
+	}
+
+	// Aliens spawned at an isolated city are trapped from the start.
+	if (len(a.isolated) > 0) {
+		fmt.Printf("\nAliens spawned at random are trapped from the start %.1f%% of the time.\n", percent(len(a.isolated)))
+	}
+	return nil
+}
+
+// Handles the command line of the map analysis mode: -analyze <MAPFILE>
+func mainAnalyze(args []string) int {
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for map analysis mode.");
+		return usageError()
+	} else if (len(args) > 1) {
+		fmt.Printf("Too many arguments for map analysis mode: '%s'.\n", args[1]);
+		return usageError()
+	} else {
+		return reportError(analyze(args[0]))
+	}
+}
@@ -0,0 +1,101 @@
+/*
+   Alien Invasion Simulator - map anonymizer
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"bufio"
+	"flag"
+)
+
+// Replaces every city name in a map file with a pseudonym, keeping the topology intact, so that
+//   maps can be shared (e.g. in bug reports) without disclosing the real city names.
+// The pseudonyms are deterministic: they only depend on the position of the city in the file,
+//   so anonymizing the same file twice gives the same output.
+// Writes the anonymized map to "<mapfile>.anon" and the pseudonym -> real name mapping to
+//   "<mapfile>.anon.names", one "PSEUDONYM REALNAME" pair per line (see anonFiles).
+func anonymize(mapfile string, overwrite bool) error {
+	fmt.Printf("Will read mapfile '%s' and anonymize it.\n", mapfile)
+
+	anonFileName, namesFileName := anonFiles(mapfile)
+	if (! overwrite) {
+		for _, f := range []string{ anonFileName, namesFileName } {
+			if err := checkNoOverwrite(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	nodes, _, err := loadMap(mapfile)
+	if (err != nil) {
+		return err
+	}
+
+	realNames := make([]string, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		realNames[i] = nodes[i].cityName
+		nodes[i].cityName = pseudonym(i)
+	}
+
+	fmt.Printf("Writing anonymized map file to '%s'.\n", anonFileName)
+
+	if err := saveMap(anonFileName, nodes, overwrite); err != nil {
+		return err
+	}
+
+	fmt.Printf("Writing city name mapping to '%s'.\n", namesFileName)
+
+	err = writeFileAtomic(namesFileName, overwrite, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for i := 0; i < len(nodes); i++ {
+			if _, err := fmt.Fprintf(bw, "%s %s\n", nodes[i].cityName, realNames[i]); err != nil {
+				return err
+			}
+		}
+		return bw.Flush()
+	})
+	if (err != nil) {
+		return err
+	}
+
+	fmt.Println("Done.");
+	return nil
+}
+
+// The names of the anonymized map and of the name mapping of "mapfile", named like the other
+//   output files (see outputFile). The anonymized map of a compressed map file is compressed too.
+func anonFiles(mapfile string) (string, string) {
+	anonFileName := outputFile(mapfile, ".anon")
+	if (gzipped(mapfile)) {
+		anonFileName += GZIP_SUFFIX
+	}
+	return anonFileName, outputFile(mapfile, ".anon.names")
+}
+
+// The pseudonym of the i-th city of a map file.
+func pseudonym(i int) string {
+	return fmt.Sprintf("C%d", i + 1)
+}
+
+// Handles the command line of the map anonymizer mode: anonymize <MAPFILE> [-overwrite]
+func mainAnonymize(args []string) int {
+	var overwrite bool
+	flags := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.BoolVar(&overwrite, "overwrite", false, "replace existing output files")
+
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for map anonymizer mode.");
+		return usageError()
+	} else if (flags.Parse(args[1:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map anonymizer mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else {
+		return reportError(anonymize(args[0], overwrite))
+	}
+}
@@ -0,0 +1,177 @@
+/*
+   Alien Invasion Simulator - summary checks against a baseline
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"flag"
+	"math"
+	"strconv"
+	"strings"
+	"encoding/json"
+)
+
+// The summary fields (see Summary) that are checked against a baseline. Numeric metrics may drift
+//   within the tolerance; the others must be equal.
+var baselineMetrics = []string{
+	"iterations", "stop_reason", "cities_destroyed", "aliens_alive", "cities_visited",
+	"aliens_repelled", "aliens_lost", "human_casualties", "defender_kills",
+}
+
+// The result of checking one metric.
+type MetricCheck struct {
+	name      string
+	baseline  string
+	run       string
+	drift     float64   // Relative difference to the baseline (+Inf if the baseline is 0 and the run is not)
+	ok        bool
+}
+
+// Reads a summary file as a map of its JSON fields, so that summaries written by other versions
+//   of the program can be compared too.
+func loadSummaryFields(filename string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot read from summary file '%s'", filename)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("Cannot parse summary file '%s': %v", filename, err)
+	}
+	return fields, nil
+}
+
+// Compares the key metrics of a run summary with a baseline summary. Metrics missing from the
+//   baseline are skipped; metrics missing from the run fail. "tolerance" is the largest relative
+//   drift allowed for numeric metrics (e.g. 0.05 for 5%).
+func checkBaseline(run map[string]interface{}, baseline map[string]interface{}, tolerance float64) []MetricCheck {
+	var checks []MetricCheck
+	for _, name := range baselineMetrics {
+		b, ok := baseline[name]
+		if (! ok) {
+			continue
+		}
+		r, present := run[name]
+		c := MetricCheck{ name: name, baseline: fmt.Sprint(b), run: "(missing)" }
+		if (present) {
+			c.run = fmt.Sprint(r)
+		}
+
+		bn, bnum := b.(float64)
+		rn, rnum := r.(float64)
+		switch {
+		case (! present):
+			c.drift = math.Inf(1)
+		case (bnum) && (rnum):
+			if (bn == rn) {
+				c.drift = 0
+			} else if (bn == 0) {
+				c.drift = math.Inf(1)
+			} else {
+				c.drift = math.Abs(rn - bn) / math.Abs(bn)
+			}
+			c.ok = (c.drift <= tolerance)
+		default:
+			c.ok = (c.run == c.baseline)
+			if (! c.ok) {
+				c.drift = math.Inf(1)
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// Parses a tolerance given as a percentage, with or without the percent sign (e.g. "5%" or "5").
+func parseTolerance(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if (err != nil) || (pct < 0) || (math.IsInf(pct, 0)) || (math.IsNaN(pct)) {
+		return 0, fmt.Errorf("Invalid tolerance '%s' (must be a percentage like '5%%')", s)
+	}
+	return pct / 100, nil
+}
+
+// Checks a run summary against a baseline summary, printing a report. Returns false if some
+//   metric drifted beyond the tolerance, or if the files can't be read.
+func compareBaseline(runfile string, basefile string, tolerance float64) bool {
+	fmt.Printf("Will check summary '%s' against baseline '%s' with a tolerance of %g%%.\n", runfile, basefile, tolerance * 100)
+
+	run, err := loadSummaryFields(runfile)
+	if (err != nil) {
+		fmt.Printf("ERROR: %s.\n", err)
+		return false
+	}
+	baseline, err := loadSummaryFields(basefile)
+	if (err != nil) {
+		fmt.Printf("ERROR: %s.\n", err)
+		return false
+	}
+
+	checks := checkBaseline(run, baseline, tolerance)
+	failed := 0
+
+	fmt.Printf("\n   %-18s %16s %16s %9s\n", "Metric", "Baseline", "Run", "Drift")
+	for _, c := range checks {
+		drift := "-"
+		switch {
+		case (math.IsInf(c.drift, 1)):
+			drift = "inf"
+		case (c.baseline != c.run):
+			drift = fmt.Sprintf("%.1f%%", c.drift * 100)
+		}
+		status := ""
+		if (! c.ok) {
+			status = "  FAIL"
+			failed ++
+		}
+		fmt.Printf("   %-18s %16s %16s %9s%s\n", c.name, shorten(c.baseline, 16), shorten(c.run, 16), drift, status)
+	}
+	fmt.Println()
+
+	if (len(checks) == 0) {
+		fmt.Printf("ERROR: Baseline '%s' has none of the checked metrics.\n", basefile)
+		return false
+	}
+	if (failed > 0) {
+		fmt.Printf("%d of %d metric(s) drifted beyond the tolerance.\n", failed, len(checks))
+		return false
+	}
+	fmt.Printf("All %d metrics are within the tolerance.\n", len(checks))
+	return true
+}
+
+// Handles the command line of the baseline check mode:
+//   check-baseline <SUMMARY> <BASELINE> [-tolerance T]
+// Exits with EXIT_FAILURE if the summary drifted from the baseline, or EXIT_USAGE on usage errors,
+//   so it can be used in scripts.
+func mainCheckBaseline(args []string) int {
+	tolerance := "0%"
+	flags := flag.NewFlagSet("check-baseline", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&tolerance, "tolerance", tolerance, "largest relative drift allowed for numeric metrics, in percent")
+
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for baseline check mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for baseline check mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	tol, err := parseTolerance(tolerance)
+	if (err != nil) {
+		fmt.Printf("Check baseline: %s.\n", err);
+		return usageError()
+	}
+
+	if (! compareBaseline(args[0], args[1], tol)) {
+		return EXIT_FAILURE
+	}
+	return EXIT_OK
+}
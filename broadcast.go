@@ -0,0 +1,77 @@
+/*
+   Alien Invasion Simulator - message broadcasting to live subscribers
+*/
+
+package main
+
+import (
+	"sync"
+)
+
+// The number of messages that may be queued for a subscriber before it is considered too slow.
+const BROADCAST_QUEUE int = 4096
+
+// Fans out messages to any number of subscribers. Publishing never blocks: a subscriber that
+//   falls BROADCAST_QUEUE messages behind is dropped (its channel is closed).
+type Broadcaster struct {
+	mu      sync.Mutex
+	subs    map[chan []byte]bool
+	closed  bool
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{ subs: make(map[chan []byte]bool) }
+}
+
+// Adds a subscriber. The channel is closed when the broadcaster is closed, or if the subscriber
+//   is dropped for being too slow. Subscribing to a closed broadcaster returns a closed channel.
+func (b *Broadcaster) Subscribe() chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan []byte, BROADCAST_QUEUE)
+	if (b.closed) {
+		close(ch)
+	} else {
+		b.subs[ch] = true
+	}
+	return ch
+}
+
+// Removes a subscriber.
+func (b *Broadcaster) Unsubscribe(ch chan []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if (b.subs[ch]) {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Sends a message to every subscriber.
+func (b *Broadcaster) Publish(msg []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- msg:
+		default:
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Closes every subscriber channel. Later messages are discarded.
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = make(map[chan []byte]bool)
+	b.closed = true
+}
@@ -0,0 +1,119 @@
+/*
+   Alien Invasion Simulator - map generator calibration
+*/
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"math"
+	"strconv"
+)
+
+// Generator parameters that are expected to produce a given city count and average degree.
+type Calibration struct {
+	size  int        // Width and height of the (square) grid
+	cd    float64    // City density
+	rd    float64    // Road density
+}
+
+// The expected average number of roads per city in a generated "size" x "size" grid.
+// A pair of adjacent nodes gets a road if both nodes have cities and the road is placed, so each
+//   city has cd * rd expected roads to each of its neighbors. A square grid has 2 * size * (size - 1)
+//   pairs of adjacent nodes and size * size nodes, which gives the border correction below.
+func expectedDegree(size int, cd float64, rd float64) float64 {
+	if (size < 2) {
+		return 0
+	}
+	return 4 * cd * rd * float64(size - 1) / float64(size)
+}
+
+// Solves for generator parameters that give "cities" cities with an average degree of "degree".
+// If "cd" is positive, the city density is fixed and only the grid size and road density are
+//   solved for. Otherwise the city and road densities are kept equal, which keeps the cities
+//   spread out while using as few empty grid nodes as possible; if that would need a density above
+//   1, the grid is filled with cities and only the road density is raised.
+func calibrate(cities int, degree float64, cd float64) (Calibration, error) {
+	var c Calibration
+
+	if (cities < 1) {
+		return c, fmt.Errorf("The city count must be positive")
+	}
+	if (! ((degree >= 0) && (degree < 4))) {
+		return c, fmt.Errorf("The average degree must be in the [0, 4) range")
+	}
+
+	if (cd <= 0) {
+		// Fixed-point iteration over the grid size, since the border correction depends on it.
+		size := math.Sqrt(float64(cities))
+		for i := 0; i < 50; i++ {
+			cd = math.Min(1, math.Sqrt(degree * size / (4 * math.Max(size - 1, 0.5))))
+			if (cd <= 0) {
+				cd = 1
+			}
+			size = math.Sqrt(float64(cities) / cd)
+		}
+	}
+
+	// Round the grid size and adjust the city density to hit the city count.
+	c.size = int(math.Max(1, math.Round(math.Sqrt(float64(cities) / cd))))
+	c.cd = math.Min(1, float64(cities) / float64(c.size * c.size))
+
+	maxDegree := expectedDegree(c.size, c.cd, 1)
+	if (degree > maxDegree) {
+		return c, fmt.Errorf("An average degree of %g is not reachable with %d cities and city density %g (at most %.3f)", degree, cities, c.cd, maxDegree)
+	}
+	if (maxDegree > 0) {
+		c.rd = degree / maxDegree
+	}
+	return c, nil
+}
+
+// Generates a map file with approximately the given number of cities and average degree.
+func generateCalibrated(mapfile string, cities int, degree float64, cd float64, names string) error {
+	fmt.Printf("Will calibrate the generator for %d cities with an average degree of %g.\n", cities, degree)
+
+	c, err := calibrate(cities, degree, cd)
+	if (err != nil) {
+		return err
+	}
+
+	fmt.Printf("Calibrated parameters: dimensions %d x %d, city density %f and road density %f.\n", c.size, c.size, c.cd, c.rd)
+
+	opts := defaultGenOptions()
+	opts.Names = names
+	return generate(mapfile, c.size, c.size, c.cd, c.rd, opts)
+}
+
+// Handles the command line of the calibrated generation mode:
+//   -calibrate <MAPFILE> <CITIES> <AVGDEG> [-cd <CD>] [-names N]
+func mainCalibrate(args []string) int {
+	var cd float64
+	var names string
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.Float64Var(&cd, "cd", 0, "fix the city density instead of solving for it")
+	flags.StringVar(&names, "names", "coords", "city names: coords, syllables, or a word list file")
+
+	if (len(args) < 3) {
+		fmt.Println("Too few arguments for calibrated generation mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[3:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for calibrated generation mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+
+	cities, err1 := strconv.Atoi(args[1])
+	degree, err2 := strconv.ParseFloat(args[2], 64)
+	if (err1 != nil) || (err2 != nil) || (cd > 1) || (math.IsNaN(degree)) || (math.IsInf(degree, 0)) || (math.IsNaN(cd)) || (math.IsInf(cd, 0)) {
+		fmt.Println("Calibrate: Error parsing numeric arguments.");
+		return usageError()
+	}
+
+	return reportError(generateCalibrated(args[0], cities, degree, cd, names))
+}
@@ -0,0 +1,214 @@
+/*
+   Alien Invasion Simulator - SVG and PNG drawing
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"bufio"
+	"html"
+	"math"
+	"strings"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// A drawing surface for rendered maps. Coordinates are in pixels, and colors are CSS colors
+//   ("#rrggbb", "hsl(H, S%, L%)" or "white"). Titles (the tooltips of an SVG drawing) and
+//   text are only drawn where the format supports them.
+type Canvas interface {
+	line(x1 int, y1 int, x2 int, y2 int, color string, width int, dashed bool)
+	circle(x int, y int, r int, color string, filled bool, title string)
+	polyline(xs []int, ys []int, color string, width int)
+	rect(x int, y int, w int, h int, color string)
+	text(x int, y int, s string)
+	group(title string)    // Starts a group of shapes with a title, until endGroup()
+	endGroup()
+	close() error          // Writes the drawing
+}
+
+// ---------------------------------------------------------------------------------------------------
+// SVG
+// ---------------------------------------------------------------------------------------------------
+
+type svgCanvas struct {
+	bw  *bufio.Writer
+}
+
+func newSVGCanvas(w io.Writer, width int, height int) *svgCanvas {
+	c := &svgCanvas{ bw: bufio.NewWriter(w) }
+	fmt.Fprintf(c.bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", width, height, width, height)
+	fmt.Fprintf(c.bw, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+	return c
+}
+
+func (c *svgCanvas) line(x1 int, y1 int, x2 int, y2 int, color string, width int, dashed bool) {
+	dash := ""
+	if (dashed) {
+		dash = " stroke-dasharray=\"3,3\""
+	}
+	fmt.Fprintf(c.bw, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"%s\" stroke-width=\"%d\"%s/>\n", x1, y1, x2, y2, color, width, dash)
+}
+
+func (c *svgCanvas) circle(x int, y int, r int, color string, filled bool, title string) {
+	paint := fmt.Sprintf("fill=\"%s\"", color)
+	if (! filled) {
+		paint = fmt.Sprintf("fill=\"none\" stroke=\"%s\" stroke-width=\"2\"", color)
+	}
+	if (title == "") {
+		fmt.Fprintf(c.bw, "<circle cx=\"%d\" cy=\"%d\" r=\"%d\" %s/>\n", x, y, r, paint)
+		return
+	}
+	fmt.Fprintf(c.bw, "<circle cx=\"%d\" cy=\"%d\" r=\"%d\" %s><title>%s</title></circle>\n", x, y, r, paint, html.EscapeString(title))
+}
+
+func (c *svgCanvas) polyline(xs []int, ys []int, color string, width int) {
+	points := make([]string, len(xs))
+	for k := range xs {
+		points[k] = fmt.Sprintf("%d,%d", xs[k], ys[k])
+	}
+	fmt.Fprintf(c.bw, "<polyline points=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%d\" stroke-opacity=\"0.8\"/>\n", strings.Join(points, " "), color, width)
+}
+
+func (c *svgCanvas) rect(x int, y int, w int, h int, color string) {
+	fmt.Fprintf(c.bw, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", x, y, w, h, color)
+}
+
+func (c *svgCanvas) text(x int, y int, s string) {
+	fmt.Fprintf(c.bw, "<text x=\"%d\" y=\"%d\" font-family=\"sans-serif\" font-size=\"12\">%s</text>\n", x, y, html.EscapeString(s))
+}
+
+func (c *svgCanvas) group(title string) {
+	fmt.Fprintf(c.bw, "<g><title>%s</title>\n", html.EscapeString(title))
+}
+
+func (c *svgCanvas) endGroup() {
+	fmt.Fprintf(c.bw, "</g>\n")
+}
+
+func (c *svgCanvas) close() error {
+	fmt.Fprintf(c.bw, "</svg>\n")
+	return c.bw.Flush()
+}
+
+// ---------------------------------------------------------------------------------------------------
+// PNG
+// ---------------------------------------------------------------------------------------------------
+
+// Draws into an image, which is encoded as PNG when closed. There are no fonts, so text is left out.
+type pngCanvas struct {
+	w    io.Writer
+	img  *image.RGBA
+}
+
+func newPNGCanvas(w io.Writer, width int, height int) *pngCanvas {
+	c := &pngCanvas{ w: w, img: image.NewRGBA(image.Rect(0, 0, width, height)) }
+	c.rect(0, 0, width, height, "white")
+	return c
+}
+
+// Paints a square dot of a given width centered on a pixel.
+func (c *pngCanvas) dot(x int, y int, width int, col color.RGBA) {
+	for dy := -(width - 1) / 2; dy <= width / 2; dy++ {
+		for dx := -(width - 1) / 2; dx <= width / 2; dx++ {
+			c.img.SetRGBA(x + dx, y + dy, col)
+		}
+	}
+}
+
+func (c *pngCanvas) line(x1 int, y1 int, x2 int, y2 int, color string, width int, dashed bool) {
+	col := parseColor(color)
+	steps := max(abs(x2 - x1), abs(y2 - y1))
+	for k := 0; k <= steps; k++ {
+		if (dashed) && ((k / 3) % 2 == 1) {
+			continue
+		}
+		x, y := x1, y1
+		if (steps > 0) {
+			x = x1 + int(math.Round(float64((x2 - x1) * k) / float64(steps)))
+			y = y1 + int(math.Round(float64((y2 - y1) * k) / float64(steps)))
+		}
+		c.dot(x, y, width, col)
+	}
+}
+
+func (c *pngCanvas) circle(x int, y int, r int, color string, filled bool, title string) {
+	col := parseColor(color)
+	for dy := -r - 1; dy <= r + 1; dy++ {
+		for dx := -r - 1; dx <= r + 1; dx++ {
+			d := math.Sqrt(float64(dx * dx + dy * dy))
+			if (filled) && (d <= float64(r)) || (! filled) && (math.Abs(d - float64(r)) <= 1) {
+				c.img.SetRGBA(x + dx, y + dy, col)
+			}
+		}
+	}
+}
+
+func (c *pngCanvas) polyline(xs []int, ys []int, color string, width int) {
+	for k := 1; k < len(xs); k++ {
+		c.line(xs[k - 1], ys[k - 1], xs[k], ys[k], color, width, false)
+	}
+}
+
+func (c *pngCanvas) rect(x int, y int, w int, h int, color string) {
+	col := parseColor(color)
+	for py := y; py < y + h; py++ {
+		for px := x; px < x + w; px++ {
+			c.img.SetRGBA(px, py, col)
+		}
+	}
+}
+
+func (c *pngCanvas) text(x int, y int, s string) {}
+func (c *pngCanvas) group(title string) {}
+func (c *pngCanvas) endGroup() {}
+
+func (c *pngCanvas) close() error {
+	bw := bufio.NewWriter(c.w)
+	if err := png.Encode(bw, c.img); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Converts a CSS color as used by the renderer into RGB. Unknown colors are black.
+func parseColor(s string) color.RGBA {
+	var r, g, b uint8
+	var h, sat, l float64
+	switch {
+	case (s == "white"):
+		return color.RGBA{ 255, 255, 255, 255 }
+	case (strings.HasPrefix(s, "#")):
+		fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b)
+		return color.RGBA{ r, g, b, 255 }
+	case (strings.HasPrefix(s, "hsl(")):
+		fmt.Sscanf(s, "hsl(%g, %g%%, %g%%)", &h, &sat, &l)
+		sat, l = sat / 100, l / 100
+		chroma := (1 - math.Abs(2 * l - 1)) * sat
+		hp := math.Mod(h, 360) / 60
+		x := chroma * (1 - math.Abs(math.Mod(hp, 2) - 1))
+		var rf, gf, bf float64
+		switch (int(hp)) {
+		case 0: rf, gf, bf = chroma, x, 0
+		case 1: rf, gf, bf = x, chroma, 0
+		case 2: rf, gf, bf = 0, chroma, x
+		case 3: rf, gf, bf = 0, x, chroma
+		case 4: rf, gf, bf = x, 0, chroma
+		default: rf, gf, bf = chroma, 0, x
+		}
+		m := l - chroma / 2
+		to8 := func(v float64) uint8 { return uint8(math.Round((v + m) * 255)) }
+		return color.RGBA{ to8(rf), to8(gf), to8(bf), 255 }
+	}
+	return color.RGBA{ 0, 0, 0, 255 }
+}
+
+func abs(x int) int {
+	if (x < 0) {
+		return -x
+	}
+	return x
+}
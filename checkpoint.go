@@ -0,0 +1,263 @@
+/*
+   Alien Invasion Simulator - simulation checkpoints
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"encoding/json"
+)
+
+// The version of the checkpoint file format. Bump it whenever the format changes.
+const CHECKPOINT_VERSION int = 16
+
+// The full state of a simulation between two movement steps, from which it can be resumed.
+type Checkpoint struct {
+	Version             int               `json:"version"`
+	MapFile             string            `json:"mapfile"`
+	MaxSteps            int               `json:"max_steps"`
+	StopWhen            []string          `json:"stop_when"`
+	StopAfterQuiescent  int               `json:"stop_after_quiescent"`
+	DefenseRate         float64           `json:"defense_rate"`
+	SpawnBorder         bool              `json:"spawn_border"`
+	SpawnPolicy         string            `json:"spawn_policy"`   // For the waves still to come
+	AlienRatio          float64           `json:"alien_ratio,omitempty"`
+	FightThreshold      int               `json:"fight_threshold"`
+	SpareCities         bool              `json:"spare_cities"`
+	CombatModel         string            `json:"combat_model"`
+	HitPoints           int               `json:"hit_points"`
+	CityHitPoints       int               `json:"city_hit_points"`
+	Movement            string            `json:"movement"`
+	Factions            int               `json:"factions"`
+	FearOfRuins         float64           `json:"fear_of_ruins"`
+	Script              string            `json:"script,omitempty"`  // Movement script, loaded again on resume
+	RoadDecay           float64           `json:"road_decay"`
+	Collateral          float64           `json:"collateral"`
+	Roads               int               `json:"roads"`          // Initial number of roads
+	RoadsDestroyed      int               `json:"roads_destroyed"`
+	Defenders           bool              `json:"defenders"`
+	Waves               string            `json:"waves,omitempty"`  // As given to --waves, "" if the aliens spawned at once
+	WavesSpawned        int               `json:"waves_spawned"`
+	Spawned             int               `json:"spawned"`        // Number of aliens spawned so far
+	Population          int               `json:"population"`
+	Casualties          int               `json:"casualties"`
+	DefenderKills       int               `json:"defender_kills"`
+	RecordPaths         bool              `json:"record_paths"`
+	WriteVisits         bool              `json:"write_visits"`
+	WritePositions      bool              `json:"write_positions"`
+	Seed                int64             `json:"seed"`
+	RNG                 []byte            `json:"rng"`            // State of the random number generator
+	Iteration           int               `json:"iteration"`
+	QuietSteps          int               `json:"quiet_steps"`
+	Repelled            int               `json:"repelled"`
+	Directions          []string          `json:"directions,omitempty"`  // Direction pairs used by the map that are not built in
+	Cities              []CheckpointCity  `json:"cities"`
+	Aliens              []int             `json:"aliens"`         // City index of each alien, -1 if dead
+	AlienHP             []int             `json:"alien_hp,omitempty"`  // Hit points left to each alien, if the combat model wounds them
+	Paths               [][]int           `json:"paths,omitempty"`
+}
+
+// The state of a city in a checkpoint.
+type CheckpointCity struct {
+	Name       string          `json:"name"`
+	Roads      map[string]int  `json:"roads"`        // City index in each direction that has a road, by direction label
+	Dead       bool            `json:"dead,omitempty"`
+	LastVisit  int             `json:"last_visit"`   // Iteration of the last alien visit, -1 if never visited
+	Pop        int             `json:"pop,omitempty"`
+	Damage     int             `json:"damage,omitempty"`  // Hit points lost in fights (see COMBAT_DAMAGE)
+}
+
+// Captures the state of the simulation.
+func (sim *Simulator) Checkpoint(mapfile string) *Checkpoint {
+	rng, _ := sim.src.state()
+
+	cp := &Checkpoint{
+		Version:             CHECKPOINT_VERSION,
+		MapFile:             mapfile,
+		MaxSteps:            sim.opts.MaxSteps,
+		StopWhen:            append([]string{}, sim.opts.StopWhen...),
+		StopAfterQuiescent:  sim.opts.StopAfterQuiescent,
+		DefenseRate:         sim.opts.DefenseRate,
+		SpawnBorder:         sim.opts.SpawnBorder,
+		SpawnPolicy:         sim.opts.SpawnPolicy,
+		AlienRatio:          sim.opts.AlienRatio,
+		FightThreshold:      sim.opts.FightThreshold,
+		SpareCities:         sim.opts.SpareCities,
+		CombatModel:         sim.opts.CombatModel,
+		HitPoints:           sim.opts.HitPoints,
+		CityHitPoints:       sim.opts.CityHitPoints,
+		Movement:            sim.opts.Movement,
+		Factions:            sim.opts.Factions,
+		FearOfRuins:         sim.opts.FearOfRuins,
+		Script:              sim.opts.Script,
+		RoadDecay:           sim.opts.RoadDecay,
+		Collateral:          sim.opts.Collateral,
+		Roads:               sim.roads,
+		RoadsDestroyed:      sim.roadsDestroyed,
+		Defenders:           sim.opts.Defenders,
+		Waves:               sim.opts.Waves.String(),
+		WavesSpawned:        sim.wave,
+		Spawned:             sim.spawned,
+		Population:          sim.population,
+		Casualties:          sim.casualties,
+		DefenderKills:       sim.defenderKills,
+		RecordPaths:         sim.opts.RecordPaths,
+		WriteVisits:         sim.opts.WriteVisits,
+		WritePositions:      sim.opts.WritePositions,
+		Seed:                sim.seed,
+		RNG:                 rng,
+		Iteration:           sim.iteration,
+		QuietSteps:          sim.quietSteps,
+		Repelled:            sim.repelledCounter,
+		Directions:          customDirections(sim.nodes),
+		Cities:              make([]CheckpointCity, len(sim.nodes)),
+		Aliens:              make([]int, len(sim.aliens)),
+		Paths:               sim.paths,
+	}
+	for i, alien := range sim.aliens {
+		cp.Aliens[i] = alien.city
+	}
+	if (sim.opts.CombatModel != COMBAT_ANNIHILATE) {
+		cp.AlienHP = make([]int, len(sim.aliens))
+		for i, alien := range sim.aliens {
+			cp.AlienHP[i] = alien.hp
+		}
+	}
+	for i := 0; i < len(sim.nodes); i++ {
+		roads := make(map[string]int)
+		for _, r := range sim.nodes[i].roads {
+			roads[directionName(r.dir)] = r.to
+		}
+		cp.Cities[i] = CheckpointCity{ Name: sim.nodes[i].cityName, Roads: roads, Dead: sim.nodes[i].dead, LastVisit: sim.nodes[i].lastVisit, Pop: sim.nodes[i].pop, Damage: sim.nodes[i].damage }
+	}
+	return cp
+}
+
+// The simulation options saved in a checkpoint, on top of the defaults.
+func (cp *Checkpoint) options() SimOptions {
+	opts := defaultSimOptions()
+	opts.MaxSteps = cp.MaxSteps
+	opts.StopWhen = append(StopConds{}, cp.StopWhen...)
+	opts.StopAfterQuiescent = cp.StopAfterQuiescent
+	opts.DefenseRate = cp.DefenseRate
+	opts.SpawnBorder = cp.SpawnBorder
+	opts.SpawnPolicy = cp.SpawnPolicy
+	opts.AlienRatio = cp.AlienRatio
+	opts.FightThreshold = cp.FightThreshold
+	opts.SpareCities = cp.SpareCities
+	opts.CombatModel = cp.CombatModel
+	opts.HitPoints = cp.HitPoints
+	opts.CityHitPoints = cp.CityHitPoints
+	opts.Movement = cp.Movement
+	opts.Factions = cp.Factions
+	opts.FearOfRuins = cp.FearOfRuins
+	opts.Script = cp.Script
+	opts.RoadDecay = cp.RoadDecay
+	opts.Collateral = cp.Collateral
+	opts.Defenders = cp.Defenders
+	if (cp.Waves != "") {
+		opts.Waves.Set(cp.Waves)
+	}
+	opts.RecordPaths = cp.RecordPaths
+	opts.WriteVisits = cp.WriteVisits
+	opts.WritePositions = cp.WritePositions
+	opts.Seed = cp.Seed
+	return opts
+}
+
+// Rebuilds a simulation from a checkpoint.
+func restoreSimulator(cp *Checkpoint, opts SimOptions) (*Simulator, error) {
+	nodes := make(SNodeArray, len(cp.Cities))
+	nodeMap := make(SNodeMap)
+
+	for _, pair := range cp.Directions {
+		if err := parseDirectionsDirective(DIRECTIONS_DIRECTIVE + " " + pair); err != nil {
+			return nil, fmt.Errorf("Checkpoint is corrupted: %v", err)
+		}
+	}
+	for i, c := range cp.Cities {
+		nodes[i] = SNode{ index: i, cityName: c.Name, dead: c.Dead, lastVisit: c.LastVisit, pop: c.Pop, damage: c.Damage }
+		for label, to := range c.Roads {
+			dir, ok := lookupDirection(label)
+			if (! ok) {
+				return nil, fmt.Errorf("Checkpoint is corrupted: city '%s' has a road in unknown direction '%s'", c.Name, label)
+			}
+			if (to < 0) || (to >= len(cp.Cities)) {
+				return nil, fmt.Errorf("Checkpoint is corrupted: city '%s' has a road to city #%d", c.Name, to)
+			}
+			nodes[i].setRoad(dir, to)
+		}
+		nodeMap[c.Name] = i
+	}
+
+	opts.Seed = cp.Seed
+	sim := NewSimulator(nodes, nodeMap, len(cp.Aliens), opts)
+	if err := sim.src.restore(cp.RNG); err != nil {
+		return nil, fmt.Errorf("Checkpoint is corrupted: bad random number generator state (%v)", err)
+	}
+
+	sim.iteration = cp.Iteration
+	sim.quietSteps = cp.QuietSteps
+	sim.repelledCounter = cp.Repelled
+	sim.wave = cp.WavesSpawned
+	sim.roads = cp.Roads
+	sim.roadsDestroyed = cp.RoadsDestroyed
+	sim.spawned = cp.Spawned
+	sim.population = cp.Population
+	sim.casualties = cp.Casualties
+	sim.defenderKills = cp.DefenderKills
+	if (opts.MaxSteps > 0) {
+		sim.percent = 100 * cp.Iteration / opts.MaxSteps
+	}
+	for i := 0; i < len(nodes); i++ {
+		if (nodes[i].dead) {
+			sim.deadCityCounter ++
+		}
+		if (nodes[i].lastVisit != -1) {
+			sim.visitedCounter ++
+		}
+	}
+	for i, city := range cp.Aliens {
+		if (city < -1) || (city >= len(nodes)) {
+			return nil, fmt.Errorf("Checkpoint is corrupted: Alien #%d is in city #%d", i, city)
+		}
+		sim.aliens[i].city = city
+		if (len(cp.AlienHP) == len(cp.Aliens)) {
+			sim.aliens[i].hp = cp.AlienHP[i]
+		} else if (city != -1) {
+			sim.aliens[i].hp = sim.opts.HitPoints
+		}
+		if (city != -1) {
+			nodes[city].occupants = append(nodes[city].occupants, i)
+			sim.liveAlienCounter ++
+			sim.trapped[i] = cityTrapped(nodes, city)
+		}
+	}
+	if (sim.paths != nil) && (len(cp.Paths) == len(cp.Aliens)) {
+		sim.paths = cp.Paths
+	}
+	return sim, nil
+}
+
+// Writes a checkpoint file, atomically (an older checkpoint is replaced only by a complete one).
+func saveCheckpoint(filename string, cp *Checkpoint) error {
+	return saveJSON(filename, true, cp)
+}
+
+// Reads a checkpoint file.
+func loadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot read from checkpoint file '%s'", filename)
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("Cannot parse checkpoint file '%s': %v", filename, err)
+	}
+	if (cp.Version != CHECKPOINT_VERSION) {
+		return nil, fmt.Errorf("Checkpoint file '%s' has version %d, but this program reads version %d", filename, cp.Version, CHECKPOINT_VERSION)
+	}
+	return cp, nil
+}
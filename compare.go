@@ -0,0 +1,216 @@
+/*
+   Alien Invasion Simulator - head-to-head map comparison
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"flag"
+	"strconv"
+	"time"
+)
+
+// The accumulated outcome of the comparison runs of one map.
+type CompareSide struct {
+	mapfile    string
+	nodes      SNodeArray
+	nodeMap    SNodeMap
+	destroyed  int        // Cities destroyed, summed over all runs
+	alive      int        // Aliens alive at the end, summed over all runs
+	iterations int        // Iterations run, summed over all runs
+	wins       int        // Runs in which this map lost a smaller fraction of its cities
+}
+
+// Copies a city data store, so that a simulation can change the copy (e.g. destroy roads) while
+//   the original is kept for the next run.
+func cloneNodes(nodes SNodeArray) SNodeArray {
+	clone := append(SNodeArray(nil), nodes...)
+	for i := 0; i < len(clone); i++ {
+		clone[i].roads = append([]SRoad(nil), nodes[i].roads...)
+		clone[i].occupants = append([]int(nil), nodes[i].occupants...)
+	}
+	return clone
+}
+
+// Runs one simulation of a map with the given seed to the end, without printing anything.
+func runQuiet(mapfile string, nodes SNodeArray, nodeMap SNodeMap, numaliens int, opts SimOptions, seed int64) (Summary, error) {
+	opts.Seed = seed
+	opts.Log = io.Discard
+	sim := NewSimulator(cloneNodes(nodes), nodeMap, numaliens, opts)
+	sim.progress = false
+	if (! sim.Spawn()) {
+		return Summary{}, &AbortedError{ fmt.Errorf("Cannot spawn %d aliens in map '%s'", numaliens, mapfile) }
+	}
+	if err := sim.Run(); err != nil {
+		return Summary{}, &AbortedError{ err }
+	}
+	return sim.Summary(mapfile), nil
+}
+
+// Runs one simulation of a side's map with the given seed, without printing anything.
+func (side *CompareSide) run(numaliens int, opts SimOptions, seed int64) (Summary, error) {
+	s, err := runQuiet(side.mapfile, side.nodes, side.nodeMap, numaliens, opts, seed)
+	if (err != nil) {
+		return s, err
+	}
+	side.destroyed += s.CitiesDestroyed
+	side.alive += s.AliensAlive
+	side.iterations += s.Iterations
+	return s, nil
+}
+
+// The fraction of the cities of a map destroyed in a run.
+func destroyedFraction(s Summary) float64 {
+	if (s.Cities == 0) {
+		return 0
+	}
+	return float64(s.CitiesDestroyed) / float64(s.Cities)
+}
+
+// Simulates two maps with the same alien count and the same seeds, "runs" times, and prints a
+//   side-by-side report. The map that loses the smaller fraction of its cities is considered the
+//   more resilient one.
+// Run i uses seed "opts.Seed + i" for both maps; a zero seed is replaced with a time-based one.
+func compare(mapfileA string, mapfileB string, numaliens int, runs int, opts SimOptions) error {
+	fmt.Printf("Will compare mapfiles '%s' and '%s' with %d aliens over %d run(s).\n", mapfileA, mapfileB, numaliens, runs)
+
+	sides := [2]*CompareSide{ &CompareSide{mapfile: mapfileA}, &CompareSide{mapfile: mapfileB} }
+	for _, side := range sides {
+		var err error
+		side.nodes, side.nodeMap, err = loadMap(side.mapfile)
+		if (err != nil) {
+			return err
+		}
+	}
+
+	seed := opts.Seed
+	if (seed == 0) {
+		seed = time.Now().UnixNano()
+	}
+
+	ties := 0
+	for i := 0; i < runs; i++ {
+		var results [2]Summary
+		for k, side := range sides {
+			var err error
+			results[k], err = side.run(numaliens, opts, seed + int64(i))
+			if (err != nil) {
+				return err
+			}
+		}
+		fa, fb := destroyedFraction(results[0]), destroyedFraction(results[1])
+		if (fa < fb) {
+			sides[0].wins ++
+		} else if (fb < fa) {
+			sides[1].wins ++
+		} else {
+			ties ++
+		}
+	}
+
+	// ---------------------------------------------------------------------------------------------------
+	// Side-by-side report (averages over all runs)
+	// ---------------------------------------------------------------------------------------------------
+
+	a, b := sides[0], sides[1]
+	avg := func(total int) float64 { return float64(total) / float64(runs) }
+	pct := func(side *CompareSide) float64 {
+		if (len(side.nodes) == 0) {
+			return 0
+		}
+		return 100 * avg(side.destroyed) / float64(len(side.nodes))
+	}
+
+	fmt.Println("\nComparison:");
+	fmt.Printf("   %-22s %18s %18s\n", "", "A", "B");
+	fmt.Printf("   %-22s %18s %18s\n", "Map file", shorten(a.mapfile, 18), shorten(b.mapfile, 18));
+	fmt.Printf("   %-22s %18d %18d\n", "Cities", len(a.nodes), len(b.nodes));
+	fmt.Printf("   %-22s %18.1f %18.1f\n", "Cities destroyed", avg(a.destroyed), avg(b.destroyed));
+	fmt.Printf("   %-22s %17.1f%% %17.1f%%\n", "Cities destroyed (%)", pct(a), pct(b));
+	fmt.Printf("   %-22s %18.1f %18.1f\n", "Aliens alive", avg(a.alive), avg(b.alive));
+	fmt.Printf("   %-22s %18.1f %18.1f\n", "Iterations", avg(a.iterations), avg(b.iterations));
+	fmt.Printf("   %-22s %18d %18d\n", "Runs won", a.wins, b.wins);
+	fmt.Printf("   Ties: %d; seeds %d to %d.\n", ties, seed, seed + int64(runs) - 1);
+
+	if (pct(a) < pct(b)) {
+		fmt.Printf("\nMap A ('%s') is more resilient to invasion.\n", a.mapfile)
+	} else if (pct(b) < pct(a)) {
+		fmt.Printf("\nMap B ('%s') is more resilient to invasion.\n", b.mapfile)
+	} else {
+		fmt.Println("\nBoth maps are equally resilient to invasion.")
+	}
+	return nil
+}
+
+// Shortens a string to at most "n" characters, keeping its end (the most specific part of a path).
+func shorten(s string, n int) string {
+	if (len(s) <= n) {
+		return s
+	}
+	return "..." + s[len(s) - n + 3:]
+}
+
+// Adds the flags of the simulation options that change the outcome of a run (as opposed to the
+//   output and interaction options) to the flag set of a batch mode such as compare or tournament.
+func modelFlags(flags *flag.FlagSet, opts *SimOptions) {
+	flags.IntVar(&opts.MaxSteps, "max-steps", opts.MaxSteps, "maximum number of movement steps")
+	flags.Var(&opts.StopWhen, "stop-when", "termination condition (all-trapped, half-destroyed)")
+	flags.IntVar(&opts.StopAfterQuiescent, "stop-after-quiescent", opts.StopAfterQuiescent, "stop after K steps without fights")
+	flags.BoolVar(&opts.SpawnBorder, "spawn-border", opts.SpawnBorder, "spawn aliens only at border cities")
+	flags.StringVar(&opts.SpawnPolicy, "spawn-policy", opts.SpawnPolicy, "uniform, clustered, perimeter, weighted-degree or weighted-population")
+	flags.Float64Var(&opts.DefenseRate, "defense-rate", opts.DefenseRate, "defense points gained by every surviving city in each step")
+	flags.IntVar(&opts.FightThreshold, "fight-threshold", opts.FightThreshold, "number of aliens in a city that makes them fight")
+	flags.BoolVar(&opts.SpareCities, "spare-cities", opts.SpareCities, "fights kill the aliens but leave the city standing")
+	flags.StringVar(&opts.CombatModel, "combat-model", opts.CombatModel, "annihilate, wounds or damage")
+	flags.IntVar(&opts.HitPoints, "hit-points", opts.HitPoints, "hit points of every alien (for -combat-model wounds or damage)")
+	flags.IntVar(&opts.CityHitPoints, "city-hit-points", opts.CityHitPoints, "hit points of every city (for -combat-model damage)")
+	flags.StringVar(&opts.Movement, "movement", opts.Movement, "sequential, simultaneous or parallel")
+	flags.IntVar(&opts.Factions, "factions", opts.Factions, "number of alien factions that fight each other")
+	flags.BoolVar(&opts.Defenders, "defenders", opts.Defenders, "the population of a city may kill the aliens fighting in it")
+	flags.Float64Var(&opts.RoadDecay, "road-decay", opts.RoadDecay, "probability that each road is destroyed in each step")
+	flags.Float64Var(&opts.Collateral, "collateral", opts.Collateral, "probability that each road next to a destroyed city is destroyed with it")
+	flags.Float64Var(&opts.FearOfRuins, "fear-of-ruins", opts.FearOfRuins, "probability that an alien avoids moving next to destroyed cities")
+	flags.Var(&opts.Waves, "waves", "spawn the aliens in <COUNT>x<INTERVAL> waves")
+}
+
+// Handles the command line of the map comparison mode:
+//   -compare <MAPFILE_A> <MAPFILE_B> <NUMALIENS> [-runs N] [-seed S] [-max-steps N] [-stop-when C]
+//            [-stop-after-quiescent K] [-spawn-border] [-spawn-policy P] [-defense-rate R]
+//            [-fight-threshold N] [-spare-cities] [-combat-model M] [-hit-points N]
+//            [-city-hit-points N] [-movement M] [-factions K] [-road-decay P] [-collateral P]
+//            [-fear-of-ruins P] [-defenders] [-waves CxN]
+func mainCompare(args []string) int {
+	runs := 1
+	opts := defaultSimOptions()
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.IntVar(&runs, "runs", runs, "number of runs (with consecutive seeds) to average over")
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "seed for the random number generator of the first run")
+	modelFlags(flags, &opts)
+
+	if (len(args) < 3) {
+		fmt.Println("Too few arguments for map comparison mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[3:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map comparison mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	if err := checkSimOptions(&opts); err != nil {
+		fmt.Printf("Compare: %s.\n", err);
+		return usageError()
+	}
+
+	numaliens, err := strconv.Atoi(args[2])
+	if (err != nil) || (runs < 1) {
+		fmt.Println("Compare: Error parsing numeric arguments.");
+		return usageError()
+	}
+
+	return reportError(compare(args[0], args[1], numaliens, runs, opts))
+}
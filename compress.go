@@ -0,0 +1,76 @@
+/*
+   Alien Invasion Simulator - gzip-compressed files
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bufio"
+	"strings"
+	"compress/gzip"
+	"encoding/binary"
+)
+
+// Map files are highly compressible text. Compressed map files are read transparently, as they
+//   are recognized by their first bytes. Output files whose name ends in GZIP_SUFFIX are written
+//   compressed, and the result map of a compressed map file is compressed too.
+
+// The suffix of the names of gzip-compressed files.
+const GZIP_SUFFIX string = ".gz"
+
+// The first two bytes of gzip-compressed data.
+var gzipMagic = []byte{ 0x1f, 0x8b }
+
+// Returns true if a file is written compressed, by its name.
+func gzipped(filename string) bool {
+	return (filename != STDIO) && (strings.HasSuffix(filename, GZIP_SUFFIX))
+}
+
+// Returns a reader of the decompressed data of "r" if it starts with the gzip magic bytes, or of
+//   the data of "r" as it is otherwise.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(gzipMagic))
+	if (err != nil) || (string(magic) != string(gzipMagic)) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot decompress gzip data: %v", err)
+	}
+	return zr, nil
+}
+
+// The size of the data of a file, once decompressed. The size of compressed data is read from the
+//   gzip trailer, which only has its lower 32 bits, so it is only a guess for data above 4 GiB.
+func dataSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if (err != nil) {
+		return 0
+	}
+	size := info.Size()
+
+	head := make([]byte, len(gzipMagic))
+	if _, err := file.ReadAt(head, 0); (err != nil) || (string(head) != string(gzipMagic)) {
+		return size
+	}
+	trailer := make([]byte, 4)
+	if _, err := file.ReadAt(trailer, size - 4); (err != nil) {
+		return size
+	}
+	return int64(binary.LittleEndian.Uint32(trailer))
+}
+
+// Wraps the writer of an output file in a gzip writer if the file is written compressed. The
+//   returned function must be called once everything has been written, to flush the compressed
+//   data.
+func compress(filename string, w io.Writer) (io.Writer, func() error) {
+	if (! gzipped(filename)) {
+		return w, func() error { return nil }
+	}
+	zw := gzip.NewWriter(w)
+	return zw, zw.Close
+}
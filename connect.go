@@ -0,0 +1,144 @@
+/*
+   Alien Invasion Simulator - map generator connectivity
+*/
+
+package main
+
+// A grid edge between node "a" and the node to its EAST or SOUTH ("dir"), for connect().
+// Nodes are numbered y * width + x.
+type gridEdge struct {
+	a    int
+	dir  int
+}
+
+// Finds the representative of a node in a union-find forest, compressing the path to it.
+func find(parent []int, a int) int {
+	for (parent[a] != a) {
+		parent[a] = parent[parent[a]]
+		a = parent[a]
+	}
+	return a
+}
+
+// Adds roads (and, where needed, cities) to a generated world so that all of its cities end up
+//   in a single connected component.
+// Every grid edge of the topology gets a cost: 0 if it is already a road, 1 if it would be a new road between two
+//   cities, and 2 if it touches a node without a city (so a bridging city would be needed there).
+//   A minimum spanning tree of the whole grid under those costs (Kruskal, with ties broken at
+//   random so the new roads don't all line up) keeps all existing roads and uses as few new ones
+//   as it can. Empty nodes that end up as leaves of the tree are then pruned, repeatedly, and the
+//   empty nodes left in the tree become bridging cities.
+// Returns the number of roads and cities added.
+func (wmap World) connect(topology string) (newRoads int, newCities int) {
+	height := len(wmap)
+	if (height == 0) {
+		return 0, 0
+	}
+	width := len(wmap[0])
+	size := width * height
+	city := func(n int) bool { return wmap[n / width][n % width].cityName != "" }
+	other := func(e gridEdge) int {
+		x, y := wmap.neighbor(e.a % width, e.a / width, e.dir)
+		return y * width + x
+	}
+
+	var buckets [3][]gridEdge
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			n := y * width + x
+			if (adjacent(topology, width, height, x, y, EAST)) {
+				cost := 2
+				if (wmap[y][x].roads[EAST]) {
+					cost = 0
+				} else if (city(n)) && (city(other(gridEdge{n, EAST}))) {
+					cost = 1
+				}
+				buckets[cost] = append(buckets[cost], gridEdge{n, EAST})
+			}
+			if (adjacent(topology, width, height, x, y, SOUTH)) {
+				cost := 2
+				if (wmap[y][x].roads[SOUTH]) {
+					cost = 0
+				} else if (city(n)) && (city(other(gridEdge{n, SOUTH}))) {
+					cost = 1
+				}
+				buckets[cost] = append(buckets[cost], gridEdge{n, SOUTH})
+			}
+		}
+	}
+
+	parent := make([]int, size)
+	for i := range parent {
+		parent[i] = i
+	}
+	var tree []gridEdge
+	degree := make([]int, size)
+	for cost := 0; cost < 3; cost++ {
+		edges := buckets[cost]
+		rnd.Shuffle(len(edges), func(i, j int) { edges[i], edges[j] = edges[j], edges[i] })
+		for _, e := range edges {
+			ra, rb := find(parent, e.a), find(parent, other(e))
+			if (ra != rb) {
+				parent[ra] = rb
+				tree = append(tree, e)
+				degree[e.a] ++
+				degree[other(e)] ++
+			}
+		}
+	}
+
+	// Prune the empty leaves. "removed" marks the nodes cut off the tree.
+	removed := make([]bool, size)
+	var leaves []int
+	for n := 0; n < size; n++ {
+		if (! city(n)) && (degree[n] <= 1) {
+			leaves = append(leaves, n)
+		}
+	}
+	adjacent := make([][]int, size)
+	for _, e := range tree {
+		adjacent[e.a] = append(adjacent[e.a], other(e))
+		adjacent[other(e)] = append(adjacent[other(e)], e.a)
+	}
+	for len(leaves) > 0 {
+		n := leaves[len(leaves) - 1]
+		leaves = leaves[:len(leaves) - 1]
+		if (removed[n]) {
+			continue
+		}
+		removed[n] = true
+		for _, m := range adjacent[n] {
+			if (! removed[m]) {
+				degree[m] --
+				if (! city(m)) && (degree[m] <= 1) {
+					leaves = append(leaves, m)
+				}
+			}
+		}
+	}
+
+	// Build the new roads and bridging cities along the remaining tree.
+	for _, e := range tree {
+		b := other(e)
+		if (removed[e.a]) || (removed[b]) {
+			continue
+		}
+		for _, n := range []int{ e.a, b } {
+			if (! city(n)) {
+				wmap.placeCity(n % width, n / width)
+				newCities ++
+			}
+		}
+		node := &wmap[e.a / width][e.a % width]
+		if (! node.roads[e.dir]) {
+			node.roads[e.dir] = true
+			newRoads ++
+		}
+	}
+	return newRoads, newCities
+}
+
+// Places a city at a node of the world, named after its coordinates like the generated ones.
+func (wmap World) placeCity(x int, y int) {
+	wmap[y][x].cityName = cityNameAt(x, y)
+}
@@ -0,0 +1,123 @@
+/*
+   Alien Invasion Simulator - bundled demo scenarios
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"embed"
+)
+
+// The demo maps are compiled into the binary, so that new users can run a meaningful simulation
+//   without generating or supplying any files.
+//
+//go:embed demos/*.map
+var demoFiles embed.FS
+
+// A bundled scenario: a map and the simulation settings that show it off.
+type Demo struct {
+	name         string
+	description  string
+	aliens       int
+	args         []string   // Simulation options, applied before the ones on the command line
+}
+
+var demos = []Demo{
+	{ "town", "A small hand-drawn town of 12 cities around a market square.",
+		4, []string{ "-max-steps", "200" } },
+	{ "maze", "A 12 x 12 maze: a single path between any two cities, with many dead ends.",
+		20, []string{ "-stop-when", "all-trapped" } },
+	{ "continents", "Two continents joined by a single causeway, invaded from their shores.",
+		30, []string{ "-spawn-border" } },
+}
+
+// Finds a demo by name, or returns nil.
+func findDemo(name string) *Demo {
+	for i := range demos {
+		if (demos[i].name == name) {
+			return &demos[i]
+		}
+	}
+	return nil
+}
+
+// Lists the bundled demos.
+func listDemos() {
+	fmt.Println("Available demos:")
+	for _, d := range demos {
+		fmt.Printf("   %-12s %s\n", d.name, d.description)
+	}
+}
+
+// Writes the map of a demo to "<name>.map" in the current directory and simulates it. The map
+//   is written out so that the output files of the simulation have a map file to sit next to,
+//   and so that it can be inspected and edited afterwards.
+func runDemo(d *Demo, numaliens int, opts SimOptions) error {
+	data, err := demoFiles.ReadFile("demos/" + d.name + ".map")
+	if (err != nil) {
+		return fmt.Errorf("Demo '%s' has no map (%v)", d.name, err)
+	}
+
+	mapfile := d.name + ".map"
+	if (! opts.Overwrite) {
+		if err := checkNoOverwrite(mapfile); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(mapfile, data, 0644); err != nil {
+		return fmt.Errorf("Cannot write to demo map file '%s'", mapfile)
+	}
+	fmt.Printf("Demo '%s': %s\n", d.name, d.description)
+	fmt.Printf("Wrote the demo map to '%s'.\n", mapfile)
+
+	return simulate(mapfile, numaliens, opts)
+}
+
+// Handles the command line of the demo mode: demo [<NAME> [<NUMALIENS>] [options]]
+// The options of the scenario are the defaults for the options given here.
+func mainDemo(args []string) int {
+	if (len(args) < 1) {
+		listDemos()
+		return EXIT_OK
+	}
+
+	d := findDemo(args[0])
+	if (d == nil) {
+		fmt.Printf("Unknown demo '%s'.\n", args[0])
+		listDemos()
+		return EXIT_USAGE
+	}
+
+	opts := defaultSimOptions()
+	if (simFlags("demo", &opts).Parse(d.args) != nil) {
+		return reportError(fmt.Errorf("Demo '%s' has invalid options", d.name))
+	}
+
+	// The number of aliens of the scenario may be replaced, like in simulation mode
+	rest := args[1:]
+	numaliens := d.aliens
+	if (len(rest) > 0) && (len(rest[0]) > 0) && (rest[0][0] != '-') {
+		n, ratio, err := parseAlienCount(rest[0])
+		if (err != nil) {
+			fmt.Printf("Demo: %s.\n", err);
+			return usageError()
+		}
+		numaliens, opts.AlienRatio = n, ratio
+		rest = rest[1:]
+	}
+
+	flags := simFlags("demo", &opts)
+	if (flags.Parse(rest) != nil) {
+		return usageError()
+	} else if err := checkSimOptions(&opts); err != nil {
+		fmt.Printf("Demo: %s.\n", err);
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for demo mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else {
+		return reportSimError(runDemo(d, numaliens, opts), opts)
+	}
+}
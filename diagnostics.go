@@ -0,0 +1,136 @@
+/*
+   Alien Invasion Simulator - diagnostics bundle
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"time"
+	"runtime"
+	"runtime/debug"
+	"archive/zip"
+	"encoding/json"
+)
+
+// The number of most recent events kept for the diagnostics bundle.
+const DIAGNOSTICS_EVENTS int = 1000
+
+// Keeps the last DIAGNOSTICS_EVENTS events of a simulation (moves included), for the diagnostics
+//   bundle.
+type EventRing struct {
+	events  []Event
+	next    int     // Where the next event goes, once the ring is full
+}
+
+// Starts recording the events of a simulation.
+func (ring *EventRing) attach(sim *Simulator) {
+	sim.Subscribe(func(ev Event) {
+		if (len(ring.events) < DIAGNOSTICS_EVENTS) {
+			ring.events = append(ring.events, ev)
+			return
+		}
+		ring.events[ring.next] = ev
+		ring.next = (ring.next + 1) % DIAGNOSTICS_EVENTS
+	}, true)
+}
+
+// The recorded events, oldest first.
+func (ring *EventRing) last() []Event {
+	return append(append([]Event{}, ring.events[ring.next:]...), ring.events[:ring.next]...)
+}
+
+// The configuration of the failed run, as saved in the diagnostics bundle.
+type DiagnosticsConfig struct {
+	Args        []string    `json:"args"`
+	GoVersion   string      `json:"go_version"`
+	Time        string      `json:"time"`
+	MapFile     string      `json:"mapfile"`
+	Aliens      int         `json:"aliens"`
+	Options     SimOptions  `json:"options"`
+}
+
+// Runs a simulation (or its interactive session), turning a panic of the simulator into an error
+//   so that the diagnostics bundle can still be written. The stack of the panic is returned too.
+func runGuarded(run func() error) (err error, stack []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Simulator panic: %v", r)
+			stack = debug.Stack()
+		}
+	}()
+	return run(), nil
+}
+
+// Writes a zip file with everything needed to reproduce a fatal simulation error:
+//
+//   error.txt         the error, and the stack if the simulator panicked
+//   config.json       the command line and the simulation options
+//   checkpoint.json   the simulation state at the time of the error, RNG state included
+//                     (it can be given to -resume)
+//   events.json       the last DIAGNOSTICS_EVENTS events, moves included
+//   map.txt           the input map file, if it can still be read
+func writeDiagnostics(filename string, overwrite bool, mapfile string, sim *Simulator, ring *EventRing, simErr error, stack []byte) error {
+	config := DiagnosticsConfig{
+		Args:       os.Args,
+		GoVersion:  runtime.Version(),
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		MapFile:    mapfile,
+		Aliens:     len(sim.aliens),
+		Options:    sim.opts,
+	}
+
+	return writeFileAtomic(filename, overwrite, func(w io.Writer) error {
+		zw := zip.NewWriter(w)
+		now := time.Now()
+		create := func(name string) (io.Writer, error) {
+			return zw.CreateHeader(&zip.FileHeader{ Name: name, Method: zip.Deflate, Modified: now })
+		}
+
+		addJSON := func(name string, v interface{}) error {
+			data, err := json.MarshalIndent(v, "", "  ")
+			if (err != nil) {
+				return err
+			}
+			f, err := create(name)
+			if (err != nil) {
+				return err
+			}
+			_, err = f.Write(append(data, '\n'))
+			return err
+		}
+
+		f, err := create("error.txt")
+		if (err != nil) {
+			return err
+		}
+		fmt.Fprintf(f, "%s\n", simErr)
+		if (stack != nil) {
+			fmt.Fprintf(f, "\n%s", stack)
+		}
+
+		if err := addJSON("config.json", config); err != nil {
+			return err
+		}
+		if err := addJSON("checkpoint.json", sim.Checkpoint(mapfile)); err != nil {
+			return err
+		}
+		if err := addJSON("events.json", ring.last()); err != nil {
+			return err
+		}
+
+		if data, err := os.ReadFile(mapfile); err == nil {
+			f, err := create("map.txt")
+			if (err != nil) {
+				return err
+			}
+			if _, err := f.Write(data); err != nil {
+				return err
+			}
+		}
+
+		return zw.Close()
+	})
+}
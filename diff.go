@@ -0,0 +1,160 @@
+/*
+   Alien Invasion Simulator - result map diff
+*/
+
+package main
+
+import (
+	"fmt"
+	"flag"
+)
+
+// A road of a map, as found in a diff.
+type DiffRoad struct {
+	From      string  `json:"from"`
+	Dir       string  `json:"dir"`
+	To        string  `json:"to"`
+	WithCity  bool    `json:"with_city"`   // Lost because one of its cities was destroyed
+}
+
+// The differences between a map and the result of simulating it: the cities that were destroyed
+//   and the roads that were lost, in the order of the original map file.
+type MapDiff struct {
+	Original         string      `json:"original"`
+	Result           string      `json:"result"`
+	Cities           int         `json:"cities"`
+	Roads            int         `json:"roads"`
+	CitiesDestroyed  []string    `json:"cities_destroyed"`
+	RoadsLost        []DiffRoad  `json:"roads_lost"`
+	CitiesAdded      []string    `json:"cities_added,omitempty"`   // Cities of the result that are not in the original
+	RoadsAdded       []DiffRoad  `json:"roads_added,omitempty"`    // Roads of the result that are not in the original
+	DestroyedPct     float64     `json:"cities_destroyed_pct"`
+	LostPct          float64     `json:"roads_lost_pct"`
+}
+
+// The road in direction "dir" of city "name" of a map, as the name of the city it leads to, or
+//   "" if there is none (or the city is not in the map).
+func roadTo(nodes SNodeArray, nodeMap SNodeMap, name string, dir int) string {
+	idx, ok := nodeMap[name]
+	if (! ok) {
+		return ""
+	}
+	if to := nodes[idx].road(dir); (to != -1) {
+		return nodes[to].cityName
+	}
+	return ""
+}
+
+// Finds the cities and roads of map "a" that are missing from map "b". Roads are compared by the
+//   names of their cities and their direction, so the order of the lines and of the roads in each
+//   line, and which end of a road declares it, don't matter.
+func missing(a SNodeArray, b SNodeArray, bMap SNodeMap) ([]string, []DiffRoad) {
+	cities := []string{}
+	roads := []DiffRoad{}
+	for i := 0; i < len(a); i++ {
+		if _, ok := bMap[a[i].cityName]; (! ok) {
+			cities = append(cities, a[i].cityName)
+		}
+	}
+	forEachRoad(a, func(from int, road SRoad) {
+		fromName, toName := a[from].cityName, a[road.to].cityName
+		if (roadTo(b, bMap, fromName, road.dir) != toName) {
+			_, fromOk := bMap[fromName]
+			_, toOk := bMap[toName]
+			roads = append(roads, DiffRoad{ From: fromName, Dir: directionName(road.dir), To: toName, WithCity: (! fromOk) || (! toOk) })
+		}
+	})
+	return cities, roads
+}
+
+// Compares a map file with the result map file of a simulation.
+func diffMaps(original string, result string) (*MapDiff, error) {
+	a, aMap, err := loadMap(original)
+	if (err != nil) {
+		return nil, err
+	}
+	b, bMap, err := loadMap(result)
+	if (err != nil) {
+		return nil, err
+	}
+
+	d := &MapDiff{ Original: original, Result: result, Cities: len(a), Roads: countRoads(a, false) }
+	d.CitiesDestroyed, d.RoadsLost = missing(a, b, bMap)
+	d.CitiesAdded, d.RoadsAdded = missing(b, a, aMap)
+	if (d.Cities > 0) {
+		d.DestroyedPct = 100 * float64(len(d.CitiesDestroyed)) / float64(d.Cities)
+	}
+	if (d.Roads > 0) {
+		d.LostPct = 100 * float64(len(d.RoadsLost)) / float64(d.Roads)
+	}
+	return d, nil
+}
+
+// Prints a diff for humans.
+func (d *MapDiff) Print() {
+	fmt.Printf("\nCities destroyed (%d):\n", len(d.CitiesDestroyed))
+	for _, c := range d.CitiesDestroyed {
+		fmt.Printf("   %s\n", c)
+	}
+
+	own := 0
+	fmt.Printf("\nRoads lost (%d):\n", len(d.RoadsLost))
+	for _, r := range d.RoadsLost {
+		if (r.WithCity) {
+			fmt.Printf("   %s %s=%s (with its city)\n", r.From, r.Dir, r.To)
+		} else {
+			fmt.Printf("   %s %s=%s\n", r.From, r.Dir, r.To)
+			own ++
+		}
+	}
+
+	if (len(d.CitiesAdded) > 0) || (len(d.RoadsAdded) > 0) {
+		fmt.Printf("\nWARNING: The result has %d cities and %d roads that are not in the original map:\n", len(d.CitiesAdded), len(d.RoadsAdded))
+		for _, c := range d.CitiesAdded {
+			fmt.Printf("   %s\n", c)
+		}
+		for _, r := range d.RoadsAdded {
+			fmt.Printf("   %s %s=%s\n", r.From, r.Dir, r.To)
+		}
+	}
+
+	fmt.Println("\nDiff:");
+	fmt.Printf("   Cities destroyed:  %d of %d (%.1f%%)\n", len(d.CitiesDestroyed), d.Cities, d.DestroyedPct);
+	fmt.Printf("   Roads lost:        %d of %d (%.1f%%, %d on their own)\n", len(d.RoadsLost), d.Roads, d.LostPct, own);
+}
+
+// Handles the command line of the map diff mode: -diff <ORIGINAL> <RESULT> [-json F] [-overwrite]
+func mainDiff(args []string) int {
+	var jsonFile string
+	var overwrite bool
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&jsonFile, "json", "", "also write the diff as JSON to this file")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace the JSON file if it already exists")
+
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for map diff mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map diff mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+
+	fmt.Printf("Will compare mapfile '%s' with result mapfile '%s'.\n", args[0], args[1])
+
+	d, err := diffMaps(args[0], args[1])
+	if (err != nil) {
+		return reportError(err)
+	}
+	d.Print()
+
+	if (jsonFile != "") {
+		fmt.Printf("\nWriting the diff to '%s'.\n", jsonFile)
+		return reportError(saveJSON(jsonFile, overwrite, d))
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,253 @@
+/*
+   Alien Invasion Simulator - road directions
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------------------------------
+// Direction registry
+// ---------------------------------------------------------------------------------------------------
+
+// Roads are labeled with a direction, and every direction has an opposite: if city A has a road
+//   to city B in some direction, then city B has a road to city A in the opposite direction.
+// The directions are interned into small integers, so that SNode roads don't store strings. The
+//   four cardinal directions are always 0 to 3 (EAST, SOUTH, WEST and NORTH), followed by the
+//   other built-in directions. Map files may declare more direction pairs with a directive line
+//   (see readMap), which are added to the registry as they are read. A label may be its own
+//   opposite (e.g. "portal/portal").
+// The registry is shared by all the maps read by the program, so a label can only have one
+//   opposite; a map that pairs it differently is rejected.
+
+// The built-in direction pairs. The first two pairs must stay the cardinal directions, in the
+//   order of the EAST, SOUTH, WEST and NORTH indices.
+var builtinDirections = [][2]string{
+	{ "east", "west" }, { "south", "north" },
+	{ "northeast", "southwest" }, { "southeast", "northwest" },
+	{ "up", "down" }, { "in", "out" },
+}
+
+// The directive that declares direction pairs in a map file, e.g. "%directions portal/portal".
+const DIRECTIONS_DIRECTIVE string = "%directions"
+
+type directionRegistry struct {
+	mu        sync.RWMutex
+	names     []string          // Label of each direction
+	index     map[string]int    // Direction of each label
+	opposite  []int             // Opposite of each direction
+	builtin   int               // Number of built-in directions
+}
+
+var directions = newDirectionRegistry()
+
+func newDirectionRegistry() *directionRegistry {
+	reg := &directionRegistry{ index: make(map[string]int) }
+
+	// Intern the cardinal directions first, so that they get the EAST, SOUTH, WEST and NORTH indices.
+	order := []string{ "east", "south", "west", "north" }
+	for _, pair := range builtinDirections[2:] {
+		order = append(order, pair[0], pair[1])
+	}
+	for _, name := range order {
+		reg.index[name] = len(reg.names)
+		reg.names = append(reg.names, name)
+		reg.opposite = append(reg.opposite, -1)
+	}
+	for _, pair := range builtinDirections {
+		a, b := reg.index[pair[0]], reg.index[pair[1]]
+		reg.opposite[a], reg.opposite[b] = b, a
+	}
+	reg.builtin = len(reg.names)
+	return reg
+}
+
+// The label of a direction.
+func directionName(d int) string {
+	directions.mu.RLock()
+	defer directions.mu.RUnlock()
+	return directions.names[d]
+}
+
+// The direction of a label, or false if the label is not known.
+func lookupDirection(label string) (int, bool) {
+	directions.mu.RLock()
+	defer directions.mu.RUnlock()
+	d, ok := directions.index[label]
+	return d, ok
+}
+
+// Converts a direction into its opposite, e.g. NORTH ( 3 ) becomes SOUTH ( 1 ).
+func opposite(d int) int {
+	directions.mu.RLock()
+	defer directions.mu.RUnlock()
+	return directions.opposite[d]
+}
+
+// Returns true for the directions that don't need to be declared in a map file.
+func builtinDirection(d int) bool {
+	return d < directions.builtin
+}
+
+// Declares a pair of opposite directions. Declaring a pair that is already known is fine, but a
+//   label can't be paired with two different opposites.
+func declareDirections(a string, b string) error {
+	if (a == "") || (b == "") || (strings.ContainsAny(a + b, "= /")) || (a == POPULATION_KEY) || (b == POPULATION_KEY) {
+		return fmt.Errorf("Invalid direction pair '%s/%s'", a, b)
+	}
+
+	directions.mu.Lock()
+	defer directions.mu.Unlock()
+
+	da, oka := directions.index[a]
+	db, okb := directions.index[b]
+	switch {
+	case (oka) && (okb) && (directions.opposite[da] == db):
+		return nil
+	case (oka):
+		return fmt.Errorf("Direction '%s' is already the opposite of '%s'", a, directions.names[directions.opposite[da]])
+	case (okb):
+		return fmt.Errorf("Direction '%s' is already the opposite of '%s'", b, directions.names[directions.opposite[db]])
+	}
+
+	da = len(directions.names)
+	directions.index[a] = da
+	directions.names = append(directions.names, a)
+	directions.opposite = append(directions.opposite, da)
+	if (b != a) {
+		db = len(directions.names)
+		directions.index[b] = db
+		directions.names = append(directions.names, b)
+		directions.opposite = append(directions.opposite, da)
+		directions.opposite[da] = db
+	}
+	return nil
+}
+
+// Parses the pairs of a directive line ("%directions a/b c/d ..."), declaring each of them.
+func parseDirectionsDirective(line string) error {
+	items := strings.Fields(line)[1:]
+	if (len(items) == 0) {
+		return fmt.Errorf("The %s directive declares no direction pairs", DIRECTIONS_DIRECTIVE)
+	}
+	for _, item := range items {
+		pair := strings.Split(item, "/")
+		if (len(pair) != 2) {
+			return fmt.Errorf("Invalid direction pair '%s' (must be 'label/opposite')", item)
+		}
+		if err := declareDirections(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The grid step of the compass directions, for laying out maps on a grid. Returns false for the
+//   directions with no place in a grid (e.g. up/down, or declared ones).
+func directionDelta(d int) (dx int, dy int, ok bool) {
+	switch (directionName(d)) {
+	case "east":      return  1,  0, true
+	case "south":     return  0,  1, true
+	case "west":      return -1,  0, true
+	case "north":     return  0, -1, true
+	case "northeast": return  1, -1, true
+	case "southwest": return -1,  1, true
+	case "southeast": return  1,  1, true
+	case "northwest": return -1, -1, true
+	}
+	return 0, 0, false
+}
+
+// ---------------------------------------------------------------------------------------------------
+// City roads
+// ---------------------------------------------------------------------------------------------------
+
+// A road leaving a city.
+type SRoad struct {
+	dir  int    // Direction of the road
+	to   int    // Index into a city data store of the city at the other end
+}
+
+// A road declared in a map file, before the city names are resolved (for the validator).
+type SRoadName struct {
+	dir   int
+	name  string
+}
+
+// The city reached by the road leaving a node in direction "dir", or -1 if there is none.
+func (node *SNode) road(dir int) int {
+	for _, r := range node.roads {
+		if (r.dir == dir) {
+			return r.to
+		}
+	}
+	return -1
+}
+
+// Replaces the road leaving a node in direction "dir" with a road to city "to", or removes it if
+//   "to" is -1. This only changes this end of the road (see Simulator.SetRoad).
+// The roads are kept sorted by direction, which the mover relies on.
+func (node *SNode) setRoad(dir int, to int) {
+	for i, r := range node.roads {
+		if (r.dir == dir) {
+			if (to == -1) {
+				node.roads = append(node.roads[:i], node.roads[i+1:]...)
+			} else {
+				node.roads[i].to = to
+			}
+			return
+		}
+	}
+	if (to == -1) {
+		return
+	}
+	i := sort.Search(len(node.roads), func(k int) bool { return node.roads[k].dir > dir })
+	node.roads = append(node.roads, SRoad{})
+	copy(node.roads[i+1:], node.roads[i:])
+	node.roads[i] = SRoad{ dir, to }
+}
+
+// The name of the city declared in direction "dir" of a list of declared roads, or "" if none.
+func sroadName(sroads []SRoadName, dir int) string {
+	for _, r := range sroads {
+		if (r.dir == dir) {
+			return r.name
+		}
+	}
+	return ""
+}
+
+// The direction pairs used by a map that are not built in, as "label/opposite" strings in the
+//   order of the registry, for the directive line of a map file.
+func customDirections(nodes SNodeArray) []string {
+	used := make(map[int]bool)
+	for i := 0; i < len(nodes); i++ {
+		for _, r := range nodes[i].roads {
+			if (! builtinDirection(r.dir)) {
+				used[r.dir] = true
+			}
+		}
+	}
+	var dirs []int
+	for d := range used {
+		dirs = append(dirs, d)
+	}
+	sort.Ints(dirs)
+
+	var pairs []string
+	seen := make(map[int]bool)
+	for _, d := range dirs {
+		od := opposite(d)
+		if (seen[d]) || (seen[od]) {
+			continue
+		}
+		seen[d], seen[od] = true, true
+		pairs = append(pairs, directionName(d) + "/" + directionName(od))
+	}
+	return pairs
+}
@@ -0,0 +1,584 @@
+/*
+   Alien Invasion Simulator - simulation mode driver
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"flag"
+	"strconv"
+	"strings"
+	"math"
+	"time"
+)
+
+// The options of a simulation run when no flags are given.
+func defaultSimOptions() SimOptions {
+	return SimOptions{
+		MaxSteps:        10000,
+		FightThreshold:  2,
+		CombatModel:     COMBAT_ANNIHILATE,
+		HitPoints:       1,
+		CityHitPoints:   DEFAULT_CITY_HIT_POINTS,
+		Movement:        MOVEMENT_SEQUENTIAL,
+		StreamEvery:     100,
+		WatchDelay:      200 * time.Millisecond,
+	}
+}
+
+// Creates the flag set for the simulation options. The current values in "opts" are the defaults.
+func simFlags(name string, opts *SimOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.BoolVar(&opts.SpawnBorder, "spawn-border", opts.SpawnBorder, "spawn aliens only at border cities")
+	flags.StringVar(&opts.SpawnPolicy, "spawn-policy", opts.SpawnPolicy, "uniform, clustered, perimeter, weighted-degree or weighted-population")
+	flags.IntVar(&opts.MaxSteps, "max-steps", opts.MaxSteps, "maximum number of movement steps")
+	flags.Var(&opts.StopWhen, "stop-when", "termination condition (all-trapped, half-destroyed)")
+	flags.IntVar(&opts.StopAfterQuiescent, "stop-after-quiescent", opts.StopAfterQuiescent, "stop after K steps without fights")
+	flags.Float64Var(&opts.DefenseRate, "defense-rate", opts.DefenseRate, "defense points gained by every surviving city in each step")
+	flags.IntVar(&opts.FightThreshold, "fight-threshold", opts.FightThreshold, "number of aliens in a city that makes them fight")
+	flags.BoolVar(&opts.SpareCities, "spare-cities", opts.SpareCities, "fights kill the aliens but leave the city standing")
+	flags.StringVar(&opts.CombatModel, "combat-model", opts.CombatModel, "annihilate, wounds or damage")
+	flags.IntVar(&opts.HitPoints, "hit-points", opts.HitPoints, "hit points of every alien (for -combat-model wounds or damage)")
+	flags.IntVar(&opts.CityHitPoints, "city-hit-points", opts.CityHitPoints, "hit points of every city (for -combat-model damage)")
+	flags.StringVar(&opts.Movement, "movement", opts.Movement, "sequential, simultaneous or parallel")
+	flags.IntVar(&opts.Workers, "workers", opts.Workers, "goroutines that pick the moves with -movement parallel (0 for one per CPU)")
+	flags.IntVar(&opts.Factions, "factions", opts.Factions, "number of alien factions that fight each other")
+	flags.BoolVar(&opts.Defenders, "defenders", opts.Defenders, "the population of a city may kill the aliens fighting in it")
+	flags.Float64Var(&opts.RoadDecay, "road-decay", opts.RoadDecay, "probability that each road is destroyed in each step")
+	flags.Float64Var(&opts.Collateral, "collateral", opts.Collateral, "probability that each road next to a destroyed city is destroyed with it")
+	flags.Float64Var(&opts.FearOfRuins, "fear-of-ruins", opts.FearOfRuins, "probability that an alien avoids moving next to destroyed cities")
+	flags.StringVar(&opts.Script, "script", opts.Script, "Starlark file with the movement strategy of the aliens")
+	flags.Var(&opts.Waves, "waves", "spawn the aliens in <COUNT>x<INTERVAL> waves")
+	flags.Var(&opts.MaxMemory, "max-memory", "refuse to simulate models estimated to need more memory than this")
+	flags.BoolVar(&opts.TwoPass, "two-pass", opts.TwoPass, "read the map file twice, which needs less memory for very large maps")
+	flags.BoolVar(&opts.Overwrite, "overwrite", opts.Overwrite, "replace existing output files")
+	flags.StringVar(&opts.OutFile, "out", opts.OutFile, "write the result map to this file ('-' for the standard output)")
+	flags.BoolVar(&opts.Interactive, "interactive", opts.Interactive, "pause between steps and read commands")
+	flags.BoolVar(&opts.Watch, "watch", opts.Watch, "draw the grid on the terminal after every step")
+	flags.DurationVar(&opts.WatchDelay, "frame-delay", opts.WatchDelay, "delay between watch mode frames")
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "seed for the random number generator")
+	flags.BoolVar(&opts.RecordPaths, "paths", opts.RecordPaths, "write the path of each alien to <MAPFILE>.paths")
+	flags.BoolVar(&opts.WriteVisits, "visits", opts.WriteVisits, "write the last visit of each city to <MAPFILE>.visits")
+	flags.BoolVar(&opts.WritePositions, "positions", opts.WritePositions, "write the final city of each live alien to <MAPFILE>.positions")
+	flags.StringVar(&opts.StreamFile, "stream", opts.StreamFile, "stream events and partial results to this file")
+	flags.IntVar(&opts.StreamEvery, "stream-every", opts.StreamEvery, "steps between the partial results in the stream")
+	flags.StringVar(&opts.StatsFile, "stats", opts.StatsFile, "write per-iteration statistics to this CSV file")
+	flags.IntVar(&opts.CheckpointEvery, "checkpoint-every", opts.CheckpointEvery, "steps between checkpoints")
+	flags.BoolVar(&opts.Quiet, "quiet", opts.Quiet, "only log errors")
+	flags.BoolVar(&opts.Verbose, "verbose", opts.Verbose, "also log every alien move")
+	flags.StringVar(&opts.LogFormat, "log-format", opts.LogFormat, "text or json")
+	flags.Var(&opts.Mute, "mute", "kinds of messages not to log (e.g. destroyed,repelled)")
+	flags.StringVar(&opts.DiagnosticsFile, "diagnostics", opts.DiagnosticsFile, "write a diagnostics bundle (zip) to this file on a fatal error")
+	flags.BoolVar(&opts.CheckInvariants, "check-invariants", opts.CheckInvariants, "check the simulation state after every step and abort if it is broken")
+	flags.DurationVar(&opts.ProgressEvery, "progress", opts.ProgressEvery, "log a progress line at this interval instead of progress dots")
+	flags.DurationVar(&opts.TimeLimit, "time-limit", opts.TimeLimit, "stop the simulation after this much time")
+	return flags
+}
+
+// Checks the option values that the flag parser can't check by itself.
+func checkSimOptions(opts *SimOptions) error {
+	if (opts.MaxSteps < 0) || (opts.StopAfterQuiescent < 0) || (opts.StreamEvery < 0) || (opts.CheckpointEvery < 0) {
+		return fmt.Errorf("Step counts must not be negative")
+	}
+	if (! (opts.DefenseRate >= 0)) || (math.IsInf(opts.DefenseRate, 0)) {
+		return fmt.Errorf("The defense rate must be a non-negative number")
+	}
+	if (opts.FightThreshold < 2) {
+		return fmt.Errorf("The fight threshold must be at least 2")
+	}
+	if (opts.Factions < 0) {
+		return fmt.Errorf("The number of factions must not be negative")
+	}
+	if (opts.HitPoints < 1) || (opts.CityHitPoints < 1) {
+		return fmt.Errorf("Hit points must be at least 1")
+	}
+	if err := checkCombatModel(opts.CombatModel); err != nil {
+		return err
+	}
+	if (! ((opts.FearOfRuins >= 0) && (opts.FearOfRuins <= 1))) {
+		return fmt.Errorf("The fear of ruins must be a probability in the [0, 1] range")
+	}
+	if (! ((opts.RoadDecay >= 0) && (opts.RoadDecay <= 1))) || (! ((opts.Collateral >= 0) && (opts.Collateral <= 1))) {
+		return fmt.Errorf("The road decay and collateral damage must be probabilities in the [0, 1] range")
+	}
+	if err := checkSpawnPolicy(opts.SpawnPolicy); err != nil {
+		return err
+	}
+	if err := checkLogFormat(opts.LogFormat); err != nil {
+		return err
+	}
+	if (opts.Quiet) && (opts.Verbose) {
+		return fmt.Errorf("-quiet and -verbose can't be combined")
+	}
+	if (opts.SpawnBorder) && (opts.SpawnPolicy != "") && (opts.SpawnPolicy != SPAWN_PERIMETER) {
+		return fmt.Errorf("-spawn-border is the same as -spawn-policy %s, and can't be combined with -spawn-policy %s", SPAWN_PERIMETER, opts.SpawnPolicy)
+	}
+	if (opts.Workers < 0) {
+		return fmt.Errorf("The number of workers must not be negative")
+	}
+	if (opts.ProgressEvery < 0) || (opts.TimeLimit < 0) {
+		return fmt.Errorf("The progress interval and the time limit must not be negative")
+	}
+	return checkMovement(opts.Movement)
+}
+
+// Checks the name of a movement mode ("" means sequential).
+func checkMovement(movement string) error {
+	if (movement != "") && (movement != MOVEMENT_SEQUENTIAL) && (movement != MOVEMENT_SIMULTANEOUS) && (movement != MOVEMENT_PARALLEL) {
+		return fmt.Errorf("Unknown movement mode '%s' (must be '%s', '%s' or '%s')", movement, MOVEMENT_SEQUENTIAL, MOVEMENT_SIMULTANEOUS, MOVEMENT_PARALLEL)
+	}
+	return nil
+}
+
+// Checks the name of a combat model ("" means annihilate).
+func checkCombatModel(model string) error {
+	if (model != "") && (model != COMBAT_ANNIHILATE) && (model != COMBAT_WOUNDS) && (model != COMBAT_DAMAGE) {
+		return fmt.Errorf("Unknown combat model '%s' (must be '%s', '%s' or '%s')", model, COMBAT_ANNIHILATE, COMBAT_WOUNDS, COMBAT_DAMAGE)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Automatic number of aliens
+// ---------------------------------------------------------------------------------------------------
+
+// The number of aliens per alive city used by "auto" when no ratio is given.
+const DEFAULT_ALIEN_RATIO float64 = 0.1
+
+// Parses the number of aliens argument, which is either a positive integer or "auto[:RATIO]".
+// Returns the number of aliens, or the ratio of aliens to alive cities if it is "auto".
+func parseAlienCount(s string) (int, float64, error) {
+	if (s == "auto") {
+		return 0, DEFAULT_ALIEN_RATIO, nil
+	}
+	if (strings.HasPrefix(s, "auto:")) {
+		ratio, err := strconv.ParseFloat(s[len("auto:"):], 64)
+		if (err != nil) || (ratio <= 0) || (math.IsInf(ratio, 0)) || (math.IsNaN(ratio)) {
+			return 0, 0, fmt.Errorf("Invalid alien ratio in '%s' (must be a positive number)", s)
+		}
+		return 0, ratio, nil
+	}
+	numaliens, err := strconv.Atoi(s)
+	if (err != nil) || (numaliens < 0) {
+		return 0, 0, fmt.Errorf("Invalid number of aliens '%s' (must be a positive integer, 'auto' or 'auto:RATIO')", s)
+	}
+	return numaliens, 0, nil
+}
+
+// Picks a number of aliens for a map as a ratio of its alive cities, rounded, and at least one
+//   if the map has any alive city.
+func autoAliens(nodes SNodeArray, ratio float64) int {
+	alive := 0
+	for i := 0; i < len(nodes); i++ {
+		if (! nodes[i].dead) {
+			alive ++
+		}
+	}
+	numaliens := int(math.Round(ratio * float64(alive)))
+	if (numaliens < 1) && (alive > 0) {
+		numaliens = 1
+	}
+	return numaliens
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Simulation mode
+// ---------------------------------------------------------------------------------------------------
+
+// Simulates a map with "numaliens" aliens or, if opts.AlienRatio is set, with a number of aliens
+//   chosen from the size of the map (see autoAliens).
+func simulate(mapfile string, numaliens int, opts SimOptions) error {
+	log := newLogger(os.Stdout, opts)
+
+	if (opts.AlienRatio > 0) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Will read mapfile '%s' and simulate it with %g aliens per city.\n", mapfile, opts.AlienRatio)
+	} else {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Will read mapfile '%s' and simulate it with %d aliens.\n", mapfile, numaliens)
+	}
+
+	if err := checkOutputs(mapfile, opts); err != nil {
+		return err
+	}
+
+	if err := checkMapFileSize(mapfile, opts); err != nil {
+		return &AbortedError{ err }
+	}
+
+	load := loadMap
+	if (opts.TwoPass) {
+		load = loadMapTwoPass
+	}
+	nodes, nodeMap, err := load(mapfile)
+	if (err != nil) {
+		return err
+	}
+
+	log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Successfully read %d cities from the input file.\n", len(nodes))
+
+	if (opts.AlienRatio > 0) {
+		numaliens = autoAliens(nodes, opts.AlienRatio)
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Chose %d aliens for %d cities.\n", numaliens, len(nodes))
+	}
+
+	if err := checkMemory(nodes, numaliens, opts); err != nil {
+		return &AbortedError{ err }
+	}
+
+	if err := loadScriptStrategy(&opts); err != nil {
+		return err
+	}
+
+	sim := NewSimulator(nodes, nodeMap, numaliens, opts)
+	return runSimulation(mapfile, sim, opts, true)
+}
+
+// Resumes a simulation from a checkpoint file written with -checkpoint-every.
+func resume(cpfile string, cp *Checkpoint, opts SimOptions) error {
+	log := newLogger(os.Stdout, opts)
+
+	log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Will resume the simulation of mapfile '%s' from checkpoint '%s' at iteration %d.\n", cp.MapFile, cpfile, cp.Iteration)
+
+	if err := checkOutputs(cp.MapFile, opts); err != nil {
+		return err
+	}
+
+	if err := loadScriptStrategy(&opts); err != nil {
+		return err
+	}
+
+	sim, err := restoreSimulator(cp, opts)
+	if (err != nil) {
+		return err
+	}
+
+	if err := checkMemory(sim.nodes, len(sim.aliens), opts); err != nil {
+		return &AbortedError{ err }
+	}
+
+	return runSimulation(cp.MapFile, sim, opts, false)
+}
+
+// Sets the movement strategy of the aliens to the script given with -script, if any.
+func loadScriptStrategy(opts *SimOptions) error {
+	if (opts.Script == "") {
+		return nil
+	}
+	if (opts.FearOfRuins > 0) {
+		return fmt.Errorf("-script and -fear-of-ruins can't be combined: the script chooses the moves")
+	}
+	if (opts.Movement == MOVEMENT_PARALLEL) {
+		return fmt.Errorf("-script and -movement %s can't be combined: scripts can't choose moves in parallel", MOVEMENT_PARALLEL)
+	}
+	s, err := loadScript(opts.Script)
+	if (err != nil) {
+		return err
+	}
+	opts.Strategy = s
+	return nil
+}
+
+// The name of the result map file of a simulation of "mapfile": SimOptions.OutFile if given (which
+//   may be STDIO), or "<MAPFILE>.result" ("<MAPFILE>.result.gz" for a compressed map file).
+func resultFile(mapfile string, opts SimOptions) string {
+	if (opts.OutFile != "") {
+		return opts.OutFile
+	}
+	if (gzipped(mapfile)) {
+		return outputFile(mapfile, ".result" + GZIP_SUFFIX)
+	}
+	return outputFile(mapfile, ".result")
+}
+
+// Refuses to start if we would clobber the results of a previous run.
+func checkOutputs(mapfile string, opts SimOptions) error {
+	if (opts.Overwrite) {
+		return nil
+	}
+
+	outputs := []string{ resultFile(mapfile, opts), outputFile(mapfile, ".summary.json") }
+	if (opts.RecordPaths) {
+		outputs = append(outputs, outputFile(mapfile, ".paths"))
+	}
+	if (opts.WriteVisits) {
+		outputs = append(outputs, outputFile(mapfile, ".visits"))
+	}
+	if (opts.WritePositions) {
+		outputs = append(outputs, outputFile(mapfile, ".positions"))
+	}
+	if (opts.CheckpointEvery > 0) {
+		outputs = append(outputs, outputFile(mapfile, ".checkpoint"))
+	}
+	if (opts.DiagnosticsFile != "") {
+		outputs = append(outputs, opts.DiagnosticsFile)
+	}
+	for _, f := range outputs {
+		if err := checkNoOverwrite(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs a simulation to the end and writes its output files. If "spawn" is false, the aliens
+//   have already been placed (i.e. the simulation was restored from a checkpoint).
+// An error that stops the simulation is returned as an AbortedError. The errors writing the
+//   output files after it are logged as they happen, and summed up in the error returned.
+func runSimulation(mapfile string, sim *Simulator, opts SimOptions, spawn bool) error {
+	log := newLogger(os.Stdout, opts)
+	resultFileName := resultFile(mapfile, opts)
+	summaryFileName := outputFile(mapfile, ".summary.json")
+	pathsFileName := outputFile(mapfile, ".paths")
+	visitsFileName := outputFile(mapfile, ".visits")
+	positionsFileName := outputFile(mapfile, ".positions")
+	checkpointFileName := outputFile(mapfile, ".checkpoint")
+
+	var err error
+	var stream *StreamWriter
+	if (opts.StreamFile != "") {
+		stream, err = newStreamWriter(opts.StreamFile, opts.StreamEvery, opts.Overwrite)
+		if (err != nil) {
+			return err
+		}
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Streaming destruction events and partial results to '%s'.\n", opts.StreamFile)
+		stream.attach(sim)
+	}
+
+	var stats *StatsWriter
+	if (opts.StatsFile != "") {
+		stats, err = newStatsWriter(opts.StatsFile, opts.Overwrite)
+		if (err != nil) {
+			if (stream != nil) {
+				stream.close(nil)
+			}
+			return err
+		}
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Writing per-iteration statistics to '%s'.\n", opts.StatsFile)
+		stats.attach(sim)
+	}
+
+	var ring *EventRing
+	if (opts.DiagnosticsFile != "") {
+		ring = &EventRing{}
+		ring.attach(sim)
+	}
+
+	if (spawn) && (! sim.Spawn()) {
+		if (stream != nil) {
+			stream.close(nil)
+		}
+		if (stats != nil) {
+			stats.close()
+		}
+		return nil
+	}
+
+	if (stream != nil) {
+		stream.flush()
+	}
+	if (stats != nil) {
+		stats.sample(sim)
+	}
+
+	failed := 0
+	if (opts.CheckpointEvery > 0) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Writing a checkpoint to '%s' every %d steps.\n", checkpointFileName, opts.CheckpointEvery)
+		sim.AddAfterIteration(func(s *Simulator, iteration int) {
+			if (s.iteration % opts.CheckpointEvery == 0) {
+				if err := saveCheckpoint(checkpointFileName, s.Checkpoint(mapfile)); err != nil {
+					s.endProgress()
+					log.error(err)
+					failed ++
+				}
+			}
+		})
+	}
+
+	rw := watchRun(sim, opts)
+	if (opts.TimeLimit > 0) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Will stop the simulation after %v.\n", opts.TimeLimit)
+	}
+
+	if (opts.Watch) && (! sim.watch(opts.WatchDelay)) {
+		log.logf(LOG_ERROR, LOG_KIND_RUN, 0, "WARNING: Watch mode needs a grid map, with city names that encode the coordinates (e.g. 'X3Y7') or roads that fit in a grid; not watching.\n")
+	}
+
+	run := func() error {
+		if (opts.Interactive) {
+			return sim.Interact(os.Stdin)
+		}
+		return sim.Run()
+	}
+	var stack []byte
+	if (ring != nil) {
+		err, stack = runGuarded(run)
+	} else {
+		err = run()
+	}
+	rw.stop()
+	if (err != nil) {
+		sim.endProgress()
+		if (ring != nil) {
+			if derr := writeDiagnostics(opts.DiagnosticsFile, opts.Overwrite, mapfile, sim, ring, err, stack); derr != nil {
+				log.error(derr)
+			} else {
+				log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Wrote a diagnostics bundle to '%s'. Please attach it to your bug report.\n", opts.DiagnosticsFile)
+			}
+		}
+		if (stream != nil) {
+			stream.close(nil)
+		}
+		if (stats != nil) {
+			stats.close()
+		}
+		return &AbortedError{ err }
+	}
+
+	// ---------------------------------------------------------------------------------------------------
+	// Report the final summary and save it to "<mapfile>.summary.json"
+	// ---------------------------------------------------------------------------------------------------
+
+	summary := sim.Summary(mapfile)
+	log.summary(&summary)
+
+	if err := saveJSON(summaryFileName, opts.Overwrite, summary); err != nil {
+		log.error(err)
+		failed ++
+	}
+
+	if (stream != nil) {
+		if err := stream.close(&summary); err != nil {
+			log.error(err)
+			failed ++
+		}
+	}
+
+	if (stats != nil) {
+		if err := stats.close(); err != nil {
+			log.error(err)
+			failed ++
+		}
+	}
+
+	if (opts.RecordPaths) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "\nWriting alien paths to '%s'.\n", pathsFileName);
+		if err := writeFileAtomic(pathsFileName, opts.Overwrite, sim.writePaths); err != nil {
+			log.error(err)
+			failed ++
+		}
+	}
+
+	if (opts.WriteVisits) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "\nWriting city visits to '%s'.\n", visitsFileName);
+		if err := writeFileAtomic(visitsFileName, opts.Overwrite, sim.writeVisits); err != nil {
+			log.error(err)
+			failed ++
+		}
+	}
+
+	if (opts.WritePositions) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "\nWriting alien positions to '%s'.\n", positionsFileName);
+		if err := writeFileAtomic(positionsFileName, opts.Overwrite, sim.writePositions); err != nil {
+			log.error(err)
+			failed ++
+		}
+	}
+
+	// ---------------------------------------------------------------------------------------------------
+	// Serialize the simulator data model to "<mapfile>.result"
+	// ---------------------------------------------------------------------------------------------------
+
+	if (resultFileName == STDIO) {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "\nWriting resulting map to the standard output.\n");
+	} else {
+		log.logf(LOG_INFO, LOG_KIND_RUN, 0, "\nWriting resulting map file to '%s'.\n", resultFileName);
+	}
+
+	if err := saveMap(resultFileName, sim.nodes, opts.Overwrite); err != nil {
+		log.error(err)
+		failed ++
+	}
+
+	if (failed > 0) {
+		return fmt.Errorf("%d output file(s) could not be written", failed)
+	}
+	if (rw.interrupted) {
+		return &AbortedError{ fmt.Errorf("Interrupted at iteration %d; the results so far have been written", sim.iteration) }
+	}
+	log.logf(LOG_INFO, LOG_KIND_RUN, 0, "Done.\n");
+	return nil
+}
+
+// Logs the error of a simulation, if any, in the log format of its options, and returns its exit code.
+func reportSimError(err error, opts SimOptions) int {
+	if (err != nil) {
+		newLogger(os.Stdout, opts).error(err)
+	}
+	return exitCode(err)
+}
+
+// Handles the command line of the simulation mode: <MAPFILE> <NUMALIENS> [options]
+func mainSimulate(args []string) int {
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for simulation mode.");
+		return usageError()
+	}
+
+	opts := defaultSimOptions()
+	flags := simFlags("simulate", &opts)
+	if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	} else if err := checkSimOptions(&opts); err != nil {
+		fmt.Printf("Simulate: %s.\n", err);
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for simulation mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else {
+		mapfile := args[0];
+		numaliens, ratio, err := parseAlienCount( args[1] );
+		if (err != nil) {
+			fmt.Printf("Simulate: %s.\n", err);
+			return usageError()
+		} else if (mapfile == STDIO) && (opts.Interactive) {
+			fmt.Println("Simulate: Interactive mode reads commands from the standard input, so it can't read the map from it too.");
+			return usageError()
+		} else if (mapfile == STDIO) && (opts.TwoPass) {
+			fmt.Println("Simulate: The standard input can't be read twice; read the map from a file with -two-pass.");
+			return usageError()
+		} else {
+			opts.AlienRatio = ratio
+			return reportSimError(simulate(mapfile, numaliens, opts), opts)
+		}
+	}
+}
+
+// Handles the command line of the resume mode: -resume <CHECKPOINT> [options]
+// The simulation options saved in the checkpoint are the defaults for the options given here.
+func mainResume(args []string) int {
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for resume mode.");
+		return usageError()
+	}
+
+	cp, err := loadCheckpoint(args[0])
+	if (err != nil) {
+		return reportError(err)
+	}
+
+	opts := cp.options()
+	flags := simFlags("resume", &opts)
+	if (flags.Parse(args[1:]) != nil) {
+		return usageError()
+	} else if err := checkSimOptions(&opts); err != nil {
+		fmt.Printf("Resume: %s.\n", err);
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for resume mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else {
+		return reportSimError(resume(args[0], cp, opts), opts)
+	}
+}
@@ -0,0 +1,512 @@
+/*
+   Alien Invasion Simulator - map editor and map merging
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"flag"
+	"bufio"
+	"strings"
+)
+
+// The editor changes a map one command at a time, and refuses any change that would make the map
+//   invalid (e.g. a road to a city that doesn't exist, or a second road in the same direction), so
+//   that mistakes are reported where they are made instead of when the map is loaded. Every road
+//   is added and removed together with its opposite, so the roads of an edited map always agree.
+
+func printEditHelp() {
+	fmt.Println("Commands:");
+	fmt.Println("   city <name> [pop=P]         Add a city, with a population of P (default 0).");
+	fmt.Println("   road <city> <dir> <city>    Add a road between two cities, and its opposite.");
+	fmt.Println("   rm-city <name>              Remove a city and all of its roads.");
+	fmt.Println("   rm-road <city> <dir>        Remove the road leaving a city in a direction, and its opposite.");
+	fmt.Println("   pop <name> <P>              Set the population of a city.");
+	fmt.Println("   rename <name> <new>         Rename a city.");
+	fmt.Println("   directions <a/b>...         Declare pairs of opposite road directions (e.g. portal/gate).");
+	fmt.Println("   show <name>                 Show the line of a city in the map file.");
+	fmt.Println("   list                        Show the whole map file.");
+	fmt.Println("   check                       Show the number of cities and roads, isolated cities and");
+	fmt.Println("                               the connected components.");
+	fmt.Println("   save [file]                 Write the map to its file (or to another file).");
+	fmt.Println("   quit                        Leave the editor.");
+	fmt.Println("   help                        Show this list of commands.");
+}
+
+// A map being edited. The cities are kept in the order of the map file, with no dead cities.
+type mapEditor struct {
+	nodes    SNodeArray
+	nodeMap  SNodeMap
+	changed  bool          // There are changes that have not been saved
+}
+
+// Checks that a city name can be written to a map file.
+func checkCityName(name string) error {
+	if (name == "") || (strings.ContainsAny(name, "= \t")) || (strings.HasPrefix(name, "%")) {
+		return fmt.Errorf("Invalid city name '%s' (it can't contain spaces or '=', or start with '%%')", name)
+	}
+	return nil
+}
+
+// The index of a city, or an error if there is no such city.
+func (ed *mapEditor) city(name string) (int, error) {
+	idx, ok := ed.nodeMap[name]
+	if (! ok) {
+		return -1, fmt.Errorf("There is no city '%s'", name)
+	}
+	return idx, nil
+}
+
+// The direction with a label, or an error if it is not known.
+func editDirection(label string) (int, error) {
+	dir, ok := lookupDirection(label)
+	if (! ok) {
+		return -1, fmt.Errorf("Unknown direction '%s' (declare it first, e.g. 'directions %s/<opposite>')", label, label)
+	}
+	return dir, nil
+}
+
+func (ed *mapEditor) addCity(name string, pop int) error {
+	if err := checkCityName(name); err != nil {
+		return err
+	}
+	if _, exists := ed.nodeMap[name]; (exists) {
+		return fmt.Errorf("City '%s' already exists", name)
+	}
+	idx := len(ed.nodes)
+	ed.nodes = append(ed.nodes, SNode{ index: idx, cityName: name, lastVisit: -1, pop: pop })
+	ed.nodeMap[name] = idx
+	ed.changed = true
+	return nil
+}
+
+// Removes a city and its roads. The cities after it move down one place, so the indexes in the
+//   roads are renumbered.
+func (ed *mapEditor) removeCity(name string) error {
+	idx, err := ed.city(name)
+	if (err != nil) {
+		return err
+	}
+	for _, road := range ed.nodes[idx].roads {
+		ed.nodes[road.to].setRoad(opposite(road.dir), -1)
+	}
+	ed.nodes = append(ed.nodes[:idx], ed.nodes[idx+1:]...)
+	delete(ed.nodeMap, name)
+	for i := range ed.nodes {
+		node := &ed.nodes[i]
+		if (i >= idx) {
+			node.index = i
+			ed.nodeMap[node.cityName] = i
+		}
+		for k := range node.roads {
+			if (node.roads[k].to > idx) {
+				node.roads[k].to --
+			}
+		}
+	}
+	ed.changed = true
+	return nil
+}
+
+// Adds the road leaving city "from" in direction "dir" to city "to", and the opposite road back,
+//   if neither city has a road in that direction yet.
+func (ed *mapEditor) addRoad(from string, label string, to string) error {
+	a, err := ed.city(from)
+	if (err != nil) {
+		return err
+	}
+	b, err := ed.city(to)
+	if (err != nil) {
+		return err
+	}
+	dir, err := editDirection(label)
+	if (err != nil) {
+		return err
+	}
+	if (a == b) {
+		return fmt.Errorf("City '%s' can't have a road to itself", from)
+	}
+	od := opposite(dir)
+	if other := ed.nodes[a].road(dir); (other != -1) {
+		return fmt.Errorf("City '%s' already has a %s road (to '%s')", from, label, ed.nodes[other].cityName)
+	}
+	if other := ed.nodes[b].road(od); (other != -1) {
+		return fmt.Errorf("City '%s' already has a %s road (to '%s'), which the opposite road would take", to, directionName(od), ed.nodes[other].cityName)
+	}
+	ed.nodes[a].setRoad(dir, b)
+	ed.nodes[b].setRoad(od, a)
+	ed.changed = true
+	return nil
+}
+
+func (ed *mapEditor) removeRoad(from string, label string) error {
+	a, err := ed.city(from)
+	if (err != nil) {
+		return err
+	}
+	dir, err := editDirection(label)
+	if (err != nil) {
+		return err
+	}
+	b := ed.nodes[a].road(dir)
+	if (b == -1) {
+		return fmt.Errorf("City '%s' has no %s road", from, label)
+	}
+	ed.nodes[a].setRoad(dir, -1)
+	ed.nodes[b].setRoad(opposite(dir), -1)
+	ed.changed = true
+	return nil
+}
+
+func (ed *mapEditor) rename(name string, newName string) error {
+	idx, err := ed.city(name)
+	if (err != nil) {
+		return err
+	}
+	if err := checkCityName(newName); err != nil {
+		return err
+	}
+	if _, exists := ed.nodeMap[newName]; (exists) {
+		return fmt.Errorf("City '%s' already exists", newName)
+	}
+	ed.nodes[idx].cityName = newName
+	delete(ed.nodeMap, name)
+	ed.nodeMap[newName] = idx
+	ed.changed = true
+	return nil
+}
+
+// Prints the size and the connectivity of the map.
+func (ed *mapEditor) check() {
+	a := analyzeMap(ed.nodes)
+	fmt.Printf("%d cities and %d roads, in %d connected component(s).\n", a.cities, a.roads, len(a.components))
+	if (len(a.components) > 1) {
+		fmt.Printf("Component sizes: %s\n", strings.Trim(fmt.Sprint(a.components), "[]"))
+	}
+	if (len(a.isolated) > 0) {
+		fmt.Printf("Isolated cities: %s\n", cityNames(ed.nodes, a.isolated))
+	}
+}
+
+// Runs one editor command. Returns true if the command is to leave the editor.
+func (ed *mapEditor) run(mapfile string, args []string) (bool, error) {
+	usage := func(s string) error {
+		return fmt.Errorf("Usage: %s", s)
+	}
+
+	switch args[0] {
+	case "city", "add-city":
+		if (len(args) < 2) || (len(args) > 3) {
+			return false, usage("city <name> [pop=P]")
+		}
+		pop := 0
+		if (len(args) == 3) {
+			value, ok := strings.CutPrefix(args[2], POPULATION_KEY + "=")
+			if (! ok) {
+				return false, usage("city <name> [pop=P]")
+			}
+			var err error
+			if pop, err = parsePopulation(value); err != nil {
+				return false, err
+			}
+		}
+		return false, ed.addCity(args[1], pop)
+
+	case "road", "add-road":
+		if (len(args) != 4) {
+			return false, usage("road <city> <dir> <city>")
+		}
+		return false, ed.addRoad(args[1], args[2], args[3])
+
+	case "rm-city", "remove-city":
+		if (len(args) != 2) {
+			return false, usage("rm-city <name>")
+		}
+		return false, ed.removeCity(args[1])
+
+	case "rm-road", "remove-road":
+		if (len(args) != 3) {
+			return false, usage("rm-road <city> <dir>")
+		}
+		return false, ed.removeRoad(args[1], args[2])
+
+	case "pop":
+		if (len(args) != 3) {
+			return false, usage("pop <name> <P>")
+		}
+		idx, err := ed.city(args[1])
+		if (err != nil) {
+			return false, err
+		}
+		pop, err := parsePopulation(args[2])
+		if (err != nil) {
+			return false, err
+		}
+		ed.nodes[idx].pop = pop
+		ed.changed = true
+
+	case "rename":
+		if (len(args) != 3) {
+			return false, usage("rename <name> <new>")
+		}
+		return false, ed.rename(args[1], args[2])
+
+	case "directions":
+		return false, parseDirectionsDirective(strings.Join(args, " "))
+
+	case "show":
+		if (len(args) != 2) {
+			return false, usage("show <name>")
+		}
+		idx, err := ed.city(args[1])
+		if (err != nil) {
+			return false, err
+		}
+		fmt.Println(mapLine(ed.nodes, idx))
+
+	case "list", "ls":
+		return false, writeMap(os.Stdout, ed.nodes)
+
+	case "check":
+		ed.check()
+
+	case "save", "w":
+		if (len(args) > 2) {
+			return false, usage("save [file]")
+		}
+		if (len(args) == 2) {
+			mapfile = args[1]
+		}
+		if err := saveMap(mapfile, ed.nodes, true); err != nil {
+			return false, err
+		}
+		fmt.Printf("Saved %d cities to '%s'.\n", len(ed.nodes), mapfile)
+		if (len(args) == 1) {
+			ed.changed = false
+		}
+
+	case "quit", "q", "exit":
+		return true, nil
+
+	case "help", "h", "?":
+		printEditHelp()
+
+	default:
+		return false, fmt.Errorf("Unknown command '%s' (type 'help' for the list of commands)", args[0])
+	}
+	return false, nil
+}
+
+// Edits a map file with commands read from "in". If "batch" is set (commands read from a file),
+//   the first command that fails stops the editor without saving anything, and the map is saved
+//   at the end; otherwise the errors are printed and the editing goes on.
+func (ed *mapEditor) edit(mapfile string, in io.Reader, batch bool, source string) error {
+	scanner := bufio.NewScanner(in)
+	warned := false
+	line := 0
+
+	for {
+		if (! batch) {
+			fmt.Print("edit> ")
+		}
+		if (! scanner.Scan()) {
+			if (! batch) {
+				fmt.Println()
+			}
+			break
+		}
+		line ++
+
+		args := strings.Fields(scanner.Text())
+		if (len(args) == 0) || (strings.HasPrefix(args[0], "#")) {
+			continue
+		}
+
+		quit, err := ed.run(mapfile, args)
+		if (err != nil) {
+			if (batch) {
+				return &MapError{ fmt.Errorf("%s:%d: %v", source, line, err) }
+			}
+			fmt.Printf("%s.\n", err)
+			continue
+		}
+		if (quit) {
+			if (batch) || (! ed.changed) || (warned) {
+				break
+			}
+			fmt.Println("The map has unsaved changes: type 'save' to keep them, or 'quit' again to discard them.")
+			warned = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Cannot read the editor commands: %v", err)
+	}
+
+	if (ed.changed) {
+		if (! batch) {
+			fmt.Println("Unsaved changes discarded.")
+			return nil
+		}
+		if err := saveMap(mapfile, ed.nodes, true); err != nil {
+			return err
+		}
+		fmt.Printf("Saved %d cities to '%s'.\n", len(ed.nodes), mapfile)
+	}
+	return nil
+}
+
+// Opens a map file in the editor, or starts a new map if the file doesn't exist.
+func editMap(mapfile string, commands string) error {
+	ed := &mapEditor{ nodeMap: make(SNodeMap) }
+	if _, err := os.Stat(mapfile); err == nil {
+		ed.nodes, ed.nodeMap, err = loadMap(mapfile)
+		if (err != nil) {
+			return err
+		}
+		fmt.Printf("Editing mapfile '%s': %d cities and %d roads.\n", mapfile, len(ed.nodes), countRoads(ed.nodes, false))
+	} else {
+		fmt.Printf("Editing new mapfile '%s'.\n", mapfile)
+	}
+
+	if (commands == "") {
+		fmt.Println("Type 'help' for the list of commands.")
+		return ed.edit(mapfile, os.Stdin, false, "")
+	}
+	file, err := os.Open(commands)
+	if (err != nil) {
+		return fmt.Errorf("Cannot open command file '%s'", commands)
+	}
+	defer file.Close()
+	return ed.edit(mapfile, file, true, commands)
+}
+
+// Handles the command line of the map editor mode: -edit <MAPFILE> [-commands F]
+func mainEdit(args []string) int {
+	var commands string
+	flags := flag.NewFlagSet("edit", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&commands, "commands", "", "run the editor commands in this file, then save")
+
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for map editor mode.");
+		return usageError()
+	} else if (flags.Parse(args[1:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map editor mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	return reportError(editMap(args[0], commands))
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Map merging
+// ---------------------------------------------------------------------------------------------------
+
+// A road that connects a city of the first map to a city of the second map (-road A:DIR:B).
+type mergeRoad struct {
+	from, dir, to  string
+}
+
+// Merges two map files into one, with the cities of the first map followed by those of the second.
+//   The names of the cities may be given a prefix (one for each map), so that maps that use the
+//   same names (e.g. two generated grids) can be merged; the cities of the two maps must end up
+//   with different names. The roads connect the cities of the two maps, by their names before the
+//   prefixes.
+func mergeMaps(fileA string, fileB string, outfile string, prefixA string, prefixB string, roads []mergeRoad, overwrite bool) error {
+	if (! overwrite) {
+		if err := checkNoOverwrite(outfile); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Will merge mapfiles '%s' and '%s' into mapfile '%s'.\n", fileA, fileB, outfile)
+
+	nodesA, _, err := loadMap(fileA)
+	if (err != nil) {
+		return err
+	}
+	nodesB, _, err := loadMap(fileB)
+	if (err != nil) {
+		return err
+	}
+
+	ed := &mapEditor{ nodeMap: make(SNodeMap) }
+	for _, part := range []struct { nodes SNodeArray; prefix string; file string } {
+		{ nodesA, prefixA, fileA },
+		{ nodesB, prefixB, fileB },
+	} {
+		offset := len(ed.nodes)
+		for _, node := range part.nodes {
+			name := part.prefix + node.cityName
+			if _, exists := ed.nodeMap[name]; (exists) {
+				return &MapError{ fmt.Errorf("City '%s' is in both maps (give the cities of one of them a prefix with -prefix-a or -prefix-b)", name) }
+			}
+			if err := checkCityName(name); err != nil {
+				return err
+			}
+			roads := make([]SRoad, len(node.roads))
+			for k, road := range node.roads {
+				roads[k] = SRoad{ road.dir, road.to + offset }
+			}
+			ed.nodeMap[name] = len(ed.nodes)
+			ed.nodes = append(ed.nodes, SNode{ index: len(ed.nodes), cityName: name, roads: roads, lastVisit: -1, pop: node.pop })
+		}
+	}
+
+	for _, road := range roads {
+		if _, ok := ed.nodeMap[prefixA + road.from]; (! ok) || (road.from == "") {
+			return fmt.Errorf("There is no city '%s' in '%s'", road.from, fileA)
+		}
+		if _, ok := ed.nodeMap[prefixB + road.to]; (! ok) || (road.to == "") {
+			return fmt.Errorf("There is no city '%s' in '%s'", road.to, fileB)
+		}
+		if err := ed.addRoad(prefixA + road.from, road.dir, prefixB + road.to); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Merged %d + %d cities, with %d connecting road(s): %d cities and %d roads.\n",
+		len(nodesA), len(nodesB), len(roads), len(ed.nodes), countRoads(ed.nodes, false))
+
+	fmt.Printf("Writing map file to '%s'.\n", outfile)
+	if err := saveMap(outfile, ed.nodes, overwrite); err != nil {
+		return err
+	}
+	fmt.Println("Done.");
+	return nil
+}
+
+// Handles the command line of the map merging mode: -merge <A> <B> <OUTFILE> [options]
+func mainMerge(args []string) int {
+	var prefixA, prefixB string
+	var roads []mergeRoad
+	var overwrite bool
+	flags := flag.NewFlagSet("merge", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&prefixA, "prefix-a", "", "prefix for the city names of the first map")
+	flags.StringVar(&prefixB, "prefix-b", "", "prefix for the city names of the second map")
+	flags.Func("road", "a road A:DIR:B from a city of the first map to a city of the second", func(s string) error {
+		parts := strings.Split(s, ":")
+		if (len(parts) != 3) {
+			return fmt.Errorf("Invalid road '%s' (must be 'CITY_A:DIR:CITY_B')", s)
+		}
+		roads = append(roads, mergeRoad{ parts[0], parts[1], parts[2] })
+		return nil
+	})
+	flags.BoolVar(&overwrite, "overwrite", false, "replace the output map file")
+
+	if (len(args) < 3) {
+		fmt.Println("Too few arguments for map merging mode.");
+		return usageError()
+	} else if (flags.Parse(args[3:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for map merging mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	return reportError(mergeMaps(args[0], args[1], args[2], prefixA, prefixB, roads, overwrite))
+}
@@ -0,0 +1,199 @@
+/*
+   Alien Invasion Simulator - grid embedding of general maps
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ---------------------------------------------------------------------------------------------------
+// Grid coordinates of the cities of a map
+// ---------------------------------------------------------------------------------------------------
+
+// The grid coordinates encoded in the city names (see parseCoords), or ok == false if some city
+//   name does not encode them.
+func nameCoords(nodes SNodeArray) (xs []int, ys []int, ok bool) {
+	xs = make([]int, len(nodes))
+	ys = make([]int, len(nodes))
+	for i := 0; i < len(nodes); i++ {
+		xs[i], ys[i], ok = parseCoords(nodes[i].cityName)
+		if (! ok) {
+			return nil, nil, false
+		}
+	}
+	return xs, ys, true
+}
+
+// Infers grid coordinates for the cities of a map from its roads alone: an east road leads to the
+//   next position on the right, a south road to the next position below, a northeast road to
+//   the next position up and to the right, and so on (see directionDelta). Each
+//   connected component is laid out by a breadth-first walk from its first city, and the
+//   components are then packed next to each other, in rows, with an empty column or row between
+//   them.
+// Fails if a component has no consistent layout, i.e. if walking the roads puts a city at two
+//   different positions or two cities at the same position (e.g. a torus, or roads that skip
+//   positions), or if a road is in a direction with no place in a grid (e.g. up/down).
+func inferCoords(nodes SNodeArray) (xs []int, ys []int, err error) {
+	xs = make([]int, len(nodes))
+	ys = make([]int, len(nodes))
+	placed := make([]bool, len(nodes))
+
+	type component struct {
+		cities         []int
+		width, height  int
+	}
+	var components []component
+
+	for start := 0; start < len(nodes); start++ {
+		if (placed[start]) {
+			continue
+		}
+
+		// Walk the component, with coordinates relative to its first city.
+		comp := component{ cities: []int{start} }
+		occupied := map[[2]int]int{ {0, 0}: start }
+		placed[start] = true
+		xs[start], ys[start] = 0, 0
+		minx, miny, maxx, maxy := 0, 0, 0, 0
+
+		for k := 0; k < len(comp.cities); k++ {
+			a := comp.cities[k]
+			for _, road := range nodes[a].roads {
+				d, b := road.dir, road.to
+				dx, dy, ok := directionDelta(d)
+				if (! ok) {
+					return nil, nil, fmt.Errorf("The %s road from city '%s' to city '%s' has no direction in a grid", directionName(d), nodes[a].cityName, nodes[b].cityName)
+				}
+				x, y := xs[a] + dx, ys[a] + dy
+				if (placed[b]) {
+					if (xs[b] != x) || (ys[b] != y) {
+						return nil, nil, fmt.Errorf("The %s road from city '%s' to city '%s' does not fit in a grid", directionName(d), nodes[a].cityName, nodes[b].cityName)
+					}
+					continue
+				}
+				if other, taken := occupied[[2]int{x, y}]; taken {
+					return nil, nil, fmt.Errorf("Cities '%s' and '%s' would be at the same grid position", nodes[other].cityName, nodes[b].cityName)
+				}
+				occupied[[2]int{x, y}] = b
+				placed[b] = true
+				xs[b], ys[b] = x, y
+				comp.cities = append(comp.cities, b)
+				if (x < minx) { minx = x }
+				if (y < miny) { miny = y }
+				if (x > maxx) { maxx = x }
+				if (y > maxy) { maxy = y }
+			}
+		}
+
+		for _, c := range comp.cities {
+			xs[c] -= minx
+			ys[c] -= miny
+		}
+		comp.width = maxx - minx + 1
+		comp.height = maxy - miny + 1
+		components = append(components, comp)
+	}
+
+	// Shelf packing: the tallest components first, left to right, in rows about as wide as the
+	//   square root of the total area.
+	sort.SliceStable(components, func(a, b int) bool { return components[a].height > components[b].height })
+	area, widest := 0, 0
+	for _, comp := range components {
+		area += (comp.width + 1) * (comp.height + 1)
+		if (comp.width > widest) {
+			widest = comp.width
+		}
+	}
+	rowWidth := widest
+	for (rowWidth * rowWidth < area) {
+		rowWidth ++
+	}
+
+	x, y, shelf := 0, 0, 0
+	for _, comp := range components {
+		if (x > 0) && (x + comp.width > rowWidth) {
+			x = 0
+			y += shelf + 1
+			shelf = 0
+		}
+		for _, c := range comp.cities {
+			xs[c] += x
+			ys[c] += y
+		}
+		x += comp.width + 1
+		if (comp.height > shelf) {
+			shelf = comp.height
+		}
+	}
+	return xs, ys, nil
+}
+
+// Grid coordinates for the cities of a map: the ones encoded in the city names if there are any,
+//   or else the ones inferred from the roads. Returns false in "named" for inferred coordinates.
+func gridCoords(nodes SNodeArray) (xs []int, ys []int, named bool, err error) {
+	if xs, ys, ok := nameCoords(nodes); ok {
+		return xs, ys, true, nil
+	}
+	xs, ys, err = inferCoords(nodes)
+	return xs, ys, false, err
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Lifting a map into the generator's World model
+// ---------------------------------------------------------------------------------------------------
+
+// Converts a parsed map back into the generator's World model, so that the grid transforms
+//   (see transform.go) can be applied to it, e.g. to a simulation result.
+// The cities keep the coordinates encoded in their names, if any (so the World starts at X0Y0 and
+//   gaps left by destroyed cities are kept), or get coordinates inferred from the roads.
+// Every road must join two cities that are next to each other in its direction. With coordinates
+//   from the names, a road may also wrap around from the last column (or row) to the first one,
+//   like the roads of a torus.
+// Returns "named" as true if the cities had coordinate names.
+func liftWorld(nodes SNodeArray) (wmap World, named bool, err error) {
+	xs, ys, named, err := gridCoords(nodes)
+	if (err != nil) {
+		return nil, false, fmt.Errorf("The map has no grid embedding: %v", err)
+	}
+
+	width, height := 0, 0
+	for i := 0; i < len(nodes); i++ {
+		if (xs[i] + 1 > width) { width = xs[i] + 1 }
+		if (ys[i] + 1 > height) { height = ys[i] + 1 }
+	}
+
+	wmap = make(World, height)
+	for y := 0; y < height; y++ {
+		wmap[y] = make([]Node, width)
+	}
+	for i := 0; i < len(nodes); i++ {
+		if (wmap[ys[i]][xs[i]].cityName != "") {
+			return nil, false, fmt.Errorf("The map has no grid embedding: cities '%s' and '%s' are at the same grid position", wmap[ys[i]][xs[i]].cityName, nodes[i].cityName)
+		}
+		wmap[ys[i]][xs[i]].cityName = nodes[i].cityName
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		for _, road := range nodes[i].roads {
+			d, j := road.dir, road.to
+			if (d > NORTH) {
+				return nil, false, fmt.Errorf("The map has no grid embedding: the %s road from city '%s' to city '%s' is not in a cardinal direction", directionName(d), nodes[i].cityName, nodes[j].cityName)
+			}
+
+			// Each road is stored at its west or north end, as an EAST or SOUTH road.
+			from, to, dir := i, j, d
+			if (d == WEST) || (d == NORTH) {
+				from, to, dir = j, i, opposite(d)
+			}
+			nx, ny := wmap.neighbor(xs[from], ys[from], dir)
+			if (nx != xs[to]) || (ny != ys[to]) {
+				return nil, false, fmt.Errorf("The map has no grid embedding: the %s road from city '%s' to city '%s' does not join adjacent grid positions", directionName(d), nodes[i].cityName, nodes[j].cityName)
+			}
+			wmap[ys[from]][xs[from]].roads[dir] = true
+		}
+	}
+	return wmap, named, nil
+}
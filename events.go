@@ -0,0 +1,85 @@
+/*
+   Alien Invasion Simulator - simulation events
+*/
+
+package main
+
+// Event types
+const EVENT_SPAWN     string = "spawn"       // an alien has been placed in a city
+const EVENT_MOVE      string = "move"        // an alien has moved from a city to another
+const EVENT_DESTROYED string = "destroyed"   // aliens have fought and destroyed a city
+const EVENT_REPELLED  string = "repelled"    // an alien has been killed by the defenses of a city it tried to enter
+const EVENT_FIGHT     string = "fight"       // aliens have fought, but the city survived (SimOptions.SpareCities or CombatModel)
+const EVENT_CLASH     string = "clash"       // two aliens have met on a road and destroyed it (simultaneous movement)
+const EVENT_DEFENDED  string = "defended"    // the defenders of a city have killed the aliens fighting in it
+const EVENT_ROAD_DESTROYED string = "road-destroyed"  // a road has been destroyed on its own (by decay or collateral damage)
+const EVENT_TRAPPED   string = "trapped"     // an alien is in a city with no road to a live city, and can't move again
+
+// Something that happened during a simulation.
+type Event struct {
+	Iteration  int     `json:"iteration"`        // Movement step (counting from 1), or 0 for the spawn phase
+	Type       string  `json:"type"`             // One of the EVENT_* types
+	City       string  `json:"city"`             // City where the event happened (destination, for moves)
+	From       string  `json:"from,omitempty"`   // City the alien left, for moves
+	Aliens     []int   `json:"aliens"`           // Aliens involved
+	Dir        string  `json:"dir,omitempty"`    // Direction of the road from "From" to "City", for clashes and destroyed roads
+	Cause      string  `json:"cause,omitempty"`  // What destroyed the road, for destroyed roads (e.g. "decay")
+	Spawned    bool    `json:"spawned,omitempty"`  // The city was destroyed by spawning Aliens[0] on top of the others
+	Survivors  []int   `json:"survivors,omitempty"`  // Aliens that survived a fight, wounded (see SimOptions.CombatModel)
+	CityHP     int     `json:"city_hp,omitempty"`    // Hit points left to a city damaged by a fight (see COMBAT_DAMAGE)
+}
+
+// A function that receives the events of a simulation as they happen.
+type EventListener func(ev Event)
+
+// Registers a listener for the events of the simulation. Move events are only delivered if
+//   "moves" is true, since there is one for every alien in every movement step.
+func (sim *Simulator) Subscribe(listener EventListener, moves bool) {
+	sim.listeners = append(sim.listeners, eventSubscription{ listener, moves })
+	if (moves) {
+		sim.movesWanted = true
+		sim.moveEvents = true
+	}
+}
+
+// Registers a listener that receives the move events only while somebody else wants them (see
+//   WatchMoves), so that they cost nothing while nobody is watching them.
+func (sim *Simulator) SubscribeOnDemand(listener EventListener) {
+	sim.listeners = append(sim.listeners, eventSubscription{ listener, true })
+}
+
+// Asks for the move events while a watcher needs them (e.g. a client of a live stream), if "on",
+//   or stops asking for them. Every call with "on" must be matched by a call without it.
+func (sim *Simulator) WatchMoves(on bool) {
+	if (on) {
+		sim.moveWatchers ++
+	} else {
+		sim.moveWatchers --
+	}
+	sim.moveEvents = (sim.movesWanted) || (sim.moveWatchers > 0)
+}
+
+// A registered EventListener.
+type eventSubscription struct {
+	listener  EventListener
+	moves     bool
+}
+
+// Records an event, if event recording is enabled, and delivers it to the listeners.
+func (sim *Simulator) emit(ev Event) {
+	isMove := (ev.Type == EVENT_MOVE)
+
+	if (sim.opts.RecordEvents) && ((! isMove) || (sim.opts.RecordMoves)) {
+		sim.events = append(sim.events, ev)
+	}
+	for _, sub := range sim.listeners {
+		if (! isMove) || (sub.moves) {
+			sub.listener(ev)
+		}
+	}
+}
+
+// The events recorded so far (see SimOptions.RecordEvents).
+func (sim *Simulator) Events() []Event {
+	return sim.events
+}
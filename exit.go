@@ -0,0 +1,71 @@
+/*
+   Alien Invasion Simulator - exit codes
+*/
+
+package main
+
+import (
+	"fmt"
+	"errors"
+)
+
+// Exit codes of the program, so that scripts can tell why a run failed.
+const EXIT_OK      int = 0    // Success
+const EXIT_FAILURE int = 1    // Any other error, e.g. a file that can't be read or written
+const EXIT_USAGE   int = 2    // Invalid command line
+const EXIT_MAP     int = 3    // A map file that can't be parsed
+const EXIT_ABORTED int = 4    // A simulation that was refused (e.g. by --max-memory) or failed while running
+
+// An error in the contents of a map file.
+type MapError struct {
+	Err  error
+}
+
+func (e *MapError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MapError) Unwrap() error {
+	return e.Err
+}
+
+// An error that aborted a simulation.
+type AbortedError struct {
+	Err  error
+}
+
+func (e *AbortedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AbortedError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the exit code for the error of a run, which is EXIT_OK if "err" is nil.
+func exitCode(err error) int {
+	var mapErr *MapError
+	var abortedErr *AbortedError
+	if (err == nil) {
+		return EXIT_OK
+	} else if (errors.As(err, &mapErr)) {
+		return EXIT_MAP
+	} else if (errors.As(err, &abortedErr)) {
+		return EXIT_ABORTED
+	}
+	return EXIT_FAILURE
+}
+
+// Prints the error of a run, if any, and returns its exit code.
+func reportError(err error) int {
+	if (err != nil) {
+		fmt.Printf("ERROR: %s.\n", err)
+	}
+	return exitCode(err)
+}
+
+// Prints the usage of the program and returns the exit code of an invalid command line.
+func usageError() int {
+	printHelp()
+	return EXIT_USAGE
+}
@@ -0,0 +1,628 @@
+/*
+   Alien Invasion Simulator - experiment runner
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bytes"
+	"flag"
+	"sync"
+	"strconv"
+	"strings"
+	"runtime"
+	"path/filepath"
+	"encoding/csv"
+)
+
+// An experiment file describes a parameter sweep: every combination of its maps, profiles, swept
+//   options, alien counts, strategies and seeds is simulated once, and the outcome of every run is
+//   written as a row of a CSV (or JSON) results file. It is a YAML file (see yaml.go), e.g.:
+//
+//   maps: [small.map, big.map]          # map files to simulate, and/or maps to generate:
+//   generate:
+//     size: [20x20, 50x50]              # every size, city density and road density is combined
+//     city_density: [0.6, 0.9]
+//     road_density: [0.5, 0.8]
+//     topology: grid                    # grid (the default), torus or hex
+//   aliens: [20, 50, "auto:0.3"]        # alien counts, as in simulation mode
+//   strategies: [random-walk, fear-of-ruins, my-strategy.star]
+//   seeds: 10                           # 10 seeds from 'seed' on, or a list of seeds
+//   seed: 1
+//   options:                            # simulation options of every run
+//     max-steps: 2000
+//   profiles:                           # named sets of options; each run uses one of them
+//     baseline: {}
+//     fortified: { defense-rate: 2, defenders: true }
+//   sweep:                              # options with several values, all of them combined
+//     fight-threshold: [2, 3]
+//   workers: 4                          # runs at the same time (default: one per CPU)
+//   output: results.csv                 # or .json (default: the experiment file name + .csv)
+//
+// The options are the model options of the batch modes (see modelFlags), without the dash. The
+//   generated maps are the same for a given seed. The rows are written in the order of the runs
+//   whatever the number of workers, so the results of an experiment file can be reproduced.
+
+// A map of an experiment.
+type ExperimentMap struct {
+	name         string
+	nodes        SNodeArray
+	nodeMap      SNodeMap
+	size         string     // Size, city density and road density of a generated map
+	cityDensity  string
+	roadDensity  string
+}
+
+// A named set of options of an experiment.
+type ExperimentProfile struct {
+	name  string
+	args  []string     // Option flags, e.g. "-defense-rate=2"
+}
+
+// An option of an experiment with several values.
+type ExperimentParam struct {
+	name    string
+	values  []string
+}
+
+type Experiment struct {
+	maps        []*ExperimentMap
+	generated   bool
+	aliens      []string
+	strategies  []string
+	scripts     map[string]Strategy    // The strategies loaded from Starlark files, by file name
+	seeds       []int64
+	options     []string
+	profiles    []ExperimentProfile
+	sweep       []ExperimentParam
+	workers     int
+	output      string
+}
+
+// A run of an experiment, and its outcome: a row of the results file.
+type ExperimentRow struct {
+	Run          int                `json:"run"`
+	Map          string             `json:"map"`
+	Size         string             `json:"size,omitempty"`
+	CityDensity  string             `json:"city_density,omitempty"`
+	RoadDensity  string             `json:"road_density,omitempty"`
+	Profile      string             `json:"profile"`
+	Params       map[string]string  `json:"params,omitempty"`     // The values of the swept options
+	Aliens       string             `json:"aliens"`
+	Strategy     string             `json:"strategy"`
+	Seed         int64              `json:"seed"`
+	Error        string             `json:"error,omitempty"`
+	Summary      *Summary           `json:"summary,omitempty"`
+	mapIndex     int
+	opts         SimOptions
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Experiment files
+// ---------------------------------------------------------------------------------------------------
+
+// The string values of a key: a scalar or a list of scalars. Returns nil if the key is not set.
+func yamlStrings(m *yamlMap, key string) ([]string, error) {
+	v, ok := m.get(key)
+	if (! ok) {
+		return nil, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return []string{ v }, nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if (! ok) {
+				return nil, fmt.Errorf("The values of '%s' must be scalars", key)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("'%s' must be a value or a list of values", key)
+}
+
+// The option flags of a mapping of options (a list value gives the option once for each item,
+//   e.g. for stop-when).
+func yamlOptions(v interface{}, where string) ([]string, error) {
+	if (v == "") {
+		return nil, nil
+	}
+	m, ok := v.(*yamlMap)
+	if (! ok) {
+		return nil, fmt.Errorf("The %s must be a mapping of options to values", where)
+	}
+	var args []string
+	for _, key := range m.keys {
+		values, err := yamlStrings(m, key)
+		if (err != nil) {
+			return nil, fmt.Errorf("%v, in the %s", err, where)
+		}
+		for _, value := range values {
+			args = append(args, "-" + key + "=" + value)
+		}
+	}
+	return args, nil
+}
+
+// Reads an experiment file, and loads or generates its maps.
+func loadExperiment(filename string) (*Experiment, error) {
+	data, err := os.ReadFile(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot read experiment file '%s'", filename)
+	}
+	doc, err := parseYAML(string(data))
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot parse experiment file '%s': %v", filename, err)
+	}
+
+	known := map[string]bool{ "name": true, "maps": true, "generate": true, "aliens": true, "strategies": true, "seeds": true,
+		"seed": true, "options": true, "profiles": true, "sweep": true, "workers": true, "output": true }
+	for _, key := range doc.keys {
+		if (! known[key]) {
+			return nil, fmt.Errorf("Unknown key '%s' in experiment file '%s'", key, filename)
+		}
+	}
+
+	exp := &Experiment{ scripts: make(map[string]Strategy) }
+	strs := func(key string) []string {
+		if (err == nil) {
+			var values []string
+			values, err = yamlStrings(doc, key)
+			return values
+		}
+		return nil
+	}
+	mapfiles := strs("maps")
+	exp.aliens = strs("aliens")
+	exp.strategies = strs("strategies")
+	seeds := strs("seeds")
+	seed := strs("seed")
+	workers := strs("workers")
+	output := strs("output")
+	if (err != nil) {
+		return nil, err
+	}
+
+	if (len(exp.aliens) == 0) {
+		return nil, fmt.Errorf("The experiment has no alien counts (aliens: [...])")
+	}
+	for _, a := range exp.aliens {
+		if _, _, err := parseAlienCount(a); err != nil {
+			return nil, err
+		}
+	}
+	if (len(exp.strategies) == 0) {
+		exp.strategies = []string{ "" }
+	}
+
+	// Seeds: a count of seeds from the first one, or a list of them
+	first := int64(1)
+	if (len(seed) > 0) {
+		if first, err = strconv.ParseInt(seed[0], 10, 64); (err != nil) || (len(seed) > 1) {
+			return nil, fmt.Errorf("Invalid seed '%s'", strings.Join(seed, ", "))
+		}
+	}
+	if v, _ := doc.get("seeds"); (len(seeds) == 1) && (v == seeds[0]) {
+		n, err := strconv.Atoi(seeds[0])
+		if (err != nil) || (n < 1) {
+			return nil, fmt.Errorf("Invalid number of seeds '%s'", seeds[0])
+		}
+		for i := 0; i < n; i++ {
+			exp.seeds = append(exp.seeds, first + int64(i))
+		}
+	} else {
+		for _, s := range seeds {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if (err != nil) {
+				return nil, fmt.Errorf("Invalid seed '%s'", s)
+			}
+			exp.seeds = append(exp.seeds, n)
+		}
+	}
+	if (len(exp.seeds) == 0) {
+		exp.seeds = []int64{ first }
+	}
+
+	if (len(workers) > 0) {
+		if exp.workers, err = strconv.Atoi(workers[0]); (err != nil) || (exp.workers < 1) {
+			return nil, fmt.Errorf("Invalid number of workers '%s'", workers[0])
+		}
+	}
+	if (len(output) > 0) {
+		exp.output = output[0]
+	}
+
+	// Options, profiles and swept options
+	if v, ok := doc.get("options"); (ok) {
+		if exp.options, err = yamlOptions(v, "options"); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := doc.get("profiles"); (ok) && (v != "") {
+		profiles, ok := v.(*yamlMap)
+		if (! ok) {
+			return nil, fmt.Errorf("The profiles must be a mapping of names to options")
+		}
+		for _, name := range profiles.keys {
+			args, err := yamlOptions(profiles.values[name], "profile '" + name + "'")
+			if (err != nil) {
+				return nil, err
+			}
+			exp.profiles = append(exp.profiles, ExperimentProfile{ name, args })
+		}
+	}
+	if (len(exp.profiles) == 0) {
+		exp.profiles = []ExperimentProfile{ { "default", nil } }
+	}
+	if v, ok := doc.get("sweep"); (ok) && (v != "") {
+		sweep, ok := v.(*yamlMap)
+		if (! ok) {
+			return nil, fmt.Errorf("The sweep must be a mapping of options to lists of values")
+		}
+		for _, name := range sweep.keys {
+			values, err := yamlStrings(sweep, name)
+			if (err != nil) {
+				return nil, err
+			}
+			if (len(values) == 0) {
+				return nil, fmt.Errorf("The swept option '%s' has no values", name)
+			}
+			exp.sweep = append(exp.sweep, ExperimentParam{ name, values })
+		}
+	}
+
+	// Strategies from Starlark files are loaded once, and shared by all the runs
+	for _, s := range exp.strategies {
+		if (strings.HasSuffix(s, ".star")) && (exp.scripts[s] == nil) {
+			script, err := loadScript(s)
+			if (err != nil) {
+				return nil, err
+			}
+			exp.scripts[s] = script
+		}
+	}
+
+	// Maps
+	for _, mapfile := range mapfiles {
+		fmt.Printf("Reading mapfile '%s'.\n", mapfile)
+		nodes, nodeMap, err := loadMap(mapfile)
+		if (err != nil) {
+			return nil, err
+		}
+		exp.maps = append(exp.maps, &ExperimentMap{ name: mapfile, nodes: nodes, nodeMap: nodeMap })
+	}
+	if v, ok := doc.get("generate"); (ok) && (v != "") {
+		gen, ok := v.(*yamlMap)
+		if (! ok) {
+			return nil, fmt.Errorf("'generate' must be a mapping of map parameters")
+		}
+		if err := exp.generateMaps(gen, first); err != nil {
+			return nil, err
+		}
+	}
+	if (len(exp.maps) == 0) {
+		return nil, fmt.Errorf("The experiment has no maps (maps: [...] or generate: ...)")
+	}
+	return exp, nil
+}
+
+// Generates the maps of every combination of the sizes, city densities and road densities of
+//   the "generate" section of an experiment, from the same seed.
+func (exp *Experiment) generateMaps(gen *yamlMap, seed int64) error {
+	for _, key := range gen.keys {
+		if (key != "size") && (key != "city_density") && (key != "road_density") && (key != "topology") {
+			return fmt.Errorf("Unknown map parameter '%s' in 'generate' (must be size, city_density, road_density or topology)", key)
+		}
+	}
+	sizes, err1 := yamlStrings(gen, "size")
+	cds, err2 := yamlStrings(gen, "city_density")
+	rds, err3 := yamlStrings(gen, "road_density")
+	topologies, err4 := yamlStrings(gen, "topology")
+	for _, err := range []error{ err1, err2, err3, err4 } {
+		if (err != nil) {
+			return err
+		}
+	}
+	if (len(sizes) == 0) || (len(cds) == 0) || (len(rds) == 0) {
+		return fmt.Errorf("Generated maps need a size, a city_density and a road_density")
+	}
+	topology := TOPOLOGY_GRID
+	if (len(topologies) > 0) {
+		topology = topologies[0]
+	}
+	if err := checkTopology(topology); (err != nil) || (topology == TOPOLOGY_RANDOM_GRAPH) || (len(topologies) > 1) {
+		return fmt.Errorf("Invalid topology for generated maps '%s' (must be %s, %s or %s)", strings.Join(topologies, ", "), TOPOLOGY_GRID, TOPOLOGY_TORUS, TOPOLOGY_HEX)
+	}
+
+	for _, size := range sizes {
+		w, h, ok := strings.Cut(size, "x")
+		maxx, errx := strconv.Atoi(w)
+		maxy, erry := strconv.Atoi(h)
+		if (! ok) || (errx != nil) || (erry != nil) || (maxx < 1) || (maxy < 1) {
+			return fmt.Errorf("Invalid map size '%s' (must be WIDTHxHEIGHT, e.g. 30x20)", size)
+		}
+		for _, cd := range cds {
+			for _, rd := range rds {
+				c, errc := strconv.ParseFloat(cd, 64)
+				r, errr := strconv.ParseFloat(rd, 64)
+				if (errc != nil) || (errr != nil) || (c < 0) || (c > 1) || (r < 0) || (r > 1) {
+					return fmt.Errorf("Invalid densities %s and %s (must be in the [0, 1] range)", cd, rd)
+				}
+				rnd.Seed(seed)
+				var buf bytes.Buffer
+				if err := generateWorld(maxx, maxy, c, r, r, topology).write(&buf); err != nil {
+					return err
+				}
+				nodes, nodeMap, err := readMap(&buf)
+				if (err != nil) {
+					return err
+				}
+				name := fmt.Sprintf("%s-cd%s-rd%s", size, cd, rd)
+				fmt.Printf("Generated map '%s' with %d cities and %d roads.\n", name, len(nodes), countRoads(nodes, false))
+				exp.maps = append(exp.maps, &ExperimentMap{ name: name, nodes: nodes, nodeMap: nodeMap, size: size, cityDensity: cd, roadDensity: rd })
+				exp.generated = true
+			}
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Runs
+// ---------------------------------------------------------------------------------------------------
+
+// Lists the runs of an experiment, with their options, in order: every map, then every profile,
+//   every combination of the swept options, every alien count, every strategy and every seed.
+func (exp *Experiment) runs() ([]*ExperimentRow, error) {
+	// The combinations of the swept options, the last option changing fastest
+	combos := [][]string{ {} }
+	for _, p := range exp.sweep {
+		var next [][]string
+		for _, c := range combos {
+			for _, v := range p.values {
+				next = append(next, append(append([]string(nil), c...), v))
+			}
+		}
+		combos = next
+	}
+
+	var rows []*ExperimentRow
+	for m, xm := range exp.maps {
+		for _, profile := range exp.profiles {
+			for _, combo := range combos {
+				args := append(append([]string(nil), exp.options...), profile.args...)
+				var params map[string]string
+				if (len(exp.sweep) > 0) {
+					params = make(map[string]string)
+				}
+				for k, p := range exp.sweep {
+					args = append(args, "-" + p.name + "=" + combo[k])
+					params[p.name] = combo[k]
+				}
+
+				opts := defaultSimOptions()
+				flags := flag.NewFlagSet("experiment", flag.ContinueOnError)
+				flags.Usage = func() {}
+				flags.SetOutput(io.Discard)
+				modelFlags(flags, &opts)
+				if err := flags.Parse(args); err != nil {
+					return nil, fmt.Errorf("Invalid options in profile '%s': %v", profile.name, err)
+				}
+				if err := checkSimOptions(&opts); err != nil {
+					return nil, fmt.Errorf("Invalid options in profile '%s': %v", profile.name, err)
+				}
+
+				for _, aliens := range exp.aliens {
+					for _, strategy := range exp.strategies {
+						runOpts := opts
+						runOpts.StopWhen = append(StopConds(nil), opts.StopWhen...)
+						if (strategy != "") {
+							runOpts.Strategy = exp.strategy(strategy, opts)
+							if (runOpts.Strategy == nil) {
+								return nil, fmt.Errorf("Unknown strategy '%s'", strategy)
+							}
+						}
+						for _, seed := range exp.seeds {
+							rows = append(rows, &ExperimentRow{ Run: len(rows) + 1, Map: xm.name, Size: xm.size, CityDensity: xm.cityDensity,
+								RoadDensity: xm.roadDensity, Profile: profile.name, Params: params, Aliens: aliens,
+								Strategy: strategy, Seed: seed, mapIndex: m, opts: runOpts })
+						}
+					}
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// The strategy with a name (built-in, registered, or a Starlark file), with the parameters set
+//   in "opts", or nil if there is none.
+func (exp *Experiment) strategy(name string, opts SimOptions) Strategy {
+	if s, ok := exp.scripts[name]; (ok) {
+		return s
+	}
+	for _, s := range allStrategies(opts) {
+		if (s.Name == name) {
+			return s.Strategy
+		}
+	}
+	return nil
+}
+
+// Simulates one run of an experiment. A failed run is reported in its row.
+func (exp *Experiment) run(row *ExperimentRow) {
+	xm := exp.maps[row.mapIndex]
+	numaliens, ratio, _ := parseAlienCount(row.Aliens)
+	opts := row.opts
+	if (ratio > 0) {
+		opts.AlienRatio = ratio
+		numaliens = autoAliens(xm.nodes, ratio)
+	}
+	s, err := runQuiet(xm.name, xm.nodes, xm.nodeMap, numaliens, opts, row.Seed)
+	if (err != nil) {
+		row.Error = err.Error()
+		return
+	}
+	row.Summary = &s
+}
+
+// Runs an experiment file, "workers" runs at a time (0 for the number in the file), and writes
+//   the results to "output" (if not "", instead of the file in the experiment).
+func experiment(filename string, output string, workers int, overwrite bool) error {
+	fmt.Printf("Will run experiment file '%s'.\n", filename)
+
+	exp, err := loadExperiment(filename)
+	if (err != nil) {
+		return err
+	}
+	if (output == "") {
+		output = exp.output
+	}
+	if (output == "") {
+		output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".csv"
+	}
+	if (! overwrite) {
+		if err := checkNoOverwrite(output); err != nil {
+			return err
+		}
+	}
+	if (workers == 0) {
+		workers = exp.workers
+	}
+	if (workers == 0) {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	rows, err := exp.runs()
+	if (err != nil) {
+		return err
+	}
+	fmt.Printf("Will simulate %d run(s): %d map(s) x %d profile(s) x %d option combination(s) x %d alien count(s) x %d strategy(ies) x %d seed(s), %d at a time.\n",
+		len(rows), len(exp.maps), len(exp.profiles), len(rows) / (len(exp.maps) * len(exp.profiles) * len(exp.aliens) * len(exp.strategies) * len(exp.seeds)),
+		len(exp.aliens), len(exp.strategies), len(exp.seeds), workers)
+
+	next := make(chan *ExperimentRow, len(rows))
+	for _, row := range rows {
+		next <- row
+	}
+	close(next)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done, failed := 0, 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range next {
+				exp.run(row)
+				mu.Lock()
+				done ++
+				if (row.Error != "") {
+					failed ++
+					fmt.Printf("[%d/%d] Run #%d failed: %s.\n", done, len(rows), row.Run, row.Error)
+				} else {
+					fmt.Printf("[%d/%d] Run #%d (%s, %s, %s aliens, seed %d): %d of %d cities destroyed, %d of %d aliens alive.\n",
+						done, len(rows), row.Run, row.Map, row.Profile, row.Aliens, row.Seed, row.Summary.CitiesDestroyed,
+						row.Summary.Cities, row.Summary.AliensAlive, row.Summary.AliensSpawned)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	fmt.Printf("Writing the results to '%s'.\n", output)
+	if (strings.HasSuffix(strings.TrimSuffix(output, GZIP_SUFFIX), ".json")) {
+		err = saveJSON(output, overwrite, rows)
+	} else {
+		err = writeFileAtomic(output, overwrite, func(w io.Writer) error {
+			return exp.writeCSV(w, rows)
+		})
+	}
+	if (err != nil) {
+		return err
+	}
+	if (failed > 0) {
+		return &AbortedError{ fmt.Errorf("%d of %d run(s) failed (see the error column of the results)", failed, len(rows)) }
+	}
+	fmt.Println("Done.");
+	return nil
+}
+
+// Writes the rows of the results of an experiment as CSV, with a header line. The swept options
+//   have a column each, after the profile.
+func (exp *Experiment) writeCSV(w io.Writer, rows []*ExperimentRow) error {
+	cw := csv.NewWriter(w)
+	header := []string{ "run", "map" }
+	if (exp.generated) {
+		header = append(header, "size", "city_density", "road_density")
+	}
+	header = append(header, "profile")
+	for _, p := range exp.sweep {
+		header = append(header, p.name)
+	}
+	header = append(header, "aliens", "strategy", "seed", "cities", "aliens_spawned", "cities_destroyed", "aliens_alive",
+		"aliens_trapped", "iterations", "last_fight", "stop_reason", "roads", "roads_destroyed", "human_casualties", "aliens_lost", "error")
+	cw.Write(header)
+
+	for _, row := range rows {
+		record := []string{ strconv.Itoa(row.Run), row.Map }
+		if (exp.generated) {
+			record = append(record, row.Size, row.CityDensity, row.RoadDensity)
+		}
+		record = append(record, row.Profile)
+		for _, p := range exp.sweep {
+			record = append(record, row.Params[p.name])
+		}
+		record = append(record, row.Aliens, row.Strategy, strconv.FormatInt(row.Seed, 10))
+		if (row.Summary != nil) {
+			s := row.Summary
+			for _, n := range []int{ s.Cities, s.AliensSpawned, s.CitiesDestroyed, s.AliensAlive, s.AliensTrapped, s.Iterations, s.LastFight } {
+				record = append(record, strconv.Itoa(n))
+			}
+			record = append(record, s.StopReason)
+			for _, n := range []int{ s.Roads, s.RoadsDestroyed, s.HumanCasualties, s.AliensLost } {
+				record = append(record, strconv.Itoa(n))
+			}
+		} else {
+			record = append(record, make([]string, 12)...)
+		}
+		record = append(record, row.Error)
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Handles the command line of the experiment mode: -experiment <FILE> [-out F] [-workers N] [-overwrite]
+func mainExperiment(args []string) int {
+	var output string
+	var workers int
+	var overwrite bool
+	flags := flag.NewFlagSet("experiment", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&output, "out", "", "write the results to this file (.csv or .json)")
+	flags.IntVar(&workers, "workers", 0, "runs at the same time")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace the results file")
+
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for experiment mode.");
+		return usageError()
+	} else if (flags.Parse(args[1:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for experiment mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else if (workers < 0) {
+		fmt.Println("Experiment: the number of workers must not be negative.");
+		return usageError()
+	}
+	return reportError(experiment(args[0], output, workers, overwrite))
+}
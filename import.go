@@ -0,0 +1,588 @@
+/*
+   Alien Invasion Simulator - road network importer
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"flag"
+	"math"
+	"sort"
+	"strings"
+	"strconv"
+	"encoding/csv"
+	"encoding/json"
+)
+
+// Converts a real-world road network into a map file, so that invasions can run on real city
+//   graphs (e.g. OSM extracts) and not just generated grids. Two input formats are read:
+//
+//   GeoJSON   A FeatureCollection whose LineString and MultiLineString features are roads. Every
+//             vertex is a point where roads may meet; points where roads don't meet (the ones
+//             that only shape a road) are merged into the road, and the points left are the
+//             cities. Point features with a "name" property name the city at their position (and
+//             add one if there is none), and their "population" property gives its population.
+//   CSV       An edge list with a header line naming its columns: "from" and "to" (the city
+//             names) are needed; "direction" gives the label of the road from "from" to "to",
+//             and "from_x", "from_y", "to_x", "to_y" the positions of its cities (x grows to the
+//             east and y to the north).
+//
+// Roads with a position get the direction closest to their bearing (see IMPORT_CARDINAL and
+//   IMPORT_COMPASS), or the next closest if the closest one is already taken at either end;
+//   roads with no free direction within 90 degrees are dropped. Roads with no position, and
+//   every road with IMPORT_GENERIC, are labeled "road1", "road2", ... (directions that are their
+//   own opposite, declared in the map file), which fit any network.
+
+// Ways of choosing the directions of the imported roads, for the -directions option.
+const IMPORT_CARDINAL string = "cardinal"    // east, south, west and north
+const IMPORT_COMPASS  string = "compass"     // the cardinal directions and the diagonals (the default)
+const IMPORT_GENERIC  string = "generic"     // "road1", "road2", ..., with no geometry
+
+// Input formats, for the -format option.
+const IMPORT_GEOJSON string = "geojson"
+const IMPORT_CSV     string = "csv"
+
+// The prefix of the labels of generic roads.
+const IMPORT_ROAD_LABEL string = "road"
+
+// GeoJSON positions closer than this many decimal digits of a degree (about 1 cm) are the same point.
+const IMPORT_COORD_DIGITS int = 7
+
+// The angle of each direction used for road bearings, in degrees counterclockwise from the east.
+var importAngles = map[string]float64{
+	"east": 0, "northeast": 45, "north": 90, "northwest": 135,
+	"west": 180, "southwest": 225, "south": 270, "southeast": 315,
+}
+
+// A road network being imported: its points (the cities to be), and the roads between them.
+type importGraph struct {
+	names    []string          // Name of each point ("" until named)
+	pops     []int             // Population of each point
+	xs, ys   []float64         // Position of each point, if "placed"
+	placed   []bool
+	index    map[string]int    // Point of each name (CSV) or position key (GeoJSON)
+	roads    []importRoad
+	dropped  int               // Roads dropped while reading the network (see mergeSegments)
+}
+
+type importRoad struct {
+	from, to  int
+	label     string    // Direction from "from" to "to", if given by the input
+}
+
+func newImportGraph() *importGraph {
+	return &importGraph{ index: make(map[string]int) }
+}
+
+// The point with a key, which is added if it is new.
+func (g *importGraph) point(key string) int {
+	if p, ok := g.index[key]; ok {
+		return p
+	}
+	g.index[key] = len(g.names)
+	g.names = append(g.names, "")
+	g.pops = append(g.pops, 0)
+	g.xs = append(g.xs, 0)
+	g.ys = append(g.ys, 0)
+	g.placed = append(g.placed, false)
+	return len(g.names) - 1
+}
+
+// The point at a position, which is added if it is new.
+func (g *importGraph) pointAt(x float64, y float64) int {
+	p := g.point(strconv.FormatFloat(x, 'f', IMPORT_COORD_DIGITS, 64) + "," + strconv.FormatFloat(y, 'f', IMPORT_COORD_DIGITS, 64))
+	g.xs[p], g.ys[p], g.placed[p] = x, y, true
+	return p
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Input formats
+// ---------------------------------------------------------------------------------------------------
+
+type geoFeature struct {
+	Type        string                  `json:"type"`
+	Geometry    *geoGeometry            `json:"geometry"`
+	Properties  map[string]interface{}  `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type         string           `json:"type"`
+	Coordinates  json.RawMessage  `json:"coordinates"`
+}
+
+// Reads the roads and named places of a GeoJSON FeatureCollection (or of a single Feature).
+func readGeoJSON(r io.Reader) (*importGraph, error) {
+	var doc struct {
+		geoFeature
+		Features  []geoFeature  `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("Invalid GeoJSON: %v", err)
+	}
+	features := doc.Features
+	switch (doc.Type) {
+	case "FeatureCollection":
+	case "Feature":
+		features = []geoFeature{ doc.geoFeature }
+	default:
+		return nil, fmt.Errorf("Invalid GeoJSON: expected a FeatureCollection or a Feature, not '%s'", doc.Type)
+	}
+
+	g := newImportGraph()
+	var lines [][][]float64
+	for k, f := range features {
+		if (f.Geometry == nil) {
+			continue
+		}
+		var err error
+		switch (f.Geometry.Type) {
+		case "LineString":
+			var line [][]float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &line); err == nil {
+				lines = append(lines, line)
+			}
+		case "MultiLineString":
+			var multi [][][]float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &multi); err == nil {
+				lines = append(lines, multi...)
+			}
+		case "Point":
+			var pos []float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &pos); err == nil {
+				err = g.namePoint(pos, f.Properties)
+			}
+		}
+		if (err != nil) {
+			return nil, fmt.Errorf("Invalid GeoJSON %s in feature #%d: %v", f.Geometry.Type, k + 1, err)
+		}
+	}
+
+	// Every pair of consecutive positions of a line is a segment of a road.
+	segments := make(map[[2]int]bool)
+	for _, line := range lines {
+		prev := -1
+		for _, pos := range line {
+			if (len(pos) < 2) {
+				return nil, fmt.Errorf("Invalid GeoJSON: a position has less than two coordinates")
+			}
+			p := g.pointAt(pos[0], pos[1])
+			if (prev != -1) && (prev != p) {
+				segments[[2]int{ min(prev, p), max(prev, p) }] = true
+			}
+			prev = p
+		}
+	}
+	g.mergeSegments(segments)
+	return g, nil
+}
+
+// Names the point at a GeoJSON position from the properties of a Point feature. Points with no
+//   name are ignored.
+func (g *importGraph) namePoint(pos []float64, props map[string]interface{}) error {
+	if (len(pos) < 2) {
+		return fmt.Errorf("a position has less than two coordinates")
+	}
+	name, ok := props["name"].(string)
+	if (! ok) || (strings.TrimSpace(name) == "") {
+		return nil
+	}
+	p := g.pointAt(pos[0], pos[1])
+	g.names[p] = name
+	if pop, ok := props["population"].(float64); ok && (pop >= 0) {
+		g.pops[p] = int(pop)
+	}
+	return nil
+}
+
+// Turns road segments (pairs of points, the lower point first) into roads between the points that
+//   are cities: the named points, and the points where roads end or meet (i.e. that don't have
+//   exactly two neighbors). The segments through the other points are joined into a single road.
+// Roads that loop back to the same city, duplicates of other roads, and closed rings with no city
+//   on them are dropped, and counted in g.dropped.
+func (g *importGraph) mergeSegments(segments map[[2]int]bool) {
+	near := make([][]int, len(g.names))
+	for s := range segments {
+		near[s[0]] = append(near[s[0]], s[1])
+		near[s[1]] = append(near[s[1]], s[0])
+	}
+	for p := range near {
+		sort.Ints(near[p])
+	}
+
+	city := func(p int) bool {
+		return (g.names[p] != "") || (len(near[p]) != 2)
+	}
+
+	joined := make(map[[2]int]bool)
+	walked := make([]bool, len(g.names))
+	loopEnds := 0
+	for p := 0; p < len(g.names); p++ {
+		if (! city(p)) {
+			continue
+		}
+		for _, next := range near[p] {
+			prev, cur := p, next
+			for (! city(cur)) {
+				walked[cur] = true
+				if (near[cur][0] != prev) {
+					prev, cur = cur, near[cur][0]
+				} else {
+					prev, cur = cur, near[cur][1]
+				}
+			}
+			if (cur == p) {
+				// A loop is walked from both of its ends
+				loopEnds ++
+				continue
+			}
+			if (cur < p) {
+				continue
+			}
+			if (joined[[2]int{ p, cur }]) {
+				g.dropped ++
+				continue
+			}
+			joined[[2]int{ p, cur }] = true
+			g.roads = append(g.roads, importRoad{ from: p, to: cur })
+		}
+	}
+	g.dropped += loopEnds / 2
+
+	// The points that were not walked from a city are on rings with no city
+	for p := range near {
+		if (city(p)) || (walked[p]) {
+			continue
+		}
+		g.dropped ++
+		for cur := p; (! walked[cur]); {
+			walked[cur] = true
+			if (! walked[near[cur][0]]) {
+				cur = near[cur][0]
+			} else {
+				cur = near[cur][1]
+			}
+		}
+	}
+
+	// Only the cities are kept.
+	keep := make([]int, len(g.names))
+	n := 0
+	for p := range g.names {
+		keep[p] = -1
+		if (city(p)) {
+			keep[p] = n
+			g.names[n], g.pops[n], g.xs[n], g.ys[n], g.placed[n] = g.names[p], g.pops[p], g.xs[p], g.ys[p], g.placed[p]
+			n ++
+		}
+	}
+	g.names, g.pops, g.xs, g.ys, g.placed = g.names[:n], g.pops[:n], g.xs[:n], g.ys[:n], g.placed[:n]
+	g.index = nil
+	for k := range g.roads {
+		g.roads[k].from, g.roads[k].to = keep[g.roads[k].from], keep[g.roads[k].to]
+	}
+}
+
+// Reads the roads of a CSV edge list (see the file comment for its columns).
+func readEdgeCSV(r io.Reader) (*importGraph, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if (err != nil) {
+		return nil, fmt.Errorf("Invalid CSV: cannot read the header line (%v)", err)
+	}
+	col := make(map[string]int)
+	for k, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = k
+	}
+	if _, ok := col["from"]; ! ok {
+		return nil, fmt.Errorf("Invalid CSV: the header line has no 'from' column")
+	}
+	if _, ok := col["to"]; ! ok {
+		return nil, fmt.Errorf("Invalid CSV: the header line has no 'to' column")
+	}
+	field := func(rec []string, name string) string {
+		if k, ok := col[name]; ok && (k < len(rec)) {
+			return strings.TrimSpace(rec[k])
+		}
+		return ""
+	}
+	position := func(rec []string, end string) (float64, float64, bool, error) {
+		xs, ys := field(rec, end + "_x"), field(rec, end + "_y")
+		if (xs == "") && (ys == "") {
+			return 0, 0, false, nil
+		}
+		x, errx := strconv.ParseFloat(xs, 64)
+		y, erry := strconv.ParseFloat(ys, 64)
+		if (errx != nil) || (erry != nil) {
+			return 0, 0, false, fmt.Errorf("invalid position '%s,%s'", xs, ys)
+		}
+		return x, y, true, nil
+	}
+
+	g := newImportGraph()
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if (err == io.EOF) {
+			break
+		} else if (err != nil) {
+			return nil, fmt.Errorf("Invalid CSV: %v", err)
+		}
+		road := importRoad{ label: field(rec, "direction") }
+		for k, end := range []string{ "from", "to" } {
+			name := field(rec, end)
+			if (name == "") {
+				return nil, fmt.Errorf("Invalid CSV: no '%s' city in line %d", end, line)
+			}
+			p := g.point(name)
+			g.names[p] = name
+			x, y, ok, err := position(rec, end)
+			if (err != nil) {
+				return nil, fmt.Errorf("Invalid CSV: %v in line %d", err, line)
+			}
+			if (ok) && (! g.placed[p]) {
+				g.xs[p], g.ys[p], g.placed[p] = x, y, true
+			}
+			if (k == 0) {
+				road.from = p
+			} else {
+				road.to = p
+			}
+		}
+		g.roads = append(g.roads, road)
+	}
+	return g, nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Map building
+// ---------------------------------------------------------------------------------------------------
+
+// Turns a city name from the input into a valid and unique map file city name.
+func importName(name string, p int, used map[string]bool) string {
+	name = strings.Join(strings.Fields(name), "_")
+	name = strings.ReplaceAll(name, "=", "-")
+	name = strings.TrimLeft(name, "%")
+	if (name == "") {
+		name = pseudonym(p)
+	}
+	unique := name
+	for k := 2; used[unique]; k++ {
+		unique = fmt.Sprintf("%s_%d", name, k)
+	}
+	used[unique] = true
+	return unique
+}
+
+// The directions a road with a bearing (in degrees counterclockwise from the east) may take, the
+//   closest first, up to 90 degrees away.
+func bearingDirections(bearing float64, mode string) []int {
+	type candidate struct {
+		dir    int
+		delta  float64
+	}
+	var cands []candidate
+	for label, angle := range importAngles {
+		if (mode == IMPORT_CARDINAL) && (math.Mod(angle, 90) != 0) {
+			continue
+		}
+		delta := math.Abs(math.Mod(bearing - angle + 540, 360) - 180)
+		if (delta <= 90) {
+			d, _ := lookupDirection(label)
+			cands = append(cands, candidate{ d, delta })
+		}
+	}
+	sort.Slice(cands, func(a, b int) bool {
+		if (cands[a].delta != cands[b].delta) {
+			return cands[a].delta < cands[b].delta
+		}
+		return cands[a].dir < cands[b].dir
+	})
+	dirs := make([]int, len(cands))
+	for k, c := range cands {
+		dirs[k] = c.dir
+	}
+	return dirs
+}
+
+// Builds the cities and roads of a map from an imported network. Returns the cities and the
+//   number of roads that were dropped: loops, duplicates, roads with no free direction, and the
+//   roads dropped while reading the network (g.dropped).
+func (g *importGraph) build(mode string, geographic bool) (SNodeArray, int, error) {
+	nodes := make(SNodeArray, len(g.names))
+	used := make(map[string]bool)
+	for p := range nodes {
+		nodes[p] = SNode{ index: p, cityName: importName(g.names[p], p, used), lastVisit: -1, pop: g.pops[p] }
+	}
+
+	free := func(a int, b int, d int) bool {
+		return (nodes[a].road(d) == -1) && (nodes[b].road(opposite(d)) == -1)
+	}
+
+	dropped := g.dropped
+	for _, road := range g.roads {
+		a, b := road.from, road.to
+		if (a == b) || (connected(nodes, a, b)) {
+			dropped ++
+			continue
+		}
+
+		var dirs []int
+		switch {
+		case (road.label != ""):
+			d, ok := lookupDirection(road.label)
+			if (! ok) {
+				return nil, 0, fmt.Errorf("Unknown direction '%s' for the road from '%s' to '%s'", road.label, g.names[a], g.names[b])
+			}
+			dirs = []int{ d }
+		case (mode != IMPORT_GENERIC) && (g.placed[a]) && (g.placed[b]):
+			dx, dy := g.xs[b] - g.xs[a], g.ys[b] - g.ys[a]
+			if (geographic) {
+				dx *= math.Cos((g.ys[a] + g.ys[b]) / 2 * math.Pi / 180)
+			}
+			dirs = bearingDirections(math.Atan2(dy, dx) * 180 / math.Pi, mode)
+		default:
+			d, err := genericDirection(nodes, a, b)
+			if (err != nil) {
+				return nil, 0, err
+			}
+			dirs = []int{ d }
+		}
+
+		linked := false
+		for _, d := range dirs {
+			if (free(a, b, d)) {
+				nodes[a].setRoad(d, b)
+				nodes[b].setRoad(opposite(d), a)
+				linked = true
+				break
+			}
+		}
+		if (! linked) {
+			dropped ++
+		}
+	}
+	return nodes, dropped, nil
+}
+
+// Returns true if there is already a road between two cities.
+func connected(nodes SNodeArray, a int, b int) bool {
+	for _, r := range nodes[a].roads {
+		if (r.to == b) {
+			return true
+		}
+	}
+	return false
+}
+
+// The first generic direction ("road1", "road2", ...) that is free at both cities of a road,
+//   declared if needed.
+func genericDirection(nodes SNodeArray, a int, b int) (int, error) {
+	for k := 1; ; k++ {
+		label := fmt.Sprintf("%s%d", IMPORT_ROAD_LABEL, k)
+		if err := declareDirections(label, label); err != nil {
+			return -1, err
+		}
+		d, _ := lookupDirection(label)
+		if (nodes[a].road(d) == -1) && (nodes[b].road(d) == -1) {
+			return d, nil
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Import mode
+// ---------------------------------------------------------------------------------------------------
+
+// Reads a road network in a format (IMPORT_GEOJSON or IMPORT_CSV, or "" to tell it by the name of
+//   the file) and writes it as a map file, with the directions chosen by "mode".
+func importMap(infile string, mapfile string, format string, mode string, overwrite bool) error {
+	if (format == "") {
+		format = importFormat(infile)
+	}
+	if (format != IMPORT_GEOJSON) && (format != IMPORT_CSV) {
+		return fmt.Errorf("Unknown import format '%s' (must be '%s' or '%s')", format, IMPORT_GEOJSON, IMPORT_CSV)
+	}
+	if (mode != IMPORT_CARDINAL) && (mode != IMPORT_COMPASS) && (mode != IMPORT_GENERIC) {
+		return fmt.Errorf("Unknown direction mode '%s' (must be '%s', '%s' or '%s')", mode, IMPORT_CARDINAL, IMPORT_COMPASS, IMPORT_GENERIC)
+	}
+	if (! overwrite) {
+		if err := checkNoOverwrite(mapfile); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Will read %s road network '%s' and import it into mapfile '%s'.\n", format, infile, mapfile)
+
+	file, err := os.Open(infile)
+	if (err != nil) {
+		return fmt.Errorf("Cannot open input file '%s'", infile)
+	}
+	defer file.Close()
+	r, err := decompress(file)
+	if (err != nil) {
+		return err
+	}
+
+	var g *importGraph
+	if (format == IMPORT_GEOJSON) {
+		g, err = readGeoJSON(r)
+	} else {
+		g, err = readEdgeCSV(r)
+	}
+	if (err != nil) {
+		return &MapError{ fmt.Errorf("%v (in '%s')", err, infile) }
+	}
+
+	nodes, dropped, err := g.build(mode, format == IMPORT_GEOJSON)
+	if (err != nil) {
+		return &MapError{ err }
+	}
+	roads := countRoads(nodes, false)
+	fmt.Printf("Imported %d cities and %d roads.\n", len(nodes), roads)
+	if (dropped > 0) {
+		fmt.Printf("WARNING: %d roads were dropped (loops, duplicates, rings with no city, or no free direction at either end).\n", dropped)
+	}
+
+	fmt.Printf("Writing map file to '%s'.\n", mapfile)
+	if err := saveMap(mapfile, nodes, overwrite); err != nil {
+		return err
+	}
+	fmt.Println("Done.");
+	return nil
+}
+
+// The format of an input file, by the suffix of its name.
+func importFormat(infile string) string {
+	name := strings.ToLower(strings.TrimSuffix(infile, GZIP_SUFFIX))
+	if (strings.HasSuffix(name, ".csv")) {
+		return IMPORT_CSV
+	}
+	return IMPORT_GEOJSON
+}
+
+// Handles the command line of the import mode: -import <INFILE> <MAPFILE> [options]
+func mainImport(args []string) int {
+	var format string
+	var overwrite bool
+	mode := IMPORT_COMPASS
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&format, "format", format, "geojson or csv")
+	flags.StringVar(&mode, "directions", mode, "cardinal, compass or generic")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace an existing map file")
+
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for import mode.");
+		return usageError()
+	} else if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for import mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	return reportError(importMap(args[0], args[1], format, mode, overwrite))
+}
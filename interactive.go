@@ -0,0 +1,156 @@
+/*
+   Alien Invasion Simulator - interactive step-by-step mode
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"bufio"
+	"strconv"
+	"strings"
+)
+
+func printInteractiveHelp() {
+	fmt.Println("Commands:");
+	fmt.Println("   step [n]     Run n movement steps (default 1).");
+	fmt.Println("   status       Show the iteration count and the number of live aliens and cities.");
+	fmt.Println("   city <name>  Show a city: whether it is destroyed, its alien and its roads.");
+	fmt.Println("   alien <id>   Show where an alien is.");
+	fmt.Println("   run          Leave interactive mode and run the simulation to the end.");
+	fmt.Println("   quit         Stop the simulation here (the result files are still written).");
+	fmt.Println("   help         Show this list of commands.");
+}
+
+// Runs the movement phase under the control of commands read from "in", pausing between steps.
+func (sim *Simulator) Interact(in io.Reader) error {
+	fmt.Println("\nSimulation Phase #2: Moving aliens (interactive mode, type 'help' for commands).");
+
+	sim.progress = false
+	scanner := bufio.NewScanner(in)
+
+	for {
+		if (sim.Stopped()) {
+			fmt.Printf("Simulation has stopped (%s).\n", sim.stopReason)
+			break
+		}
+
+		fmt.Printf("[%d] ais> ", sim.iteration)
+		if (! scanner.Scan()) {
+			fmt.Println()
+			sim.Stop("quit")
+			break
+		}
+
+		args := strings.Fields(scanner.Text())
+		if (len(args) == 0) {
+			continue
+		}
+
+		switch args[0] {
+		case "step", "s":
+			n := 1
+			if (len(args) > 1) {
+				var err error
+				n, err = strconv.Atoi(args[1])
+				if (err != nil) || (n < 1) {
+					fmt.Printf("Invalid step count '%s'.\n", args[1])
+					continue
+				}
+			}
+			for k := 0; (k < n) && (! sim.Stopped()); k++ {
+				if err := sim.Iterate(); err != nil {
+					return err
+				}
+			}
+			sim.printStatus()
+
+		case "status":
+			sim.printStatus()
+
+		case "city", "c":
+			if (len(args) != 2) {
+				fmt.Println("Usage: city <name>")
+				continue
+			}
+			sim.printCity(args[1])
+
+		case "alien", "a":
+			if (len(args) != 2) {
+				fmt.Println("Usage: alien <id>")
+				continue
+			}
+			id, err := strconv.Atoi(args[1])
+			if (err != nil) || (id < 0) || (id >= len(sim.aliens)) {
+				fmt.Printf("No such alien '%s' (aliens are numbered 0 to %d).\n", args[1], len(sim.aliens) - 1)
+				continue
+			}
+			if (sim.aliens[id].city == -1) {
+				fmt.Printf("Alien #%d is dead.\n", id)
+			} else {
+				fmt.Printf("Alien #%d is in city '%s', with %d hit points.\n", id, sim.nodes[sim.aliens[id].city].cityName, sim.aliens[id].hp)
+			}
+
+		case "run", "r":
+			sim.progress = true
+			for (! sim.Stopped()) {
+				if err := sim.Iterate(); err != nil {
+					return err
+				}
+			}
+			sim.endProgress()
+
+		case "quit", "q":
+			sim.Stop("quit")
+
+		case "help", "h", "?":
+			printInteractiveHelp()
+
+		default:
+			fmt.Printf("Unknown command '%s' (type 'help' for commands).\n", args[0])
+		}
+	}
+
+	fmt.Printf("\nSimulation complete. Aliens remaining alive: %d\n", sim.liveAlienCounter);
+	return nil
+}
+
+func (sim *Simulator) printStatus() {
+	fmt.Printf("Iteration %d of %d. Aliens alive: %d of %d. Cities destroyed: %d of %d.\n",
+		sim.iteration, sim.opts.MaxSteps, sim.liveAlienCounter, len(sim.aliens), sim.deadCityCounter, len(sim.nodes))
+	if (sim.opts.DefenseRate > 0) {
+		fmt.Printf("City defense: %g points. Aliens repelled: %d.\n", sim.Defense(sim.iteration), sim.repelledCounter)
+	}
+}
+
+func (sim *Simulator) printCity(cityName string) {
+	idx := sim.CityIndex(cityName)
+	if (idx == -1) {
+		fmt.Printf("No such city '%s'.\n", cityName)
+		return
+	}
+	node := &sim.nodes[idx]
+
+	state := "alive"
+	if (node.dead) {
+		state = "destroyed"
+	}
+	occupant := "no alien"
+	if (len(node.occupants) > 0) {
+		occupant = alienList(node.occupants)
+	}
+	fmt.Printf("City '%s' (#%d) is %s, with %s.\n", node.cityName, idx, state, occupant)
+	if (node.pop > 0) {
+		fmt.Printf("   Population: %d\n", node.pop)
+	}
+
+	for _, road := range node.roads {
+		other := road.to
+		note := ""
+		if (sim.nodes[other].dead) {
+			note = " (destroyed)"
+		}
+		fmt.Printf("   %s=%s%s\n", directionName(road.dir), sim.nodes[other].cityName, note)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+   Alien Invasion Simulator - invariant checking
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// With SimOptions.CheckInvariants, the state of the simulation is checked after every movement
+//   step, and the simulation aborts on the first step that breaks it, with a dump of the aliens
+//   and cities involved. It is slow (every city and alien is visited in every step), so it is
+//   meant for debugging the simulator and its strategies, not for normal runs.
+
+// The maximum number of violations listed in the error of CheckInvariants.
+const MAX_INVARIANT_VIOLATIONS int = 20
+
+// Checks the invariants of the simulation state:
+//
+//   - every live alien has hit points left, and is in a city that has not been destroyed, and is
+//     listed once among the occupants of that city, which lists no other aliens;
+//   - no city holds enough hostile aliens for a fight that did not happen;
+//   - every road has its opposite road back (e.g. a north road from A to B means a south road
+//     from B to A);
+//   - the counters of live aliens and destroyed cities match the aliens and cities.
+//
+// Returns an error with the violations found and the state of the cities and aliens involved,
+//   or nil if there are none.
+func (sim *Simulator) CheckInvariants() error {
+	var problems []string
+	cities := make(map[int]bool)
+	aliens := make(map[int]bool)
+	report := func(city int, alien int, format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+		if (city >= 0) && (city < len(sim.nodes)) {
+			cities[city] = true
+		}
+		if (alien >= 0) {
+			aliens[alien] = true
+		}
+	}
+
+	nodes := sim.nodes
+	live, dead := 0, 0
+	for i, alien := range sim.aliens {
+		city := alien.city
+		if (city == -1) {
+			continue
+		}
+		live ++
+		if (city < 0) || (city >= len(nodes)) {
+			report(-1, i, "Alien #%d is in city #%d, which does not exist", i, city)
+			continue
+		}
+		if (nodes[city].dead) {
+			report(city, i, "Alien #%d is in destroyed city '%s'", i, nodes[city].cityName)
+		}
+		if (alien.hp <= 0) {
+			report(city, i, "Alien #%d is alive with %d hit points", i, alien.hp)
+		}
+		seen := 0
+		for _, a := range nodes[city].occupants {
+			if (a == i) {
+				seen ++
+			}
+		}
+		if (seen != 1) {
+			report(city, i, "Alien #%d is listed %d times among the occupants of its city '%s'", i, seen, nodes[city].cityName)
+		}
+	}
+
+	for c := 0; c < len(nodes); c++ {
+		node := &nodes[c]
+		if (node.dead) {
+			dead ++
+		}
+		for _, a := range node.occupants {
+			if (a < 0) || (a >= len(sim.aliens)) {
+				report(c, -1, "City '%s' lists Alien #%d among its occupants, which does not exist", node.cityName, a)
+			} else if (sim.aliens[a].city != c) {
+				report(c, a, "City '%s' lists Alien #%d among its occupants, but the alien is in city #%d", node.cityName, a, sim.aliens[a].city)
+			}
+		}
+		if (len(node.occupants) >= sim.opts.FightThreshold) && (sim.hostile(node.occupants)) {
+			report(c, -1, "City '%s' holds %s, who should have fought", node.cityName, alienList(node.occupants))
+		}
+		for _, road := range node.roads {
+			if (road.to < 0) || (road.to >= len(nodes)) {
+				report(c, -1, "The %s road from city '%s' leads to city #%d, which does not exist", directionName(road.dir), node.cityName, road.to)
+			} else if back := nodes[road.to].road(opposite(road.dir)); (back != c) {
+				report(c, -1, "The %s road from city '%s' to city '%s' has no %s road back", directionName(road.dir), node.cityName, nodes[road.to].cityName, directionName(opposite(road.dir)))
+				cities[road.to] = true
+			}
+		}
+	}
+
+	if (live != sim.liveAlienCounter) {
+		report(-1, -1, "The live alien counter is %d, but %d aliens are alive", sim.liveAlienCounter, live)
+	}
+	if (dead != sim.deadCityCounter) {
+		report(-1, -1, "The destroyed city counter is %d, but %d cities are destroyed", sim.deadCityCounter, dead)
+	}
+
+	if (len(problems) == 0) {
+		return nil
+	}
+	return fmt.Errorf("Simulation invariants broken after movement step %d:\n%s", sim.iteration, sim.dumpInvariants(problems, cities, aliens))
+}
+
+// Formats the violations found by CheckInvariants, with the state of the cities and aliens involved.
+func (sim *Simulator) dumpInvariants(problems []string, cities map[int]bool, aliens map[int]bool) string {
+	var b strings.Builder
+	for k, p := range problems {
+		if (k == MAX_INVARIANT_VIOLATIONS) {
+			fmt.Fprintf(&b, "   ... and %d more\n", len(problems) - k)
+			break
+		}
+		fmt.Fprintf(&b, "   %s\n", p)
+	}
+
+	for _, c := range sortedKeys(cities) {
+		node := &sim.nodes[c]
+		state := "standing"
+		if (node.dead) {
+			state = "destroyed"
+		}
+		var roads []string
+		for _, road := range node.roads {
+			to := fmt.Sprintf("#%d", road.to)
+			if (road.to >= 0) && (road.to < len(sim.nodes)) {
+				to = "'" + sim.nodes[road.to].cityName + "'"
+			}
+			roads = append(roads, directionName(road.dir) + "=" + to)
+		}
+		fmt.Fprintf(&b, "   City '%s' (#%d): %s, occupants %v, roads [%s]\n", node.cityName, c, state, node.occupants, strings.Join(roads, " "))
+	}
+	for _, a := range sortedKeys(aliens) {
+		if (a >= len(sim.aliens)) {
+			continue
+		}
+		fmt.Fprintf(&b, "   Alien #%d: faction %d, in city #%d, %d hit points\n", a, sim.Faction(a), sim.aliens[a].city, sim.aliens[a].hp)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// The keys of a set of indices, in increasing order.
+func sortedKeys(set map[int]bool) []int {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,157 @@
+/*
+   Alien Invasion Simulator - map layout for rendering
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Layout modes, for the -layout option of the render mode.
+const LAYOUT_AUTO  string = "auto"     // a grid if the map is a grid map, a force-directed layout otherwise
+const LAYOUT_GRID  string = "grid"     // the grid coordinates of the cities (see gridLayoutOf)
+const LAYOUT_FORCE string = "force"    // a force-directed layout (see forceLayout)
+
+// The force-directed layout compares every pair of cities in every round, so it is limited to
+//   maps of up to this many cities.
+const LAYOUT_MAX_CITIES int = 2000
+
+// Rounds of the force-directed layout.
+const LAYOUT_ROUNDS int = 300
+
+// Cities farther apart than this don't push each other in the force-directed layout.
+const LAYOUT_REACH float64 = 3
+
+// The strength of the pull towards the center in the force-directed layout.
+const LAYOUT_GRAVITY float64 = 0.05
+
+// Where each city of a map is drawn, in pixels.
+type MapLayout struct {
+	xs, ys         []int
+	width, height  int     // Size of the map drawing, margins included
+	grid           bool    // The cities are on a grid, with neighbors RENDER_CELL pixels apart
+}
+
+// Lays out a map for rendering, in one of the LAYOUT_* modes.
+func mapLayoutOf(nodes SNodeArray, mode string) (*MapLayout, error) {
+	if (mode != LAYOUT_FORCE) {
+		if grid := gridLayoutOf(nodes); (grid != nil) {
+			return gridMapLayout(grid), nil
+		}
+		if (mode == LAYOUT_GRID) {
+			return nil, fmt.Errorf("The map can't be laid out on a grid: it needs city names that encode the coordinates (e.g. 'X3Y7') or roads that fit in a grid")
+		}
+	}
+	if (len(nodes) > LAYOUT_MAX_CITIES) {
+		return nil, fmt.Errorf("The map has too many cities for a force-directed layout (%d, at most %d)", len(nodes), LAYOUT_MAX_CITIES)
+	}
+	return pixelLayout(forceLayout(nodes)), nil
+}
+
+// The pixel positions of the cities of a grid layout.
+func gridMapLayout(grid *GridLayout) *MapLayout {
+	layout := &MapLayout{ xs: make([]int, len(grid.xs)), ys: make([]int, len(grid.ys)), grid: true }
+	for i := range grid.xs {
+		layout.xs[i] = RENDER_MARGIN + (grid.xs[i] - grid.minx) * RENDER_CELL
+		layout.ys[i] = RENDER_MARGIN + (grid.ys[i] - grid.miny) * RENDER_CELL
+	}
+	layout.width = 2 * RENDER_MARGIN + (grid.width - 1) * RENDER_CELL
+	layout.height = 2 * RENDER_MARGIN + (grid.height - 1) * RENDER_CELL
+	return layout
+}
+
+// The pixel positions of cities laid out in the plane, with 1 as the distance between neighbors.
+func pixelLayout(xs []float64, ys []float64) *MapLayout {
+	layout := &MapLayout{ xs: make([]int, len(xs)), ys: make([]int, len(ys)) }
+	minx, miny, maxx, maxy := 0.0, 0.0, 0.0, 0.0
+	for i := range xs {
+		if (i == 0) || (xs[i] < minx) { minx = xs[i] }
+		if (i == 0) || (ys[i] < miny) { miny = ys[i] }
+		if (i == 0) || (xs[i] > maxx) { maxx = xs[i] }
+		if (i == 0) || (ys[i] > maxy) { maxy = ys[i] }
+	}
+	for i := range xs {
+		layout.xs[i] = RENDER_MARGIN + int(math.Round((xs[i] - minx) * float64(RENDER_CELL)))
+		layout.ys[i] = RENDER_MARGIN + int(math.Round((ys[i] - miny) * float64(RENDER_CELL)))
+	}
+	layout.width = 2 * RENDER_MARGIN + int(math.Ceil((maxx - minx) * float64(RENDER_CELL)))
+	layout.height = 2 * RENDER_MARGIN + int(math.Ceil((maxy - miny) * float64(RENDER_CELL)))
+	return layout
+}
+
+// Lays out the cities of a map in the plane with the Fruchterman-Reingold algorithm: roads pull
+//   their cities together and nearby cities push each other apart, for a number of rounds in
+//   which the cities move less and less. Neighbors end up about 1 apart. A weak pull towards the
+//   center keeps the parts of the map that are not connected from drifting apart. The layout
+//   of a map is always the same (the starting positions come from a fixed seed).
+func forceLayout(nodes SNodeArray) ([]float64, []float64) {
+	n := len(nodes)
+	side := math.Max(1, math.Sqrt(float64(n)))
+	rnd := rand.New(rand.NewSource(1))
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	for i := 0; i < n; i++ {
+		xs[i], ys[i] = rnd.Float64() * side, rnd.Float64() * side
+	}
+
+	var froms, tos []int
+	forEachRoad(nodes, func(from int, road SRoad) {
+		froms = append(froms, from)
+		tos = append(tos, road.to)
+	})
+
+	dx := make([]float64, n)
+	dy := make([]float64, n)
+	for round := 0; round < LAYOUT_ROUNDS; round++ {
+		for i := 0; i < n; i++ {
+			dx[i], dy[i] = 0, 0
+		}
+
+		// Every pair of nearby cities pushes each other apart with a force of 1/d...
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				ex, ey := xs[i] - xs[j], ys[i] - ys[j]
+				d2 := math.Max(ex * ex + ey * ey, 0.0001)
+				if (d2 > LAYOUT_REACH * LAYOUT_REACH) {
+					continue
+				}
+				dx[i] += ex / d2
+				dy[i] += ey / d2
+				dx[j] -= ex / d2
+				dy[j] -= ey / d2
+			}
+		}
+
+		// ...and every road pulls its cities together with a force of d^2.
+		for k := range froms {
+			a, b := froms[k], tos[k]
+			ex, ey := xs[a] - xs[b], ys[a] - ys[b]
+			d := math.Sqrt(ex * ex + ey * ey)
+			dx[a] -= ex * d
+			dy[a] -= ey * d
+			dx[b] += ex * d
+			dy[b] += ey * d
+		}
+
+		// ...and the center pulls every city with a force of d.
+		for i := 0; i < n; i++ {
+			dx[i] -= (xs[i] - side / 2) * LAYOUT_GRAVITY
+			dy[i] -= (ys[i] - side / 2) * LAYOUT_GRAVITY
+		}
+
+		// Each city moves along its force, by at most the temperature of the round.
+		temp := side / 4 * (1 - float64(round) / float64(LAYOUT_ROUNDS))
+		for i := 0; i < n; i++ {
+			d := math.Sqrt(dx[i] * dx[i] + dy[i] * dy[i])
+			if (d > 0) {
+				step := math.Min(d, temp)
+				xs[i] += dx[i] / d * step
+				ys[i] += dy[i] / d * step
+			}
+		}
+	}
+	return xs, ys
+}
@@ -0,0 +1,175 @@
+/*
+   Alien Invasion Simulator - leveled logging
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"encoding/json"
+)
+
+// Log levels. A message is logged if its level is at most the level of the logger.
+const LOG_ERROR int = 0    // Errors only (-quiet)
+const LOG_INFO  int = 1    // Also the simulation phases and events such as city destruction (the default)
+const LOG_DEBUG int = 2    // Also every alien move (-verbose)
+
+// Log formats.
+const LOG_FORMAT_TEXT string = "text"    // The messages as they are, for humans (the default)
+const LOG_FORMAT_JSON string = "json"    // One JSON object per message (see LogRecord), for log collectors
+
+// The kinds of log messages that can be muted with --mute, besides the EVENT_* types of the
+//   events that have a message.
+const LOG_KIND_PHASE    string = "phase"       // the simulation phases and waves
+const LOG_KIND_STOP     string = "stop"        // why the simulation stopped
+const LOG_KIND_PROGRESS string = "progress"    // the progress dots and percentages
+const LOG_KIND_RUN      string = "run"         // the messages of the simulation mode around the simulation
+
+// The kinds of log messages, as given to --mute.
+var logKinds = []string{ EVENT_DESTROYED, EVENT_FIGHT, EVENT_DEFENDED, EVENT_REPELLED, EVENT_CLASH, EVENT_ROAD_DESTROYED,
+	EVENT_TRAPPED, EVENT_MOVE, LOG_KIND_PHASE, LOG_KIND_STOP, LOG_KIND_PROGRESS, LOG_KIND_RUN }
+
+// A list of kinds of log messages. Implements flag.Value so that --mute can be repeated.
+type LogKinds []string
+
+func (k *LogKinds) String() string {
+	return strings.Join(*k, ",")
+}
+
+func (k *LogKinds) Set(value string) error {
+	for _, kind := range strings.Split(value, ",") {
+		known := false
+		for _, lk := range logKinds {
+			known = known || (kind == lk)
+		}
+		if (! known) {
+			return fmt.Errorf("unknown message kind '%s' (must be one of %s)", kind, strings.Join(logKinds, ", "))
+		}
+		*k = append(*k, kind)
+	}
+	return nil
+}
+
+func (k LogKinds) has(kind string) bool {
+	for _, c := range k {
+		if (c == kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// A log message in the JSON log format.
+type LogRecord struct {
+	Time       string    `json:"time"`
+	Level      string    `json:"level"`
+	Kind       string    `json:"kind"`
+	Iteration  int       `json:"iteration,omitempty"`
+	Msg        string    `json:"msg,omitempty"`
+	Summary    *Summary  `json:"summary,omitempty"`
+}
+
+// Writes log messages of a level and kind that are enabled, in a log format.
+type Logger struct {
+	out     io.Writer
+	level   int
+	json    bool
+	mute    LogKinds
+}
+
+// Creates the logger for a set of simulation options, writing to "out".
+func newLogger(out io.Writer, opts SimOptions) *Logger {
+	l := &Logger{ out: out, level: LOG_INFO, json: (opts.LogFormat == LOG_FORMAT_JSON), mute: opts.Mute }
+	if (opts.Quiet) {
+		l.level = LOG_ERROR
+	} else if (opts.Verbose) {
+		l.level = LOG_DEBUG
+	}
+	return l
+}
+
+// Checks the name of a log format ("" means text).
+func checkLogFormat(format string) error {
+	if (format != "") && (format != LOG_FORMAT_TEXT) && (format != LOG_FORMAT_JSON) {
+		return fmt.Errorf("Unknown log format '%s' (must be '%s' or '%s')", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+	return nil
+}
+
+// Returns true if messages of a level and kind are logged.
+func (l *Logger) enabled(level int, kind string) bool {
+	return (level <= l.level) && (! l.mute.has(kind))
+}
+
+// Returns true if free-form text (e.g. progress dots) can be written to the log.
+func (l *Logger) text(kind string) bool {
+	return (! l.json) && (l.enabled(LOG_INFO, kind))
+}
+
+// Logs a message of a level and kind, at movement step "iteration". In the text format, the
+//   message is written as it is; in the JSON format, without its surrounding blank lines.
+func (l *Logger) logf(level int, kind string, iteration int, format string, args ...any) {
+	if (! l.enabled(level, kind)) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if (! l.json) {
+		fmt.Fprint(l.out, msg)
+		return
+	}
+	l.write(LogRecord{ Level: levelName(level), Kind: kind, Iteration: iteration, Msg: strings.TrimSpace(msg) })
+}
+
+// Logs an error, as "ERROR: <err>." in the text format. Errors can't be muted.
+func (l *Logger) error(err error) {
+	if (! l.json) {
+		fmt.Fprintf(l.out, "ERROR: %s.\n", err)
+		return
+	}
+	l.write(LogRecord{ Level: levelName(LOG_ERROR), Kind: "error", Msg: err.Error() })
+}
+
+// Prints the summary of a simulation, as a record of kind "summary" in the JSON format. The summary
+//   is the result of the simulation, so it is printed at every level and can't be muted.
+func (l *Logger) summary(s *Summary) {
+	if (! l.json) {
+		s.Print()
+		return
+	}
+	l.write(LogRecord{ Level: levelName(LOG_INFO), Kind: "summary", Summary: s })
+}
+
+// Writes a record in the JSON format, with the current time.
+func (l *Logger) write(rec LogRecord) {
+	rec.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if data, err := json.Marshal(rec); err == nil {
+		l.out.Write(append(data, '\n'))
+	}
+}
+
+// Returns true if a command line asks for quiet or JSON logging, which leave out the banner.
+func logsQuietly(args []string) bool {
+	for i, a := range args {
+		if (strings.HasPrefix(a, "--")) {
+			a = a[1:]
+		}
+		if (a == "-quiet") || (a == "-log-format=" + LOG_FORMAT_JSON) {
+			return true
+		}
+		if (a == "-log-format") && (i + 1 < len(args)) && (args[i + 1] == LOG_FORMAT_JSON) {
+			return true
+		}
+	}
+	return false
+}
+
+func levelName(level int) string {
+	switch level {
+	case LOG_ERROR: return "error"
+	case LOG_DEBUG: return "debug"
+	}
+	return "info"
+}
@@ -0,0 +1,464 @@
+/*
+   Alien Invasion Simulator - map file reader and writer
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"math"
+	"bufio"
+	"bytes"
+	"strings"
+	"strconv"
+)
+
+// ---------------------------------------------------------------------------------------------------
+// Map file format
+// ---------------------------------------------------------------------------------------------------
+
+// A map file has one city per line. Each line is the city name followed by zero or more
+//   DIRECTION=CITY items separated by a single space, e.g.:
+//
+//   Foo north=Bar west=Baz south=Qu-ux
+//
+// Road directions are the four cardinal directions, the diagonals (northeast, southwest, southeast,
+//   northwest), up/down and in/out. Other direction pairs must be declared with a directive line
+//   before they are used, e.g.:
+//
+//   %directions portal/gate wormhole/wormhole
+//
+// Every direction has an opposite (a direction may be its own opposite). The reader only requires
+//   each road to be declared on one side; the opposite road is implied (but if declared, it must
+//   agree). See directions.go.
+//
+// A line may also give the population of the city with a POPULATION_KEY item (e.g. "pop=5000"),
+//   which is 0 if not given. The population defends the city (see SimOptions.Defenders).
+
+// The key of the population item in a city line. It can't be used as a direction label.
+const POPULATION_KEY string = "pop"
+
+// Parses the value of a population item.
+func parsePopulation(value string) (int, error) {
+	pop, err := strconv.Atoi(value)
+	if (err != nil) || (pop < 0) {
+		return 0, fmt.Errorf("Invalid population '%s' (must be a non-negative integer)", value)
+	}
+	return pop, nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Map file reader
+// ---------------------------------------------------------------------------------------------------
+
+// The reader keeps the memory it needs close to the size of the map itself, so that maps with
+//   millions of cities can be simulated:
+//
+//   - Lines are parsed in place, and only the city names are kept, once, instead of a string per
+//     line and per road.
+//   - Each road is resolved to the index of its city as soon as that city is defined. Only the
+//     names of the cities that are referenced before they are defined are kept, until then.
+//   - The city data store is allocated for the size of the map file up front, and the roads of
+//     all the cities share a single array.
+//
+// The two-pass reader (see loadMapTwoPass) reads the city names first, so that every road is
+//   resolved as it is read and the city data store has exactly the size of the map.
+
+// The shortest line length to expect from a map file, to allocate the city data store for a map
+//   file of a given size. Maps written by the generator have lines of 25 to 45 bytes. Guessing
+//   too many cities is cheap: the memory that is never used is never touched either.
+const MAP_BYTES_PER_CITY int64 = 24
+
+// A road declared in a map file: its direction and the index of its city, or -1 while that city
+//   is not defined yet. The fields are 32 bits wide to halve the memory the reader needs for them.
+type declRoad struct {
+	dir  int32
+	to   int32
+}
+
+// The state of the map file reader.
+type mapReader struct {
+	nodes    SNodeArray
+	nodeMap  SNodeMap
+	decl     []declRoad           // The roads declared by the cities, in the order of the map file
+	first    []int32              // The index in "decl" of the first road declared by each city
+	pending  map[string][]int32   // The roads declared to each city that is not defined yet
+	known    bool                 // Set if the cities are all in "nodes" already (second pass)
+}
+
+// Creates a map file reader for a map of about "cities" cities.
+func newMapReader(cities int64) *mapReader {
+	return &mapReader{
+		nodes:    make(SNodeArray, 0, cities),
+		nodeMap:  make(SNodeMap, cities),
+		decl:     make([]declRoad, 0, 2 * cities),
+		first:    make([]int32, 0, cities),
+		pending:  make(map[string][]int32),
+	}
+}
+
+// Reads a map file into a city data store and its name index. The map is read from the standard
+//   input if "mapfile" is STDIO, and decompressed if it is gzip-compressed. Errors in the contents of the map are returned as a MapError.
+func loadMap(mapfile string) (SNodeArray, SNodeMap, error) {
+	if (mapfile == STDIO) {
+		return readMapSized(os.Stdin, 0)
+	}
+
+	file, err := os.Open(mapfile)
+	if (err != nil) {
+		return nil, nil, fmt.Errorf("Cannot read from input file '%s'", mapfile)
+	}
+	defer file.Close()
+
+	return readMapSized(file, dataSize(file))
+}
+
+// Reads a map file like loadMap, but in two passes: the first one only reads the city names, and
+//   the second one the roads, which are all resolved as they are read. This needs less memory
+//   for very large maps, but the map can't be read from the standard input.
+func loadMapTwoPass(mapfile string) (SNodeArray, SNodeMap, error) {
+	file, err := os.Open(mapfile)
+	if (err != nil) {
+		return nil, nil, fmt.Errorf("Cannot read from input file '%s'", mapfile)
+	}
+	defer file.Close()
+
+	// First pass: index the city names, and count the roads
+	mr := newMapReader(0)
+	roads := 0
+	err = scanMap(file, func(line []byte) error {
+		if (len(line) > 0) && (! bytes.HasPrefix(line, []byte(DIRECTIONS_DIRECTIVE + " "))) {
+			name, _, _ := bytes.Cut(line, []byte(" "))
+			if _, ok := mr.nodeMap[string(name)]; (! ok) {
+				mr.nodeMap[string(name)] = len(mr.nodeMap)
+			}
+			roads += bytes.Count(line, []byte("="))
+		}
+		return nil
+	})
+	if (err != nil) {
+		return nil, nil, &MapError{ err }
+	}
+
+	mr.nodes = make(SNodeArray, len(mr.nodeMap))
+	for name, i := range mr.nodeMap {
+		mr.nodes[i] = SNode{ index: i, cityName: name, lastVisit: -1 }
+	}
+	mr.decl = make([]declRoad, 0, roads)
+	mr.first = make([]int32, 0, len(mr.nodes))
+	mr.known = true
+
+	// Second pass: read the cities again, with their roads
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("Cannot read from input file '%s' again", mapfile)
+	}
+	if err := scanMap(file, mr.readLine); err != nil {
+		return nil, nil, &MapError{ err }
+	}
+	if err := mr.compile(); err != nil {
+		return nil, nil, &MapError{ err }
+	}
+	return mr.nodes, mr.nodeMap, nil
+}
+
+// Parses map data into a city data store and its name index.
+func readMap(r io.Reader) (SNodeArray, SNodeMap, error) {
+	return readMapSized(r, 0)
+}
+
+// Parses map data of about "size" bytes (0 if not known) into a city data store and its name
+//   index. Errors in the map data are returned as a MapError.
+func readMapSized(r io.Reader, size int64) (SNodeArray, SNodeMap, error) {
+	mr := newMapReader(size / MAP_BYTES_PER_CITY)
+	if err := scanMap(r, mr.readLine); err != nil {
+		return nil, nil, &MapError{ err }
+	}
+	if err := mr.compile(); err != nil {
+		return nil, nil, &MapError{ err }
+	}
+	return mr.nodes, mr.nodeMap, nil
+}
+
+// Calls "f" with every line of map data, which is decompressed if it is gzip-compressed. The line
+//   is only valid until "f" returns.
+func scanMap(r io.Reader, f func(line []byte) error) error {
+	r, err := decompress(r)
+	if (err != nil) {
+		return err
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := f(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error encountered while parsing input file: %v", err)
+	}
+	return nil
+}
+
+// Parses a line of map data: a city definition, or a directive that declares more road directions.
+func (mr *mapReader) readLine(line []byte) error {
+
+	// If the line isn't empty, it denotes a new city definition
+	if (len(line) == 0) {
+		return nil
+	}
+
+	// Or it declares more road directions
+	if (bytes.HasPrefix(line, []byte(DIRECTIONS_DIRECTIVE + " "))) {
+		return parseDirectionsDirective(string(line))
+	}
+
+	// Line is some tokens separated by a space, the city name first
+	name, rest, more := bytes.Cut(line, []byte(" "))
+	idx, err := mr.define(name)
+	if (err != nil) {
+		return err
+	}
+	node := &mr.nodes[idx]
+
+	// Parse all DIRECTION=CITY items from this line into declared roads
+	for (more) {
+		var item []byte
+		item, rest, more = bytes.Cut(rest, []byte(" "))
+
+		label, neighborName, ok := bytes.Cut(item, []byte("="))
+		if (! ok) || (bytes.IndexByte(neighborName, '=') != -1) {
+			return fmt.Errorf("Syntax error parsing city connection in line '%s'", line)
+		}
+
+		if (string(label) == POPULATION_KEY) {
+			pop, err := parsePopulation(string(neighborName))
+			if (err != nil) {
+				return fmt.Errorf("%v in line '%s'", err, line)
+			}
+			node.pop = pop
+			continue
+		}
+
+		dir, ok := lookupDirection(string(label))
+		if (! ok) {
+			return fmt.Errorf("Unknown direction '%s' in line '%s'", label, line)
+		}
+		if (mr.declared(idx, dir) != -1) {
+			return fmt.Errorf("City '%s' declares more than one %s road", node.cityName, label)
+		}
+		if (string(neighborName) == node.cityName) {
+			return fmt.Errorf("City '%s' is being defined as a neighbor of itself", node.cityName)
+		}
+		if (len(mr.decl) == math.MaxInt32) {
+			return fmt.Errorf("Too many roads (more than %d)", math.MaxInt32)
+		}
+		mr.decl = append(mr.decl, declRoad{ int32(dir), mr.resolve(neighborName) })
+	}
+	return nil
+}
+
+// Defines the city of the next line of the map, and returns its index in the city data store.
+func (mr *mapReader) define(name []byte) (int, error) {
+	idx := len(mr.first)
+
+	// In the second pass, the cities are already indexed in the order of their lines
+	if (mr.known) {
+		if (mr.nodeMap[string(name)] != idx) {
+			return -1, fmt.Errorf("Duplicate city definition found: '%s'", name)
+		}
+		mr.first = append(mr.first, int32(len(mr.decl)))
+		return idx, nil
+	}
+
+	// Forbid city redefinition
+	if _, exists := mr.nodeMap[string(name)]; (exists) {
+		return -1, fmt.Errorf("Duplicate city definition found: '%s'", name)
+	}
+
+	cityName := string(name)
+	mr.nodes = append(mr.nodes, SNode{ index: idx, cityName: cityName, lastVisit: -1 })
+	mr.nodeMap[cityName] = idx
+	mr.first = append(mr.first, int32(len(mr.decl)))
+
+	// Resolve the roads declared to this city by the cities before it
+	if refs, ok := mr.pending[cityName]; (ok) {
+		for _, k := range refs {
+			mr.decl[k].to = int32(idx)
+		}
+		delete(mr.pending, cityName)
+	}
+	return idx, nil
+}
+
+// Resolves the city of the next declared road, or returns -1 and remembers the road if that city
+//   is not defined yet.
+func (mr *mapReader) resolve(name []byte) int32 {
+	if idx, ok := mr.nodeMap[string(name)]; (ok) {
+		return int32(idx)
+	}
+	key := string(name)
+	mr.pending[key] = append(mr.pending[key], int32(len(mr.decl)))
+	return -1
+}
+
+// Returns the index in "decl" of the road that city "idx" declares in direction "dir", or -1.
+func (mr *mapReader) declared(idx int, dir int) int {
+	end := int(mr.first[idx]) + mr.roads(idx)
+	for k := int(mr.first[idx]); k < end; k++ {
+		if (int(mr.decl[k].dir) == dir) {
+			return k
+		}
+	}
+	return -1
+}
+
+// The name of the city of declared road "k", which may not be defined.
+func (mr *mapReader) declName(k int) string {
+	if (mr.decl[k].to != -1) {
+		return mr.nodes[mr.decl[k].to].cityName
+	}
+	for name, refs := range mr.pending {
+		for _, ref := range refs {
+			if (int(ref) == k) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Once all of the cities have been read, compiles the declared roads into SNode.roads, adding the opposite roads that are implied. We
+//   also check that the roads in opposite directions between adjacent cities are consistent.
+func (mr *mapReader) compile() error {
+	nodes := mr.nodes
+
+	// Every declared road is a road of both of its cities, at most; the roads of all the cities
+	//   share one array, each city with room for all of its roads.
+	degree := make([]int32, len(nodes))
+	for i := 0; i < len(mr.first); i++ {
+		degree[i] = int32(mr.roads(i))
+	}
+	for _, road := range mr.decl {
+		if (road.to != -1) {
+			degree[road.to] ++
+		}
+	}
+	total := 0
+	for _, n := range degree {
+		total += int(n)
+	}
+	all := make([]SRoad, total)
+	for i := 0; i < len(nodes); i++ {
+		n := int(degree[i])
+		nodes[i].roads = all[:0:n]
+		all = all[n:]
+	}
+
+	for i := 0; i < len(mr.first); i++ {
+
+		var node *SNode = &nodes[i]
+
+		end := int(mr.first[i]) + mr.roads(i)
+		for k := int(mr.first[i]); k < end; k++ {
+
+			d, idx := int(mr.decl[k].dir), int(mr.decl[k].to)
+			if (idx == -1) {
+				return fmt.Errorf("City '%s' references an adjacent but non-existing city '%s'", node.cityName, mr.declName(k))
+			}
+
+			node.setRoad(d, idx);
+
+			// Now, either the neighbor hasn't declared the backlink to us, or if they did, it must point
+			//   to us as well. If they did not declare it, we will set it now.
+
+			od := opposite(d);
+
+			var neighNode *SNode = &nodes[idx];
+
+			back := mr.declared(idx, od)
+			if (back == -1) || (int(mr.decl[back].to) == i) {
+				neighNode.setRoad(od, i);
+			} else {
+				return fmt.Errorf("City '%s' declares a %s road to city '%s', but the inverse %s road points to '%s' instead",
+					node.cityName, directionName(d), neighNode.cityName, directionName(od), mr.declName(back))
+			}
+		}
+	}
+
+	return nil
+}
+
+// The number of roads declared by city "idx".
+func (mr *mapReader) roads(idx int) int {
+	if (idx + 1 < len(mr.first)) {
+		return int(mr.first[idx + 1] - mr.first[idx])
+	}
+	return len(mr.decl) - int(mr.first[idx])
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Map file writer
+// ---------------------------------------------------------------------------------------------------
+
+// Serializes the cities that have not been destroyed, and the roads between them, in the map
+//   file format.
+func writeMap(w io.Writer, nodes SNodeArray) error {
+	bw := bufio.NewWriter(w)
+
+	// Declare the direction pairs that are not built in, so that the file can be read back
+	if pairs := customDirections(nodes); (len(pairs) > 0) {
+		if _, err := bw.WriteString(DIRECTIONS_DIRECTIVE + " " + strings.Join(pairs, " ") + "\n"); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < len(nodes); i++ {
+
+		// Skip dead cities
+		if (nodes[i].dead) {
+			continue
+		}
+
+		// Write out the line
+		if _, err := bw.WriteString(mapLine(nodes, i) + "\n"); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// The line of a city in the map file format, without the line break.
+func mapLine(nodes SNodeArray, i int) string {
+
+	// Line starts with the name of the city, and its population if any
+	line := nodes[i].cityName;
+	if (nodes[i].pop > 0) {
+		line += fmt.Sprintf(" %s=%d", POPULATION_KEY, nodes[i].pop)
+	}
+
+	// Then we look for all valid directions that link to other non-dead
+	//   cities and append them to the output line
+	for _, road := range nodes[i].roads {
+
+		otherIdx := road.to
+
+		// Leads to dead city
+		if (nodes[otherIdx].dead) {
+			continue
+		}
+
+		// It's good
+		line += " " + directionName(road.dir) + "=" + nodes[otherIdx].cityName;
+	}
+	return line
+}
+
+// Writes the cities that have not been destroyed to a map file, atomically.
+// If "overwrite" is false, an existing file is not replaced.
+func saveMap(mapfile string, nodes SNodeArray, overwrite bool) error {
+	return writeFileAtomic(mapfile, overwrite, func(w io.Writer) error {
+		return writeMap(w, nodes)
+	})
+}
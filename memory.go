@@ -0,0 +1,132 @@
+/*
+   Alien Invasion Simulator - memory limits
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// A size in bytes. Implements flag.Value so that --max-memory accepts sizes such as "512M" or
+//   "2G" (binary units: K is 1024 bytes), as well as plain byte counts.
+type ByteSize int64
+
+func (b *ByteSize) String() string {
+	return formatBytes(int64(*b))
+}
+
+func (b *ByteSize) Set(value string) error {
+	s := strings.TrimSuffix(strings.ToUpper(value), "B")
+	s = strings.TrimSuffix(s, "I")
+	unit := int64(1)
+	if (s != "") {
+		switch s[len(s) - 1] {
+		case 'K': unit = 1 << 10
+		case 'M': unit = 1 << 20
+		case 'G': unit = 1 << 30
+		case 'T': unit = 1 << 40
+		}
+		if (unit > 1) {
+			s = s[:len(s) - 1]
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if (err != nil) || (n < 0) {
+		return fmt.Errorf("invalid size '%s' (expected e.g. 512M or 2G)", value)
+	}
+	*b = ByteSize(n * float64(unit))
+	return nil
+}
+
+// Formats a byte count for humans, e.g. "1.5 MiB".
+func formatBytes(n int64) string {
+	const units = "KMGT"
+	if (n < 1024) {
+		return fmt.Sprintf("%d B", n)
+	}
+	v := float64(n) / 1024
+	u := 0
+	for (v >= 1024) && (u < len(units) - 1) {
+		v /= 1024
+		u ++
+	}
+	return fmt.Sprintf("%.1f %ciB", v, units[u])
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Memory estimates
+// ---------------------------------------------------------------------------------------------------
+
+// The memory taken by an entry of the city name index, besides the name itself (which is shared
+//   with the city): the key and value, and the spare room of the hash table.
+const NODEMAP_ENTRY_SIZE int64 = 2 * int64(unsafe.Sizeof("") + unsafe.Sizeof(0))
+
+// Estimates the memory that the simulation model of a map needs: the cities with their names and
+//   roads, the city name index, and the positions and city occupancy of "numaliens" aliens. The
+//   alien paths (-paths) grow as the simulation runs, so only their first entry is counted.
+func estimateMemory(nodes SNodeArray, numaliens int) int64 {
+	var total int64
+	for i := 0; i < len(nodes); i++ {
+		total += int64(unsafe.Sizeof(nodes[i])) + int64(len(nodes[i].cityName)) + NODEMAP_ENTRY_SIZE
+		total += int64(cap(nodes[i].roads)) * int64(unsafe.Sizeof(SRoad{}))
+	}
+
+	// Every alien has a position and hit points, and a place in the occupants of a city.
+	total += int64(numaliens) * int64(unsafe.Sizeof(SAlien{}) + unsafe.Sizeof(0))
+	return total
+}
+
+// Refuses to simulate a model whose estimated memory exceeds the --max-memory cap, if any.
+func checkMemory(nodes SNodeArray, numaliens int, opts SimOptions) error {
+	if (opts.MaxMemory <= 0) {
+		return nil
+	}
+	estimate := estimateMemory(nodes, numaliens)
+	if (opts.RecordPaths) {
+		estimate += int64(numaliens) * int64(unsafe.Sizeof([]int{}) + unsafe.Sizeof(0))
+	}
+	if (estimate > int64(opts.MaxMemory)) {
+		return fmt.Errorf("The simulation of %d cities and %d aliens needs an estimated %s, which exceeds the memory cap of %s (see -max-memory)",
+			len(nodes), numaliens, formatBytes(estimate), formatBytes(int64(opts.MaxMemory)))
+	}
+	return nil
+}
+
+// Refuses to read a map file that is larger than the --max-memory cap, if any, since the model
+//   always takes more memory than the city names and roads in the file.
+func checkMapFileSize(mapfile string, opts SimOptions) error {
+	if (opts.MaxMemory <= 0) {
+		return nil
+	}
+	info, err := os.Stat(mapfile)
+	if (err != nil) {
+		return nil // Let the map reader report it
+	}
+	if (info.Size() > int64(opts.MaxMemory)) {
+		return fmt.Errorf("Map file '%s' is %s, which exceeds the memory cap of %s (see -max-memory)",
+			mapfile, formatBytes(info.Size()), formatBytes(int64(opts.MaxMemory)))
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Memory usage
+// ---------------------------------------------------------------------------------------------------
+
+// Movement steps between the samples of the memory in use.
+const MEMORY_SAMPLE_EVERY int = 100
+
+// Samples the heap memory in use, to report its peak in the summary.
+func (sim *Simulator) sampleMemory() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if (int64(ms.HeapInuse) > sim.peakMemory) {
+		sim.peakMemory = int64(ms.HeapInuse)
+	}
+}
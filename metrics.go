@@ -0,0 +1,134 @@
+/*
+   Alien Invasion Simulator - server metrics
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// The server counts what its simulations do, across all of them, and serves the counts at
+//   GET /metrics in the Prometheus text exposition format
+//   (https://prometheus.io/docs/instrumenting/exposition_formats/), so that long-running servers
+//   can be monitored by the usual Prometheus and Grafana setups. The counters start from zero
+//   when the server starts.
+
+// The metrics of a server.
+type ServerMetrics struct {
+	mu                sync.Mutex
+	running           int    // Simulations running now
+	started           int    // Simulations started
+	done              int    // Simulations that ran to the end
+	failed            int    // Simulations that failed while running
+	steps             int    // Movement steps run
+	fights            int    // Fights between aliens, in cities and on roads
+	citiesDestroyed   int
+	aliensKilled      int
+	mapErrors         int    // Uploaded maps that could not be parsed
+	requestErrors     int    // Simulation requests that could not be decoded or were invalid
+}
+
+// Counts the events of a simulation into the metrics of the server.
+type metricsObserver struct {
+	NopObserver
+	m  *ServerMetrics
+}
+
+func (o metricsObserver) OnFight(sim *Simulator, ev Event) {
+	o.m.mu.Lock()
+	defer o.m.mu.Unlock()
+	if (ev.Type != EVENT_REPELLED) {
+		o.m.fights ++
+	}
+	o.m.aliensKilled += len(ev.Aliens) - len(ev.Survivors)
+}
+
+func (o metricsObserver) OnCityDestroyed(sim *Simulator, ev Event) {
+	o.m.mu.Lock()
+	defer o.m.mu.Unlock()
+	o.m.citiesDestroyed ++
+	if (len(ev.Aliens) > 0) {
+		o.m.fights ++
+		o.m.aliensKilled += len(ev.Aliens)
+	}
+}
+
+func (o metricsObserver) OnStep(sim *Simulator, iteration int) {
+	o.m.mu.Lock()
+	defer o.m.mu.Unlock()
+	o.m.steps ++
+}
+
+// Counts a simulation that starts, and follows its events.
+func (m *ServerMetrics) jobStarted(sim *Simulator) {
+	sim.AddObserver(metricsObserver{ m: m }, false)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started ++
+	m.running ++
+}
+
+// Counts a simulation that has ended, in one of the JOB_* states.
+func (m *ServerMetrics) jobEnded(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running --
+	if (state == JOB_FAILED) {
+		m.failed ++
+	} else {
+		m.done ++
+	}
+}
+
+func (m *ServerMetrics) mapError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mapErrors ++
+}
+
+func (m *ServerMetrics) requestError() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requestErrors ++
+}
+
+// Writes the metrics in the Prometheus text format.
+func (m *ServerMetrics) format(maps int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	metric := func(name string, kind string, help string, samples ...string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, s := range samples {
+			fmt.Fprintf(&b, "%s%s\n", name, s)
+		}
+	}
+	value := func(n int) string {
+		return fmt.Sprintf(" %d", n)
+	}
+	metric("ais_simulations_running", "gauge", "Simulations running now.", value(m.running))
+	metric("ais_simulations_started_total", "counter", "Simulations started.", value(m.started))
+	metric("ais_simulations_finished_total", "counter", "Simulations that have ended, by how they ended.",
+		fmt.Sprintf("{state=\"%s\"} %d", JOB_DONE, m.done), fmt.Sprintf("{state=\"%s\"} %d", JOB_FAILED, m.failed))
+	metric("ais_steps_total", "counter", "Movement steps run by all simulations.", value(m.steps))
+	metric("ais_fights_total", "counter", "Fights between aliens, in cities and on roads.", value(m.fights))
+	metric("ais_cities_destroyed_total", "counter", "Cities destroyed.", value(m.citiesDestroyed))
+	metric("ais_aliens_killed_total", "counter", "Aliens killed.", value(m.aliensKilled))
+	metric("ais_parse_errors_total", "counter", "Uploaded maps and simulation requests that were rejected.",
+		fmt.Sprintf("{kind=\"map\"} %d", m.mapErrors), fmt.Sprintf("{kind=\"request\"} %d", m.requestErrors))
+	metric("ais_maps", "gauge", "Maps uploaded to the server.", value(maps))
+	return b.String()
+}
+
+func (srv *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	maps := len(srv.maps)
+	srv.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, srv.metrics.format(maps))
+}
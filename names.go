@@ -0,0 +1,145 @@
+/*
+   Alien Invasion Simulator - city name generators
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"bufio"
+	"strings"
+)
+
+// Gives a name to the city at the given grid coordinates. Each call must return a name that
+//   was not returned before, so that city names stay unique.
+// Names must not contain spaces or '=' characters, which the map file format reserves.
+type CityNamer func(x int, y int) string
+
+// The default city names, which encode the grid coordinates ("X<x>Y<y>"). With other names, the
+//   grid has to be inferred from the roads (see inferCoords), which fails for a torus.
+func coordNamer() CityNamer {
+	return cityNameAt
+}
+
+// Syllables for the procedural city names.
+var nameOnsets = []string{ "b", "br", "c", "ch", "d", "dr", "f", "g", "gr", "h", "k", "l", "m", "n", "p", "pr", "r", "s", "st", "t", "th", "tr", "v", "w", "z" }
+var nameVowels = []string{ "a", "e", "i", "o", "u", "ai", "ea", "io", "ou" }
+var nameCodas  = []string{ "", "", "", "n", "r", "l", "s", "th", "m", "x" }
+var nameEnds   = []string{ "", "", "", "ia", "on", "ar", "en", "ville", "burg", "ford", "ton", "mouth", "holm" }
+
+// Procedural city names made of random syllables (e.g. "Tranholm", "Beaxia").
+// If a generated name was already used, new names are tried a few times, and then a numeric
+//   suffix is added to keep it unique.
+func syllableNamer() CityNamer {
+	used := make(map[string]bool)
+	pick := func(list []string) string { return list[rnd.Intn(len(list))] }
+
+	return func(x int, y int) string {
+		var name string
+		for try := 0; try < 10; try++ {
+			name = ""
+			syllables := 1 + rnd.Intn(3)
+			for i := 0; i < syllables; i++ {
+				name += pick(nameOnsets) + pick(nameVowels) + pick(nameCodas)
+			}
+			name = strings.ToUpper(name[:1]) + name[1:] + pick(nameEnds)
+			if (! used[name]) {
+				break
+			}
+		}
+		return uniqueName(used, name)
+	}
+}
+
+// City names drawn at random from a word list, without repetition. Once every word has been
+//   used, the words are reused with a numeric suffix (e.g. "Lisbon-2").
+func dictionaryNamer(words []string) CityNamer {
+	used := make(map[string]bool)
+	var pool []string
+
+	return func(x int, y int) string {
+		if (len(pool) == 0) {
+			pool = append(pool, words...)
+			rnd.Shuffle(len(pool), func(i, j int) { pool[i], pool[j] = pool[j], pool[i] })
+		}
+		name := pool[len(pool) - 1]
+		pool = pool[:len(pool) - 1]
+		return uniqueName(used, name)
+	}
+}
+
+// Marks a name as used and returns it, adding a "-N" suffix if it was already used.
+func uniqueName(used map[string]bool, name string) string {
+	unique := name
+	for n := 2; used[unique]; n++ {
+		unique = fmt.Sprintf("%s-%d", name, n)
+	}
+	used[unique] = true
+	return unique
+}
+
+// Reads a word list for dictionaryNamer: one name per line, with blank lines and lines starting
+//   with '#' ignored. Spaces inside a name are replaced with '_', since the map file format uses
+//   them as separators. Duplicate names are dropped.
+func loadNames(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot read from name file '%s'", filename)
+	}
+	defer file.Close()
+
+	var words []string
+	seen := make(map[string]bool)
+	lineNumber := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineNumber ++
+		word := strings.TrimSpace(scanner.Text())
+		if (word == "") || (strings.HasPrefix(word, "#")) {
+			continue
+		}
+		if (strings.Contains(word, "=")) {
+			return nil, fmt.Errorf("Name '%s' in line %d of name file '%s' contains a '='", word, lineNumber, filename)
+		}
+		word = strings.Join(strings.Fields(word), "_")
+		if (! seen[word]) {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error encountered while reading name file '%s': %v", filename, err)
+	}
+	if (len(words) == 0) {
+		return nil, fmt.Errorf("Name file '%s' has no names", filename)
+	}
+	return words, nil
+}
+
+// Creates the city namer selected by the -names option: "coords" (the default), "syllables",
+//   or the name of a word list file.
+func newCityNamer(names string) (CityNamer, error) {
+	switch (names) {
+	case "", "coords":
+		return coordNamer(), nil
+	case "syllables":
+		return syllableNamer(), nil
+	}
+	words, err := loadNames(names)
+	if (err != nil) {
+		return nil, err
+	}
+	return dictionaryNamer(words), nil
+}
+
+// Renames all the cities of a generated world, in row order.
+func (wmap World) rename(namer CityNamer) {
+	for y := 0; y < len(wmap); y++ {
+		for x := 0; x < len(wmap[y]); x++ {
+			if (wmap[y][x].cityName != "") {
+				wmap[y][x].cityName = namer(x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+   Alien Invasion Simulator - simulation observers
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Observers are the way to follow a simulation from the code that embeds the simulator (e.g. to
+//   drive a custom UI, collect metrics or persist the results elsewhere): each kind of event has
+//   its own method, and OnStep is called after every movement step. The messages that the
+//   simulator prints about the events are written by one such observer (see consoleObserver),
+//   so the simulation itself never prints them.
+
+// Receives what happens in a simulation, as it happens. The simulation can be inspected from the
+//   methods (e.g. Simulator.LiveAliens), but must not be modified from the event methods.
+type Observer interface {
+	OnSpawn(sim *Simulator, ev Event)            // An alien has been placed in a city (EVENT_SPAWN)
+	OnMove(sim *Simulator, ev Event)             // An alien has moved (EVENT_MOVE), if moves were asked for
+	OnFight(sim *Simulator, ev Event)            // Aliens have fought without destroying a city (EVENT_FIGHT, EVENT_DEFENDED, EVENT_CLASH, EVENT_REPELLED)
+	OnCityDestroyed(sim *Simulator, ev Event)    // A city has been destroyed (EVENT_DESTROYED)
+	OnRoadDestroyed(sim *Simulator, ev Event)    // A road has been destroyed on its own (EVENT_ROAD_DESTROYED)
+	OnTrapped(sim *Simulator, ev Event)          // An alien can't move again (EVENT_TRAPPED)
+	OnStep(sim *Simulator, iteration int)        // A movement step has ended (see SimOptions.AfterIteration)
+}
+
+// An Observer that ignores everything. Embed it in an observer to implement only some methods.
+type NopObserver struct {}
+
+func (NopObserver) OnSpawn(sim *Simulator, ev Event) {}
+func (NopObserver) OnMove(sim *Simulator, ev Event) {}
+func (NopObserver) OnFight(sim *Simulator, ev Event) {}
+func (NopObserver) OnCityDestroyed(sim *Simulator, ev Event) {}
+func (NopObserver) OnRoadDestroyed(sim *Simulator, ev Event) {}
+func (NopObserver) OnTrapped(sim *Simulator, ev Event) {}
+func (NopObserver) OnStep(sim *Simulator, iteration int) {}
+
+// Registers an observer of the simulation. OnMove is only called if "moves" is true, since there
+//   is a move for every alien in every movement step.
+func (sim *Simulator) AddObserver(o Observer, moves bool) {
+	sim.Subscribe(func(ev Event) {
+		switch ev.Type {
+		case EVENT_SPAWN:
+			o.OnSpawn(sim, ev)
+		case EVENT_MOVE:
+			o.OnMove(sim, ev)
+		case EVENT_FIGHT, EVENT_DEFENDED, EVENT_CLASH, EVENT_REPELLED:
+			o.OnFight(sim, ev)
+		case EVENT_DESTROYED:
+			o.OnCityDestroyed(sim, ev)
+		case EVENT_ROAD_DESTROYED:
+			o.OnRoadDestroyed(sim, ev)
+		case EVENT_TRAPPED:
+			o.OnTrapped(sim, ev)
+		}
+	}, moves)
+	sim.AddAfterIteration(func(s *Simulator, iteration int) {
+		o.OnStep(s, iteration)
+	})
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Console output
+// ---------------------------------------------------------------------------------------------------
+
+// The observer that logs a message for every event, through the logger of the simulation.
+type consoleObserver struct {
+	NopObserver
+}
+
+func (consoleObserver) OnMove(sim *Simulator, ev Event) {
+	sim.endProgress()
+	sim.logf(LOG_DEBUG, EVENT_MOVE, "Alien #%d has moved from '%s' to '%s'.\n", ev.Aliens[0], ev.From, ev.City)
+}
+
+func (consoleObserver) OnFight(sim *Simulator, ev Event) {
+	sim.endProgress()
+	switch ev.Type {
+	case EVENT_DEFENDED:
+		sim.logf(LOG_INFO, EVENT_DEFENDED, "The defenders of city '%s' have killed %s!\n", ev.City, alienList(ev.Aliens))
+	case EVENT_FIGHT:
+		msg := fmt.Sprintf("City '%s' has survived a fight between %s", ev.City, alienList(ev.Aliens))
+		if (ev.CityHP == 1) {
+			msg += ", with 1 hit point left"
+		} else if (ev.CityHP > 0) {
+			msg += fmt.Sprintf(", with %d hit points left", ev.CityHP)
+		}
+		if (len(ev.Survivors) > 0) {
+			msg += fmt.Sprintf(", won by %s", alienList(ev.Survivors))
+		}
+		sim.logf(LOG_INFO, EVENT_FIGHT, "%s!\n", msg)
+	case EVENT_CLASH:
+		sim.logf(LOG_INFO, EVENT_CLASH, "Alien #%d and Alien #%d have met on the %s road from '%s' to '%s' and destroyed it!\n",
+			ev.Aliens[0], ev.Aliens[1], ev.Dir, ev.From, ev.City)
+	case EVENT_REPELLED:
+		sim.logf(LOG_INFO, EVENT_REPELLED, "Alien #%d has been killed by the defenses of city '%s'!\n", ev.Aliens[0], ev.City)
+	}
+}
+
+func (consoleObserver) OnCityDestroyed(sim *Simulator, ev Event) {
+	sim.endProgress()
+	switch {
+	case (len(ev.Aliens) == 0):
+		sim.logf(LOG_INFO, EVENT_DESTROYED, "City '%s' has been destroyed!\n", ev.City)
+	case (ev.Spawned):
+		sim.logf(LOG_INFO, EVENT_DESTROYED, "City '%s' has been destroyed by spawning %s on top of %s!\n", ev.City, alienList(ev.Aliens[:1]), alienList(ev.Aliens[1:]))
+	default:
+		sim.logf(LOG_INFO, EVENT_DESTROYED, "City '%s' has been destroyed by %s!\n", ev.City, alienList(ev.Aliens))
+	}
+}
+
+func (consoleObserver) OnRoadDestroyed(sim *Simulator, ev Event) {
+	sim.endProgress()
+	sim.logf(LOG_INFO, EVENT_ROAD_DESTROYED, "The %s road from '%s' to '%s' has been destroyed by %s!\n", ev.Dir, ev.From, ev.City, ev.Cause)
+}
+
+func (consoleObserver) OnTrapped(sim *Simulator, ev Event) {
+	sim.endProgress()
+	sim.logf(LOG_INFO, EVENT_TRAPPED, "Alien #%d is trapped in city '%s': no road leads to a city that is still standing!\n", ev.Aliens[0], ev.City)
+}
@@ -0,0 +1,143 @@
+/*
+   Alien Invasion Simulator - output files
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"strings"
+	"path/filepath"
+	"encoding/json"
+	"math/rand/v2"
+)
+
+// The file name that stands for the standard input (for maps) or the standard output.
+const STDIO string = "-"
+
+// The base name of the output files of a map read from the standard input.
+const STDIN_BASENAME string = "stdin"
+
+// Where data written to STDIO goes. It is the standard output, which is then taken away from the
+//   log messages (see logToStderr).
+var stdout io.Writer = os.Stdout
+
+// Sends the log messages (everything printed to os.Stdout) to the standard error instead, so that
+//   the standard output only carries the data written to STDIO (e.g. a map for a pipeline).
+func logToStderr() {
+	os.Stdout = os.Stderr
+}
+
+// Returns true if a command line writes data to the standard output: "-gen -" or "-out -".
+func writesToStdout(args []string) bool {
+	if (len(args) >= 2) && (args[0] == "-gen") && (args[1] == STDIO) {
+		return true
+	}
+	for i := 0; i + 1 < len(args); i++ {
+		if ((args[i] == "-out") || (args[i] == "--out")) && (args[i + 1] == STDIO) {
+			return true
+		}
+	}
+	return false
+}
+
+// The name of the output file of a simulation of "mapfile" with suffix "suffix" (e.g. ".result").
+// The outputs of a map read from the standard input are named after STDIN_BASENAME, and those of
+//   a compressed map file after its name without GZIP_SUFFIX.
+func outputFile(mapfile string, suffix string) string {
+	if (mapfile == STDIO) {
+		return STDIN_BASENAME + suffix
+	}
+	return strings.TrimSuffix(mapfile, GZIP_SUFFIX) + suffix
+}
+
+// Writes an output file atomically: the contents are written to a temporary file in the same
+//   directory, which is then renamed over the destination only if everything was written
+//   successfully. An interrupted run thus leaves either the previous file or the new one, but
+//   never a truncated file. A file that is replaced keeps its permissions; a new file gets the
+//   permissions that os.Create would give it.
+// If "overwrite" is false and the destination already exists, nothing is written.
+// If the file name is STDIO, the contents are written to the standard output instead. If it ends
+//   in GZIP_SUFFIX, the contents are compressed.
+func writeFileAtomic(filename string, overwrite bool, write func(w io.Writer) error) error {
+	if (filename == STDIO) {
+		if err := write(stdout); err != nil {
+			return fmt.Errorf("Cannot write to the standard output: %v", err)
+		}
+		return nil
+	}
+
+	if (! overwrite) {
+		if err := checkNoOverwrite(filename); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := createTemp(filename)
+	if (err != nil) {
+		return fmt.Errorf("Cannot write to output file '%s': %v", filename, err)
+	}
+	tmpName := tmp.Name()
+
+	w, flush := compress(filename, tmp)
+	err = write(w)
+	if (err == nil) {
+		err = flush()
+	}
+	if (err == nil) {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); (err == nil) {
+		err = cerr
+	}
+	if info, serr := os.Stat(filename); (err == nil) && (serr == nil) {
+		err = os.Chmod(tmpName, info.Mode().Perm())
+	}
+	if (err == nil) {
+		err = os.Rename(tmpName, filename)
+	}
+	if (err != nil) {
+		os.Remove(tmpName)
+		return fmt.Errorf("Cannot write to output file '%s': %v", filename, err)
+	}
+	return nil
+}
+
+// Creates the temporary file of writeFileAtomic in the directory of "filename". Unlike
+//   os.CreateTemp, which always uses mode 0600, it is created with mode 0666 masked by the umask,
+//   as os.Create would do.
+func createTemp(filename string) (*os.File, error) {
+	for i := 0; ; i++ {
+		name := filepath.Join(filepath.Dir(filename), fmt.Sprintf(".%s.tmp%d", filepath.Base(filename), rand.Uint32()))
+		f, err := os.OpenFile(name, os.O_RDWR | os.O_CREATE | os.O_EXCL, 0666)
+		if (os.IsExist(err)) && (i < 100) {
+			continue
+		}
+		return f, err
+	}
+}
+
+// Returns an error if the file exists, so that existing results are not replaced by accident.
+func checkNoOverwrite(filename string) error {
+	if (filename == STDIO) {
+		return nil
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return fmt.Errorf("Output file '%s' already exists (use -overwrite to replace it)", filename)
+	}
+	return nil
+}
+
+// Writes a value as indented JSON to an output file, atomically.
+func saveJSON(filename string, overwrite bool, v interface{}) error {
+	return writeFileAtomic(filename, overwrite, func(w io.Writer) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if (err != nil) {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	})
+}
@@ -0,0 +1,168 @@
+/*
+   Alien Invasion Simulator - parallel movement
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"runtime"
+	"math/rand"
+	randv2 "math/rand/v2"
+)
+
+// Parallel movement (MOVEMENT_PARALLEL) is simultaneous movement (see stepSimultaneous) with the
+//   first phase, in which every alien picks its move, spread over several goroutines. That phase
+//   is most of the work of a step with many aliens, and since the aliens pick their moves from the
+//   state of the map at the start of the step, the picks don't depend on each other:
+//
+//   - The map is cut into regions of nearby cities (see partitionCities), and the workers take
+//     the regions one at a time and pick the moves of the aliens in them.
+//   - Each alien draws its random numbers from its own stream, seeded from the seed of the
+//     simulation, the movement step and the alien number (see alienStream), instead of from the
+//     random number generator of the simulation, which would make the picks depend on the order
+//     in which the workers get to them.
+//   - The picks are stored by alien number, and merged in alien order, so that the aliens repelled
+//     by the cities, the mid-road fights and the arrivals (including the ones from other regions)
+//     are resolved in the same order as in a simultaneous step with the same picks.
+//
+// So the results of a seed don't depend on the number of workers (but they are not the same as
+//   with simultaneous movement). The fights are still resolved by the simulation goroutine, with
+//   the random number generator of the simulation. The movement strategy must be a
+//   ParallelStrategy.
+
+// Regions of the map per worker, so that a worker that gets the regions with few aliens picks up
+//   more of them.
+const PARALLEL_REGIONS_PER_WORKER int = 8
+
+// The move picked by an alien in a parallel movement step.
+type parallelPick struct {
+	dir       int     // -1 if the alien stays where it is
+	to        int
+	repelled  bool    // The city "to" repels the alien
+}
+
+// The state of the parallel movement of a simulation, kept between steps.
+type parallelMover struct {
+	workers  int
+	region   []int32     // Region of each city
+	aliens   [][]int     // The live aliens in each region, in alien order (rebuilt in every step)
+	picks    []parallelPick  // The picks of the live aliens, by alien number
+}
+
+func newParallelMover(sim *Simulator) *parallelMover {
+	workers := sim.opts.Workers
+	if (workers <= 0) {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	regions := workers * PARALLEL_REGIONS_PER_WORKER
+	return &parallelMover{
+		workers: workers,
+		region: partitionCities(sim.nodes, regions),
+		aliens: make([][]int, regions),
+		picks: make([]parallelPick, len(sim.aliens)),
+	}
+}
+
+// Cuts a map into "regions" regions of about the same number of cities, by the breadth-first order
+//   of the cities (starting from the first city of each connected component). Connected
+//   components smaller than a region stay together, and larger ones are cut into bands of cities
+//   at about the same road distance from their first city (e.g. diagonal bands of a grid).
+func partitionCities(nodes SNodeArray, regions int) []int32 {
+	region := make([]int32, len(nodes))
+	seen := make([]bool, len(nodes))
+	rank := 0
+	for i := 0; i < len(nodes); i++ {
+		if (! seen[i]) {
+			bfs(nodes, i, seen, func(city int, dist int) {
+				region[city] = int32(rank * regions / len(nodes))
+				rank ++
+			})
+		}
+	}
+	return region
+}
+
+// The seed of the random number stream of alien "id" in movement step "iteration" (see
+//   parallel movement): the two halves of the state of a PCG generator.
+func alienStream(seed int64, iteration int, id int) (uint64, uint64) {
+	return uint64(seed), mix64(uint64(iteration) << 32 ^ uint64(id))
+}
+
+// The SplitMix64 finalizer, which turns consecutive numbers into unrelated ones.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Runs a simultaneous movement step with the moves picked in parallel (see parallel movement).
+// Returns the number of fights (including the ones on roads) that happened during the step.
+func (sim *Simulator) stepParallel() (int, error) {
+	strategy, ok := sim.strategy.(ParallelStrategy)
+	if (! ok) {
+		return 0, fmt.Errorf("The movement strategy can't pick moves in parallel (only the built-in strategies can)")
+	}
+	if (sim.parallel == nil) {
+		sim.parallel = newParallelMover(sim)
+	}
+	p := sim.parallel
+	aliens := sim.aliens
+	iteration := sim.iteration + 1
+
+	// Sort the live aliens into the regions of their cities
+	for r := range p.aliens {
+		p.aliens[r] = p.aliens[r][:0]
+	}
+	for i := 0; i < len(aliens); i++ {
+		if (aliens[i].city != -1) {
+			r := p.region[aliens[i].city]
+			p.aliens[r] = append(p.aliens[r], i)
+		}
+	}
+
+	// 1. Pick the moves, one region at a time in each worker
+	next := make(chan int, len(p.aliens))
+	for r := range p.aliens {
+		next <- r
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	for w := 0; w < p.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := &pcgSource{ pcg: randv2.NewPCG(0, 0) }
+			rnd := rand.New(src)
+			for r := range next {
+				for _, i := range p.aliens[r] {
+					src.pcg.Seed(alienStream(sim.seed, iteration, i))
+					dir, dest := strategy.ChooseWith(sim, rnd, i, aliens[i].city)
+					p.picks[i] = parallelPick{ dir, dest, (dir != -1) && (sim.repelled(rnd)) }
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Merge the picks in alien order
+	var moves []alienMove
+	for i := 0; i < len(aliens); i++ {
+		pick := p.picks[i]
+		if (aliens[i].city == -1) || (pick.dir == -1) {
+			continue
+		}
+		if (pick.repelled) {
+			sim.killRepelled(i, pick.to)
+			continue
+		}
+		moves = append(moves, alienMove{ i, aliens[i].city, pick.dir, pick.to })
+	}
+
+	return sim.resolveMoves(moves)
+}
@@ -0,0 +1,91 @@
+/*
+   Alien Invasion Simulator - progress reports, time limits and interruptions
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"os/signal"
+)
+
+// Long runs on large maps can take minutes between the first and the last line of the log, and
+//   used to lose everything when they were stopped. So the simulation mode can:
+//
+//   - Log a progress line every -progress interval of wall time, with the iteration, the aliens
+//     alive and the cities standing, instead of the progress dots.
+//   - Stop after a -time-limit of wall time, as if the step limit had been reached.
+//   - Stop at the end of the current movement step on the first SIGINT (Ctrl-C), and then write
+//     the summary, the result map, the stream and the other output files of the simulation so
+//     far, as at the end of a run, before exiting. A second SIGINT kills the simulator at once.
+//
+// A simulation stopped by the time limit or an interruption has "time-limit" or "interrupted" as
+//   its stop reason. The limit and interruptions are checked between movement steps only.
+
+// Stop reasons of the simulations stopped by the time limit or by SIGINT.
+const STOP_TIME_LIMIT  string = "time-limit"
+const STOP_INTERRUPTED string = "interrupted"
+
+// Follows the wall time and the interruptions of a simulation run.
+type RunWatch struct {
+	start        time.Time
+	lastReport   time.Time
+	signals      chan os.Signal
+	interrupted  bool
+}
+
+// Starts following a simulation: reports its progress every "opts.ProgressEvery", stops it after
+//   "opts.TimeLimit", and stops it on SIGINT unless it is interactive. stop() must be called
+//   when the run ends.
+func watchRun(sim *Simulator, opts SimOptions) *RunWatch {
+	rw := &RunWatch{ start: time.Now() }
+	rw.lastReport = rw.start
+	if (! opts.Interactive) {
+		rw.signals = make(chan os.Signal, 1)
+		signal.Notify(rw.signals, os.Interrupt)
+	}
+	if (opts.ProgressEvery > 0) {
+		sim.progress = false
+	}
+
+	sim.AddAfterIteration(func(s *Simulator, iteration int) {
+		now := time.Now()
+		if (opts.ProgressEvery > 0) && (now.Sub(rw.lastReport) >= opts.ProgressEvery) {
+			rw.lastReport = now
+			s.logf(LOG_INFO, LOG_KIND_PROGRESS, "%s\n", s.progressLine(now.Sub(rw.start)))
+		}
+		if (opts.TimeLimit > 0) && (now.Sub(rw.start) >= opts.TimeLimit) && (! s.Stopped()) {
+			s.endProgress()
+			s.logf(LOG_INFO, LOG_KIND_STOP, "Reached the time limit of %v at iteration %d. Stopping the simulator.\n", opts.TimeLimit, s.iteration)
+			s.Stop(STOP_TIME_LIMIT)
+		}
+		select {
+		case <-rw.signals:
+			// Let a second SIGINT kill the simulator, if writing the outputs takes too long
+			signal.Stop(rw.signals)
+			rw.interrupted = true
+			s.endProgress()
+			s.logf(LOG_INFO, LOG_KIND_STOP, "Interrupted at iteration %d. Stopping the simulator and writing the results so far.\n", s.iteration)
+			s.Stop(STOP_INTERRUPTED)
+		default:
+		}
+	})
+	return rw
+}
+
+// Stops listening for SIGINT.
+func (rw *RunWatch) stop() {
+	if (rw.signals != nil) {
+		signal.Stop(rw.signals)
+	}
+}
+
+// A progress report of a simulation, e.g.:
+//   Iteration 1200 of 10000 (12%): 8531 aliens alive, 96112 of 100000 cities standing, 1m30s elapsed.
+func (sim *Simulator) progressLine(elapsed time.Duration) string {
+	return fmt.Sprintf("Iteration %d of %d (%d%%): %d aliens alive, %d of %d cities standing, %v elapsed.",
+		sim.iteration, sim.opts.MaxSteps, 100 * sim.iteration / max(sim.opts.MaxSteps, 1), sim.liveAlienCounter,
+		len(sim.nodes) - sim.deadCityCounter, len(sim.nodes), elapsed.Round(time.Second))
+}
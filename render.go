@@ -0,0 +1,445 @@
+/*
+   Alien Invasion Simulator - map rendering
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bufio"
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// Geometry of the rendered map, in pixels.
+const RENDER_CELL    int = 24    // distance between two adjacent grid positions
+const RENDER_MARGIN  int = 20    // blank space around the map
+const RENDER_RADIUS  int = 4     // radius of a city
+const RENDER_LEGEND  int = 16    // height of a line of the legend
+
+// The recorded path of an alien, as city indices.
+type AlienPath struct {
+	alien   int
+	cities  []int
+}
+
+// Reads a paths file written by the simulator's -paths option. Only the paths of the aliens in
+//   "selected" are returned, or all of them if "selected" is empty. City names are resolved
+//   through "nodeMap"; paths through cities that are not in the map are skipped with a warning.
+func loadPaths(pathsfile string, nodeMap SNodeMap, selected map[int]bool) ([]AlienPath, error) {
+	file, err := os.Open(pathsfile)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot read from paths file '%s'", pathsfile)
+	}
+	defer file.Close()
+
+	var paths []AlienPath
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64 * 1024 * 1024)
+	for scanner.Scan() {
+		items := strings.Fields(scanner.Text())
+		if (len(items) == 0) {
+			continue
+		}
+
+		alien, err := strconv.Atoi(items[0])
+		if (err != nil) {
+			return nil, fmt.Errorf("Syntax error in paths file '%s': bad alien number '%s'", pathsfile, items[0])
+		}
+		if (len(selected) > 0) && (! selected[alien]) {
+			continue
+		}
+
+		path := AlienPath{ alien: alien }
+		for _, cityName := range items[1:] {
+			idx, ok := nodeMap[cityName]
+			if (! ok) {
+				fmt.Printf("WARNING: The path of Alien #%d goes through city '%s', which is not in the map; skipping it.\n", alien, cityName)
+				path.cities = nil
+				break
+			}
+			path.cities = append(path.cities, idx)
+		}
+		if (len(path.cities) > 0) {
+			paths = append(paths, path)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error encountered while reading paths file '%s': %v", pathsfile, err)
+	}
+	return paths, nil
+}
+
+// The drawing color of the k-th alien path. Hues are spread by the golden angle so that any
+//   number of paths get distinct colors.
+func pathColor(k int) string {
+	return fmt.Sprintf("hsl(%d, 85%%, 42%%)", (k * 137) % 360)
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Simulation results
+// ---------------------------------------------------------------------------------------------------
+
+// The outcome of a simulation, drawn over its map.
+type RenderState struct {
+	dead     []bool              // Cities destroyed (the ones missing from the result map)
+	lost     map[[2]int]bool     // Roads destroyed between cities that survived, by city and direction
+	aliens   [][]int             // Aliens left in each city, if their positions are known
+}
+
+// The name of the map of a result map file ("<MAPFILE>.result" or "<MAPFILE>.result.gz"), or "" if
+//   "resultfile" is not named like a result map or its map file doesn't exist.
+func resultSource(resultfile string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(resultfile, GZIP_SUFFIX), ".result")
+	if (base == strings.TrimSuffix(resultfile, GZIP_SUFFIX)) {
+		return ""
+	}
+	for _, mapfile := range []string{ base, base + GZIP_SUFFIX } {
+		if _, err := os.Stat(mapfile); err == nil {
+			return mapfile
+		}
+	}
+	return ""
+}
+
+// Compares a map with the result map of a simulation on it, to tell the cities and roads that
+//   were destroyed.
+func loadRenderState(nodes SNodeArray, resultfile string) (*RenderState, error) {
+	result, resultMap, err := loadMap(resultfile)
+	if (err != nil) {
+		return nil, err
+	}
+	state := &RenderState{ dead: make([]bool, len(nodes)), lost: make(map[[2]int]bool) }
+	for i := 0; i < len(nodes); i++ {
+		_, ok := resultMap[nodes[i].cityName]
+		state.dead[i] = ! ok
+	}
+	for i := 0; i < len(nodes); i++ {
+		if (state.dead[i]) {
+			continue
+		}
+		survivor := &result[resultMap[nodes[i].cityName]]
+		for _, road := range nodes[i].roads {
+			if (state.dead[road.to]) {
+				continue
+			}
+			if to := survivor.road(road.dir); (to == -1) || (result[to].cityName != nodes[road.to].cityName) {
+				state.lost[[2]int{ i, road.dir }] = true
+			}
+		}
+	}
+	return state, nil
+}
+
+// Reads the positions of the aliens left at the end of a simulation, as written by the
+//   simulator's -positions option, into the render state.
+func (state *RenderState) loadPositions(positionsfile string, nodeMap SNodeMap) error {
+	file, err := os.Open(positionsfile)
+	if (err != nil) {
+		return fmt.Errorf("Cannot read from positions file '%s'", positionsfile)
+	}
+	defer file.Close()
+
+	state.aliens = make([][]int, len(state.dead))
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		items := strings.Fields(scanner.Text())
+		if (len(items) == 0) {
+			continue
+		}
+		alien, err := strconv.Atoi(items[0])
+		if (err != nil) || (len(items) != 2) {
+			return fmt.Errorf("Syntax error in positions file '%s' at line %d", positionsfile, line)
+		}
+		idx, ok := nodeMap[items[1]]
+		if (! ok) {
+			return fmt.Errorf("Alien #%d is in city '%s', which is not in the map (positions file '%s')", alien, items[1], positionsfile)
+		}
+		state.aliens[idx] = append(state.aliens[idx], alien)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error encountered while reading positions file '%s': %v", positionsfile, err)
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Drawing
+// ---------------------------------------------------------------------------------------------------
+
+// Colors of the drawing.
+const RENDER_ROAD_COLOR   string = "#9e9e9e"    // road between live cities
+const RENDER_RUIN_COLOR   string = "#e0e0e0"    // road to a destroyed city
+const RENDER_LOST_COLOR   string = "#ef9a9a"    // road destroyed between live cities
+const RENDER_CITY_COLOR   string = "#2e7d32"    // live city
+const RENDER_DEAD_COLOR   string = "#c62828"    // destroyed city
+const RENDER_ALIEN_COLOR  string = "#6a1b9a"    // aliens left in a city
+
+// Draws a map, with the outcome of a simulation ("state", if not nil) and the given alien paths
+//   overlaid on top of it, and a legend below it.
+func drawMap(c Canvas, nodes SNodeArray, layout *MapLayout, state *RenderState, paths []AlienPath) {
+	pos := func(idx int) (int, int) {
+		return layout.xs[idx], layout.ys[idx]
+	}
+	dead := func(idx int) bool {
+		return (state != nil) && (state.dead[idx])
+	}
+
+	// Roads. Each road is drawn once, from the end with the lower direction (e.g. EAST or SOUTH).
+	//   On a grid, roads between cities that are not next to each other in the direction of the
+	//   road (e.g. the roads that wrap around a torus) are drawn as dashed stubs leaving both
+	//   cities in that direction, instead of a line across the map, and roads in directions that
+	//   have no place in a grid (e.g. up/down) are drawn as dashed lines.
+	for i := 0; i < len(nodes); i++ {
+		for _, road := range nodes[i].roads {
+			d, other := road.dir, road.to
+			if od := opposite(d); (od < d) || ((od == d) && (other < i)) {
+				continue
+			}
+			color, lost := RENDER_ROAD_COLOR, false
+			if (dead(i)) || (dead(other)) {
+				color = RENDER_RUIN_COLOR
+			} else if (state != nil) && (state.lost[[2]int{ i, d }]) {
+				color, lost = RENDER_LOST_COLOR, true
+			}
+			x1, y1 := pos(i)
+			x2, y2 := pos(other)
+			dx, dy, ok := directionDelta(d)
+			dx, dy = dx * RENDER_CELL / 2, dy * RENDER_CELL / 2
+			if (! layout.grid) {
+				c.line(x1, y1, x2, y2, color, 2, lost)
+			} else if (! ok) {
+				c.line(x1, y1, x2, y2, color, 2, true)
+			} else if (x2 - x1 == 2 * dx) && (y2 - y1 == 2 * dy) {
+				c.line(x1, y1, x2, y2, color, 2, lost)
+			} else {
+				c.line(x1, y1, x1 + dx, y1 + dy, color, 2, true)
+				c.line(x2, y2, x2 - dx, y2 - dy, color, 2, true)
+			}
+		}
+	}
+
+	// Cities, with a cross for the destroyed ones
+	for i := 0; i < len(nodes); i++ {
+		x, y := pos(i)
+		if (dead(i)) {
+			r := RENDER_RADIUS
+			c.group(nodes[i].cityName + " (destroyed)")
+			c.line(x - r, y - r, x + r, y + r, RENDER_DEAD_COLOR, 2, false)
+			c.line(x - r, y + r, x + r, y - r, RENDER_DEAD_COLOR, 2, false)
+			c.endGroup()
+			continue
+		}
+		c.circle(x, y, RENDER_RADIUS, RENDER_CITY_COLOR, true, nodes[i].cityName)
+	}
+
+	// The aliens left, as a dot next to their city
+	left := 0
+	if (state != nil) && (state.aliens != nil) {
+		for i := 0; i < len(nodes); i++ {
+			if (len(state.aliens[i]) == 0) {
+				continue
+			}
+			left += len(state.aliens[i])
+			x, y := pos(i)
+			c.circle(x + RENDER_RADIUS + 1, y - RENDER_RADIUS - 1, 3, RENDER_ALIEN_COLOR, true, alienList(state.aliens[i]) + " in " + nodes[i].cityName)
+		}
+	}
+
+	// Alien paths. Each path is shifted by a few pixels so that aliens walking the same roads can
+	//   still be told apart. The start of the path is a hollow circle and the end a filled one.
+	for k, path := range paths {
+		color := pathColor(k)
+		shift := (k % 5) * 2 - 4
+
+		xs := make([]int, len(path.cities))
+		ys := make([]int, len(path.cities))
+		for n, city := range path.cities {
+			x, y := pos(city)
+			xs[n], ys[n] = x + shift, y + shift
+		}
+
+		c.group(fmt.Sprintf("Alien #%d", path.alien))
+		c.polyline(xs, ys, color, 2)
+		c.circle(xs[0], ys[0], 6, color, false, "")
+		c.circle(xs[len(xs) - 1], ys[len(ys) - 1], 4, color, true, "")
+		c.endGroup()
+	}
+
+	// Legend
+	ly := layout.height
+	if (state != nil) {
+		destroyed := 0
+		for _, d := range state.dead {
+			if (d) {
+				destroyed ++
+			}
+		}
+		line := fmt.Sprintf("Cities destroyed: %d of %d. Roads lost between live cities: %d.", destroyed, len(nodes), len(state.lost))
+		if (state.aliens != nil) {
+			line += fmt.Sprintf(" Aliens left: %d.", left)
+		}
+		c.text(RENDER_MARGIN, ly, line)
+		ly += RENDER_LEGEND
+	}
+	for k, path := range paths {
+		c.rect(RENDER_MARGIN, ly - 6, 20, 4, pathColor(k))
+		c.text(RENDER_MARGIN + 28, ly, fmt.Sprintf("Alien #%d (%d steps)", path.alien, len(path.cities) - 1))
+		ly += RENDER_LEGEND
+	}
+}
+
+// The height of the legend drawn below a map.
+func legendHeight(state *RenderState, paths []AlienPath) int {
+	lines := len(paths)
+	if (state != nil) {
+		lines ++
+	}
+	return lines * RENDER_LEGEND
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Render mode
+// ---------------------------------------------------------------------------------------------------
+
+// Options of the render mode.
+type RenderOptions struct {
+	MapFile        string          // The map of the result being rendered, if not found from its name
+	PathsFile      string          // Overlay the alien paths recorded in this file
+	PositionsFile  string          // Draw the aliens left at the positions in this file
+	Aliens         map[int]bool    // Only draw the paths of these aliens (all of them if empty)
+	Layout         string          // One of the LAYOUT_* modes
+	Overwrite      bool
+}
+
+// Renders a map, or the result map of a simulation over its map, to an SVG or PNG file.
+// When a result map is given, the destroyed cities and roads are drawn, and so are the aliens
+//   left at the end if their positions are known (from -positions, or "<MAPFILE>.positions").
+func render(infile string, outfile string, opts RenderOptions) error {
+	fmt.Printf("Will read mapfile '%s' and render it to '%s'.\n", infile, outfile)
+
+	ext := strings.ToLower(outfile)
+	if (! strings.HasSuffix(ext, ".svg")) && (! strings.HasSuffix(ext, ".png")) {
+		return fmt.Errorf("Unsupported output format for '%s' (only .svg and .png are supported)", outfile)
+	}
+
+	mapfile, resultfile := infile, ""
+	if (opts.MapFile != "") {
+		mapfile, resultfile = opts.MapFile, infile
+	} else if source := resultSource(infile); (source != "") {
+		mapfile, resultfile = source, infile
+	}
+
+	nodes, nodeMap, err := loadMap(mapfile)
+	if (err != nil) {
+		return err
+	}
+
+	var state *RenderState
+	if (resultfile != "") {
+		fmt.Printf("Drawing the result '%s' over the map '%s'.\n", resultfile, mapfile)
+		state, err = loadRenderState(nodes, resultfile)
+		if (err != nil) {
+			return err
+		}
+		positionsfile := opts.PositionsFile
+		if (positionsfile == "") {
+			if _, err := os.Stat(outputFile(mapfile, ".positions")); err == nil {
+				positionsfile = outputFile(mapfile, ".positions")
+			}
+		}
+		if (positionsfile != "") {
+			fmt.Printf("Drawing the aliens left at the positions in '%s'.\n", positionsfile)
+			if err := state.loadPositions(positionsfile, nodeMap); err != nil {
+				return err
+			}
+		}
+	} else if (opts.PositionsFile != "") {
+		return fmt.Errorf("Alien positions can only be drawn over the result map of a simulation")
+	}
+
+	layout, err := mapLayoutOf(nodes, opts.Layout)
+	if (err != nil) {
+		return err
+	}
+	if (! layout.grid) {
+		fmt.Printf("Using a force-directed layout.\n")
+	}
+
+	var paths []AlienPath
+	if (opts.PathsFile != "") {
+		paths, err = loadPaths(opts.PathsFile, nodeMap, opts.Aliens)
+		if (err != nil) {
+			return err
+		}
+		fmt.Printf("Overlaying the paths of %d aliens.\n", len(paths))
+	}
+
+	err = writeFileAtomic(outfile, opts.Overwrite, func(w io.Writer) error {
+		width, height := layout.width, layout.height + legendHeight(state, paths)
+		var c Canvas
+		if (strings.HasSuffix(ext, ".png")) {
+			c = newPNGCanvas(w, width, height)
+		} else {
+			c = newSVGCanvas(w, width, height)
+		}
+		drawMap(c, nodes, layout, state, paths)
+		return c.close()
+	})
+	if (err != nil) {
+		return err
+	}
+
+	fmt.Println("Done.");
+	return nil
+}
+
+// Handles the command line of the render mode:
+//   -render <MAPFILE> <OUTFILE> [-map <MAPFILE>] [-paths <PATHSFILE>] [-positions <POSITIONSFILE>]
+//     [-aliens <ID,ID,...>] [-layout <MODE>] [-overwrite]
+func mainRender(args []string) int {
+	var alienList string
+	opts := RenderOptions{ Layout: LAYOUT_AUTO, Aliens: make(map[int]bool) }
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&opts.MapFile, "map", "", "the map of the result map being rendered")
+	flags.StringVar(&opts.PathsFile, "paths", "", "overlay the alien paths recorded in this file")
+	flags.StringVar(&opts.PositionsFile, "positions", "", "draw the aliens left at the positions in this file")
+	flags.StringVar(&alienList, "aliens", "", "comma-separated list of aliens whose paths are drawn")
+	flags.StringVar(&opts.Layout, "layout", opts.Layout, "auto, grid or force")
+	flags.BoolVar(&opts.Overwrite, "overwrite", false, "replace an existing output file")
+
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for render mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for render mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	if (opts.Layout != LAYOUT_AUTO) && (opts.Layout != LAYOUT_GRID) && (opts.Layout != LAYOUT_FORCE) {
+		fmt.Printf("Render: Unknown layout '%s'.\n", opts.Layout);
+		return usageError()
+	}
+
+	if (alienList != "") {
+		for _, item := range strings.Split(alienList, ",") {
+			id, err := strconv.Atoi(strings.TrimSpace(item))
+			if (err != nil) {
+				fmt.Printf("Render: Error parsing alien number '%s'.\n", item);
+				return usageError()
+			}
+			opts.Aliens[id] = true
+		}
+	}
+
+	return reportError(render(args[0], args[1], opts))
+}
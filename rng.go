@@ -0,0 +1,48 @@
+/*
+   Alien Invasion Simulator - random number generation
+*/
+
+package main
+
+import (
+	"math/rand"
+	randv2 "math/rand/v2"
+)
+
+// The random number source of a simulation. It is a PCG generator, whose state (unlike that of the
+//   default math/rand source) can be saved in a checkpoint and restored later, so that a resumed
+//   simulation makes exactly the same random choices it would have made without the interruption.
+type pcgSource struct {
+	pcg  *randv2.PCG
+}
+
+func newPCGSource(seed int64) *pcgSource {
+	return &pcgSource{ pcg: randv2.NewPCG(uint64(seed), 0) }
+}
+
+func (s *pcgSource) Int63() int64 {
+	return int64(s.pcg.Uint64() >> 1)
+}
+
+func (s *pcgSource) Uint64() uint64 {
+	return s.pcg.Uint64()
+}
+
+func (s *pcgSource) Seed(seed int64) {
+	s.pcg.Seed(uint64(seed), 0)
+}
+
+// The generator state, for checkpoints.
+func (s *pcgSource) state() ([]byte, error) {
+	return s.pcg.MarshalBinary()
+}
+
+// Restores a generator state saved by state().
+func (s *pcgSource) restore(data []byte) error {
+	return s.pcg.UnmarshalBinary(data)
+}
+
+// Creates a random number generator over a PCG source.
+func newRand(src *pcgSource) *rand.Rand {
+	return rand.New(src)
+}
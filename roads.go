@@ -0,0 +1,103 @@
+/*
+   Alien Invasion Simulator - road destruction
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Roads are destroyed on their own (see destroyRoad) when aliens clash on them (simultaneous
+//   movement), by decay (SimOptions.RoadDecay) or as collateral damage of the destruction of a
+//   nearby city (SimOptions.Collateral). A destroyed road is removed from both of its cities, so
+//   the result map only has the roads that survived. The roads of a destroyed city are lost with
+//   it, but are kept in the model (the aliens can't take them anyway), so that strategies can still
+//   see the ruins next to a city.
+
+// Calls "f" once for every road between two cities, from the city and direction where
+//   the road is stored first: the lower direction of the two (e.g. east or south), or the lower
+//   city for directions that are their own opposite.
+func forEachRoad(nodes SNodeArray, f func(from int, road SRoad)) {
+	for i := 0; i < len(nodes); i++ {
+		for _, road := range nodes[i].roads {
+			od := opposite(road.dir)
+			if (road.dir < od) || ((road.dir == od) && (i < road.to)) {
+				f(i, road)
+			}
+		}
+	}
+}
+
+// Counts the roads of a map. If "alive" is set, only the roads between cities that have not been
+//   destroyed are counted.
+func countRoads(nodes SNodeArray, alive bool) int {
+	n := 0
+	forEachRoad(nodes, func(from int, road SRoad) {
+		if (! alive) || ((! nodes[from].dead) && (! nodes[road.to].dead)) {
+			n ++
+		}
+	})
+	return n
+}
+
+// The number of roads between cities that have not been destroyed.
+func (sim *Simulator) RoadsSurviving() int {
+	return countRoads(sim.nodes, true)
+}
+
+// Destroys the road in direction "dir" of city "from", and reports it as destroyed by "cause"
+//   (e.g. "decay"), unless "cause" is "" (e.g. clashes, which have their own event).
+func (sim *Simulator) destroyRoad(from int, dir int, iteration int, cause string) {
+	to := sim.nodes[from].road(dir)
+	if (to == -1) {
+		return
+	}
+	sim.SetRoad(from, dir, -1)
+	sim.roadsDestroyed ++
+	if (cause == "") {
+		return
+	}
+	sim.emit(Event{ Iteration: iteration, Type: EVENT_ROAD_DESTROYED, City: sim.nodes[to].cityName, From: sim.nodes[from].cityName, Dir: directionName(dir), Cause: cause })
+}
+
+// Destroys every road between two cities that have not been destroyed with probability
+//   SimOptions.RoadDecay, after movement step "iteration".
+func (sim *Simulator) decayRoads(iteration int) {
+	var doomed []SRoad
+	var froms []int
+	forEachRoad(sim.nodes, func(from int, road SRoad) {
+		if (sim.nodes[from].dead) || (sim.nodes[road.to].dead) {
+			return
+		}
+		if (sim.rnd.Float64() < sim.opts.RoadDecay) {
+			doomed = append(doomed, road)
+			froms = append(froms, from)
+		}
+	})
+	for k, road := range doomed {
+		sim.destroyRoad(froms[k], road.dir, iteration, "decay")
+	}
+}
+
+// Destroys, with probability SimOptions.Collateral, every road of the neighbors of the destroyed
+//   city "idx" that leads to another city that has not been destroyed.
+// No random numbers are used if the probability is 0.
+func (sim *Simulator) collateral(idx int, iteration int) {
+	if (sim.opts.Collateral <= 0) {
+		return
+	}
+	for _, near := range sim.nodes[idx].roads {
+		if (sim.nodes[near.to].dead) {
+			continue
+		}
+		for _, road := range append([]SRoad{}, sim.nodes[near.to].roads...) {
+			if (sim.nodes[road.to].dead) {
+				continue
+			}
+			if (sim.rnd.Float64() < sim.opts.Collateral) {
+				sim.destroyRoad(near.to, road.dir, iteration, fmt.Sprintf("the destruction of '%s'", sim.nodes[idx].cityName))
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+//go:build starlark
+
+/*
+   Alien Invasion Simulator - Starlark movement scripts
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// A movement script is a Starlark file (https://github.com/bazelbuild/starlark) that defines the
+//   function that chooses the moves of the aliens, e.g.:
+//
+//   def choose(alien, city, roads):
+//       safe = [r for r in roads if r.dead_neighbors == 0]
+//       if safe and random() < 0.5:
+//           return safe[randint(len(safe))].dir
+//       return roads[randint(len(roads))].dir if roads else None
+//
+// It is called for every live alien in every movement step with:
+//
+//   alien  the alien: id, faction, hp and iteration (the movement step being run, from 1)
+//   city   the city the alien is in: name, population, occupants (the number of aliens in it),
+//          dead_neighbors (the number of destroyed cities it has a road to) and last_visit
+//   roads  the roads out of the city to cities that have not been destroyed, in direction
+//          order: dir (the direction label), plus the name, population, occupants,
+//          dead_neighbors and last_visit of the city the road leads to
+//
+// and returns the direction of one of the roads, or None to stay in the city. The script can
+//   draw random numbers from the simulation with random() (a float in [0, 1)) and randint(n) (an
+//   integer in [0, n)), so that runs can be reproduced from their seed. The output of print()
+//   goes to the log of the simulation.
+//
+// This file is only built with the "starlark" build tag, with the pinned versions of its
+//   dependencies (see STARLARK_BUILD in script_stub.go, and the README).
+
+// The function that a movement script must define.
+const SCRIPT_FUNCTION string = "choose"
+
+// The maximum number of Starlark computation steps of a call to the script function, so that a
+//   runaway script (e.g. a loop over range(10000000000)) fails instead of hanging the simulation.
+const SCRIPT_MAX_STEPS uint64 = 1000000
+
+// The movement strategy of a script.
+type ScriptStrategy struct {
+	filename  string
+	choose    starlark.Callable
+}
+
+// The built-in functions of movement scripts, besides the standard Starlark ones.
+var scriptBuiltins = starlark.StringDict{
+	"random": starlark.NewBuiltin("random", scriptRandom),
+	"randint": starlark.NewBuiltin("randint", scriptRandint),
+}
+
+// Loads a movement script. The script runs once here, to define its functions (its global
+//   variables are frozen afterwards, so the function has no state between calls).
+func loadScript(filename string) (Strategy, error) {
+	src, err := os.ReadFile(filename)
+	if (err != nil) {
+		return nil, err
+	}
+	thread := &starlark.Thread{ Name: filename }
+	globals, err := starlark.ExecFile(thread, filename, src, scriptBuiltins)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot load script '%s': %v", filename, err)
+	}
+	choose, ok := globals[SCRIPT_FUNCTION].(starlark.Callable)
+	if (! ok) {
+		return nil, fmt.Errorf("Script '%s' doesn't define a %s(alien, city, roads) function", filename, SCRIPT_FUNCTION)
+	}
+	return &ScriptStrategy{ filename: filename, choose: choose }, nil
+}
+
+// Describes a city to a script, with the fields of the city and roads arguments of its function.
+func scriptCity(sim *Simulator, idx int, fields starlark.StringDict) *starlarkstruct.Struct {
+	fields["name"] = starlark.String(sim.CityName(idx))
+	fields["population"] = starlark.MakeInt(sim.CityPopulation(idx))
+	fields["occupants"] = starlark.MakeInt(len(sim.Occupants(idx)))
+	fields["dead_neighbors"] = starlark.MakeInt(sim.DeadNeighbors(idx))
+	fields["last_visit"] = starlark.MakeInt(sim.CityLastVisit(idx))
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+}
+
+// Calls the script function for alien "id" in city "city". A script that fails, or returns
+//   something other than the direction of one of its roads or None, aborts the simulation.
+func (s *ScriptStrategy) Choose(sim *Simulator, id int, city int) (int, int) {
+	var roads []starlark.Value
+	var live []SRoad
+	for _, road := range sim.Roads(city) {
+		if (sim.CityDead(road.to)) {
+			continue
+		}
+		live = append(live, road)
+		roads = append(roads, scriptCity(sim, road.to, starlark.StringDict{ "dir": starlark.String(directionName(road.dir)) }))
+	}
+	alien := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id": starlark.MakeInt(id),
+		"faction": starlark.MakeInt(sim.Faction(id)),
+		"hp": starlark.MakeInt(sim.AlienHitPoints(id)),
+		"iteration": starlark.MakeInt(sim.Iteration() + 1),
+	})
+	args := starlark.Tuple{ alien, scriptCity(sim, city, starlark.StringDict{}), starlark.NewList(roads) }
+
+	thread := &starlark.Thread{ Name: s.filename, Print: func(_ *starlark.Thread, msg string) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_RUN, "%s\n", msg)
+	}}
+	thread.SetLocal("sim", sim)
+	thread.SetMaxExecutionSteps(SCRIPT_MAX_STEPS)
+
+	result, err := starlark.Call(thread, s.choose, args, nil)
+	if (err != nil) {
+		sim.Fail(fmt.Errorf("Script '%s' failed for Alien #%d in city '%s': %v", s.filename, id, sim.CityName(city), err))
+		return -1, -1
+	}
+	if (result == starlark.None) {
+		return -1, -1
+	}
+	label, ok := starlark.AsString(result)
+	if (! ok) {
+		sim.Fail(fmt.Errorf("Script '%s' returned %s for Alien #%d, which is not a direction or None", s.filename, result.String(), id))
+		return -1, -1
+	}
+	for _, road := range live {
+		if (directionName(road.dir) == label) {
+			return road.dir, road.to
+		}
+	}
+	sim.Fail(fmt.Errorf("Script '%s' chose the %s road for Alien #%d, but city '%s' has no such road to a city that is still standing", s.filename, label, id, sim.CityName(city)))
+	return -1, -1
+}
+
+// random(): a random float in [0, 1) from the simulation.
+func scriptRandom(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	sim, ok := thread.Local("sim").(*Simulator)
+	if (! ok) {
+		return nil, fmt.Errorf("%s: random numbers can only be drawn during the simulation", b.Name())
+	}
+	return starlark.Float(sim.Rand().Float64()), nil
+}
+
+// randint(n): a random integer in [0, n) from the simulation.
+func scriptRandint(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n int
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &n); err != nil {
+		return nil, err
+	}
+	if (n <= 0) {
+		return nil, fmt.Errorf("%s: n must be positive, not %d", b.Name(), n)
+	}
+	sim, ok := thread.Local("sim").(*Simulator)
+	if (! ok) {
+		return nil, fmt.Errorf("%s: random numbers can only be drawn during the simulation", b.Name())
+	}
+	return starlark.MakeInt(sim.Rand().Intn(n)), nil
+}
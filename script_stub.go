@@ -0,0 +1,23 @@
+//go:build !starlark
+
+/*
+   Alien Invasion Simulator - Starlark movement scripts (not built in)
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Movement scripts need the Starlark interpreter (go.starlark.net), which is only built in with
+//   the "starlark" build tag (see script.go), so that the default build needs nothing beyond the
+//   standard library. The versions of go.starlark.net and golang.org/x/sys that it is built and
+//   tested with are pinned in STARLARK_BUILD (and in the README).
+
+// How to build the simulator with scripting support, from the source directory.
+const STARLARK_BUILD string = "go mod init ais && go get go.starlark.net@v0.0.0-20231121155337-90ade8b19d09 golang.org/x/sys@v0.9.0 && go build -tags starlark -o ais ."
+
+func loadScript(filename string) (Strategy, error) {
+	return nil, fmt.Errorf("Cannot load script '%s': this build of the simulator has no scripting support (build it with: %s)", filename, STARLARK_BUILD)
+}
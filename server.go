@@ -0,0 +1,597 @@
+/*
+   Alien Invasion Simulator - HTTP server mode
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"bytes"
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+	"net/http"
+	"encoding/json"
+)
+
+// The largest map file that can be uploaded to the server.
+const SERVER_MAX_MAP_SIZE int64 = 512 * 1024 * 1024
+
+// States of a simulation run by the server.
+const JOB_RUNNING string = "running"
+const JOB_DONE    string = "done"
+const JOB_FAILED  string = "failed"
+
+// The server keeps every uploaded map and every simulation in memory.
+type Server struct {
+	mu      sync.Mutex
+	maps    map[string]*StoredMap
+	jobs    map[string]*SimJob
+	lastMapID  int
+	lastJobID  int
+	metrics    ServerMetrics
+}
+
+// A map file uploaded to the server.
+type StoredMap struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Cities  int     `json:"cities"`
+	data    []byte
+}
+
+// The parameters of a simulation, as posted to /simulations.
+type SimRequest struct {
+	Map                 string    `json:"map"`                    // ID of an uploaded map
+	Aliens              int       `json:"aliens"`
+	Seed                int64     `json:"seed"`
+	MaxSteps            int       `json:"max_steps"`              // 0 means the default (10000)
+	StopWhen            []string  `json:"stop_when"`
+	StopAfterQuiescent  int       `json:"stop_after_quiescent"`
+	SpawnBorder         bool      `json:"spawn_border"`
+	SpawnPolicy         string    `json:"spawn_policy"`           // "uniform" (the default), "clustered", "perimeter", "weighted-degree" or "weighted-population"
+	DefenseRate         float64   `json:"defense_rate"`           // Defense points gained by every city in each step
+	FightThreshold      int       `json:"fight_threshold"`        // Number of aliens in a city that makes them fight, 0 means 2
+	SpareCities         bool      `json:"spare_cities"`           // Fights kill the aliens but leave the city standing
+	CombatModel         string    `json:"combat_model"`           // "annihilate" (the default), "wounds" or "damage"
+	HitPoints           int       `json:"hit_points"`             // Hit points of every alien, 0 means 1
+	CityHitPoints       int       `json:"city_hit_points"`        // Hit points of every city, 0 means 3
+	Movement            string    `json:"movement"`               // "sequential" (the default), "simultaneous" or "parallel"
+	Factions            int       `json:"factions"`               // Number of alien factions, 0 or 1 for a single one
+	FearOfRuins         float64   `json:"fear_of_ruins"`          // Probability that an alien avoids moving next to destroyed cities
+	RoadDecay           float64   `json:"road_decay"`             // Probability that each road is destroyed in each step
+	Collateral          float64   `json:"collateral"`             // Probability that each road next to a destroyed city is destroyed with it
+	Defenders           bool      `json:"defenders"`              // The population of a city may kill the aliens fighting in it
+	Waves               string    `json:"waves"`                  // "<COUNT>x<INTERVAL>" to spawn the aliens in waves, "" for all at once
+	Moves               bool      `json:"moves"`                  // Also record move events
+	StepDelayMs         int       `json:"step_delay_ms"`          // Pause between movement steps, for live viewers
+}
+
+// A simulation run by the server. The simulator runs in its own goroutine; "mu" guards the
+//   simulator state between movement steps so that it can be polled while running.
+type SimJob struct {
+	ID        string
+	Request   SimRequest
+	mu        sync.Mutex
+	sim       *Simulator
+	bcast     *Broadcaster   // Live events for the WebSocket subscribers
+	state     string
+	err       string
+	started   time.Time
+	finished  time.Time
+}
+
+// The status of a simulation, as returned by /simulations/{id}.
+type SimStatus struct {
+	ID               string    `json:"id"`
+	Map              string    `json:"map"`
+	State            string    `json:"state"`
+	Error            string    `json:"error,omitempty"`
+	Iteration        int       `json:"iteration"`
+	MaxSteps         int       `json:"max_steps"`
+	Aliens           int       `json:"aliens"`
+	AliensAlive      int       `json:"aliens_alive"`
+	Cities           int       `json:"cities"`
+	CitiesDestroyed  int       `json:"cities_destroyed"`
+	Started          time.Time `json:"started"`
+	Finished         *time.Time `json:"finished,omitempty"`
+	Summary          *Summary  `json:"summary,omitempty"`
+}
+
+// A city of a result map, as returned by /simulations/{id}/result.
+type ResultCity struct {
+	Name   string             `json:"name"`
+	Roads  map[string]string  `json:"roads"`
+	Pop    int                `json:"pop,omitempty"`
+}
+
+func NewServer() *Server {
+	return &Server{ maps: make(map[string]*StoredMap), jobs: make(map[string]*SimJob) }
+}
+
+// Routes the REST API:
+//   POST /maps                      upload a map file (request body), optional ?name=
+//   GET  /maps                      list the uploaded maps
+//   GET  /maps/{id}                 describe an uploaded map
+//   POST /simulations               start a simulation (SimRequest JSON body)
+//   GET  /simulations               list the simulations
+//   GET  /simulations/{id}          poll the status of a simulation
+//   GET  /simulations/{id}/result   the result map of a finished simulation (?format=map for text)
+//   GET  /simulations/{id}/events   the events recorded so far
+//   GET  /simulations/{id}/stream   WebSocket with the events as they happen (see handleStream)
+//   GET  /metrics                   counters of all the simulations, for Prometheus (see metrics.go)
+func (srv *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /maps", srv.handleUploadMap)
+	mux.HandleFunc("GET /maps", srv.handleListMaps)
+	mux.HandleFunc("GET /maps/{id}", srv.handleGetMap)
+	mux.HandleFunc("POST /simulations", srv.handleStartSimulation)
+	mux.HandleFunc("GET /simulations", srv.handleListSimulations)
+	mux.HandleFunc("GET /simulations/{id}", srv.handleGetSimulation)
+	mux.HandleFunc("GET /simulations/{id}/result", srv.handleGetResult)
+	mux.HandleFunc("GET /simulations/{id}/events", srv.handleGetEvents)
+	mux.HandleFunc("GET /simulations/{id}/stream", srv.handleStream)
+	mux.HandleFunc("GET /metrics", srv.handleMetrics)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{ "error": fmt.Sprintf(format, args...) })
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Maps
+// ---------------------------------------------------------------------------------------------------
+
+func (srv *Server) handleUploadMap(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, SERVER_MAX_MAP_SIZE))
+	if (err != nil) {
+		writeError(w, http.StatusBadRequest, "Cannot read map data: %v", err)
+		return
+	}
+
+	nodes, _, err := readMap(bytes.NewReader(data))
+	if (err != nil) {
+		srv.metrics.mapError()
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	srv.mu.Lock()
+	srv.lastMapID ++
+	m := &StoredMap{ ID: strconv.Itoa(srv.lastMapID), Name: r.URL.Query().Get("name"), Cities: len(nodes), data: data }
+	if (m.Name == "") {
+		m.Name = "map" + m.ID
+	}
+	srv.maps[m.ID] = m
+	srv.mu.Unlock()
+
+	fmt.Printf("Map #%s '%s' uploaded with %d cities.\n", m.ID, m.Name, m.Cities)
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (srv *Server) handleListMaps(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	list := make([]*StoredMap, 0, len(srv.maps))
+	for i := 1; i <= srv.lastMapID; i++ {
+		if m, ok := srv.maps[strconv.Itoa(i)]; ok {
+			list = append(list, m)
+		}
+	}
+	srv.mu.Unlock()
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *Server) handleGetMap(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	m, ok := srv.maps[r.PathValue("id")]
+	srv.mu.Unlock()
+	if (! ok) {
+		writeError(w, http.StatusNotFound, "No such map '%s'", r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Simulations
+// ---------------------------------------------------------------------------------------------------
+
+// Converts the parameters of a simulation request into simulator options, and checks them with
+//   the same rules as the command line (see checkSimOptions). The fields left at zero keep the
+//   defaults of the command line.
+func (req *SimRequest) options() (SimOptions, error) {
+	opts := defaultSimOptions()
+	opts.SpawnBorder = req.SpawnBorder
+	opts.SpawnPolicy = req.SpawnPolicy
+	opts.StopAfterQuiescent = req.StopAfterQuiescent
+	opts.DefenseRate = req.DefenseRate
+	opts.SpareCities = req.SpareCities
+	opts.Factions = req.Factions
+	opts.FearOfRuins = req.FearOfRuins
+	opts.RoadDecay = req.RoadDecay
+	opts.Collateral = req.Collateral
+	opts.Defenders = req.Defenders
+	opts.Seed = req.Seed
+	opts.RecordEvents = true
+	opts.RecordMoves = req.Moves
+	opts.Log = io.Discard
+	if (req.MaxSteps != 0) {
+		opts.MaxSteps = req.MaxSteps
+	}
+	if (req.FightThreshold != 0) {
+		opts.FightThreshold = req.FightThreshold
+	}
+	if (req.CombatModel != "") {
+		opts.CombatModel = req.CombatModel
+	}
+	if (req.HitPoints != 0) {
+		opts.HitPoints = req.HitPoints
+	}
+	if (req.CityHitPoints != 0) {
+		opts.CityHitPoints = req.CityHitPoints
+	}
+	if (req.Movement != "") {
+		opts.Movement = req.Movement
+	}
+	if (req.Waves != "") {
+		if err := opts.Waves.Set(req.Waves); err != nil {
+			return opts, err
+		}
+	}
+	for _, c := range req.StopWhen {
+		if err := opts.StopWhen.Set(c); err != nil {
+			return opts, err
+		}
+	}
+
+	if (req.Aliens <= 0) {
+		return opts, fmt.Errorf("The number of aliens must be positive")
+	}
+	if (req.StepDelayMs < 0) {
+		return opts, fmt.Errorf("The step delay must not be negative")
+	}
+	if err := checkSimOptions(&opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func (srv *Server) handleStartSimulation(w http.ResponseWriter, r *http.Request) {
+	var req SimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		srv.metrics.requestError()
+		writeError(w, http.StatusBadRequest, "Cannot decode simulation request: %v", err)
+		return
+	}
+
+	opts, err := req.options()
+	if (err != nil) {
+		srv.metrics.requestError()
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	srv.mu.Lock()
+	m, ok := srv.maps[req.Map]
+	srv.mu.Unlock()
+	if (! ok) {
+		writeError(w, http.StatusNotFound, "No such map '%s'", req.Map)
+		return
+	}
+
+	// Every simulation gets its own copy of the map data model.
+	nodes, nodeMap, err := readMap(bytes.NewReader(m.data))
+	if (err != nil) {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+
+	job := &SimJob{ Request: req, sim: NewSimulator(nodes, nodeMap, req.Aliens, opts), bcast: NewBroadcaster(), state: JOB_RUNNING, started: time.Now() }
+
+	// Moves are only published while a stream client is watching (or if the request wants them)
+	job.sim.SubscribeOnDemand(func(ev Event) {
+		if msg, err := json.Marshal(ev); err == nil {
+			job.bcast.Publish(msg)
+		}
+	})
+	srv.metrics.jobStarted(job.sim)
+
+	srv.mu.Lock()
+	srv.lastJobID ++
+	job.ID = strconv.Itoa(srv.lastJobID)
+	srv.jobs[job.ID] = job
+	srv.mu.Unlock()
+
+	fmt.Printf("Simulation #%s started on map #%s with %d aliens.\n", job.ID, req.Map, req.Aliens)
+	go job.run(&srv.metrics)
+
+	writeJSON(w, http.StatusAccepted, job.status())
+}
+
+// Runs the simulation to the end, and counts how it ended in "metrics". Runs in its own goroutine.
+func (job *SimJob) run(metrics *ServerMetrics) {
+	delay := time.Duration(job.Request.StepDelayMs) * time.Millisecond
+
+	job.mu.Lock()
+	job.sim.Spawn()
+	job.mu.Unlock()
+
+	for {
+		job.mu.Lock()
+		if (job.sim.Stopped()) {
+			job.state = JOB_DONE
+			job.finished = time.Now()
+			job.mu.Unlock()
+			break
+		}
+		err := job.sim.Iterate()
+		if (err != nil) {
+			job.state = JOB_FAILED
+			job.err = err.Error()
+			job.finished = time.Now()
+			job.mu.Unlock()
+			break
+		}
+		job.mu.Unlock()
+
+		if (delay > 0) {
+			time.Sleep(delay)
+		}
+	}
+
+	// Tell the live subscribers how it ended, then disconnect them.
+	if msg, err := json.Marshal(job.endRecord()); err == nil {
+		job.bcast.Publish(msg)
+	}
+	job.bcast.Close()
+	metrics.jobEnded(job.state)
+
+	fmt.Printf("Simulation #%s finished (%s).\n", job.ID, job.state)
+}
+
+// The last message sent to the live subscribers of a simulation.
+type JobEnd struct {
+	Type     string    `json:"type"`      // Always "end"
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"`
+	Summary  *Summary  `json:"summary,omitempty"`
+}
+
+func (job *SimJob) endRecord() JobEnd {
+	st := job.status()
+	return JobEnd{ Type: "end", State: st.State, Error: st.Error, Summary: st.Summary }
+}
+
+func (job *SimJob) status() SimStatus {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	sim := job.sim
+	st := SimStatus{
+		ID:               job.ID,
+		Map:              job.Request.Map,
+		State:            job.state,
+		Error:            job.err,
+		Iteration:        sim.iteration,
+		MaxSteps:         sim.opts.MaxSteps,
+		Aliens:           len(sim.aliens),
+		AliensAlive:      sim.liveAlienCounter,
+		Cities:           len(sim.nodes),
+		CitiesDestroyed:  sim.deadCityCounter,
+		Started:          job.started,
+	}
+	if (job.state != JOB_RUNNING) {
+		finished := job.finished
+		st.Finished = &finished
+	}
+	if (job.state == JOB_DONE) {
+		summary := sim.Summary(job.Request.Map)
+		st.Summary = &summary
+	}
+	return st
+}
+
+// Finds the simulation named in the request path, or writes a 404 error.
+func (srv *Server) job(w http.ResponseWriter, r *http.Request) *SimJob {
+	srv.mu.Lock()
+	job, ok := srv.jobs[r.PathValue("id")]
+	srv.mu.Unlock()
+	if (! ok) {
+		writeError(w, http.StatusNotFound, "No such simulation '%s'", r.PathValue("id"))
+		return nil
+	}
+	return job
+}
+
+func (srv *Server) handleListSimulations(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	var jobs []*SimJob
+	for i := 1; i <= srv.lastJobID; i++ {
+		if job, ok := srv.jobs[strconv.Itoa(i)]; ok {
+			jobs = append(jobs, job)
+		}
+	}
+	srv.mu.Unlock()
+
+	list := make([]SimStatus, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job.status())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (srv *Server) handleGetSimulation(w http.ResponseWriter, r *http.Request) {
+	if job := srv.job(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.status())
+	}
+}
+
+func (srv *Server) handleGetResult(w http.ResponseWriter, r *http.Request) {
+	job := srv.job(w, r)
+	if (job == nil) {
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	if (job.state != JOB_DONE) {
+		writeError(w, http.StatusConflict, "Simulation '%s' is %s", job.ID, job.state)
+		return
+	}
+
+	nodes := job.sim.nodes
+
+	if (r.URL.Query().Get("format") == "map") {
+		w.Header().Set("Content-Type", "text/plain")
+		writeMap(w, nodes)
+		return
+	}
+
+	cities := make([]ResultCity, 0, len(nodes) - job.sim.deadCityCounter)
+	for i := 0; i < len(nodes); i++ {
+		if (nodes[i].dead) {
+			continue
+		}
+		city := ResultCity{ Name: nodes[i].cityName, Roads: make(map[string]string), Pop: nodes[i].pop }
+		for _, road := range nodes[i].roads {
+			if other := road.to; (! nodes[other].dead) {
+				city.Roads[directionName(road.dir)] = nodes[other].cityName
+			}
+		}
+		cities = append(cities, city)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{ "cities": cities })
+}
+
+func (srv *Server) handleGetEvents(w http.ResponseWriter, r *http.Request) {
+	job := srv.job(w, r)
+	if (job == nil) {
+		return
+	}
+
+	job.mu.Lock()
+	events := append([]Event{}, job.sim.events...)
+	job.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+// Streams the events of a simulation over a WebSocket, one JSON text message per event (see
+//   Event), so that a front-end can animate the invasion as it happens. The client first receives
+//   the events recorded so far (which include moves only if the simulation was started with
+//   "moves"), then every event live (including moves), and finally a JobEnd message, after which
+//   the server closes the connection. Clients that cannot keep up are disconnected.
+func (srv *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	job := srv.job(w, r)
+	if (job == nil) {
+		return
+	}
+
+	// Take the backlog and subscribe atomically, so that no event is lost or sent twice: the
+	//   simulator only emits events while holding the job lock.
+	job.mu.Lock()
+	backlog := append([]Event{}, job.sim.events...)
+	ch := job.bcast.Subscribe()
+	running := (job.state == JOB_RUNNING)
+	if (running) {
+		job.sim.WatchMoves(true)
+		defer func() {
+			job.mu.Lock()
+			job.sim.WatchMoves(false)
+			job.mu.Unlock()
+		}()
+	}
+	job.mu.Unlock()
+
+	ws, err := wsUpgrade(w, r)
+	if (err != nil) {
+		job.bcast.Unsubscribe(ch)
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	done := make(chan struct{})
+	go ws.readLoop(done)
+
+	send := func(v interface{}) bool {
+		msg, err := json.Marshal(v)
+		return (err == nil) && (ws.WriteText(msg) == nil)
+	}
+
+	for _, ev := range backlog {
+		if (! send(ev)) {
+			job.bcast.Unsubscribe(ch)
+			ws.conn.Close()
+			return
+		}
+	}
+
+	if (! running) {
+		job.bcast.Unsubscribe(ch)
+		send(job.endRecord())
+		ws.Close(WS_CLOSE_NORMAL)
+		return
+	}
+
+	for {
+		select {
+		case msg, ok := <-ch:
+			if (! ok) {
+				// The simulation is over (its end record was the last message), or we were
+				//   dropped for being too slow.
+				ws.Close(WS_CLOSE_NORMAL)
+				return
+			}
+			if (ws.WriteText(msg) != nil) {
+				job.bcast.Unsubscribe(ch)
+				ws.conn.Close()
+				return
+			}
+		case <-done:
+			job.bcast.Unsubscribe(ch)
+			ws.conn.Close()
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Server mode
+// ---------------------------------------------------------------------------------------------------
+
+func serve(addr string) error {
+	fmt.Printf("Serving the REST API on '%s'.\n", addr)
+
+	srv := NewServer()
+	return http.ListenAndServe(addr, srv.Handler())
+}
+
+// Handles the command line of the server mode: -serve <ADDR>
+func mainServe(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.Usage = func() {}
+
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for server mode.");
+		return usageError()
+	} else if (flags.Parse(args[1:]) != nil) {
+		return usageError()
+	} else if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for server mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	} else {
+		return reportError(serve(args[0]))
+	}
+}
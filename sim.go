@@ -0,0 +1,1499 @@
+/*
+   Alien Invasion Simulator - simulation engine
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"math/rand"
+	"bufio"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------------------------------
+// Simulator data model
+// ---------------------------------------------------------------------------------------------------
+
+// The simulator does not assume that the provided input file conforms to any topological
+//   constraints, so its data model is different from the generator's simple model.
+// Each city node (SNode) that we read in has pointers for other city nodes that lie in some
+//   direction (see directions.go). The only assumption we make is that if city A has a "north"
+//   connection to city B, then city B has a "south" connection to city A (and similar to every
+//   other pair of opposite directions). If the input file violates that (e.g. city B has a "south"
+//   connection to some city "C" instead) then we abort the simulator with an error.
+
+// Additional indices for SNode.roads
+const WEST  int = 2;
+const NORTH int = 3;
+
+type SNodeArray []SNode         // a city data store
+
+type SNodeMap map[string]int    // index into a city data store (access city struct's index by city name)
+
+type SNode struct {
+	index        int        // Own index in the SNodeArray
+	cityName     string     // Name of the city ("" is an invalid name)
+	roads        []SRoad      // Roads to adjacent cities, sorted by direction (at most one road per direction)
+	dead         bool       // Set to true if the city has been destroyed
+	occupants    []int        // Aliens that are present in this city, in order of arrival
+	lastVisit    int        // Iteration at which an alien last entered (or spawned in) this city, -1 if never
+	pop          int        // Population of the city (see SimOptions.Defenders)
+	damage       int        // Hit points the city has lost in fights (see COMBAT_DAMAGE)
+}
+
+// The state of an alien.
+type SAlien struct {
+	city         int        // Index into a SNodeArray of the city the alien is in, -1 if dead (or not spawned yet)
+	hp           int        // Hit points left (see SimOptions.HitPoints)
+}
+
+type AlienArray []SAlien     // Index is alien number
+
+// A function that the simulator calls around every movement step (iteration). The iteration
+//   number starts at 0. Hooks may inspect and modify the simulation through the Simulator methods
+//   (e.g. SetRoad, DestroyCity, Stop).
+type IterationHook func(sim *Simulator, iteration int)
+
+// Simulation options that are given as optional flags after the positional arguments.
+type SimOptions struct {
+	SpawnBorder         bool           // Only spawn aliens at cities on the border (periphery) of the map (same as SPAWN_PERIMETER)
+	SpawnPolicy         string         // Where the aliens spawn: SPAWN_UNIFORM ("" too), SPAWN_CLUSTERED, SPAWN_PERIMETER, ...
+	Spawner             SpawnPolicy    `json:"-"`  // How the aliens choose where to spawn, if not one of the built-in policies
+	MaxSteps            int            // Maximum number of movement steps (iterations) to run
+	StopWhen            StopConds      // Additional termination conditions checked after every step
+	StopAfterQuiescent  int            // Stop if no fight happened in this many steps (0 to disable)
+	DefenseRate         float64        // Defense points gained by every surviving city in each step (0 to disable)
+	FightThreshold      int            // Number of aliens in a city that makes them fight (0 means 2)
+	SpareCities         bool           // Fights kill the aliens but leave the city standing
+	CombatModel         string         // How fights are resolved: COMBAT_ANNIHILATE ("" too), COMBAT_WOUNDS or COMBAT_DAMAGE
+	HitPoints           int            // Hit points of every alien, for the combat models that wound (0 means 1)
+	CityHitPoints       int            // Hit points of every city, for COMBAT_DAMAGE (0 means DEFAULT_CITY_HIT_POINTS)
+	Defenders           bool           // The population of a city may kill the aliens fighting in it instead of being destroyed
+	RoadDecay           float64        // Probability that each road is destroyed in each movement step
+	Collateral          float64        // Probability that each road of the neighbors of a destroyed city is destroyed with it
+	FearOfRuins         float64        // Probability that an alien avoids moving next to destroyed cities (see FearOfRuins)
+	Strategy            Strategy       `json:"-"`  // How the aliens choose their moves (nil for a random walk, or FearOfRuins if set)
+	Script              string         // Starlark file with the movement strategy of the aliens, if not "" (see loadScript)
+	Factions            int            // Number of alien factions (0 or 1 for a single one); aliens of the same faction never fight
+	Movement            string         // How the aliens take turns to move: MOVEMENT_SEQUENTIAL ("" too), MOVEMENT_SIMULTANEOUS or MOVEMENT_PARALLEL
+	Workers             int            // Goroutines that pick the moves under MOVEMENT_PARALLEL (0 for one per CPU); the results don't depend on it
+	AlienRatio          float64        // Aliens per alive city, if the number of aliens was chosen that way (see autoAliens)
+	Waves               Waves          // Spawn the aliens in successive waves instead of all at once
+	MaxMemory           ByteSize       // Refuse to simulate models estimated to need more memory than this (0 for no cap)
+	TwoPass             bool           // Read the map file in two passes, which needs less memory (see loadMapTwoPass)
+	BeforeIteration     IterationHook  `json:"-"`  // Called before each movement step, if not nil
+	AfterIteration      IterationHook  `json:"-"`  // Called after each movement step, if not nil
+	Overwrite           bool           // Allow output files to replace existing files
+	OutFile             string         // Write the result map to this file instead of "<mapfile>.result" (STDIO for the standard output)
+	Interactive         bool           // Read step-by-step commands from the terminal
+	Watch               bool           // Draw the map on the terminal after every step
+	WatchDelay          time.Duration  // Delay between the frames drawn in watch mode
+	RecordPaths         bool           // Record the cities visited by each alien
+	WriteVisits         bool           // Write the last visit of each city to "<mapfile>.visits"
+	WritePositions      bool           // Write the city of each live alien at the end to "<mapfile>.positions"
+	StreamFile          string         // Stream events and partial results to this file, if not ""
+	StreamEvery         int            // Movement steps between the partial results in the stream
+	StatsFile           string         // Write per-iteration statistics to this CSV file, if not ""
+	CheckpointEvery     int            // Movement steps between checkpoints (0 to disable)
+	DiagnosticsFile     string         // Write a diagnostics bundle (zip) to this file on a fatal error, if not ""
+	CheckInvariants     bool           // Check the simulation state after every movement step (see CheckInvariants)
+	ProgressEvery       time.Duration  // Wall time between the progress lines of the simulation mode (0 for progress dots)
+	TimeLimit           time.Duration  // Stop the simulation mode after this much wall time (0 for no limit)
+	RecordEvents        bool           // Record the spawn and destruction events (see Simulator.Events)
+	RecordMoves         bool           // Also record a move event for every alien movement
+	Seed                int64          // Seed for the random number generator (0 picks a random seed)
+	Log                 io.Writer      `json:"-"`  // Where the simulator prints its messages (os.Stdout if nil)
+	Quiet               bool           // Only log errors
+	Verbose             bool           // Also log every alien move
+	LogFormat           string         // LOG_FORMAT_TEXT ("" too) or LOG_FORMAT_JSON
+	Mute                LogKinds       // Kinds of messages that are not logged (e.g. EVENT_DESTROYED)
+}
+
+// Termination conditions for the --stop-when flag.
+const STOP_ALL_TRAPPED    string = "all-trapped"      // every alien left alive is unable to move
+const STOP_HALF_DESTROYED string = "half-destroyed"   // at least half of the cities have been destroyed
+
+// Movement modes for the --movement flag.
+const MOVEMENT_SEQUENTIAL   string = "sequential"     // aliens move one after the other, in order
+const MOVEMENT_SIMULTANEOUS string = "simultaneous"   // aliens pick their moves first, then all move at once
+const MOVEMENT_PARALLEL     string = "parallel"       // as simultaneous, with the moves picked concurrently (see stepParallel)
+
+// The defense points at which a city repels half of the aliens that try to enter it.
+const DEFENSE_SCALE float64 = 100
+
+// The population at which the defenders of a city win half of the fights in it.
+const DEFENDER_SCALE float64 = 1000
+
+// Combat models for the --combat-model flag.
+const COMBAT_ANNIHILATE string = "annihilate"   // all the fighters die and the city is destroyed
+const COMBAT_WOUNDS     string = "wounds"       // the fighters wound each other until one side is left (see melee)
+const COMBAT_DAMAGE     string = "damage"       // the fighters and the city lose hit points until either gives out (see siege)
+
+// The probability that a blow lands in a fight under COMBAT_WOUNDS.
+const COMBAT_HIT_CHANCE float64 = 0.5
+
+// The hit points of a city under COMBAT_DAMAGE, if not given.
+const DEFAULT_CITY_HIT_POINTS int = 3
+
+// A list of termination conditions. Implements flag.Value so that --stop-when can be repeated.
+type StopConds []string
+
+func (s *StopConds) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *StopConds) Set(value string) error {
+	for _, c := range strings.Split(value, ",") {
+		if (c != STOP_ALL_TRAPPED) && (c != STOP_HALF_DESTROYED) {
+			return fmt.Errorf("unknown termination condition '%s'", c)
+		}
+		*s = append(*s, c)
+	}
+	return nil
+}
+
+// The arrival schedule of the aliens: "Count" waves, one every "Interval" movement steps, starting
+//   with the spawn phase. Implements flag.Value for --waves <COUNT>x<INTERVAL>. The zero value
+//   spawns all of the aliens at once.
+type Waves struct {
+	Count     int
+	Interval  int
+}
+
+func (w *Waves) String() string {
+	if (w.Count == 0) {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", w.Count, w.Interval)
+}
+
+func (w *Waves) Set(value string) error {
+	parts := strings.Split(value, "x")
+	if (len(parts) != 2) {
+		return fmt.Errorf("invalid waves '%s' (expected <COUNT>x<INTERVAL>)", value)
+	}
+	count, err1 := strconv.Atoi(parts[0])
+	interval, err2 := strconv.Atoi(parts[1])
+	if (err1 != nil) || (err2 != nil) || (count < 1) || (interval < 1) {
+		return fmt.Errorf("invalid waves '%s' (count and interval must be positive integers)", value)
+	}
+	w.Count, w.Interval = count, interval
+	return nil
+}
+
+// Returns the number of waves, which is 1 if the aliens spawn all at once.
+func (w Waves) count() int {
+	if (w.Count < 1) {
+		return 1
+	}
+	return w.Count
+}
+
+func (s StopConds) has(cond string) bool {
+	for _, c := range s {
+		if (c == cond) {
+			return true
+		}
+	}
+	return false
+}
+
+// Final report of a simulation run. It is printed at the end of the simulation and also
+//   saved as JSON to "<mapfile>.summary.json".
+type Summary struct {
+	MapFile          string  `json:"mapfile"`
+	Aliens           int     `json:"aliens"`
+	Cities           int     `json:"cities"`
+	CitiesDestroyed  int     `json:"cities_destroyed"`
+	AliensAlive      int     `json:"aliens_alive"`
+	Iterations       int     `json:"iterations"`
+	LastFight        int     `json:"last_fight"`                 // Movement step of the last fight, 0 if there was none
+	MaxSteps         int     `json:"max_steps"`
+	StopReason       string  `json:"stop_reason"`
+	Seed             int64   `json:"seed"`
+	CitiesVisited    int     `json:"cities_visited"`
+	AliensRepelled   int     `json:"aliens_repelled"`
+	AliensTrapped    int     `json:"aliens_trapped"`             // Aliens alive at the end with no road to a live city
+	AliensWounded    int     `json:"aliens_wounded,omitempty"`   // Aliens alive at the end that have lost hit points
+	CitiesDamaged    int     `json:"cities_damaged,omitempty"`   // Cities standing at the end that have lost hit points
+	AlienRatio       float64 `json:"alien_ratio,omitempty"`   // Set if the number of aliens was chosen automatically
+	FactionsAlive    []int   `json:"factions_alive,omitempty"`   // Aliens alive in each faction, if there is more than one
+	Population       int     `json:"population"`                 // Initial population of all the cities
+	HumanCasualties  int     `json:"human_casualties"`           // People killed by the aliens
+	AliensLost       int     `json:"aliens_lost"`                // Aliens killed, by any cause
+	DefenderKills    int     `json:"defender_kills"`             // Aliens killed by the defenders of a city
+	Waves            int     `json:"waves,omitempty"`            // Number of alien waves, if more than one
+	WavesSpawned     int     `json:"waves_spawned,omitempty"`    // Number of alien waves that arrived before the end
+	AliensSpawned    int     `json:"aliens_spawned"`             // Aliens that arrived before the end
+	Roads            int     `json:"roads"`                      // Initial number of roads
+	RoadsDestroyed   int     `json:"roads_destroyed"`            // Roads destroyed on their own (by clashes, decay or collateral damage)
+	RoadsSurviving   int     `json:"roads_surviving"`            // Roads between cities that were not destroyed (as in the result map)
+	EstimatedMemory  int64   `json:"estimated_memory"`           // Estimated memory of the simulation model, in bytes
+	PeakMemory       int64   `json:"peak_memory"`                // Peak heap memory in use that was sampled, in bytes
+}
+
+// The state of a simulation run.
+type Simulator struct {
+	opts              SimOptions
+	nodes             SNodeArray
+	nodeMap           SNodeMap
+	aliens            AlienArray
+	liveAlienCounter  int
+	deadCityCounter   int
+	visitedCounter    int        // Number of cities that have been visited by an alien at least once
+	repelledCounter   int        // Number of aliens killed by city defenses
+	population        int        // Initial population of all the cities
+	casualties        int        // Number of people killed by the aliens
+	defenderKills     int        // Number of aliens killed by the defenders of a city
+	roads             int        // Initial number of roads
+	roadsDestroyed    int        // Number of roads destroyed on their own (not with their cities, see destroyRoad)
+	iteration         int        // Number of movement steps run so far
+	spawned           int        // Number of aliens spawned so far (aliens are spawned in ID order)
+	wave              int        // Number of waves spawned so far
+	estimatedMemory   int64      // Estimated memory of the model (see estimateMemory)
+	peakMemory        int64      // Peak heap memory in use sampled so far (see sampleMemory)
+	quietSteps        int        // Number of consecutive movement steps without fights
+	stopReason        string     // Why the simulation stopped, or "" if it can still run
+	failure           error      // The error that aborts the simulation, if any (see Fail)
+	numDirs           int        // Number of directions an alien picks from when it moves (see Step)
+	strategy          Strategy   // How the aliens choose their moves
+	parallel          *parallelMover  // The state of MOVEMENT_PARALLEL, set up in its first step
+	spawner           SpawnPolicy  // Where the aliens spawn
+	rnd               *rand.Rand // The random number generator of this simulation
+	src               *pcgSource // The source of "rnd", whose state is saved in checkpoints
+	seed              int64      // The seed of "rnd"
+	out               io.Writer  // Where the simulator prints its messages
+	log               *Logger    // The leveled logger of the messages, which writes to "out"
+	events            []Event    // Events recorded so far, if SimOptions.RecordEvents is set
+	listeners         []eventSubscription  // Receivers of the events as they happen
+	moveEvents        bool       // Move events are wanted (they are expensive, so we skip them if not)
+	movesWanted       bool       // Move events are always wanted (recorded, or by a listener)
+	moveWatchers      int        // Number of watchers that want move events for now (see WatchMoves)
+	paths             [][]int    // Cities visited by each alien, if SimOptions.RecordPaths is set
+	trapped           []bool     // The aliens that have been reported as trapped (see checkTrapped)
+	progress          bool       // Print progress dots and percentages while running
+	dot               bool       // Progress dots are pending a newline
+	percent           int        // Last progress percentage printed
+}
+
+// Creates a simulation of "numaliens" aliens over a city data store read by loadMap().
+func NewSimulator(nodes SNodeArray, nodeMap SNodeMap, numaliens int, opts SimOptions) *Simulator {
+	sim := &Simulator{ opts: opts, nodes: nodes, nodeMap: nodeMap, progress: true, out: opts.Log }
+	if (sim.out == nil) {
+		sim.out = os.Stdout
+	}
+	sim.log = newLogger(sim.out, opts)
+	if (sim.opts.FightThreshold == 0) {
+		sim.opts.FightThreshold = 2
+	}
+	if (sim.opts.CombatModel == "") {
+		sim.opts.CombatModel = COMBAT_ANNIHILATE
+	}
+	if (sim.opts.HitPoints == 0) {
+		sim.opts.HitPoints = 1
+	}
+	if (sim.opts.CityHitPoints == 0) {
+		sim.opts.CityHitPoints = DEFAULT_CITY_HIT_POINTS
+	}
+	sim.strategy = newStrategy(opts)
+	sim.spawner = newSpawnPolicy(opts)
+	for i := 0; i < len(nodes); i++ {
+		sim.population += nodes[i].pop
+	}
+
+	// Each simulation has its own random number generator, so that simulations can run
+	//   concurrently and be reproduced from their seed.
+	sim.seed = opts.Seed
+	if (sim.seed == 0) {
+		sim.seed = time.Now().UnixNano()
+	}
+	sim.src = newPCGSource(sim.seed)
+	sim.rnd = newRand(sim.src)
+
+	// All aliens start as dead (in no city) until the spawn phase places them.
+	sim.aliens = make(AlienArray, numaliens)
+	for i := 0; i < numaliens; i++ {
+		sim.aliens[i].city = -1
+	}
+
+	sim.trapped = make([]bool, numaliens)
+	if (opts.RecordPaths) {
+		sim.paths = make([][]int, numaliens)
+	}
+	sim.movesWanted = opts.RecordEvents && opts.RecordMoves
+	sim.moveEvents = sim.movesWanted
+	sim.AddObserver(consoleObserver{}, sim.log.enabled(LOG_DEBUG, EVENT_MOVE))
+	sim.estimatedMemory = estimateMemory(nodes, numaliens)
+	sim.roads = countRoads(nodes, false)
+
+	// Aliens pick at least from the four cardinal directions, so that the random choices (and the
+	//   results of a seed) on cardinal maps don't depend on which directions the map happens to use.
+	sim.numDirs = 4
+	for i := 0; i < len(nodes); i++ {
+		for _, r := range nodes[i].roads {
+			sim.useDirection(r.dir)
+		}
+	}
+	return sim
+}
+
+// Makes sure that aliens can pick direction "dir" when they move.
+func (sim *Simulator) useDirection(dir int) {
+	if (dir >= sim.numDirs) {
+		sim.numDirs = dir + 1
+	}
+	if od := opposite(dir); (od >= sim.numDirs) {
+		sim.numDirs = od + 1
+	}
+}
+
+// Adds a hook to be called after each movement step, after the hooks already installed.
+func (sim *Simulator) AddAfterIteration(hook IterationHook) {
+	prev := sim.opts.AfterIteration
+	if (prev == nil) {
+		sim.opts.AfterIteration = hook
+		return
+	}
+	sim.opts.AfterIteration = func(s *Simulator, iteration int) {
+		prev(s, iteration)
+		hook(s, iteration)
+	}
+}
+
+// Records that an alien entered a city at the given iteration.
+func (sim *Simulator) visit(city int, iteration int) {
+	if (sim.nodes[city].lastVisit == -1) {
+		sim.visitedCounter ++
+	}
+	sim.nodes[city].lastVisit = iteration
+}
+
+// Appends a city to the recorded path of an alien.
+func (sim *Simulator) recordPath(alien int, city int) {
+	if (sim.paths != nil) {
+		sim.paths[alien] = append(sim.paths[alien], city)
+	}
+}
+
+// Writes the recorded alien paths, one line per alien with the alien number followed by the names
+//   of the cities it visited, in order ("<ID> <CITY> <CITY> ..."). The path of an alien that died
+//   ends at the city where it died.
+func (sim *Simulator) writePaths(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i := 0; i < len(sim.paths); i++ {
+		line := strconv.Itoa(i)
+		for _, city := range sim.paths[i] {
+			line += " " + sim.nodes[city].cityName
+		}
+		if _, err := bw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes the last visit of each city, one line per city with the city name, the iteration at which
+//   an alien last entered it and the number of iterations it has been idle since then
+//   ("<CITY> <LASTVISIT> <IDLE>"). Cities that were never visited are written as "<CITY> never".
+func (sim *Simulator) writeVisits(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i := 0; i < len(sim.nodes); i++ {
+		line := sim.nodes[i].cityName + " never"
+		if (sim.nodes[i].lastVisit != -1) {
+			line = fmt.Sprintf("%s %d %d", sim.nodes[i].cityName, sim.nodes[i].lastVisit, sim.CityIdle(i))
+		}
+		if _, err := bw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes the city of each alien still alive, one line per alien with the alien number and the
+//   city name ("<ALIEN> <CITY>").
+func (sim *Simulator) writePositions(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for i, alien := range sim.aliens {
+		if (alien.city == -1) {
+			continue
+		}
+		if _, err := fmt.Fprintf(bw, "%d %s\n", i, sim.nodes[alien.city].cityName); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Simulator state accessors, for the simulator driver and iteration hooks
+// ---------------------------------------------------------------------------------------------------
+
+// Number of movement steps run so far.
+func (sim *Simulator) Iteration() int {
+	return sim.iteration
+}
+
+// Number of aliens left alive.
+func (sim *Simulator) LiveAliens() int {
+	return sim.liveAlienCounter
+}
+
+// Number of cities.
+func (sim *Simulator) Cities() int {
+	return len(sim.nodes)
+}
+
+// Index of the city with the given name, or -1 if there is no such city.
+func (sim *Simulator) CityIndex(cityName string) int {
+	idx, ok := sim.nodeMap[cityName]
+	if (! ok) {
+		return -1
+	}
+	return idx
+}
+
+// Name of the city at the given index.
+func (sim *Simulator) CityName(idx int) string {
+	return sim.nodes[idx].cityName
+}
+
+// Returns true if the city at the given index has been destroyed.
+func (sim *Simulator) CityDead(idx int) bool {
+	return sim.nodes[idx].dead
+}
+
+// Iteration at which an alien last entered the city at the given index (0 for the spawn phase),
+//   or -1 if no alien has ever been there.
+func (sim *Simulator) CityLastVisit(idx int) int {
+	return sim.nodes[idx].lastVisit
+}
+
+// Number of iterations since an alien last entered the city at the given index, or -1 if no
+//   alien has ever been there.
+func (sim *Simulator) CityIdle(idx int) int {
+	if (sim.nodes[idx].lastVisit == -1) {
+		return -1
+	}
+	return sim.iteration - sim.nodes[idx].lastVisit
+}
+
+// Defense points of the surviving cities at the given movement step (counting from 1).
+// Cities start with no defenses and gain SimOptions.DefenseRate points in every step, so all the
+//   surviving cities have the same defense at any given time.
+func (sim *Simulator) Defense(iteration int) float64 {
+	return sim.opts.DefenseRate * float64(iteration)
+}
+
+// Number of cities that have been visited by an alien at least once.
+func (sim *Simulator) CitiesVisited() int {
+	return sim.visitedCounter
+}
+
+// Index of the city that alien "id" is in, or -1 if the alien is dead.
+func (sim *Simulator) AlienCity(id int) int {
+	return sim.aliens[id].city
+}
+
+// Hit points left to alien "id" (see SimOptions.HitPoints).
+func (sim *Simulator) AlienHitPoints(id int) int {
+	return sim.aliens[id].hp
+}
+
+// Hit points left to city "idx" (see COMBAT_DAMAGE).
+func (sim *Simulator) CityHitPoints(idx int) int {
+	return sim.opts.CityHitPoints - sim.nodes[idx].damage
+}
+
+// Returns the faction of alien "id". The aliens are dealt to the factions in turn, so alien #0 is
+//   in faction #0, alien #1 in faction #1, and so on.
+func (sim *Simulator) Faction(id int) int {
+	if (sim.opts.Factions <= 1) {
+		return 0
+	}
+	return id % sim.opts.Factions
+}
+
+// The number of aliens in faction "f" out of "numaliens" aliens dealt to "factions" factions.
+func factionSize(numaliens int, factions int, f int) int {
+	size := numaliens / factions
+	if (f < numaliens % factions) {
+		size ++
+	}
+	return size
+}
+
+// The number of aliens alive in each faction, or nil if there is a single faction.
+func (sim *Simulator) factionsAlive() []int {
+	if (sim.opts.Factions <= 1) {
+		return nil
+	}
+	alive := make([]int, sim.opts.Factions)
+	for i, alien := range sim.aliens {
+		if (alien.city != -1) {
+			alive[sim.Faction(i)] ++
+		}
+	}
+	return alive
+}
+
+// Returns the aliens in city "idx", in order of arrival. The slice must not be modified.
+func (sim *Simulator) Occupants(idx int) []int {
+	return sim.nodes[idx].occupants
+}
+
+// Returns the index of the city reached by the road leaving city "idx" in direction "dir", or -1.
+func (sim *Simulator) Road(idx int, dir int) int {
+	return sim.nodes[idx].road(dir)
+}
+
+// Returns the roads leaving city "idx", sorted by direction. The slice must not be modified.
+func (sim *Simulator) Roads(idx int) []SRoad {
+	return sim.nodes[idx].roads
+}
+
+// Replaces the road leaving city "from" in direction "dir" with a road to city "to", or removes
+//   it if "to" is -1. The inverse road is updated as well, so the road network stays reciprocal.
+func (sim *Simulator) SetRoad(from int, dir int, to int) {
+	od := opposite(dir)
+	nodes := sim.nodes
+
+	if old := nodes[from].road(dir); (old != -1) {
+		nodes[old].setRoad(od, -1)
+	}
+	if (to != -1) {
+		if prev := nodes[to].road(od); (prev != -1) {
+			nodes[prev].setRoad(dir, -1)
+		}
+		nodes[to].setRoad(od, from)
+		sim.useDirection(dir)
+	}
+	nodes[from].setRoad(dir, to)
+}
+
+// Destroys a city, killing the aliens in it (if any).
+func (sim *Simulator) DestroyCity(idx int) {
+	node := &sim.nodes[idx]
+	if (node.dead) {
+		return
+	}
+	sim.emit(Event{ Iteration: sim.iteration, Type: EVENT_DESTROYED, City: node.cityName, Aliens: append([]int{}, node.occupants...) })
+	for _, a := range node.occupants {
+		sim.aliens[a].city = -1
+		sim.liveAlienCounter --
+	}
+	node.occupants = nil
+	sim.ruin(idx, sim.iteration)
+}
+
+// Marks city "idx" as destroyed, with its population, and damages the roads nearby (see
+//   collateral). The city must have no occupants left.
+func (sim *Simulator) ruin(idx int, iteration int) {
+	node := &sim.nodes[idx]
+	node.dead = true
+	sim.deadCityCounter ++
+	sim.casualties += node.pop
+	node.pop = 0
+	sim.collateral(idx, iteration)
+}
+
+// Stops the simulation after the current step, recording the given reason in the summary.
+func (sim *Simulator) Stop(reason string) {
+	if (sim.stopReason == "") {
+		sim.stopReason = reason
+	}
+}
+
+// Aborts the simulation with an error at the end of the current movement step, e.g. from a
+//   movement strategy that can't go on. Only the first error is kept.
+func (sim *Simulator) Fail(err error) {
+	if (sim.failure == nil) {
+		sim.failure = err
+	}
+}
+
+// Returns true once the simulation has stopped.
+func (sim *Simulator) Stopped() bool {
+	return sim.stopReason != ""
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Fights
+// ---------------------------------------------------------------------------------------------------
+
+// Formats a list of aliens as "Alien #1, Alien #2 and Alien #3".
+func alienList(ids []int) string {
+	s := ""
+	for k, id := range ids {
+		switch {
+		case (k == 0):
+		case (k == len(ids) - 1):
+			s += " and "
+		default:
+			s += ", "
+		}
+		s += fmt.Sprintf("Alien #%d", id)
+	}
+	return s
+}
+
+// Adds alien "i" to the aliens in city "city", which it has just entered (or spawned in, if
+//   "spawned" is true), at movement step "iteration".
+// If the city now has SimOptions.FightThreshold aliens, and they are not all of the same faction,
+//   they fight: all of them die and, unless SimOptions.SpareCities is set, the city is destroyed
+//   (other combat models may leave survivors or a damaged city, see combat).
+//   Fewer aliens, or aliens of a single faction, share the city in peace.
+// Returns true if there was a fight.
+func (sim *Simulator) arrive(i int, city int, iteration int, spawned bool) bool {
+	return sim.arriveAll([]int{ i }, city, iteration, spawned)
+}
+
+// Adds the aliens "newcomers" to city "city" at the same time, with a single fight among all the
+//   aliens in the city if they are enough (see arrive). The newcomers must already have been
+//   moved (i.e. sim.aliens says they are in "city").
+func (sim *Simulator) arriveAll(newcomers []int, city int, iteration int, spawned bool) bool {
+	node := &sim.nodes[city]
+	previous := node.occupants
+	node.occupants = append(append([]int{}, previous...), newcomers...)
+	if (len(node.occupants) < sim.opts.FightThreshold) || (! sim.hostile(node.occupants)) {
+		return false
+	}
+
+	// The newcomers come first, then the aliens that were already there
+	fighters := append(append([]int{}, newcomers...), previous...)
+
+	defended := sim.defend(city, len(fighters))
+	var survivors []int
+	stands := true
+	if (! defended) {
+		survivors, stands = sim.combat(fighters, city)
+	}
+	switch {
+	case (defended):
+		sim.emit(Event{ Iteration: iteration, Type: EVENT_DEFENDED, City: node.cityName, Aliens: fighters })
+	case (stands):
+		ev := Event{ Iteration: iteration, Type: EVENT_FIGHT, City: node.cityName, Aliens: fighters, Survivors: survivors }
+		if (node.damage > 0) && (sim.CityHitPoints(city) > 0) {
+			ev.CityHP = sim.CityHitPoints(city)
+		}
+		sim.emit(ev)
+	default:
+		sim.emit(Event{ Iteration: iteration, Type: EVENT_DESTROYED, City: node.cityName, Aliens: fighters, Spawned: spawned })
+	}
+
+	if (! stands) {
+		sim.ruin(city, iteration)
+	}
+
+	// Dead aliens are in no city, and the survivors stay (wounded) in it
+	for _, a := range fighters {
+		sim.aliens[a].city = -1
+	}
+	for _, a := range survivors {
+		sim.aliens[a].city = city
+	}
+	node.occupants = survivors
+	sim.liveAlienCounter -= len(fighters) - len(survivors)
+	return true
+}
+
+// Resolves a fight among the aliens "fighters" in city "city" with SimOptions.CombatModel:
+//
+//   - COMBAT_ANNIHILATE: all the fighters die, and the city is destroyed.
+//   - COMBAT_WOUNDS: the fighters wound each other (see melee) until the ones left are not enough
+//     for a fight, or are all of the same faction. They survive, wounded, and the city stands.
+//     If none of them is left, the city is destroyed.
+//   - COMBAT_DAMAGE: the fighters and the city lose a hit point in every round of the fight (see
+//     siege). The city is destroyed with the aliens in it when it has no hit points left, and
+//     stands, damaged, if the aliens give out first.
+//
+// SimOptions.SpareCities leaves the city standing in every case.
+// Returns the fighters that survived, in order, and whether the city is still standing.
+func (sim *Simulator) combat(fighters []int, city int) ([]int, bool) {
+	var survivors []int
+	switch (sim.opts.CombatModel) {
+	case COMBAT_WOUNDS:
+		survivors = sim.melee(fighters)
+	case COMBAT_DAMAGE:
+		survivors = sim.siege(fighters, city)
+	}
+	stands := (sim.opts.SpareCities) || (len(survivors) > 0)
+	if (sim.opts.CombatModel == COMBAT_DAMAGE) {
+		stands = (sim.opts.SpareCities) || (sim.CityHitPoints(city) > 0)
+	}
+	if (! stands) {
+		survivors = nil
+	}
+	return survivors, stands
+}
+
+// Returns true if aliens "a" and "b" fight each other when they meet (i.e. they are of different
+//   factions, or there is a single faction).
+func (sim *Simulator) enemies(a int, b int) bool {
+	return (sim.opts.Factions <= 1) || (sim.Faction(a) != sim.Faction(b))
+}
+
+// Removes the aliens with no hit points left from "ids". Returns the ones left, in order.
+func (sim *Simulator) standing(ids []int) []int {
+	var left []int
+	for _, a := range ids {
+		if (sim.aliens[a].hp > 0) {
+			left = append(left, a)
+		}
+	}
+	return left
+}
+
+// A fight under COMBAT_WOUNDS, in rounds: in each round, every fighter strikes one of its enemies
+//   at random, and the blow takes a hit point with probability COMBAT_HIT_CHANCE. The blows of a
+//   round land at the same time, so two aliens can kill each other. Fighters with no hit points
+//   left die. Returns the fighters left when they are no longer enough for a fight.
+func (sim *Simulator) melee(fighters []int) []int {
+	left := append([]int{}, fighters...)
+	for (len(left) >= sim.opts.FightThreshold) && (sim.hostile(left)) {
+		hits := make([]int, len(left))
+		for k, a := range left {
+			var foes []int
+			for j, b := range left {
+				if (j != k) && (sim.enemies(a, b)) {
+					foes = append(foes, j)
+				}
+			}
+			target := foes[sim.rnd.Intn(len(foes))]
+			if (sim.rnd.Float64() < COMBAT_HIT_CHANCE) {
+				hits[target] ++
+			}
+		}
+		for k, a := range left {
+			sim.aliens[a].hp -= hits[k]
+		}
+		left = sim.standing(left)
+	}
+	return left
+}
+
+// A fight under COMBAT_DAMAGE, in rounds: in each round, every fighter and the city lose a hit
+//   point. Fighters with no hit points left die. Returns the fighters left when the city has no
+//   hit points left, or when they are no longer enough for a fight.
+func (sim *Simulator) siege(fighters []int, city int) []int {
+	left := fighters
+	for (len(left) >= sim.opts.FightThreshold) && (sim.hostile(left)) {
+		for _, a := range left {
+			sim.aliens[a].hp --
+		}
+		left = sim.standing(left)
+		sim.nodes[city].damage ++
+		if (sim.CityHitPoints(city) <= 0) && (! sim.opts.SpareCities) {
+			break
+		}
+	}
+	return left
+}
+
+// Counts the aliens still alive that have lost hit points in fights.
+func (sim *Simulator) woundedAliens() int {
+	n := 0
+	for _, alien := range sim.aliens {
+		if (alien.city != -1) && (alien.hp < sim.opts.HitPoints) {
+			n ++
+		}
+	}
+	return n
+}
+
+// Counts the cities still standing that have lost hit points in fights.
+func (sim *Simulator) damagedCities() int {
+	n := 0
+	for i := 0; i < len(sim.nodes); i++ {
+		if (! sim.nodes[i].dead) && (sim.nodes[i].damage > 0) {
+			n ++
+		}
+	}
+	return n
+}
+
+// The defenders of city "city" face "n" fighting aliens, if SimOptions.Defenders is set and the
+//   city would be destroyed: with population P, they kill all the aliens with probability
+//   P / (P + DEFENDER_SCALE), losing one person for each alien. Returns true if they won.
+// The roll is skipped (and uses no random numbers) for cities with no population.
+func (sim *Simulator) defend(city int, n int) bool {
+	node := &sim.nodes[city]
+	if (! sim.opts.Defenders) || (sim.opts.SpareCities) || (node.pop == 0) {
+		return false
+	}
+	pop := float64(node.pop)
+	if (sim.rnd.Float64() >= pop / (pop + DEFENDER_SCALE)) {
+		return false
+	}
+	lost := n
+	if (lost > node.pop) {
+		lost = node.pop
+	}
+	node.pop -= lost
+	sim.casualties += lost
+	sim.defenderKills += n
+	return true
+}
+
+// The population of city "idx".
+func (sim *Simulator) CityPopulation(idx int) int {
+	return sim.nodes[idx].pop
+}
+
+// Returns true if a group of aliens is made of more than one faction.
+func (sim *Simulator) hostile(ids []int) bool {
+	for _, a := range ids {
+		if (sim.Faction(a) != sim.Faction(ids[0])) {
+			return true
+		}
+	}
+	return sim.opts.Factions <= 1
+}
+
+// Removes alien "i" from the aliens in the city it is in, as it leaves it (or dies).
+func (sim *Simulator) leave(i int) {
+	node := &sim.nodes[sim.aliens[i].city]
+	for k, a := range node.occupants {
+		if (a == i) {
+			node.occupants = append(node.occupants[:k], node.occupants[k+1:]...)
+			return
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Alien spawn phase
+// ---------------------------------------------------------------------------------------------------
+
+// Finds the cities at the border of the map, which are used as the spawn points of the perimeter
+//   spawn policy (see PerimeterSpawn).
+// If every city name encodes grid coordinates (i.e. the map came from our generator), the border
+//   is made of the cities on the outer rows and columns of the grid. Otherwise, we don't know
+//   anything about the geometry of the map, so we take the cities that have the least number of
+//   roads as the periphery of the graph.
+func borderCities(nodes SNodeArray) []int {
+	var border []int
+
+	minx, miny, maxx, maxy := -1, -1, -1, -1
+	grid := true
+	for i := 0; i < len(nodes); i++ {
+		x, y, ok := parseCoords(nodes[i].cityName)
+		if (! ok) {
+			grid = false
+			break
+		}
+		if (minx == -1) || (x < minx) { minx = x }
+		if (miny == -1) || (y < miny) { miny = y }
+		if (x > maxx) { maxx = x }
+		if (y > maxy) { maxy = y }
+	}
+
+	if (grid) {
+		for i := 0; i < len(nodes); i++ {
+			x, y, _ := parseCoords(nodes[i].cityName)
+			if (x == minx) || (x == maxx) || (y == miny) || (y == maxy) {
+				border = append(border, i)
+			}
+		}
+		return border
+	}
+
+	minDegree := -1
+	for i := 0; i < len(nodes); i++ {
+		degree := len(nodes[i].roads)
+		if (minDegree == -1) || (degree < minDegree) {
+			minDegree = degree
+			border = border[:0]
+		}
+		if (degree == minDegree) {
+			border = append(border, i)
+		}
+	}
+	return border
+}
+
+// Spawns the aliens (of the first wave) one after the other, where the spawn policy places them.
+// If enough aliens are spawned in the same city, they fight (see arrive).
+// If we run out of cities before all aliens are spawned, the simulation stops and Spawn returns
+//   false (empty map).
+func (sim *Simulator) Spawn() bool {
+	n := sim.waveSize(0)
+
+	if (sim.opts.Waves.count() > 1) {
+		sim.logf(LOG_INFO, LOG_KIND_PHASE, "\nSimulation Phase #1: Spawning %d aliens at random cities (wave 1 of %d).\n", n, sim.opts.Waves.Count);
+	} else {
+		sim.logf(LOG_INFO, LOG_KIND_PHASE, "\nSimulation Phase #1: Spawning %d aliens at random cities.\n", n);
+	}
+
+	return sim.spawnWave(true)
+}
+
+// Returns the number of aliens in wave "w" (0-based). The aliens are split as evenly as possible
+//   between the waves, and the first waves get the remainder.
+func (sim *Simulator) waveSize(w int) int {
+	count := sim.opts.Waves.count()
+	n := len(sim.aliens) / count
+	if (w < len(sim.aliens) % count) {
+		n ++
+	}
+	return n
+}
+
+// Returns true if there are waves of aliens that have not been spawned yet.
+func (sim *Simulator) wavesPending() bool {
+	return sim.wave < sim.opts.Waves.count()
+}
+
+// Spawns the next wave of aliens where the spawn policy places them. "first" is true for the
+//   spawn phase, which reports the border cities of the perimeter policy. Returns false if there
+//   were no cities left to place them.
+func (sim *Simulator) spawnWave(first bool) bool {
+	nodes := sim.nodes
+	aliens := sim.aliens
+
+	if p, ok := sim.spawner.(*PerimeterSpawn); (ok) && (first) {
+		sim.logf(LOG_INFO, LOG_KIND_PHASE, "Restricting alien spawn to %d border cities.\n", len(p.cities(sim)))
+	}
+
+	from := sim.spawned
+	to := from + sim.waveSize(sim.wave)
+	sim.wave ++
+
+	// Place aliens in sequence.
+
+	for i := from; i < to; i++ {
+
+		// Choose a city index to place the next alien.
+
+		chosenCityIndex := sim.spawner.Place(sim, i)
+
+		// Check if we have zero cities left.
+
+		if (chosenCityIndex == -1) {
+			if (first) {
+				sim.logf(LOG_INFO, LOG_KIND_STOP, "Simulation has ended at Phase #1: no cities left to place Alien #%d. The resulting map is empty (no result map file written).\n", i)
+			} else {
+				sim.logf(LOG_INFO, LOG_KIND_STOP, "Simulation has ended at iteration %d: no cities left to place Alien #%d. The resulting map is empty (no result map file written).\n", sim.iteration, i)
+			}
+			sim.Stop("no-cities-left")
+			return false
+		}
+
+		// Place the alien.
+
+		aliens[i] = SAlien{ city: chosenCityIndex, hp: sim.opts.HitPoints }
+		sim.spawned ++
+		sim.recordPath(i, chosenCityIndex)
+		sim.visit(chosenCityIndex, sim.iteration)
+		sim.liveAlienCounter ++
+		sim.emit(Event{ Iteration: sim.iteration, Type: EVENT_SPAWN, City: nodes[chosenCityIndex].cityName, Aliens: []int{ i } })
+
+		// Check if that alien placement caused a fight.
+
+		sim.arrive(i, chosenCityIndex, sim.iteration, true)
+	}
+
+	sim.checkTrapped(sim.iteration)
+	sim.sampleMemory()
+	return true
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Alien movement phase
+// ---------------------------------------------------------------------------------------------------
+
+// Returns true if a city has no road to a live city, so that the aliens in it are trapped. Roads
+//   and cities are never rebuilt, so they are trapped for good.
+func cityTrapped(nodes SNodeArray, city int) bool {
+	for _, road := range nodes[city].roads {
+		if (! nodes[road.to].dead) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if every alien still alive is in a city with no road to a live city.
+func allTrapped(nodes SNodeArray, aliens AlienArray) bool {
+	for i := 0; i < len(aliens); i++ {
+		if (aliens[i].city != -1) && (! cityTrapped(nodes, aliens[i].city)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts the aliens still alive that are in a city with no road to a live city.
+func trappedAliens(nodes SNodeArray, aliens AlienArray) int {
+	n := 0
+	for i := 0; i < len(aliens); i++ {
+		if (aliens[i].city != -1) && (cityTrapped(nodes, aliens[i].city)) {
+			n ++
+		}
+	}
+	return n
+}
+
+// Reports the aliens that have become trapped (see cityTrapped) since the last check, at movement
+//   step "iteration".
+func (sim *Simulator) checkTrapped(iteration int) {
+	for i, alien := range sim.aliens {
+		if (alien.city == -1) || (sim.trapped[i]) || (! cityTrapped(sim.nodes, alien.city)) {
+			continue
+		}
+		sim.trapped[i] = true
+		sim.emit(Event{ Iteration: iteration, Type: EVENT_TRAPPED, City: sim.nodes[alien.city].cityName, Aliens: []int{ i } })
+	}
+}
+
+// Chooses a random road out of city "city" that leads to a city that has not been destroyed.
+// Returns its direction and destination, or -1 and -1 if there is none (the alien is trapped).
+// Always draws one random number from "rnd", even if the alien is trapped.
+func (sim *Simulator) pickRoad(rnd *rand.Rand, city int) (int, int) {
+	nodes := sim.nodes
+	var anode *SNode = &nodes[city]
+
+	tryDirection := rnd.Intn(sim.numDirs);
+
+	for dr := 0; dr < sim.numDirs; dr ++ {
+
+		// Check if that direction is a valid movement direction
+
+		destCityIndex := anode.road(tryDirection)
+
+		// Skip roads to nowhere (-1) and roads to cities that are already dead
+		if (destCityIndex != -1) && (! nodes[destCityIndex].dead) {
+			return tryDirection, destCityIndex
+		}
+
+		tryDirection ++
+		if (tryDirection >= sim.numDirs) {
+			tryDirection = 0
+		}
+	}
+	return -1, -1
+}
+
+// The destination city defends itself against alien "i": with D defense points, it kills the
+//   incoming alien with probability D / (D + DEFENSE_SCALE). Returns true if the alien was killed.
+// The roll is skipped (and uses no random numbers) when defenses are disabled.
+func (sim *Simulator) repel(i int, destCityIndex int) bool {
+	if (! sim.repelled(sim.rnd)) {
+		return false
+	}
+	sim.killRepelled(i, destCityIndex)
+	return true
+}
+
+// Rolls whether a city repels an alien that tries to enter it, with a random number from "rnd".
+func (sim *Simulator) repelled(rnd *rand.Rand) bool {
+	if (sim.opts.DefenseRate <= 0) {
+		return false
+	}
+	defense := sim.Defense(sim.iteration + 1)
+	return rnd.Float64() < defense / (defense + DEFENSE_SCALE)
+}
+
+// Kills alien "i", which city "destCityIndex" has repelled.
+func (sim *Simulator) killRepelled(i int, destCityIndex int) {
+	sim.emit(Event{ Iteration: sim.iteration + 1, Type: EVENT_REPELLED, City: sim.nodes[destCityIndex].cityName, From: sim.nodes[sim.aliens[i].city].cityName, Aliens: []int{ i } })
+	sim.leave(i)
+	sim.aliens[i].city = -1
+	sim.liveAlienCounter --
+	sim.repelledCounter ++
+}
+
+// Moves alien "i" out of its city and into city "destCityIndex", without checking for fights.
+func (sim *Simulator) moveAlien(i int, destCityIndex int) {
+	sim.leave(i)    // remove this alien from the aliens of the previous location
+
+	if (sim.moveEvents) {
+		sim.emit(Event{ Iteration: sim.iteration + 1, Type: EVENT_MOVE, City: sim.nodes[destCityIndex].cityName, From: sim.nodes[sim.aliens[i].city].cityName, Aliens: []int{ i } })
+	}
+
+	sim.aliens[i].city = destCityIndex;
+	sim.recordPath(i, destCityIndex)
+	sim.visit(destCityIndex, sim.iteration + 1)
+}
+
+// Runs a single movement step, moving each alien randomly across a valid road to a city that has
+//   not been destroyed (some aliens can be trapped and unable to move, but if there IS a single
+//   valid path out of their current city, they must be able to take it).
+// With SimOptions.Movement set to MOVEMENT_SIMULTANEOUS, the aliens all move at once (see
+//   stepSimultaneous), and with MOVEMENT_PARALLEL too, picking their moves concurrently (see
+//   stepParallel); otherwise they move one after the other, in order.
+// Returns the number of fights that happened during the step.
+func (sim *Simulator) Step() (int, error) {
+	if (sim.opts.Movement == MOVEMENT_SIMULTANEOUS) {
+		return sim.stepSimultaneous()
+	}
+	if (sim.opts.Movement == MOVEMENT_PARALLEL) {
+		return sim.stepParallel()
+	}
+
+	nodes := sim.nodes
+	aliens := sim.aliens
+
+	var fights int = 0;
+
+	for i := 0; i < len(aliens); i++ {
+
+		if (aliens[i].city == -1) {
+			continue    // skip movement on dead aliens
+		}
+
+		// Choose one of the directions to roam, and check if the alien has nowhere to go.
+
+		chosenDirection, destCityIndex := sim.strategy.Choose(sim, i, aliens[i].city)
+
+		if (chosenDirection == -1) {
+			continue // Alien is just trapped.
+		}
+
+		// Move the alien.
+
+		// FIXME: Should be an assert.
+		if (destCityIndex == -1) || (nodes[destCityIndex].dead) {
+			return fights, fmt.Errorf("Simulator has a bug, moving Alien #%d to a bad destCityIndex %d", i, destCityIndex)
+		}
+
+		if (sim.repel(i, destCityIndex)) {
+			continue
+		}
+
+		sim.moveAlien(i, destCityIndex)
+
+		// Check if the destination city (where alien i moved in) already had enough aliens in it
+		//   to start a fight.
+
+		if (sim.arrive(i, destCityIndex, sim.iteration + 1, false)) {
+			fights ++
+		}
+	}
+
+	return fights, nil
+}
+
+// Runs a single movement step in which all the aliens move at once, so that no alien has an
+//   advantage from its position in the alien list:
+//
+//   1. Every live alien picks a road from the state of the map at the start of the step (and
+//      the destination city defends itself, as in sequential movement).
+//   2. Two aliens that cross the same road in opposite directions meet halfway, fight and
+//      die, and the road is destroyed.
+//   3. The other aliens arrive at their destinations. All the aliens arriving at a city, and
+//      the ones that were already there, fight together if they are enough (see arrive).
+//
+// Returns the number of fights (including the ones on roads) that happened during the step.
+func (sim *Simulator) stepSimultaneous() (int, error) {
+	aliens := sim.aliens
+
+	// 1. Pick the moves
+
+	var moves []alienMove
+	for i := 0; i < len(aliens); i++ {
+		if (aliens[i].city == -1) {
+			continue
+		}
+		dir, dest := sim.strategy.Choose(sim, i, aliens[i].city)
+		if (dir == -1) {
+			continue
+		}
+		if (sim.repel(i, dest)) {
+			continue
+		}
+		moves = append(moves, alienMove{ i, aliens[i].city, dir, dest })
+	}
+
+	return sim.resolveMoves(moves)
+}
+
+// A move picked by an alien in a simultaneous movement step.
+type alienMove struct {
+	alien  int
+	from   int
+	dir    int
+	to     int
+}
+
+// Runs the moves picked in a simultaneous movement step, in order: the mid-road fights first,
+//   then the arrivals (phases 2 and 3 of stepSimultaneous). Returns the number of fights.
+func (sim *Simulator) resolveMoves(moves []alienMove) (int, error) {
+	nodes := sim.nodes
+	aliens := sim.aliens
+	iteration := sim.iteration + 1
+
+	var fights int = 0;
+
+	// 2. Mid-road fights. The aliens are paired in order: each one meets the first alien that
+	//   has not met anyone yet and is crossing the same road the other way.
+
+	type roadEnd struct {
+		city  int
+		dir   int
+	}
+	crossing := make(map[roadEnd][]int)    // Moves waiting to be met, by the road end they leave from
+	clashed := make([]bool, len(moves))
+	for k, m := range moves {
+		back := roadEnd{ m.to, opposite(m.dir) }
+		if waiting := crossing[back]; (len(waiting) > 0) {
+			other := moves[waiting[0]]
+			crossing[back] = waiting[1:]
+			clashed[k], clashed[waiting[0]] = true, true
+
+			sim.emit(Event{ Iteration: iteration, Type: EVENT_CLASH, City: nodes[m.to].cityName, From: nodes[m.from].cityName, Aliens: []int{ m.alien, other.alien }, Dir: directionName(m.dir) })
+
+			sim.destroyRoad(m.from, m.dir, iteration, "")
+			for _, a := range []int{ m.alien, other.alien } {
+				sim.leave(a)
+				aliens[a].city = -1
+			}
+			sim.liveAlienCounter -= 2
+			fights ++
+			continue
+		}
+		here := roadEnd{ m.from, m.dir }
+		crossing[here] = append(crossing[here], k)
+	}
+
+	// 3. Arrivals, grouped by destination (in the order the destinations were first picked)
+
+	arrivals := make(map[int][]int)
+	var destinations []int
+	for k, m := range moves {
+		if (clashed[k]) {
+			continue
+		}
+		if (nodes[m.to].dead) {
+			return fights, fmt.Errorf("Simulator has a bug, moving Alien #%d to a bad destCityIndex %d", m.alien, m.to)
+		}
+		sim.moveAlien(m.alien, m.to)
+		if (len(arrivals[m.to]) == 0) {
+			destinations = append(destinations, m.to)
+		}
+		arrivals[m.to] = append(arrivals[m.to], m.alien)
+	}
+	for _, city := range destinations {
+		if (sim.arriveAll(arrivals[city], city, iteration, false)) {
+			fights ++
+		}
+	}
+
+	return fights, nil
+}
+
+// Runs one iteration of the movement phase: the iteration hooks, one movement step and then the
+//   termination checks. Does nothing if the simulation has already stopped.
+// After each step we check the optional termination conditions given in the options.
+func (sim *Simulator) Iterate() error {
+	if (sim.Stopped()) {
+		return nil
+	}
+
+	r := sim.iteration
+
+	if (r >= sim.opts.MaxSteps) {
+		sim.Stop("max-steps")
+		return nil
+	}
+
+	// Spawn the next wave of aliens when it is due, before the movement step.
+	if (sim.wavesPending()) && (r >= sim.wave * sim.opts.Waves.Interval) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_PHASE, "Wave %d of %d: spawning %d aliens at random cities at iteration %d.\n", sim.wave + 1, sim.opts.Waves.Count, sim.waveSize(sim.wave), r)
+		if (! sim.spawnWave(false)) {
+			return nil
+		}
+	}
+
+	if (sim.liveAlienCounter <= 0) && (! sim.wavesPending()) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_STOP, "We have %d aliens left alive at iteration %d. Stopping the simulator.\n", sim.liveAlienCounter, r)
+		sim.Stop("no-aliens-left")
+		return nil
+	}
+
+	if (sim.opts.BeforeIteration != nil) {
+		sim.opts.BeforeIteration(sim, r)
+	}
+
+	fights, err := sim.Step()
+	if (err == nil) {
+		err = sim.failure
+	}
+	if (err != nil) {
+		return err
+	}
+
+	if (sim.opts.RoadDecay > 0) {
+		sim.decayRoads(r + 1)
+	}
+
+	sim.checkTrapped(r + 1)
+	sim.iteration = r + 1
+
+	if (sim.opts.CheckInvariants) {
+		if err := sim.CheckInvariants(); err != nil {
+			return err
+		}
+	}
+
+	if (sim.iteration % MEMORY_SAMPLE_EVERY == 0) {
+		sim.sampleMemory()
+	}
+
+	if (sim.progress) && (sim.log.text(LOG_KIND_PROGRESS)) {
+		fmt.Fprintf(sim.out, ".")
+		sim.dot = true
+
+		var newPercent int = 100 * r / sim.opts.MaxSteps;
+		if (newPercent > sim.percent) {
+			sim.percent = newPercent
+			fmt.Fprintf(sim.out, "(%d%%)", sim.percent);
+		}
+	}
+
+	if (sim.opts.AfterIteration != nil) {
+		sim.opts.AfterIteration(sim, r)
+	}
+
+	// Check the optional termination conditions.
+
+	if (fights == 0) {
+		sim.quietSteps ++
+	} else {
+		sim.quietSteps = 0
+	}
+
+	// Aliens that are still on their way keep the simulation going.
+	if (sim.wavesPending()) {
+		return nil
+	}
+
+	if (sim.opts.StopAfterQuiescent > 0) && (sim.quietSteps >= sim.opts.StopAfterQuiescent) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_STOP, "No fights in the last %d steps at iteration %d. Stopping the simulator.\n", sim.quietSteps, sim.iteration)
+		sim.Stop("quiescent")
+	}
+
+	if (sim.opts.StopWhen.has(STOP_ALL_TRAPPED)) && (sim.liveAlienCounter > 0) && (allTrapped(sim.nodes, sim.aliens)) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_STOP, "All %d aliens left alive are trapped at iteration %d. Stopping the simulator.\n", sim.liveAlienCounter, sim.iteration)
+		sim.Stop(STOP_ALL_TRAPPED)
+	}
+
+	if (sim.opts.StopWhen.has(STOP_HALF_DESTROYED)) && (sim.deadCityCounter * 2 >= len(sim.nodes)) {
+		sim.endProgress()
+		sim.logf(LOG_INFO, LOG_KIND_STOP, "%d of %d cities are destroyed at iteration %d. Stopping the simulator.\n", sim.deadCityCounter, len(sim.nodes), sim.iteration)
+		sim.Stop(STOP_HALF_DESTROYED)
+	}
+
+	return nil
+}
+
+// Logs a message of the simulation (see Logger.logf).
+func (sim *Simulator) logf(level int, kind string, format string, args ...any) {
+	sim.log.logf(level, kind, sim.iteration, format, args...)
+}
+
+// Terminates the current line of progress dots, if any, so that a message can be printed.
+func (sim *Simulator) endProgress() {
+	if (sim.dot) {
+		sim.dot = false
+		fmt.Fprintf(sim.out, "\n")
+	}
+}
+
+// Runs movement steps until the step limit is reached or a termination condition holds.
+func (sim *Simulator) Run() error {
+	sim.logf(LOG_INFO, LOG_KIND_PHASE, "\nSimulation Phase #2: Moving aliens.\n\n");
+
+	for (! sim.Stopped()) {
+		if err := sim.Iterate(); err != nil {
+			return err
+		}
+	}
+
+	sim.endProgress()
+	sim.logf(LOG_INFO, LOG_KIND_STOP, "\nSimulation complete. Aliens remaining alive: %d\n", sim.liveAlienCounter);
+	return nil
+}
+
+// Builds the final report of the simulation.
+func (sim *Simulator) Summary(mapfile string) Summary {
+	sim.sampleMemory()
+	return Summary{
+		MapFile:          mapfile,
+		Aliens:           len(sim.aliens),
+		Cities:           len(sim.nodes),
+		CitiesDestroyed:  sim.deadCityCounter,
+		AliensAlive:      sim.liveAlienCounter,
+		Iterations:       sim.iteration,
+		LastFight:        sim.iteration - sim.quietSteps,
+		MaxSteps:         sim.opts.MaxSteps,
+		StopReason:       sim.stopReason,
+		Seed:             sim.seed,
+		CitiesVisited:    sim.visitedCounter,
+		AliensRepelled:   sim.repelledCounter,
+		AliensTrapped:    trappedAliens(sim.nodes, sim.aliens),
+		AliensWounded:    sim.woundedAliens(),
+		CitiesDamaged:    sim.damagedCities(),
+		AlienRatio:       sim.opts.AlienRatio,
+		FactionsAlive:    sim.factionsAlive(),
+		Population:       sim.population,
+		HumanCasualties:  sim.casualties,
+		AliensLost:       sim.spawned - sim.liveAlienCounter,
+		DefenderKills:    sim.defenderKills,
+		Waves:            sim.opts.Waves.Count,
+		WavesSpawned:     sim.wave,
+		AliensSpawned:    sim.spawned,
+		Roads:            sim.roads,
+		RoadsDestroyed:   sim.roadsDestroyed,
+		RoadsSurviving:   sim.RoadsSurviving(),
+		EstimatedMemory:  sim.estimatedMemory,
+		PeakMemory:       sim.peakMemory,
+	}
+}
+
+// Prints the final report of the simulation.
+func (s Summary) Print() {
+	fmt.Println("\nSummary:");
+	fmt.Printf("   Iterations run:    %d (limit %d)\n", s.Iterations, s.MaxSteps);
+	fmt.Printf("   Stop reason:       %s\n", s.StopReason);
+	fmt.Printf("   Cities destroyed:  %d of %d\n", s.CitiesDestroyed, s.Cities);
+	if (s.AlienRatio > 0) {
+		fmt.Printf("   Aliens alive:      %d of %d (%g per city)\n", s.AliensAlive, s.Aliens, s.AlienRatio);
+	} else {
+		fmt.Printf("   Aliens alive:      %d of %d\n", s.AliensAlive, s.Aliens);
+	}
+	fmt.Printf("   Roads surviving:   %d of %d (%d destroyed on their own)\n", s.RoadsSurviving, s.Roads, s.RoadsDestroyed);
+	if (s.Waves > 1) {
+		fmt.Printf("   Waves spawned:     %d of %d (%d aliens)\n", s.WavesSpawned, s.Waves, s.AliensSpawned);
+	}
+	for f, alive := range s.FactionsAlive {
+		fmt.Printf("      Faction #%d:     %d of %d\n", f, alive, factionSize(s.Aliens, len(s.FactionsAlive), f));
+	}
+	if (s.Population > 0) {
+		fmt.Printf("   Human casualties:  %d of %d\n", s.HumanCasualties, s.Population);
+		fmt.Printf("   Aliens lost:       %d (%d killed by defenders)\n", s.AliensLost, s.DefenderKills);
+	}
+	if (s.AliensRepelled > 0) {
+		fmt.Printf("   Aliens repelled:   %d\n", s.AliensRepelled);
+	}
+	if (s.AliensTrapped > 0) {
+		fmt.Printf("   Aliens trapped:    %d of %d alive\n", s.AliensTrapped, s.AliensAlive);
+	}
+	if (s.AliensWounded > 0) {
+		fmt.Printf("   Aliens wounded:    %d of %d alive\n", s.AliensWounded, s.AliensAlive);
+	}
+	if (s.CitiesDamaged > 0) {
+		fmt.Printf("   Cities damaged:    %d\n", s.CitiesDamaged);
+	}
+	fmt.Printf("   Cities visited:    %d of %d (%d never visited)\n", s.CitiesVisited, s.Cities, s.Cities - s.CitiesVisited);
+	fmt.Printf("   Peak memory:       %s (%s estimated for the model)\n", formatBytes(s.PeakMemory), formatBytes(s.EstimatedMemory));
+	fmt.Printf("   Random seed:       %d\n", s.Seed);
+}
@@ -0,0 +1,211 @@
+/*
+   Alien Invasion Simulator - alien spawn policies
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A spawn policy decides where each alien lands when it is spawned.
+// Policies see the simulation through the Simulator methods (e.g. Cities, CityDead, Roads) and must
+//   draw their random numbers from Rand, so that runs can be reproduced from their seed. A policy
+//   may keep state about the simulation it places aliens in, so each simulation needs its own.
+type SpawnPolicy interface {
+
+	// Chooses the city where alien "id" spawns, which must not have been destroyed, or returns -1
+	//   if there is no city left for it. Aliens are placed one after the other, in ID order, and
+	//   each alien lands (and possibly fights) before the next one is placed.
+	Place(sim *Simulator, id int) int
+}
+
+// The names of the built-in spawn policies, as given to --spawn-policy.
+const SPAWN_UNIFORM              string = "uniform"
+const SPAWN_CLUSTERED            string = "clustered"
+const SPAWN_PERIMETER            string = "perimeter"
+const SPAWN_WEIGHTED_DEGREE      string = "weighted-degree"
+const SPAWN_WEIGHTED_POPULATION  string = "weighted-population"
+
+// The number of road hops from the epicenter within which the clustered policy spawns aliens.
+const SPAWN_CLUSTER_RADIUS int = 2
+
+// Checks the name of a spawn policy ("" means uniform).
+func checkSpawnPolicy(policy string) error {
+	switch policy {
+	case "", SPAWN_UNIFORM, SPAWN_CLUSTERED, SPAWN_PERIMETER, SPAWN_WEIGHTED_DEGREE, SPAWN_WEIGHTED_POPULATION:
+		return nil
+	}
+	return fmt.Errorf("Unknown spawn policy '%s' (must be '%s', '%s', '%s', '%s' or '%s')", policy,
+		SPAWN_UNIFORM, SPAWN_CLUSTERED, SPAWN_PERIMETER, SPAWN_WEIGHTED_DEGREE, SPAWN_WEIGHTED_POPULATION)
+}
+
+// Creates the spawn policy for a set of simulation options.
+func newSpawnPolicy(opts SimOptions) SpawnPolicy {
+	if (opts.Spawner != nil) {
+		return opts.Spawner
+	}
+	if (opts.SpawnBorder) {
+		return &PerimeterSpawn{}
+	}
+	switch opts.SpawnPolicy {
+	case SPAWN_CLUSTERED:
+		return &ClusteredSpawn{ Radius: SPAWN_CLUSTER_RADIUS }
+	case SPAWN_PERIMETER:
+		return &PerimeterSpawn{}
+	case SPAWN_WEIGHTED_DEGREE:
+		return &WeightedSpawn{}
+	case SPAWN_WEIGHTED_POPULATION:
+		return &WeightedSpawn{ ByPopulation: true }
+	}
+	return UniformSpawn{}
+}
+
+// Picks a random city among "n" candidates, where "city" maps a candidate to its city index. If
+//   the city picked was destroyed, the next candidates are tried in turn. Returns -1 if every
+//   candidate was destroyed.
+func pickLiveCity(sim *Simulator, n int, city func(k int) int) int {
+	if (n == 0) {
+		return -1
+	}
+	k := sim.Rand().Intn(n)
+	for tries := 0; tries < n; tries++ {
+		if (! sim.CityDead(city(k))) {
+			return city(k)
+		}
+		k ++
+		if (k >= n) {
+			k = 0
+		}
+	}
+	return -1
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Uniform
+// ---------------------------------------------------------------------------------------------------
+
+// The default policy: every city that has not been destroyed is equally likely.
+type UniformSpawn struct {}
+
+func (UniformSpawn) Place(sim *Simulator, id int) int {
+	return pickLiveCity(sim, sim.Cities(), func(k int) int { return k })
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Perimeter
+// ---------------------------------------------------------------------------------------------------
+
+// Spawns the aliens at the border of the map only (see borderCities), as if they came from
+//   outside of it.
+type PerimeterSpawn struct {
+	border  []int      // The border cities, found on the first placement
+}
+
+// The border cities of the map of a simulation.
+func (p *PerimeterSpawn) cities(sim *Simulator) []int {
+	if (p.border == nil) {
+		p.border = borderCities(sim.nodes)
+	}
+	return p.border
+}
+
+func (p *PerimeterSpawn) Place(sim *Simulator, id int) int {
+	border := p.cities(sim)
+	return pickLiveCity(sim, len(border), func(k int) int { return border[k] })
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Clustered
+// ---------------------------------------------------------------------------------------------------
+
+// Spawns each wave of aliens around its own epicenter, a random city that has not been destroyed:
+//   the aliens land in random cities at most Radius roads away from it. If the cities around the
+//   epicenter are all destroyed while a wave lands, the rest of the wave picks a new epicenter.
+type ClusteredSpawn struct {
+	Radius   int
+	wave     int        // The wave that "cluster" was chosen for
+	cluster  []int      // The cities around the epicenter, the epicenter first
+}
+
+func (c *ClusteredSpawn) Place(sim *Simulator, id int) int {
+	if (c.cluster != nil) && (c.wave == sim.wave) {
+		city := pickLiveCity(sim, len(c.cluster), func(k int) int { return c.cluster[k] })
+		if (city != -1) {
+			return city
+		}
+	}
+
+	epicenter := UniformSpawn{}.Place(sim, id)
+	if (epicenter == -1) {
+		return -1
+	}
+	c.wave = sim.wave
+	c.cluster = sim.citiesAround(epicenter, c.Radius)
+	return pickLiveCity(sim, len(c.cluster), func(k int) int { return c.cluster[k] })
+}
+
+// The cities that have not been destroyed within "radius" roads of city "idx" (which comes first),
+//   in breadth-first order.
+func (sim *Simulator) citiesAround(idx int, radius int) []int {
+	cities := []int{ idx }
+	seen := map[int]bool{ idx: true }
+	frontier := 0
+	for hop := 0; hop < radius; hop++ {
+		end := len(cities)
+		for ; frontier < end; frontier++ {
+			for _, road := range sim.nodes[cities[frontier]].roads {
+				if (! seen[road.to]) && (! sim.nodes[road.to].dead) {
+					seen[road.to] = true
+					cities = append(cities, road.to)
+				}
+			}
+		}
+	}
+	return cities
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Weighted
+// ---------------------------------------------------------------------------------------------------
+
+// Spawns the aliens at random cities with a probability proportional to their number of roads to
+//   cities that have not been destroyed or, if ByPopulation is set, to their population. If every
+//   city weighs nothing (e.g. no city has a population), the cities are equally likely.
+type WeightedSpawn struct {
+	ByPopulation  bool
+	dead          int        // The number of destroyed cities when "cumulative" was computed
+	cumulative    []int64    // The running sum of the city weights, nil if not computed yet
+}
+
+// The weight of city "idx", which is 0 if it was destroyed.
+func (w *WeightedSpawn) weight(sim *Simulator, idx int) int64 {
+	if (sim.nodes[idx].dead) {
+		return 0
+	}
+	if (w.ByPopulation) {
+		return int64(sim.nodes[idx].pop)
+	}
+	return int64(len(sim.nodes[idx].roads) - sim.DeadNeighbors(idx))
+}
+
+func (w *WeightedSpawn) Place(sim *Simulator, id int) int {
+
+	// The weights only change when cities are destroyed
+	if (w.cumulative == nil) || (w.dead != sim.deadCityCounter) {
+		w.cumulative = make([]int64, len(sim.nodes))
+		var total int64
+		for i := 0; i < len(sim.nodes); i++ {
+			total += w.weight(sim, i)
+			w.cumulative[i] = total
+		}
+		w.dead = sim.deadCityCounter
+	}
+
+	if (len(w.cumulative) == 0) || (w.cumulative[len(w.cumulative) - 1] == 0) {
+		return UniformSpawn{}.Place(sim, id)
+	}
+	r := sim.Rand().Int63n(w.cumulative[len(w.cumulative) - 1])
+	return sort.Search(len(w.cumulative), func(k int) bool { return w.cumulative[k] > r })
+}
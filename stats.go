@@ -0,0 +1,97 @@
+/*
+   Alien Invasion Simulator - per-iteration statistics
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bufio"
+)
+
+// Writes a timeline of a simulation to a CSV file while it runs, one row per movement step, so
+//   that the destruction curves of a run can be plotted. The columns are:
+//
+//   iteration        the movement step (0 is the state right after the spawn phase)
+//   aliens_alive     the number of aliens still alive
+//   cities_alive     the number of cities not destroyed
+//   fights           the number of fights in the step (in cities or, with simultaneous movement, on roads)
+//   trapped_aliens   the number of live aliens in a city with no road to a live city
+//
+// The file is flushed after every movement step. Files whose name ends in GZIP_SUFFIX are
+//   written compressed.
+type StatsWriter struct {
+	filename  string
+	file      *os.File
+	w         *bufio.Writer
+	finish    func() error
+	fights    int
+	err       error
+}
+
+// The header row of the statistics file.
+const STATS_HEADER string = "iteration,aliens_alive,cities_alive,fights,trapped_aliens"
+
+// Creates the statistics file and writes its header.
+func newStatsWriter(filename string, overwrite bool) (*StatsWriter, error) {
+	if (! overwrite) {
+		if err := checkNoOverwrite(filename); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Create(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot write to statistics file '%s'", filename)
+	}
+	var w io.Writer
+	st := &StatsWriter{ filename: filename, file: file }
+	w, st.finish = compress(filename, file)
+	st.w = bufio.NewWriter(w)
+	_, st.err = fmt.Fprintln(st.w, STATS_HEADER)
+	return st, nil
+}
+
+// Starts counting the fights of a simulation and writing a row after every movement step. Must be
+//   called before the spawn phase so that the spawn-phase fights are counted in the first row.
+func (st *StatsWriter) attach(sim *Simulator) {
+	sim.Subscribe(func(ev Event) {
+		if (ev.Type == EVENT_DESTROYED) || (ev.Type == EVENT_FIGHT) || (ev.Type == EVENT_CLASH) || (ev.Type == EVENT_DEFENDED) {
+			st.fights ++
+		}
+	}, false)
+
+	sim.AddAfterIteration(func(s *Simulator, iteration int) {
+		st.sample(s)
+	})
+}
+
+// Writes the row of the current state of the simulation, with the fights since the last row.
+func (st *StatsWriter) sample(sim *Simulator) {
+	if (st.err == nil) {
+		_, st.err = fmt.Fprintf(st.w, "%d,%d,%d,%d,%d\n", sim.iteration, sim.liveAlienCounter,
+			len(sim.nodes) - sim.deadCityCounter, st.fights, trappedAliens(sim.nodes, sim.aliens))
+	}
+	if (st.err == nil) {
+		st.err = st.w.Flush()
+	}
+	st.fights = 0
+}
+
+// Closes the statistics file. The rows written so far are kept if the simulation did not complete.
+func (st *StatsWriter) close() error {
+	if (st.err == nil) {
+		st.err = st.w.Flush()
+	}
+	if err := st.finish(); (st.err == nil) {
+		st.err = err
+	}
+	if err := st.file.Close(); (st.err == nil) {
+		st.err = err
+	}
+	if (st.err != nil) {
+		return fmt.Errorf("Cannot write to statistics file '%s': %v", st.filename, st.err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+   Alien Invasion Simulator - alien movement strategies
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"math/rand"
+)
+
+// A movement strategy decides which road an alien takes out of its city in each movement step.
+// Strategies see the simulation through the Simulator methods (e.g. Roads, CityDead,
+//   DeadNeighbors) and must draw their random numbers from Rand, so that runs can be reproduced
+//   from their seed and resumed from checkpoints.
+type Strategy interface {
+
+	// Chooses the road that alien "id" takes out of city "city". Returns the direction of the
+	//   road and the city it leads to, which must not have been destroyed, or -1 and -1 if the
+	//   alien stays where it is.
+	Choose(sim *Simulator, id int, city int) (int, int)
+}
+
+// A strategy that can choose the moves of many aliens at once (see MOVEMENT_PARALLEL): it draws
+//   its random numbers from "rnd" instead of Rand, and only reads the simulation, so that it can be
+//   called from several goroutines.
+type ParallelStrategy interface {
+	Strategy
+	ChooseWith(sim *Simulator, rnd *rand.Rand, id int, city int) (int, int)
+}
+
+// The random number generator of the simulation, for strategies.
+func (sim *Simulator) Rand() *rand.Rand {
+	return sim.rnd
+}
+
+// The number of destroyed cities that city "idx" has a road to.
+func (sim *Simulator) DeadNeighbors(idx int) int {
+	dead := 0
+	for _, road := range sim.nodes[idx].roads {
+		if (sim.nodes[road.to].dead) {
+			dead ++
+		}
+	}
+	return dead
+}
+
+// Creates the movement strategy for a set of simulation options.
+func newStrategy(opts SimOptions) Strategy {
+	if (opts.Strategy != nil) {
+		return opts.Strategy
+	}
+	if (opts.FearOfRuins > 0) {
+		return FearOfRuins{ Fear: opts.FearOfRuins }
+	}
+	return RandomWalk{}
+}
+
+// The fear of ruins of the built-in FearOfRuins strategy, if not given.
+const DEFAULT_FEAR_OF_RUINS float64 = 0.5
+
+// A built-in movement strategy and its name.
+type NamedStrategy struct {
+	Name      string
+	Strategy  Strategy
+}
+
+// The built-in movement strategies, with the parameters set in "opts" (e.g. the fear of ruins).
+func builtinStrategies(opts SimOptions) []NamedStrategy {
+	fear := opts.FearOfRuins
+	if (fear <= 0) {
+		fear = DEFAULT_FEAR_OF_RUINS
+	}
+	return []NamedStrategy{
+		{ "random-walk", RandomWalk{} },
+		{ "fear-of-ruins", FearOfRuins{ Fear: fear } },
+	}
+}
+
+// Creates a movement strategy with the parameters set in the simulation options.
+type StrategyFactory func(opts SimOptions) Strategy
+
+// A movement strategy added with RegisterStrategy.
+type registeredStrategy struct {
+	name     string
+	factory  StrategyFactory
+}
+
+var strategyRegistry struct {
+	mu       sync.Mutex
+	entries  []registeredStrategy
+}
+
+// Registers a movement strategy under a name, so that the code that embeds the simulator can play
+//   its own strategies against the built-in ones (e.g. in a tournament). Names must be unique.
+func RegisterStrategy(name string, factory StrategyFactory) error {
+	strategyRegistry.mu.Lock()
+	defer strategyRegistry.mu.Unlock()
+	for _, s := range builtinStrategies(SimOptions{}) {
+		if (s.Name == name) {
+			return fmt.Errorf("Strategy '%s' is built in", name)
+		}
+	}
+	for _, r := range strategyRegistry.entries {
+		if (r.name == name) {
+			return fmt.Errorf("Strategy '%s' is already registered", name)
+		}
+	}
+	strategyRegistry.entries = append(strategyRegistry.entries, registeredStrategy{ name, factory })
+	return nil
+}
+
+// The built-in movement strategies followed by the registered ones, in order of registration.
+func allStrategies(opts SimOptions) []NamedStrategy {
+	strategyRegistry.mu.Lock()
+	defer strategyRegistry.mu.Unlock()
+	all := builtinStrategies(opts)
+	for _, r := range strategyRegistry.entries {
+		all = append(all, NamedStrategy{ r.name, r.factory(opts) })
+	}
+	return all
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Random walk
+// ---------------------------------------------------------------------------------------------------
+
+// The default strategy: take a random road to a city that has not been destroyed.
+type RandomWalk struct {}
+
+func (s RandomWalk) Choose(sim *Simulator, id int, city int) (int, int) {
+	return s.ChooseWith(sim, sim.rnd, id, city)
+}
+
+func (RandomWalk) ChooseWith(sim *Simulator, rnd *rand.Rand, id int, city int) (int, int) {
+	return sim.pickRoad(rnd, city)
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Fear of ruins
+// ---------------------------------------------------------------------------------------------------
+
+// A random walk that shies away from destroyed cities: when the random road leads to a city next
+//   to ruins (a city with a road to a destroyed city), the alien takes, with probability Fear, a
+//   random road to a city that is not next to ruins instead, if there is one.
+// The extra random numbers are only drawn when the random road leads next to ruins.
+type FearOfRuins struct {
+	Fear  float64
+}
+
+func (s FearOfRuins) Choose(sim *Simulator, id int, city int) (int, int) {
+	return s.ChooseWith(sim, sim.rnd, id, city)
+}
+
+func (s FearOfRuins) ChooseWith(sim *Simulator, rnd *rand.Rand, id int, city int) (int, int) {
+	dir, dest := sim.pickRoad(rnd, city)
+	if (dir == -1) || (sim.DeadNeighbors(dest) == 0) {
+		return dir, dest
+	}
+	if (rnd.Float64() >= s.Fear) {
+		return dir, dest
+	}
+
+	var safe []SRoad
+	for _, road := range sim.Roads(city) {
+		if (! sim.CityDead(road.to)) && (sim.DeadNeighbors(road.to) == 0) {
+			safe = append(safe, road)
+		}
+	}
+	if (len(safe) == 0) {
+		return dir, dest
+	}
+	road := safe[rnd.Intn(len(safe))]
+	return road.dir, road.to
+}
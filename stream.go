@@ -0,0 +1,128 @@
+/*
+   Alien Invasion Simulator - incremental result streaming
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"bufio"
+	"encoding/json"
+)
+
+// Streams the progress of a simulation to a file while it runs, so that a run that is interrupted
+//   or crashes still leaves usable partial output. The file has one JSON record per line:
+//
+//   {"type":"destroyed", ...}   every destruction event, as it happens (see Event)
+//   {"type":"repelled", ...}    every alien killed by the defenses of a city
+//   {"type":"fight", ...}       every fight that left the city standing
+//   {"type":"clash", ...}       every fight on a road, which destroys the road
+//   {"type":"defended", ...}    every fight won by the defenders of a city
+//   {"type":"road-destroyed", ...}  every road destroyed by decay or collateral damage
+//   {"type":"trapped", ...}     every alien left with no road to a live city
+//   {"type":"partial", ...}     the state of the simulation every N movement steps (see PartialResult)
+//   {"type":"end", ...}         the final summary, when the simulation completes
+//
+// The file is flushed after every movement step.
+type StreamWriter struct {
+	filename  string
+	file      *os.File
+	w         *bufio.Writer
+	enc       *json.Encoder
+	every     int
+	err       error
+}
+
+// A snapshot of the simulation state written to the stream.
+type PartialResult struct {
+	Type             string          `json:"type"`
+	Iteration        int             `json:"iteration"`
+	AliensAlive      int             `json:"aliens_alive"`
+	CitiesDestroyed  int             `json:"cities_destroyed"`
+	AlienCities      map[int]string  `json:"alien_cities"`    // Where each live alien is
+}
+
+// The last record of a stream.
+type StreamEnd struct {
+	Type     string   `json:"type"`
+	Summary  Summary  `json:"summary"`
+}
+
+// Creates the stream file. A partial result is written every "every" movement steps (0 disables them).
+func newStreamWriter(filename string, every int, overwrite bool) (*StreamWriter, error) {
+	if (! overwrite) {
+		if err := checkNoOverwrite(filename); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Create(filename)
+	if (err != nil) {
+		return nil, fmt.Errorf("Cannot write to stream output file '%s'", filename)
+	}
+	sw := &StreamWriter{ filename: filename, file: file, w: bufio.NewWriter(file), every: every }
+	sw.enc = json.NewEncoder(sw.w)
+	return sw, nil
+}
+
+// Writes a record to the stream. The first write error is kept and reported by close().
+func (sw *StreamWriter) write(v interface{}) {
+	if (sw.err == nil) {
+		sw.err = sw.enc.Encode(v)
+	}
+}
+
+func (sw *StreamWriter) flush() {
+	if (sw.err == nil) {
+		sw.err = sw.w.Flush()
+	}
+}
+
+// Starts streaming the events and partial results of a simulation. Must be called before the
+//   spawn phase so that the spawn-phase destructions are streamed too.
+func (sw *StreamWriter) attach(sim *Simulator) {
+	sim.Subscribe(func(ev Event) {
+		if (ev.Type == EVENT_DESTROYED) || (ev.Type == EVENT_REPELLED) || (ev.Type == EVENT_FIGHT) || (ev.Type == EVENT_CLASH) || (ev.Type == EVENT_DEFENDED) || (ev.Type == EVENT_ROAD_DESTROYED) || (ev.Type == EVENT_TRAPPED) {
+			sw.write(ev)
+		}
+	}, false)
+
+	sim.AddAfterIteration(func(s *Simulator, iteration int) {
+		if (sw.every > 0) && (s.iteration % sw.every == 0) {
+			sw.write(s.partialResult())
+		}
+		sw.flush()
+	})
+}
+
+// Builds a snapshot of the simulation state.
+func (sim *Simulator) partialResult() PartialResult {
+	pr := PartialResult{
+		Type:             "partial",
+		Iteration:        sim.iteration,
+		AliensAlive:      sim.liveAlienCounter,
+		CitiesDestroyed:  sim.deadCityCounter,
+		AlienCities:      make(map[int]string),
+	}
+	for i, alien := range sim.aliens {
+		if (alien.city != -1) {
+			pr.AlienCities[i] = sim.nodes[alien.city].cityName
+		}
+	}
+	return pr
+}
+
+// Writes the final record (if the simulation completed) and closes the stream file.
+func (sw *StreamWriter) close(summary *Summary) error {
+	if (summary != nil) {
+		sw.write(StreamEnd{ Type: "end", Summary: *summary })
+	}
+	sw.flush()
+	if err := sw.file.Close(); (sw.err == nil) {
+		sw.err = err
+	}
+	if (sw.err != nil) {
+		return fmt.Errorf("Cannot write to stream output file '%s': %v", sw.filename, sw.err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+   Alien Invasion Simulator - symmetric map generation
+*/
+
+package main
+
+import (
+	"fmt"
+)
+
+// Map symmetries, for the -symmetry option, and the mirror axis of each.
+const SYMMETRY_HORIZONTAL string = "horizontal"   // the east half mirrors the west half
+const SYMMETRY_VERTICAL   string = "vertical"     // the south half mirrors the north half
+const SYMMETRY_ROTATIONAL string = "rotational"   // the map looks the same when turned 180 degrees
+
+var symmetryAxis = map[string]string{
+	SYMMETRY_HORIZONTAL: MIRROR_HORIZONTAL,
+	SYMMETRY_VERTICAL:   MIRROR_VERTICAL,
+	SYMMETRY_ROTATIONAL: MIRROR_BOTH,
+}
+
+// Returns an error if a symmetry can't be generated with the given topology and dimensions.
+// The honeycomb of the hex topology (see adjacent()) only has a south road at every other node,
+//   and flipping the grid must map those nodes onto each other, which depends on the parity of
+//   the dimensions.
+func checkSymmetry(symmetry string, topology string, width int, height int) error {
+	if (symmetry == "") {
+		return nil
+	}
+	if _, ok := symmetryAxis[symmetry]; ! ok {
+		return fmt.Errorf("Unknown symmetry '%s'", symmetry)
+	}
+	if (topology == TOPOLOGY_RANDOM_GRAPH) {
+		return fmt.Errorf("The random-graph topology can't be symmetric")
+	}
+	if (topology == TOPOLOGY_HEX) {
+		switch {
+		case (symmetry == SYMMETRY_HORIZONTAL) && (width % 2 == 0):
+			return fmt.Errorf("Horizontal symmetry of a hex grid needs an odd width")
+		case (symmetry == SYMMETRY_VERTICAL) && (height % 2 == 1):
+			return fmt.Errorf("Vertical symmetry of a hex grid needs an even height")
+		case (symmetry == SYMMETRY_ROTATIONAL) && ((width + height) % 2 == 0):
+			return fmt.Errorf("Rotational symmetry of a hex grid needs an odd width plus height")
+		}
+	}
+	return nil
+}
+
+// Makes a world symmetric by copying one half of it over the other: every node and road that is
+//   not the first (in row order) of itself and its mirror image is replaced with its image. Roads
+//   left without a city at one end are then removed (which is symmetric too).
+func (wmap World) symmetrize(symmetry string) {
+	axis := symmetryAxis[symmetry]
+	height := len(wmap)
+	width := len(wmap[0])
+	image := wmap.mirror(axis)
+	first := func(x int, y int, ix int, iy int) bool { return (y < iy) || ((y == iy) && (x <= ix)) }
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if ix, iy := mirrorNode(axis, width, height, x, y); ! first(x, y, ix, iy) {
+				wmap[y][x].cityName = image[y][x].cityName
+			}
+			for _, dir := range []int{ EAST, SOUTH } {
+				if ix, iy := mirrorRoad(axis, width, height, x, y, dir); ! first(x, y, ix, iy) {
+					wmap[y][x].roads[dir] = image[y][x].roads[dir]
+				}
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for _, dir := range []int{ EAST, SOUTH } {
+				nx, ny := wmap.neighbor(x, y, dir)
+				if (wmap[y][x].cityName == "") || (wmap[ny][nx].cityName == "") {
+					wmap[y][x].roads[dir] = false
+				}
+			}
+		}
+	}
+	wmap.renameMirrored()
+}
+
+// Restores the symmetry of a world after roads and cities were added to it (e.g. by connect()),
+//   by adding the mirror image of every road and city. Since nothing is removed, a connected
+//   world stays connected. Returns the number of roads and cities added.
+func (wmap World) closeSymmetry(symmetry string) (newRoads int, newCities int) {
+	image := wmap.mirror(symmetryAxis[symmetry])
+	for y := 0; y < len(wmap); y++ {
+		for x := 0; x < len(wmap[y]); x++ {
+			if (wmap[y][x].cityName == "") && (image[y][x].cityName != "") {
+				wmap.placeCity(x, y)
+				newCities ++
+			}
+			for _, dir := range []int{ EAST, SOUTH } {
+				if (! wmap[y][x].roads[dir]) && (image[y][x].roads[dir]) {
+					wmap[y][x].roads[dir] = true
+					newRoads ++
+				}
+			}
+		}
+	}
+	return newRoads, newCities
+}
+
+// Names the cities of a world after their coordinates again, since copying the cities of one
+//   half over the other copies their names too.
+func (wmap World) renameMirrored() {
+	for y := 0; y < len(wmap); y++ {
+		for x := 0; x < len(wmap[y]); x++ {
+			if (wmap[y][x].cityName != "") {
+				wmap.placeCity(x, y)
+			}
+		}
+	}
+}
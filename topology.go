@@ -0,0 +1,187 @@
+/*
+   Alien Invasion Simulator - map generator topologies
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Map generator topologies, for the -topology option.
+const TOPOLOGY_GRID         string = "grid"           // bounded rectangular grid (the default)
+const TOPOLOGY_TORUS        string = "torus"          // grid whose east and south edges wrap around to the west and north edges
+const TOPOLOGY_HEX          string = "hex"            // hexagonal (honeycomb) grid
+const TOPOLOGY_RANDOM_GRAPH string = "random-graph"   // cities connected at random, with no geometry
+
+// Returns an error if the topology is not one of the TOPOLOGY_* values.
+func checkTopology(topology string) error {
+	switch (topology) {
+	case TOPOLOGY_GRID, TOPOLOGY_TORUS, TOPOLOGY_HEX, TOPOLOGY_RANDOM_GRAPH:
+		return nil
+	}
+	return fmt.Errorf("Unknown topology '%s'", topology)
+}
+
+// Returns true if the node at X,Y of a "width" by "height" world can have a road in direction
+//   "dir" (EAST or SOUTH) in the given topology.
+// The hex topology is a honeycomb drawn as a brick wall: every node has its east and west
+//   neighbors, but only every other node (in a checkerboard pattern) has a south neighbor, so
+//   each node has three neighbors, like the cells of a hexagonal grid, using only the four
+//   directions of the map file format.
+// The torus only wraps a dimension with at least 3 nodes; with 2 nodes the wrapping road would
+//   join the same two nodes as the inner one, and with 1 node it would be a road to itself.
+func adjacent(topology string, width int, height int, x int, y int, dir int) bool {
+	if (dir == EAST) {
+		return (x < width - 1) || ((topology == TOPOLOGY_TORUS) && (width > 2))
+	}
+	switch (topology) {
+	case TOPOLOGY_TORUS:
+		return (y < height - 1) || (height > 2)
+	case TOPOLOGY_HEX:
+		return (y < height - 1) && ((x + y) % 2 == 0)
+	}
+	return y < height - 1
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Random graph topology
+// ---------------------------------------------------------------------------------------------------
+
+// Generates a random graph with about "maxx" * "maxy" * "cd" cities and an average degree of
+//   about 4 * "rd" (i.e. "rd" is the fraction of the four road slots of each city that is used),
+//   and writes it to a map file.
+// Roads are placed by picking a random city, one of its free directions and a random city whose
+//   opposite direction is free, so every road stays reciprocal (an east road is always the west
+//   road of the other city). Two cities are joined by at most one road. As the graph fills up,
+//   free slots get hard to match, so the average degree may fall a bit short of the target when
+//   "rd" is close to 1.
+// City names that encode coordinates make no sense here, so "coords" names are replaced with the
+//   pseudonyms C1, C2, ... (see anonymize.go).
+func generateRandomGraph(mapfile string, maxx int, maxy int, cd float64, rd float64, opts GenOptions, namer CityNamer) error {
+	n := int(math.Round(float64(maxx * maxy) * cd))
+	target := int(math.Round(2 * float64(n) * rd))
+
+	nodes := make(SNodeArray, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = SNode{ index: i, lastVisit: -1 }
+		if (opts.Names == "coords") {
+			nodes[i].cityName = pseudonym(i)
+		} else {
+			nodes[i].cityName = namer(i, 0)
+		}
+	}
+
+	joined := func(a int, b int) bool {
+		for _, r := range nodes[a].roads {
+			if (r.to == b) {
+				return true
+			}
+		}
+		return false
+	}
+	link := func(a int, d int, b int) {
+		nodes[a].setRoad(d, b)
+		nodes[b].setRoad(opposite(d), a)
+	}
+
+	roads := 0
+	for attempt := 0; (roads < target) && (attempt < 20 * target + 100); attempt++ {
+		a := rnd.Intn(n)
+		d := rnd.Intn(4)
+		b := rnd.Intn(n)
+		if (a == b) || (nodes[a].road(d) != -1) || (nodes[b].road(opposite(d)) != -1) || (joined(a, b)) {
+			continue
+		}
+		link(a, d, b)
+		roads ++
+	}
+
+	if (opts.Connected) {
+		added, ok := connectGraph(nodes, link)
+		roads += added
+		fmt.Printf("Connected the map by adding %d roads.\n", added);
+		if (! ok) {
+			fmt.Println("WARNING: Some cities have no free road slots left and could not be connected.");
+		}
+	}
+
+	err := writeFileAtomic(mapfile, true, func(w io.Writer) error {
+		return writeMap(w, nodes)
+	})
+	if (err != nil) {
+		return err
+	}
+
+	printGenerated(n, roads)
+	return nil
+}
+
+// Joins the connected components of a graph by linking each of them to the largest one, using
+//   free road slots on both sides. Returns the number of roads added, and false if some component
+//   could not be linked.
+func connectGraph(nodes SNodeArray, link func(a int, d int, b int)) (int, bool) {
+	seen := make([]bool, len(nodes))
+	var components [][]int
+	main := -1
+	for i := 0; i < len(nodes); i++ {
+		if (seen[i]) {
+			continue
+		}
+		var comp []int
+		bfs(nodes, i, seen, func(city int, dist int) { comp = append(comp, city) })
+		components = append(components, comp)
+		if (main == -1) || (len(comp) > len(components[main])) {
+			main = len(components) - 1
+		}
+	}
+
+	// Cities of the main component with a free slot in each direction. Entries may go stale as
+	//   slots are used, so they are checked again when taken.
+	var free [4][]int
+	addFree := func(comp []int) {
+		for _, c := range comp {
+			for d := 0; d < 4; d++ {
+				if (nodes[c].road(d) == -1) {
+					free[d] = append(free[d], c)
+				}
+			}
+		}
+	}
+	addFree(components[main])
+
+	added, ok := 0, true
+	for k, comp := range components {
+		if (k == main) {
+			continue
+		}
+		linked := false
+		for _, a := range comp {
+			for d := 0; (d < 4) && (! linked); d++ {
+				if (nodes[a].road(d) != -1) {
+					continue
+				}
+				od := opposite(d)
+				for (len(free[od]) > 0) && (nodes[free[od][len(free[od]) - 1]].road(od) != -1) {
+					free[od] = free[od][:len(free[od]) - 1]
+				}
+				if (len(free[od]) > 0) {
+					link(a, d, free[od][len(free[od]) - 1])
+					linked = true
+				}
+			}
+			if (linked) {
+				break
+			}
+		}
+		if (! linked) {
+			ok = false
+			continue
+		}
+		added ++
+		addFree(comp)
+	}
+	return added, ok
+}
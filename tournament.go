@@ -0,0 +1,255 @@
+/*
+   Alien Invasion Simulator - strategy tournament
+*/
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"math"
+	"sort"
+	"path/filepath"
+	"strings"
+)
+
+// The accumulated outcome of the tournament games of one movement strategy.
+type TournamentEntry struct {
+	name        string
+	strategy    Strategy
+	aliens      int        // Aliens spawned, summed over all games
+	alive       int        // Aliens alive at the end, summed over all games
+	cities      int        // Cities, summed over all games
+	destroyed   int        // Cities destroyed, summed over all games
+	iterations  int        // Iterations run, summed over all games
+	lastFights  int        // Movement steps until the last fight (quiescence), summed over all games
+	wins        int        // Games in which this strategy kept the most aliens alive (ties are no win)
+	survivals   []float64  // Fraction of its aliens that the strategy kept alive in each game, in order
+}
+
+// The fraction of its aliens that a strategy kept alive over all games.
+func (e *TournamentEntry) survival() float64 {
+	if (e.aliens == 0) {
+		return 0
+	}
+	return float64(e.alive) / float64(e.aliens)
+}
+
+// The fraction of the cities that a strategy destroyed over all games.
+func (e *TournamentEntry) destruction() float64 {
+	if (e.cities == 0) {
+		return 0
+	}
+	return float64(e.destroyed) / float64(e.cities)
+}
+
+// The outcome of a sign test of the games of two strategies, paired by map and seed.
+// Counts the games in which the first strategy kept a larger fraction of its aliens alive, and
+//   the ones in which the second did (ties are left out), and returns the two-sided p-value of
+//   such a split if both strategies were as good: the probability that a fair coin tossed once
+//   per game that is not a tie gives a split at least as uneven.
+func signTest(a []float64, b []float64) float64 {
+	more, less := 0, 0
+	for g := range a {
+		if (a[g] > b[g]) {
+			more ++
+		} else if (a[g] < b[g]) {
+			less ++
+		}
+	}
+	n, k := more + less, min(more, less)
+	if (n == 0) {
+		return 1
+	}
+
+	// 2 * P(X <= k) for X ~ Binomial(n, 1/2), with the coefficients in logarithms so that
+	//   they don't overflow for many games.
+	lgn, _ := math.Lgamma(float64(n + 1))
+	p := 0.0
+	for i := 0; i <= k; i++ {
+		lgi, _ := math.Lgamma(float64(i + 1))
+		lgni, _ := math.Lgamma(float64(n - i + 1))
+		p += math.Exp(lgn - lgi - lgni - float64(n) * math.Ln2)
+	}
+	return math.Min(1, 2 * p)
+}
+
+// Marks a p-value with "*" if it is significant at the 5% level, and "**" at the 1% level.
+func significance(p float64) string {
+	switch {
+	case (p < 0.01):
+		return "**"
+	case (p < 0.05):
+		return "*"
+	}
+	return ""
+}
+
+// Plays every movement strategy (built in or registered) on every map with the seeds "opts.Seed" to
+//   "opts.Seed + runs - 1", and prints the strategies ranked by the fraction of their aliens that
+//   survived, then by the fraction of the cities they destroyed.
+// Every strategy plays each game (a map and a seed) with the same options, so the outcome only
+//   depends on the strategies, and the tournament is reproducible.
+func tournament(mapfiles []string, numaliens int, runs int, opts SimOptions) error {
+	entries := []*TournamentEntry{}
+	for _, s := range allStrategies(opts) {
+		entries = append(entries, &TournamentEntry{ name: s.Name, strategy: s.Strategy })
+	}
+	if (opts.Script != "") {
+		s, err := loadScript(opts.Script)
+		if (err != nil) {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(opts.Script), filepath.Ext(opts.Script))
+		entries = append(entries, &TournamentEntry{ name: name, strategy: s })
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+
+	if (opts.AlienRatio > 0) {
+		fmt.Printf("Will play a tournament of %d strategies (%s) on %d map(s) with %g aliens per city over %d seed(s).\n",
+			len(entries), strings.Join(names, ", "), len(mapfiles), opts.AlienRatio, runs)
+	} else {
+		fmt.Printf("Will play a tournament of %d strategies (%s) on %d map(s) with %d aliens over %d seed(s).\n",
+			len(entries), strings.Join(names, ", "), len(mapfiles), numaliens, runs)
+	}
+
+	ties := 0
+	for _, mapfile := range mapfiles {
+		nodes, nodeMap, err := loadMap(mapfile)
+		if (err != nil) {
+			return err
+		}
+		n := numaliens
+		if (opts.AlienRatio > 0) {
+			n = autoAliens(nodes, opts.AlienRatio)
+		}
+
+		for i := 0; i < runs; i++ {
+			best, bestAlive := -1, -1
+			for k, e := range entries {
+				gameOpts := opts
+				gameOpts.Strategy = e.strategy
+				s, err := runQuiet(mapfile, nodes, nodeMap, n, gameOpts, opts.Seed + int64(i))
+				if (err != nil) {
+					return err
+				}
+				e.aliens += s.AliensSpawned
+				e.alive += s.AliensAlive
+				e.cities += s.Cities
+				e.destroyed += s.CitiesDestroyed
+				e.iterations += s.Iterations
+				e.lastFights += s.LastFight
+				survival := 0.0
+				if (s.AliensSpawned > 0) {
+					survival = float64(s.AliensAlive) / float64(s.AliensSpawned)
+				}
+				e.survivals = append(e.survivals, survival)
+
+				if (s.AliensAlive > bestAlive) {
+					best, bestAlive = k, s.AliensAlive
+				} else if (s.AliensAlive == bestAlive) {
+					best = -1
+				}
+			}
+			if (best >= 0) {
+				entries[best].wins ++
+			} else {
+				ties ++
+			}
+		}
+		fmt.Printf("Played %d game(s) on map '%s' with %d aliens.\n", runs, mapfile, n)
+	}
+
+	// ---------------------------------------------------------------------------------------------------
+	// Ranked report (averages over all games)
+	// ---------------------------------------------------------------------------------------------------
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		if (entries[a].survival() != entries[b].survival()) {
+			return entries[a].survival() > entries[b].survival()
+		}
+		return entries[a].destruction() > entries[b].destruction()
+	})
+
+	games := float64(len(mapfiles) * runs)
+	fmt.Println("\nTournament:");
+	fmt.Printf("   %-4s %-18s %6s %17s %17s %10s %10s  %s\n", "Rank", "Strategy", "Wins", "Aliens alive", "Cities lost", "Quiescence", "Iterations", "vs #1");
+	for i, e := range entries {
+		vs := "-"
+		if (i > 0) {
+			p := signTest(entries[0].survivals, e.survivals)
+			vs = strings.TrimSpace(fmt.Sprintf("p=%.3f %s", p, significance(p)))
+		}
+		fmt.Printf("   %-4d %-18s %6d %8.1f (%5.1f%%) %8.1f (%5.1f%%) %10.1f %10.1f  %s\n", i + 1, e.name, e.wins,
+			float64(e.alive) / games, 100 * e.survival(), float64(e.destroyed) / games, 100 * e.destruction(),
+			float64(e.lastFights) / games, float64(e.iterations) / games, vs);
+	}
+	fmt.Printf("   Games: %d (%d map(s) x %d seed(s), seeds %d to %d); ties: %d.\n",
+		int(games), len(mapfiles), runs, opts.Seed, opts.Seed + int64(runs) - 1, ties);
+	fmt.Println("   Aliens alive, cities lost, quiescence (steps until the last fight) and iterations are");
+	fmt.Println("   averages per game. 'vs #1' is the p-value of a sign test of the aliens kept alive in");
+	fmt.Println("   each game against the first strategy (* significant at 5%, ** at 1%).");
+	return nil
+}
+
+// Handles the command line of the tournament mode (the alien count may also come last, e.g.
+//   -tournament <MAPFILE> <NUMALIENS>):
+//   tournament <NUMALIENS> <MAPFILE>... [-runs N] [-seed S] [-script F] [-max-steps N] [-stop-when C]
+//              [-stop-after-quiescent K] [-spawn-border] [-spawn-policy P] [-defense-rate R]
+//              [-fight-threshold N] [-spare-cities] [-combat-model M] [-hit-points N]
+//              [-city-hit-points N] [-movement M] [-factions K] [-road-decay P] [-collateral P]
+//              [-fear-of-ruins P] [-defenders] [-waves CxN]
+func mainTournament(args []string) int {
+	runs := 10
+	opts := defaultSimOptions()
+	opts.Seed = 1
+	flags := flag.NewFlagSet("tournament", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.IntVar(&runs, "runs", runs, "number of games (with consecutive seeds) on each map")
+	flags.Int64Var(&opts.Seed, "seed", opts.Seed, "seed for the random number generator of the first game")
+	flags.StringVar(&opts.Script, "script", opts.Script, "also play the movement strategy of this Starlark file")
+	modelFlags(flags, &opts)
+
+	// The map files are all the arguments before the first option
+	n := 1
+	for (n < len(args)) && (! strings.HasPrefix(args[n], "-")) {
+		n ++
+	}
+	if (n < 2) {
+		fmt.Println("Too few arguments for tournament mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[n:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for tournament mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	if err := checkSimOptions(&opts); err != nil {
+		fmt.Printf("Tournament: %s.\n", err);
+		return usageError()
+	}
+
+	count, mapfiles := args[0], args[1:n]
+	if _, _, err := parseAlienCount(count); (err != nil) {
+		if _, _, err := parseAlienCount(args[n - 1]); (err == nil) {
+			count, mapfiles = args[n - 1], args[:n - 1]
+		}
+	}
+	numaliens, ratio, err := parseAlienCount(count)
+	if (err != nil) {
+		fmt.Printf("Tournament: %s.\n", err);
+		return usageError()
+	}
+	if (runs < 1) || (opts.Seed == 0) {
+		fmt.Println("Tournament: The number of runs must be positive and the seed must not be 0.");
+		return usageError()
+	}
+	opts.AlienRatio = ratio
+
+	return reportError(tournament(mapfiles, numaliens, runs, opts))
+}
@@ -0,0 +1,218 @@
+/*
+   Alien Invasion Simulator - grid map transforms
+*/
+
+package main
+
+import (
+	"fmt"
+	"flag"
+	"strconv"
+	"strings"
+)
+
+// Mirror axes, for the -mirror option.
+const MIRROR_HORIZONTAL string = "horizontal"   // flip left to right (east becomes west)
+const MIRROR_VERTICAL   string = "vertical"     // flip top to bottom (south becomes north)
+const MIRROR_BOTH       string = "both"         // both of the above, i.e. a 180 degree rotation
+
+// Returns the part of a world from X0,Y0 to X1,Y1 (inclusive). Roads that leave the cropped
+//   area, including the roads that wrap around a torus, are dropped.
+func (wmap World) crop(x0 int, y0 int, x1 int, y1 int) World {
+	cropped := make(World, y1 - y0 + 1)
+	for y := y0; y <= y1; y++ {
+		cropped[y - y0] = append([]Node{}, wmap[y][x0:x1 + 1]...)
+		row := cropped[y - y0]
+		row[len(row) - 1].roads[EAST] = false
+		if (y == y1) {
+			for x := range row {
+				row[x].roads[SOUTH] = false
+			}
+		}
+	}
+	return cropped
+}
+
+// The position of node X,Y of a "width" by "height" world once flipped along an axis.
+func mirrorNode(axis string, width int, height int, x int, y int) (int, int) {
+	if (axis == MIRROR_HORIZONTAL) || (axis == MIRROR_BOTH) { x = width - 1 - x }
+	if (axis == MIRROR_VERTICAL) || (axis == MIRROR_BOTH) { y = height - 1 - y }
+	return x, y
+}
+
+// The node that holds the EAST or SOUTH road of node X,Y once the world is flipped along an axis.
+// An east road at X joins X and X + 1, which end up at W - 1 - X and W - 2 - X, so it becomes the
+//   road of W - 2 - X (which wraps around to W - 1 for the road that wraps around a torus).
+func mirrorRoad(axis string, width int, height int, x int, y int, dir int) (int, int) {
+	flipx := (axis == MIRROR_HORIZONTAL) || (axis == MIRROR_BOTH)
+	flipy := (axis == MIRROR_VERTICAL) || (axis == MIRROR_BOTH)
+	if (dir == EAST) {
+		if (flipx) { x = (2 * width - 2 - x) % width }
+		if (flipy) { y = height - 1 - y }
+	} else {
+		if (flipx) { x = width - 1 - x }
+		if (flipy) { y = (2 * height - 2 - y) % height }
+	}
+	return x, y
+}
+
+// Returns a world flipped left to right, top to bottom, or both (see the MIRROR_* values).
+func (wmap World) mirror(axis string) World {
+	height := len(wmap)
+	width := len(wmap[0])
+	at := func(x int, y int) (int, int) { return mirrorNode(axis, width, height, x, y) }
+	road := func(x int, y int, dir int) (int, int) { return mirrorRoad(axis, width, height, x, y, dir) }
+
+	mirrored := make(World, height)
+	for y := 0; y < height; y++ {
+		mirrored[y] = make([]Node, width)
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mx, my := at(x, y)
+			mirrored[my][mx].cityName = wmap[y][x].cityName
+			for _, dir := range []int{ EAST, SOUTH } {
+				if (wmap[y][x].roads[dir]) {
+					rx, ry := road(x, y, dir)
+					mirrored[ry][rx].roads[dir] = true
+				}
+			}
+		}
+	}
+	return mirrored
+}
+
+// Turns a world into a torus by adding the roads that wrap around from the last column to the
+//   first one, and from the last row to the first one, wherever both ends have a city (see
+//   adjacent() for the dimensions that can't wrap). Returns the number of roads added.
+func (wmap World) wrap() int {
+	height := len(wmap)
+	width := len(wmap[0])
+	added := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for _, dir := range []int{ EAST, SOUTH } {
+				if (adjacent(TOPOLOGY_GRID, width, height, x, y, dir)) || (! adjacent(TOPOLOGY_TORUS, width, height, x, y, dir)) {
+					continue
+				}
+				nx, ny := wmap.neighbor(x, y, dir)
+				if (wmap[y][x].cityName != "") && (wmap[ny][nx].cityName != "") && (! wmap[y][x].roads[dir]) {
+					wmap[y][x].roads[dir] = true
+					added ++
+				}
+			}
+		}
+	}
+	return added
+}
+
+// ---------------------------------------------------------------------------------------------------
+// Transform mode
+// ---------------------------------------------------------------------------------------------------
+
+// Parses a crop rectangle given as "X0,Y0,X1,Y1".
+func parseCrop(s string) ([4]int, error) {
+	var r [4]int
+	parts := strings.Split(s, ",")
+	if (len(parts) != 4) {
+		return r, fmt.Errorf("The crop rectangle must be given as X0,Y0,X1,Y1")
+	}
+	for i, p := range parts {
+		v, err := strconv.Atoi(strings.TrimSpace(p))
+		if (err != nil) || (v < 0) {
+			return r, fmt.Errorf("Invalid crop coordinate '%s'", p)
+		}
+		r[i] = v
+	}
+	if (r[2] < r[0]) || (r[3] < r[1]) {
+		return r, fmt.Errorf("The crop rectangle is empty")
+	}
+	return r, nil
+}
+
+// Reads a map (e.g. a simulation result), lifts it into the grid model, applies the transforms
+//   (crop, then mirror, then wrap) and writes the result.
+// Cities named after their coordinates are renamed after their new coordinates.
+func transform(mapfile string, outfile string, crop string, axis string, wrap bool, overwrite bool) error {
+	fmt.Printf("Will read mapfile '%s', transform it and write it to '%s'.\n", mapfile, outfile)
+
+	nodes, _, err := loadMap(mapfile)
+	if (err != nil) {
+		return err
+	}
+
+	wmap, named, err := liftWorld(nodes)
+	if (err != nil) {
+		return err
+	}
+	if (len(wmap) == 0) {
+		return fmt.Errorf("The map is empty")
+	}
+	fmt.Printf("Embedded %d cities in a %d x %d grid.\n", len(nodes), len(wmap[0]), len(wmap))
+
+	if (crop != "") {
+		r, err := parseCrop(crop)
+		if (err != nil) {
+			return err
+		}
+		if (r[2] >= len(wmap[0])) || (r[3] >= len(wmap)) {
+			return fmt.Errorf("The crop rectangle %s is outside of the %d x %d grid", crop, len(wmap[0]), len(wmap))
+		}
+		wmap = wmap.crop(r[0], r[1], r[2], r[3])
+		fmt.Printf("Cropped the grid to %d x %d.\n", len(wmap[0]), len(wmap))
+	}
+
+	if (axis != "") {
+		wmap = wmap.mirror(axis)
+		fmt.Printf("Mirrored the grid (%s).\n", axis)
+	}
+
+	if (wrap) {
+		fmt.Printf("Wrapped the grid around, adding %d roads.\n", wmap.wrap())
+	}
+
+	if (named) {
+		wmap.rename(coordNamer())
+	}
+
+	if err := writeFileAtomic(outfile, overwrite, wmap.write); err != nil {
+		return err
+	}
+
+	cities, roads := wmap.stats()
+	fmt.Printf("Wrote %d cities and %d roads.\n", cities, roads);
+
+	fmt.Println("Done.");
+	return nil
+}
+
+// Handles the command line of the transform mode:
+//   -transform <MAPFILE> <OUTFILE> [-crop X0,Y0,X1,Y1] [-mirror AXIS] [-wrap] [-overwrite]
+func mainTransform(args []string) int {
+	var crop, axis string
+	var wrap, overwrite bool
+	flags := flag.NewFlagSet("transform", flag.ContinueOnError)
+	flags.Usage = func() {}
+	flags.StringVar(&crop, "crop", "", "keep only the grid positions from X0,Y0 to X1,Y1")
+	flags.StringVar(&axis, "mirror", "", "flip the grid: horizontal, vertical or both")
+	flags.BoolVar(&wrap, "wrap", false, "add the roads that wrap the grid around into a torus")
+	flags.BoolVar(&overwrite, "overwrite", false, "replace the output file if it exists")
+
+	if (len(args) < 2) {
+		fmt.Println("Too few arguments for transform mode.");
+		return usageError()
+	}
+	if (flags.Parse(args[2:]) != nil) {
+		return usageError()
+	}
+	if (flags.NArg() > 0) {
+		fmt.Printf("Too many arguments for transform mode: '%s'.\n", flags.Arg(0));
+		return usageError()
+	}
+	if (axis != "") && (axis != MIRROR_HORIZONTAL) && (axis != MIRROR_VERTICAL) && (axis != MIRROR_BOTH) {
+		fmt.Printf("Transform: Unknown mirror axis '%s'.\n", axis);
+		return usageError()
+	}
+
+	return reportError(transform(args[0], args[1], crop, axis, wrap, overwrite))
+}
@@ -0,0 +1,205 @@
+/*
+   Alien Invasion Simulator - map file validator
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"io"
+	"bufio"
+	"sort"
+	"strings"
+)
+
+// A problem found in a map file, with the (1-based) line it was found in.
+type MapProblem struct {
+	line  int
+	msg   string
+}
+
+// A city definition as seen by the validator.
+type vcity struct {
+	name    string
+	line    int
+	sroads  []SRoadName
+}
+
+// Checks map data for every problem that the map reader would reject, plus roads that the map
+//   reader would silently overwrite, without stopping at the first one.
+// Returns the problems found, sorted by line, and, if there are none, the
+//   number of cities and roads in the map.
+func validateMap(r io.Reader) ([]MapProblem, int, int, error) {
+	var problems []MapProblem
+	var cities []vcity
+	cityIndex := make(map[string]int)
+
+	report := func(line int, format string, args ...any) {
+		problems = append(problems, MapProblem{line, fmt.Sprintf(format, args...)})
+	}
+
+	// First pass: syntax, directions, self-loops and duplicate cities, line by line.
+	lineNumber := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber ++
+		line := scanner.Text()
+		if (line == "") {
+			continue
+		}
+		if (strings.HasPrefix(line, DIRECTIONS_DIRECTIVE + " ")) {
+			if err := parseDirectionsDirective(line); err != nil {
+				report(lineNumber, "%v", err)
+			}
+			continue
+		}
+
+		items := strings.Split(line, " ")
+		city := vcity{name: items[0], line: lineNumber}
+
+		if (city.name == "") {
+			report(lineNumber, "Empty city name (line starts with a space)")
+		} else if (strings.Contains(city.name, "=")) {
+			report(lineNumber, "Missing city name before road '%s'", city.name)
+		}
+
+		hasPop := false
+		for i := 1; i < len(items); i++ {
+			inners := strings.Split(items[i], "=")
+			if (len(inners) != 2) || (inners[1] == "") {
+				report(lineNumber, "Syntax error in road '%s' of city '%s'", items[i], city.name)
+				continue
+			}
+			if (inners[0] == POPULATION_KEY) {
+				if (hasPop) {
+					report(lineNumber, "City '%s' declares its population more than once", city.name)
+				} else if _, err := parsePopulation(inners[1]); err != nil {
+					report(lineNumber, "%v for city '%s'", err, city.name)
+				}
+				hasPop = true
+				continue
+			}
+			dir, ok := lookupDirection(inners[0])
+			if (! ok) {
+				report(lineNumber, "Unknown direction '%s' in road '%s' of city '%s'", inners[0], items[i], city.name)
+				continue
+			}
+			if (inners[1] == city.name) {
+				report(lineNumber, "City '%s' is defined as its own %s neighbor", city.name, inners[0])
+				continue
+			}
+			if prev := sroadName(city.sroads, dir); (prev != "") {
+				report(lineNumber, "City '%s' declares more than one %s road ('%s' and '%s')", city.name, inners[0], prev, inners[1])
+				continue
+			}
+			city.sroads = append(city.sroads, SRoadName{ dir, inners[1] })
+		}
+
+		if first, exists := cityIndex[city.name]; exists {
+			report(lineNumber, "Duplicate definition of city '%s' (first defined in line %d)", city.name, cities[first].line)
+			continue
+		}
+		cityIndex[city.name] = len(cities)
+		cities = append(cities, city)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, 0, fmt.Errorf("Error encountered while reading line %d: %v", lineNumber + 1, err)
+	}
+
+	// Second pass: dangling references and road asymmetries, which need all cities to be known.
+	// "implied" records which city claimed each undeclared inverse road, to detect two cities
+	//   claiming the same one (the map reader would silently keep only the last).
+	type roadEnd struct {
+		city  int
+		dir   int
+	}
+	implied := make(map[roadEnd]int)
+	roads := make(map[roadEnd]bool)
+
+	for i := 0; i < len(cities); i++ {
+		city := &cities[i]
+		for _, sroad := range city.sroads {
+			d := sroad.dir
+			j, ok := cityIndex[sroad.name]
+			if (! ok) {
+				report(city.line, "City '%s' has a %s road to non-existing city '%s'", city.name, directionName(d), sroad.name)
+				continue
+			}
+
+			od := opposite(d)
+			neighbor := &cities[j]
+			back := sroadName(neighbor.sroads, od)
+			if (back == "") {
+				if other, claimed := implied[roadEnd{j, od}]; claimed {
+					report(city.line, "Cities '%s' (line %d) and '%s' both declare a %s road to '%s', which has no %s road of its own",
+						cities[other].name, cities[other].line, city.name, directionName(d), neighbor.name, directionName(od))
+					continue
+				}
+				implied[roadEnd{j, od}] = i
+			} else if (back != city.name) {
+				report(city.line, "City '%s' declares a %s road to city '%s', but the inverse %s road in line %d points to '%s' instead",
+					city.name, directionName(d), neighbor.name, directionName(od), neighbor.line, back)
+				continue
+			}
+
+			// Count each road once, from its end with the lower direction (e.g. east or south), or
+			//   from the lower city for directions that are their own opposite
+			if (d < od) || ((d == od) && (i < j)) {
+				roads[roadEnd{i, d}] = true
+			} else {
+				roads[roadEnd{j, od}] = true
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(a, b int) bool { return problems[a].line < problems[b].line })
+
+	return problems, len(cities), len(roads), nil
+}
+
+// Validates a map file, printing every problem found. Returns a MapError if the map is invalid.
+func validate(mapfile string) error {
+	fmt.Printf("Will validate mapfile '%s'.\n", mapfile)
+
+	file, err := os.Open(mapfile)
+	if (err != nil) {
+		return fmt.Errorf("Cannot read from input file '%s'", mapfile)
+	}
+	defer file.Close()
+
+	r, err := decompress(file)
+	if (err != nil) {
+		return &MapError{ err }
+	}
+	problems, cities, roads, err := validateMap(r)
+	if (err != nil) {
+		return err
+	}
+
+	for _, p := range problems {
+		fmt.Printf("%s:%d: %s.\n", mapfile, p.line, p.msg)
+	}
+
+	if (len(problems) > 0) {
+		return &MapError{ fmt.Errorf("Map file '%s' is invalid: %d problem(s) found", mapfile, len(problems)) }
+	}
+
+	fmt.Printf("Map file '%s' is valid: %d cities and %d roads.\n", mapfile, cities, roads)
+	return nil
+}
+
+// Handles the command line of the map validation mode: -validate <MAPFILE>
+// Exits with EXIT_MAP if the map is invalid, so it can be used in scripts.
+func mainValidate(args []string) int {
+	if (len(args) < 1) {
+		fmt.Println("Too few arguments for map validation mode.");
+		return usageError()
+	} else if (len(args) > 1) {
+		fmt.Printf("Too many arguments for map validation mode: '%s'.\n", args[1]);
+		return usageError()
+	}
+
+	return reportError(validate(args[0]))
+}
@@ -0,0 +1,169 @@
+/*
+   Alien Invasion Simulator - live terminal visualization
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"bufio"
+	"time"
+)
+
+// ANSI terminal control sequences used by the watch mode.
+const ANSI_CLEAR  string = "\x1b[H\x1b[2J"
+const ANSI_RESET  string = "\x1b[0m"
+const ANSI_GREEN  string = "\x1b[32m"
+const ANSI_RED    string = "\x1b[31m"
+const ANSI_YELLOW string = "\x1b[1;33m"
+const ANSI_GRAY   string = "\x1b[90m"
+
+// How the watch mode draws its frames. The ANSI sequences are only written to a terminal that
+//   understands them (not to a pipe or a file, nor with TERM=dumb); otherwise the frames are
+//   written one after the other, separated by a blank line. Colors are also left out if the
+//   NO_COLOR environment variable is set (https://no-color.org).
+type WatchStyle struct {
+	ansi   bool    // Clear the screen before each frame
+	color  bool    // Color the cities and roads
+}
+
+// The watch style for the standard output.
+func watchStyleOf(out *os.File) WatchStyle {
+	info, err := out.Stat()
+	ansi := (err == nil) && (info.Mode() & os.ModeCharDevice != 0) && (os.Getenv("TERM") != "dumb")
+	return WatchStyle{ ansi: ansi, color: (ansi) && (os.Getenv("NO_COLOR") == "") }
+}
+
+// Returns "s" in the color "code", if colors are used.
+func (ws WatchStyle) paint(s string, code string) string {
+	if (! ws.color) {
+		return s
+	}
+	return code + s + ANSI_RESET
+}
+
+// The position of each city in a grid map.
+type GridLayout struct {
+	minx, miny  int
+	width       int
+	height      int
+	cells       [][]int    // City index at each [y][x] grid position (relative to minx/miny), or -1 if none
+	xs, ys      []int      // Grid position of each city
+}
+
+// Lays out a map on a grid using the coordinates encoded in the city names (see parseCoords) or,
+//   if the names don't encode them, the coordinates inferred from the roads (see inferCoords).
+// Returns nil if the map can't be laid out on a grid.
+func gridLayoutOf(nodes SNodeArray) *GridLayout {
+	if (len(nodes) == 0) {
+		return nil
+	}
+
+	xs, ys, _, err := gridCoords(nodes)
+	if (err != nil) {
+		return nil
+	}
+	layout := &GridLayout{ minx: -1, miny: -1, xs: xs, ys: ys }
+	maxx, maxy := -1, -1
+
+	for i := 0; i < len(nodes); i++ {
+		x, y := xs[i], ys[i]
+		if (layout.minx == -1) || (x < layout.minx) { layout.minx = x }
+		if (layout.miny == -1) || (y < layout.miny) { layout.miny = y }
+		if (x > maxx) { maxx = x }
+		if (y > maxy) { maxy = y }
+	}
+
+	layout.width = maxx - layout.minx + 1
+	layout.height = maxy - layout.miny + 1
+	layout.cells = make([][]int, layout.height)
+	for y := 0; y < layout.height; y++ {
+		layout.cells[y] = make([]int, layout.width)
+		for x := 0; x < layout.width; x++ {
+			layout.cells[y][x] = -1
+		}
+	}
+	for i := 0; i < len(nodes); i++ {
+		layout.cells[ys[i] - layout.miny][xs[i] - layout.minx] = i
+	}
+	return layout
+}
+
+// Draws the current state of a grid map on the terminal:
+//   'o' (green)  city that is alive
+//   '@' (yellow) city with aliens in it
+//   'x' (red)    destroyed city
+//   '-' and '|'  roads between live cities
+func (sim *Simulator) drawGrid(layout *GridLayout, style WatchStyle) {
+	out := bufio.NewWriter(os.Stdout)
+	if (style.ansi) {
+		out.WriteString(ANSI_CLEAR)
+	} else {
+		out.WriteString("\n")
+	}
+
+	alive := func(idx int) bool {
+		return (idx != -1) && (! sim.nodes[idx].dead)
+	}
+
+	for y := 0; y < layout.height; y++ {
+
+		// The row with the cities and the east-west roads between them
+		for x := 0; x < layout.width; x++ {
+			idx := layout.cells[y][x]
+			switch {
+			case (idx == -1):
+				out.WriteString(" ")
+			case (sim.nodes[idx].dead):
+				out.WriteString(style.paint("x", ANSI_RED))
+			case (len(sim.nodes[idx].occupants) > 0):
+				out.WriteString(style.paint("@", ANSI_YELLOW))
+			default:
+				out.WriteString(style.paint("o", ANSI_GREEN))
+			}
+			if (alive(idx)) && (alive(sim.nodes[idx].road(EAST))) {
+				out.WriteString(style.paint("-", ANSI_GRAY))
+			} else {
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString("\n")
+
+		// The row with the north-south roads to the next row of cities
+		for x := 0; x < layout.width; x++ {
+			idx := layout.cells[y][x]
+			if (alive(idx)) && (alive(sim.nodes[idx].road(SOUTH))) {
+				out.WriteString(style.paint("|", ANSI_GRAY) + " ")
+			} else {
+				out.WriteString("  ")
+			}
+		}
+		out.WriteString("\n")
+	}
+
+	fmt.Fprintf(out, "Iteration %d of %d. Aliens alive: %d of %d. Cities destroyed: %d of %d.\n",
+		sim.iteration, sim.opts.MaxSteps, sim.liveAlienCounter, len(sim.aliens), sim.deadCityCounter, len(sim.nodes))
+	out.Flush()
+}
+
+// Sets up the watch mode, which draws the grid on the terminal after every movement step and
+//   then waits for the frame delay (see WatchStyle). Returns false if the map is not a grid map.
+func (sim *Simulator) watch(delay time.Duration) bool {
+	layout := gridLayoutOf(sim.nodes)
+	if (layout == nil) {
+		return false
+	}
+
+	sim.progress = false
+	style := watchStyleOf(os.Stdout)
+
+	sim.AddAfterIteration(func(s *Simulator, iteration int) {
+		s.drawGrid(layout, style)
+		time.Sleep(delay)
+	})
+
+	sim.drawGrid(layout, style)
+	time.Sleep(delay)
+	return true
+}
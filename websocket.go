@@ -0,0 +1,212 @@
+/*
+   Alien Invasion Simulator - minimal WebSocket server support (RFC 6455)
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"bufio"
+	"strings"
+	"sync"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+)
+
+// The GUID that is appended to the client key to compute the handshake response (RFC 6455, 1.3).
+const WS_GUID string = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const WS_OP_CONTINUATION byte = 0x0
+const WS_OP_TEXT         byte = 0x1
+const WS_OP_BINARY       byte = 0x2
+const WS_OP_CLOSE        byte = 0x8
+const WS_OP_PING         byte = 0x9
+const WS_OP_PONG         byte = 0xA
+
+// The largest frame we accept from a client. Clients only need to send control frames.
+const WS_MAX_CLIENT_FRAME uint64 = 64 * 1024
+
+// The largest payload of a control frame (RFC 6455, 5.5).
+const WS_MAX_CONTROL_PAYLOAD uint64 = 125
+
+// Close status codes (RFC 6455, 7.4.1)
+const WS_CLOSE_NORMAL         uint16 = 1000
+const WS_CLOSE_PROTOCOL_ERROR uint16 = 1002
+const WS_CLOSE_TOO_BIG        uint16 = 1009
+
+// A server-side WebSocket connection. We only send text messages; incoming messages are read
+//   (to answer pings and notice when the client goes away) and discarded.
+type wsConn struct {
+	conn  net.Conn
+	rw    *bufio.ReadWriter
+	wmu   sync.Mutex    // serializes frame writes
+}
+
+// Performs the WebSocket opening handshake and takes over the HTTP connection.
+func wsUpgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if (! headerContains(r.Header, "Connection", "upgrade")) || (! headerContains(r.Header, "Upgrade", "websocket")) {
+		return nil, fmt.Errorf("Not a WebSocket handshake")
+	}
+	if (r.Header.Get("Sec-WebSocket-Version") != "13") {
+		return nil, fmt.Errorf("Unsupported WebSocket version '%s'", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if (key == "") {
+		return nil, fmt.Errorf("Missing Sec-WebSocket-Key header")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if (! ok) {
+		return nil, fmt.Errorf("The HTTP connection cannot be taken over")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if (err != nil) {
+		return nil, err
+	}
+
+	h := sha1.New()
+	h.Write([]byte(key + WS_GUID))
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + accept + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{ conn: conn, rw: rw }, nil
+}
+
+// Returns true if a comma-separated header has the given token (case-insensitive).
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			if (strings.EqualFold(strings.TrimSpace(item), token)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Writes a single unmasked frame (server frames are never masked).
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	header := []byte{ 0x80 | opcode }
+	n := len(payload)
+	switch {
+	case (n < 126):
+		header = append(header, byte(n))
+	case (n <= 0xFFFF):
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Sends a text message.
+func (c *wsConn) WriteText(msg []byte) error {
+	return c.writeFrame(WS_OP_TEXT, msg)
+}
+
+// Sends a close frame with a status code (1000 is a normal closure) and closes the connection.
+func (c *wsConn) Close(code uint16) {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, code)
+	c.writeFrame(WS_OP_CLOSE, payload)
+	c.conn.Close()
+}
+
+// Reads the frames sent by the client until it closes the connection or an error happens, then
+//   closes "done". Pings are answered; every other message is discarded.
+// Frames that break the protocol close the connection with a protocol error (RFC 6455, 5.1 to
+//   5.5): unmasked frames, reserved bits or opcodes (no extension is negotiated), and fragmented
+//   or oversized control frames. Frames larger than WS_MAX_CLIENT_FRAME close it as too big.
+func (c *wsConn) readLoop(done chan struct{}) {
+	defer close(done)
+
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+			return
+		}
+		fin := (head[0] & 0x80) != 0
+		rsv := head[0] & 0x70
+		opcode := head[0] & 0x0F
+		masked := (head[1] & 0x80) != 0
+		length := uint64(head[1] & 0x7F)
+		control := (opcode & 0x8) != 0
+
+		if (! masked) || (rsv != 0) || (! wsKnownOpcode(opcode)) || ((control) && ((! fin) || (length > WS_MAX_CONTROL_PAYLOAD))) {
+			c.Close(WS_CLOSE_PROTOCOL_ERROR)
+			return
+		}
+
+		switch (length) {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+				return
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if (length > WS_MAX_CLIENT_FRAME) {
+			c.Close(WS_CLOSE_TOO_BIG)
+			return
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+			return
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.rw, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i % 4]
+		}
+
+		switch (opcode) {
+		case WS_OP_PING:
+			c.writeFrame(WS_OP_PONG, payload)
+		case WS_OP_CLOSE:
+			return
+		}
+	}
+}
+
+// Returns true if an opcode is defined by RFC 6455 (the others are reserved).
+func wsKnownOpcode(opcode byte) bool {
+	switch (opcode) {
+	case WS_OP_CONTINUATION, WS_OP_TEXT, WS_OP_BINARY, WS_OP_CLOSE, WS_OP_PING, WS_OP_PONG:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,250 @@
+/*
+   Alien Invasion Simulator - YAML subset reader
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Experiment files (see experiment.go) are written in the part of YAML that configuration files
+//   need, which is read here so that the simulator needs nothing beyond the standard library:
+//
+//   - Mappings of "key: value" lines, nested by indentation (with spaces).
+//   - Lists of "- value" lines, whose items are scalars or flow lists.
+//   - Flow lists ("[a, b, c]") and flow mappings ("{a: 1, b: 2}") of scalars.
+//   - Scalars, plain or quoted with '"' or "'", which are all kept as strings.
+//   - Comments, from a '#' at the start of a line or after a space to the end of the line.
+//
+// Anchors, tags, multi-line scalars and multiple documents are not supported.
+
+// A YAML mapping, which keeps the order of its keys.
+type yamlMap struct {
+	keys    []string
+	values  map[string]interface{}
+}
+
+func (m *yamlMap) get(key string) (interface{}, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// A line of a YAML file, without its indentation and comment.
+type yamlLine struct {
+	number  int
+	indent  int
+	text    string
+}
+
+// Parses a YAML document whose top level is a mapping. The values are strings, []interface{} and
+//   *yamlMap.
+func parseYAML(data string) (*yamlMap, error) {
+	var lines []yamlLine
+	for k, raw := range strings.Split(data, "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		if (strings.TrimSpace(text) == "") || (text == "---") {
+			continue
+		}
+		trimmed := strings.TrimLeft(text, " \t")
+		if (strings.Contains(text[:len(text) - len(trimmed)], "\t")) {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", k + 1)
+		}
+		lines = append(lines, yamlLine{ k + 1, len(text) - len(trimmed), trimmed })
+	}
+
+	if (len(lines) == 0) {
+		return &yamlMap{ values: map[string]interface{}{} }, nil
+	}
+	p := &yamlParser{ lines: lines }
+	v, err := p.block(lines[0].indent)
+	if (err != nil) {
+		return nil, err
+	}
+	if (p.pos < len(lines)) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].number)
+	}
+	m, ok := v.(*yamlMap)
+	if (! ok) {
+		return nil, fmt.Errorf("line %d: the document must be a mapping of keys to values", lines[0].number)
+	}
+	return m, nil
+}
+
+// Removes the comment at the end of a line, if any ('#' inside quotes is not a comment).
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case (quote != 0):
+			if (c == quote) {
+				quote = 0
+			}
+		case (c == '"') || (c == '\''):
+			quote = c
+		case (c == '#') && ((i == 0) || (line[i - 1] == ' ') || (line[i - 1] == '\t')):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+type yamlParser struct {
+	lines  []yamlLine
+	pos    int
+}
+
+// Parses the mapping or list whose lines start at indentation "indent".
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	if (p.lines[p.pos].text == "-") || (strings.HasPrefix(p.lines[p.pos].text, "- ")) {
+		return p.list(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) list(indent int) (interface{}, error) {
+	var items []interface{}
+	for (p.pos < len(p.lines)) && (p.lines[p.pos].indent == indent) {
+		line := p.lines[p.pos]
+		if (line.text != "-") && (! strings.HasPrefix(line.text, "- ")) {
+			return nil, fmt.Errorf("line %d: expected a list item ('- value')", line.number)
+		}
+		p.pos ++
+		item := strings.TrimSpace(strings.TrimPrefix(line.text, "-"))
+		if (item == "") {
+			if (p.pos == len(p.lines)) || (p.lines[p.pos].indent <= indent) {
+				return nil, fmt.Errorf("line %d: empty list item", line.number)
+			}
+			v, err := p.block(p.lines[p.pos].indent)
+			if (err != nil) {
+				return nil, err
+			}
+			items = append(items, v)
+			continue
+		}
+		v, err := parseYAMLValue(item, line.number)
+		if (err != nil) {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	m := &yamlMap{ values: map[string]interface{}{} }
+	for (p.pos < len(p.lines)) && (p.lines[p.pos].indent == indent) {
+		line := p.lines[p.pos]
+		key, value, ok := cutYAMLKey(line.text)
+		if (! ok) {
+			return nil, fmt.Errorf("line %d: expected 'key: value'", line.number)
+		}
+		if _, exists := m.values[key]; (exists) {
+			return nil, fmt.Errorf("line %d: duplicate key '%s'", line.number, key)
+		}
+		p.pos ++
+
+		var v interface{} = ""
+		if (value != "") {
+			var err error
+			if v, err = parseYAMLValue(value, line.number); err != nil {
+				return nil, err
+			}
+		} else if (p.pos < len(p.lines)) && (p.lines[p.pos].indent > indent) {
+			var err error
+			if v, err = p.block(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+		} else if (p.pos < len(p.lines)) && (p.lines[p.pos].indent == indent) && (strings.HasPrefix(p.lines[p.pos].text, "- ")) {
+			// A list may be at the same indentation as its key
+			var err error
+			if v, err = p.list(indent); err != nil {
+				return nil, err
+			}
+		}
+		m.keys = append(m.keys, key)
+		m.values[key] = v
+	}
+	if (p.pos < len(p.lines)) && (p.lines[p.pos].indent > indent) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].number)
+	}
+	return m, nil
+}
+
+// Splits a "key: value" line (or "key:" with the value in the next lines).
+func cutYAMLKey(text string) (string, string, bool) {
+	key, value, ok := strings.Cut(text, ":")
+	if (! ok) && (! strings.HasSuffix(text, ":")) {
+		return "", "", false
+	}
+	if (value != "") && (value[0] != ' ') {
+		return "", "", false
+	}
+	key = unquoteYAML(strings.TrimSpace(key))
+	return key, strings.TrimSpace(value), (key != "")
+}
+
+// Parses an inline value: a flow list, a flow mapping or a scalar.
+func parseYAMLValue(s string, line int) (interface{}, error) {
+	if (strings.HasPrefix(s, "[")) {
+		if (! strings.HasSuffix(s, "]")) {
+			return nil, fmt.Errorf("line %d: unterminated list '%s'", line, s)
+		}
+		items := []interface{}{}
+		for _, item := range splitYAMLFlow(s[1:len(s) - 1]) {
+			items = append(items, unquoteYAML(item))
+		}
+		return items, nil
+	}
+	if (strings.HasPrefix(s, "{")) {
+		if (! strings.HasSuffix(s, "}")) {
+			return nil, fmt.Errorf("line %d: unterminated mapping '%s'", line, s)
+		}
+		m := &yamlMap{ values: map[string]interface{}{} }
+		for _, item := range splitYAMLFlow(s[1:len(s) - 1]) {
+			key, value, ok := cutYAMLKey(item)
+			if (! ok) {
+				return nil, fmt.Errorf("line %d: expected 'key: value' in '%s'", line, s)
+			}
+			m.keys = append(m.keys, key)
+			m.values[key] = unquoteYAML(value)
+		}
+		return m, nil
+	}
+	return unquoteYAML(s), nil
+}
+
+// Splits the items of a flow list or mapping at the commas that are not quoted.
+func splitYAMLFlow(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(s); i++ {
+		if (i < len(s)) && (quote != 0) {
+			if (s[i] == quote) {
+				quote = 0
+			}
+			continue
+		}
+		if (i < len(s)) && ((s[i] == '"') || (s[i] == '\'')) {
+			quote = s[i]
+			continue
+		}
+		if (i == len(s)) || (s[i] == ',') {
+			if item := strings.TrimSpace(s[start:i]); (item != "") {
+				items = append(items, item)
+			}
+			start = i + 1
+		}
+	}
+	return items
+}
+
+func unquoteYAML(s string) string {
+	if (len(s) >= 2) && ((s[0] == '"') || (s[0] == '\'')) && (s[len(s) - 1] == s[0]) {
+		return s[1:len(s) - 1]
+	}
+	return s
+}